@@ -0,0 +1,390 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/git"
+	"github.com/opsgenie/oec/history"
+	"github.com/opsgenie/oec/logging"
+	"github.com/opsgenie/oec/queue"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultHistoryLimit is the number of history entries returned unless a limit is given, zero returns all.
+const defaultHistoryLimit = 100
+
+type processor interface {
+	Status() queue.Status
+	PausePollers(integration, queueUrl string) int
+	ResumePollers(integration, queueUrl string) int
+	PullRepositories() []git.RepositoryStatus
+	Jobs() []queue.JobStatus
+	CancelJob(id string) bool
+}
+
+// Poller is a poller of an integration, as listed by the admin API.
+type Poller struct {
+	Integration string `json:"integration,omitempty"`
+	queue.PollerStatus
+}
+
+type countResponse struct {
+	Count int `json:"count"`
+}
+
+type logLevelResponse struct {
+	Level     string     `json:"level"`
+	RevertsAt *time.Time `json:"revertsAt,omitempty"`
+}
+
+func newLogLevelResponse() logLevelResponse {
+	response := logLevelResponse{Level: logrus.GetLevel().String()}
+	if revertsAt := logging.LevelRevertsAt(); !revertsAt.IsZero() {
+		response.RevertsAt = &revertsAt
+	}
+	return response
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server serves the admin API, which changes a running OEC without restarting it.
+type Server struct {
+	processor processor
+	reload    func() error
+	token     string
+	network   string
+	address   string
+	mux       *http.ServeMux
+
+	server      *http.Server
+	isRunning   bool
+	startStopMu *sync.Mutex
+}
+
+// NewServer returns the admin API of the processor. The configuration is reloaded by the given function.
+func NewServer(adminConf *conf.AdminConf, processor queue.Processor, reload func() error) *Server {
+	return newServer(adminConf, processor, reload)
+}
+
+func newServer(adminConf *conf.AdminConf, processor processor, reload func() error) *Server {
+
+	network, address := "tcp", adminConf.Address
+	if adminConf.SocketPath != "" {
+		network, address = "unix", adminConf.SocketPath
+	} else if address == "" {
+		logrus.Infof("Admin address is not set, default address[%s] is set.", conf.DefaultAdminAddress)
+		address = conf.DefaultAdminAddress
+	}
+
+	if adminConf.Token == "" && network == "unix" {
+		logrus.Warnf("Admin token is not set, admin API will serve all requests of the owner of the socket.")
+	}
+
+	s := &Server{
+		processor:   processor,
+		reload:      reload,
+		token:       adminConf.Token,
+		network:     network,
+		address:     address,
+		mux:         http.NewServeMux(),
+		startStopMu: &sync.Mutex{},
+	}
+
+	s.mux.HandleFunc("/pollers", s.listPollers)
+	s.mux.HandleFunc("/pollers/pause", s.pausePollers)
+	s.mux.HandleFunc("/pollers/resume", s.resumePollers)
+	s.mux.HandleFunc("/repositories/pull", s.pullRepositories)
+	s.mux.HandleFunc("/config/reload", s.reloadConfig)
+	s.mux.HandleFunc("/log-level", s.logLevel)
+	s.mux.HandleFunc("/jobs", s.listJobs)
+	s.mux.HandleFunc("/jobs/cancel", s.cancelJob)
+	s.mux.HandleFunc("/history", s.listHistory)
+	return s
+}
+
+func (s *Server) Start() error {
+	defer s.startStopMu.Unlock()
+	s.startStopMu.Lock()
+
+	if s.isRunning {
+		return errors.New("Admin API is already running.")
+	}
+
+	// any local user can connect to an address, only the owner to the socket
+	if s.network != "unix" && s.token == "" {
+		return errors.Errorf("Admin API could not listen on %s[%s]: admin token is not set.", s.network, s.address)
+	}
+
+	if s.network == "unix" {
+		err := os.Remove(s.address)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Errorf("Admin socket[%s] could not be removed: %s", s.address, err)
+		}
+	}
+
+	listener, err := net.Listen(s.network, s.address)
+	if err != nil {
+		return errors.Errorf("Admin API could not listen on %s[%s]: %s", s.network, s.address, err)
+	}
+
+	if s.network == "unix" {
+		err = os.Chmod(s.address, 0600)
+		if err != nil {
+			listener.Close()
+			return errors.Errorf("Admin socket[%s] could not be restricted to its owner: %s", s.address, err)
+		}
+	}
+
+	s.server = &http.Server{Handler: s}
+	go func() {
+		err := s.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("Admin API has stopped serving: %s", err)
+		}
+	}()
+
+	logrus.Infof("Admin API serves on %s[%s].", s.network, s.address)
+	s.isRunning = true
+	return nil
+}
+
+func (s *Server) Stop(ctx context.Context) error {
+	defer s.startStopMu.Unlock()
+	s.startStopMu.Lock()
+
+	if !s.isRunning {
+		return errors.New("Admin API is not running.")
+	}
+
+	err := s.server.Shutdown(ctx)
+	if s.network == "unix" {
+		os.Remove(s.address)
+	}
+	s.isRunning = false
+	return err
+}
+
+// ServeHTTP serves the request if it has the token of the admin API.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if s.token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("Admin token is not valid."))
+			return
+		}
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) listPollers(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	pollers := make([]Poller, 0)
+	for _, integration := range s.processor.Status().Integrations {
+		for _, pollerStatus := range integration.Pollers {
+			pollers = append(pollers, Poller{Integration: integration.Name, PollerStatus: pollerStatus})
+		}
+	}
+	writeJson(w, http.StatusOK, pollers)
+}
+
+func (s *Server) pausePollers(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	count := s.processor.PausePollers(r.URL.Query().Get("integration"), r.URL.Query().Get("queueUrl"))
+	if count == 0 {
+		writeError(w, http.StatusNotFound, errors.New("No poller matches the integration and the queue url."))
+		return
+	}
+	logrus.Warnf("%d pollers are paused through the admin API.", count)
+	writeJson(w, http.StatusOK, countResponse{Count: count})
+}
+
+func (s *Server) resumePollers(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	count := s.processor.ResumePollers(r.URL.Query().Get("integration"), r.URL.Query().Get("queueUrl"))
+	if count == 0 {
+		writeError(w, http.StatusNotFound, errors.New("No poller matches the integration and the queue url."))
+		return
+	}
+	logrus.Infof("%d pollers are resumed through the admin API.", count)
+	writeJson(w, http.StatusOK, countResponse{Count: count})
+}
+
+func (s *Server) pullRepositories(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	logrus.Infof("Git repositories are being pulled through the admin API.")
+	writeJson(w, http.StatusOK, s.processor.PullRepositories())
+}
+
+func (s *Server) reloadConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	err := s.reload()
+	if err != nil {
+		logrus.Warnf("Configuration could not be reloaded through the admin API: %s", err)
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	logrus.Infof("Configuration is reloaded through the admin API.")
+	writeJson(w, http.StatusOK, newLogLevelResponse())
+}
+
+func (s *Server) logLevel(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		level, err := logrus.ParseLevel(r.URL.Query().Get("level"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if value := r.URL.Query().Get("duration"); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				writeError(w, http.StatusBadRequest, errors.Errorf("Duration[%s] should be a positive duration such as 15m.", value))
+				return
+			}
+			logging.SetLevelFor(level, duration)
+			logrus.Warnf("Log level is set to %s for %s through the admin API.", level.String(), duration.String())
+		} else {
+			logging.SetLevel(level)
+			logrus.Warnf("Log level is set to %s through the admin API.", level.String())
+		}
+	}
+	writeJson(w, http.StatusOK, newLogLevelResponse())
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJson(w, http.StatusOK, s.processor.Jobs())
+}
+
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, errors.New("Job id should be given."))
+		return
+	}
+
+	if !s.processor.CancelJob(id) {
+		writeError(w, http.StatusNotFound, errors.Errorf("Job[%s] is not running.", id))
+		return
+	}
+	writeJson(w, http.StatusOK, countResponse{Count: 1})
+}
+
+func (s *Server) listHistory(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	filter, err := parseHistoryFilter(r.URL.Query(), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, err := history.Query(filter)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJson(w, http.StatusOK, entries)
+}
+
+// parseHistoryFilter returns the history filter of the query parameters; integration, action, entityId,
+// requestId, since, until, failed and limit. Times are either in RFC 3339 format or durations before now.
+func parseHistoryFilter(query url.Values, now time.Time) (*history.Filter, error) {
+	filter := &history.Filter{
+		Integration: query.Get("integration"),
+		Action:      query.Get("action"),
+		EntityId:    query.Get("entityId"),
+		RequestId:   query.Get("requestId"),
+		Limit:       defaultHistoryLimit,
+	}
+
+	var err error
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = history.ParseTime(since, now); err != nil {
+			return nil, err
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = history.ParseTime(until, now); err != nil {
+			return nil, err
+		}
+	}
+	if failed := query.Get("failed"); failed != "" {
+		if filter.FailedOnly, err = strconv.ParseBool(failed); err != nil {
+			return nil, errors.Errorf("Failed[%s] should be true or false.", failed)
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return nil, errors.Errorf("Limit[%s] should be a non-negative number.", limit)
+		}
+	}
+	return filter, nil
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.Errorf("Method[%s] is not allowed.", r.Method))
+	return false
+}
+
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	writeJson(w, statusCode, errorResponse{Error: err.Error()})
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		logrus.Debugf("Admin API response could not be written: %s", err)
+	}
+}
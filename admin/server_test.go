@@ -0,0 +1,310 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/git"
+	"github.com/opsgenie/oec/history"
+	"github.com/opsgenie/oec/logging"
+	"github.com/opsgenie/oec/queue"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type MockProcessor struct {
+	StatusFunc           func() queue.Status
+	PausePollersFunc     func(integration, queueUrl string) int
+	ResumePollersFunc    func(integration, queueUrl string) int
+	PullRepositoriesFunc func() []git.RepositoryStatus
+	JobsFunc             func() []queue.JobStatus
+	CancelJobFunc        func(id string) bool
+}
+
+func (m *MockProcessor) Status() queue.Status {
+	return m.StatusFunc()
+}
+
+func (m *MockProcessor) PausePollers(integration, queueUrl string) int {
+	return m.PausePollersFunc(integration, queueUrl)
+}
+
+func (m *MockProcessor) ResumePollers(integration, queueUrl string) int {
+	return m.ResumePollersFunc(integration, queueUrl)
+}
+
+func (m *MockProcessor) PullRepositories() []git.RepositoryStatus {
+	return m.PullRepositoriesFunc()
+}
+
+func (m *MockProcessor) Jobs() []queue.JobStatus {
+	return m.JobsFunc()
+}
+
+func (m *MockProcessor) CancelJob(id string) bool {
+	return m.CancelJobFunc(id)
+}
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func serve(server *Server, method, target, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestNewServerSetsDefaultAddress(t *testing.T) {
+
+	server := newServer(&conf.AdminConf{}, &MockProcessor{}, nil)
+	assert.Equal(t, "tcp", server.network)
+	assert.Equal(t, conf.DefaultAdminAddress, server.address)
+
+	server = newServer(&conf.AdminConf{Address: "127.0.0.1:7072", SocketPath: "/tmp/oec.sock"}, &MockProcessor{}, nil)
+	assert.Equal(t, "unix", server.network)
+	assert.Equal(t, "/tmp/oec.sock", server.address)
+}
+
+func TestServerRejectsInvalidToken(t *testing.T) {
+
+	server := newServer(&conf.AdminConf{Token: "token"}, &MockProcessor{
+		JobsFunc: func() []queue.JobStatus { return []queue.JobStatus{} },
+	}, nil)
+
+	recorder := serve(server, http.MethodGet, "/jobs", "")
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	recorder = serve(server, http.MethodGet, "/jobs", "invalid")
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	recorder = serve(server, http.MethodGet, "/jobs", "token")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `[]`, recorder.Body.String())
+}
+
+func TestListPollers(t *testing.T) {
+
+	server := newServer(&conf.AdminConf{}, &MockProcessor{
+		StatusFunc: func() queue.Status {
+			return queue.Status{Integrations: []queue.IntegrationStatus{
+				{Name: "first", Pollers: []queue.PollerStatus{{QueueUrl: "queueUrl1"}}},
+				{Name: "second", Pollers: []queue.PollerStatus{{QueueUrl: "queueUrl2", IsPaused: true}}},
+			}}
+		},
+	}, nil)
+
+	recorder := serve(server, http.MethodGet, "/pollers", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	pollers := make([]Poller, 0)
+	err := json.Unmarshal(recorder.Body.Bytes(), &pollers)
+	assert.Nil(t, err)
+	assert.Len(t, pollers, 2)
+	assert.Equal(t, "second", pollers[1].Integration)
+	assert.Equal(t, "queueUrl2", pollers[1].QueueUrl)
+	assert.True(t, pollers[1].IsPaused)
+
+	recorder = serve(server, http.MethodPost, "/pollers", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestPauseAndResumePollers(t *testing.T) {
+
+	pausedIntegration, pausedQueueUrl := "", ""
+	server := newServer(&conf.AdminConf{}, &MockProcessor{
+		PausePollersFunc: func(integration, queueUrl string) int {
+			pausedIntegration, pausedQueueUrl = integration, queueUrl
+			return 1
+		},
+		ResumePollersFunc: func(integration, queueUrl string) int {
+			return 0
+		},
+	}, nil)
+
+	recorder := serve(server, http.MethodPost, "/pollers/pause?integration=first&queueUrl=queueUrl1", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"count":1}`, recorder.Body.String())
+	assert.Equal(t, "first", pausedIntegration)
+	assert.Equal(t, "queueUrl1", pausedQueueUrl)
+
+	recorder = serve(server, http.MethodPost, "/pollers/resume?integration=unknown", "")
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestReloadConfig(t *testing.T) {
+
+	reloadErr := errors.New("Test error")
+	server := newServer(&conf.AdminConf{}, &MockProcessor{}, func() error {
+		return reloadErr
+	})
+
+	recorder := serve(server, http.MethodPost, "/config/reload", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	assert.JSONEq(t, `{"error":"Test error"}`, recorder.Body.String())
+
+	reloadErr = nil
+	recorder = serve(server, http.MethodPost, "/config/reload", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestChangeLogLevel(t *testing.T) {
+
+	defer logrus.SetLevel(logrus.GetLevel())
+	server := newServer(&conf.AdminConf{}, &MockProcessor{}, nil)
+
+	recorder := serve(server, http.MethodPost, "/log-level?level=debug", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"level":"debug"}`, recorder.Body.String())
+	assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+
+	recorder = serve(server, http.MethodPost, "/log-level?level=unknown", "")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+}
+
+func TestChangeLogLevelTemporarily(t *testing.T) {
+
+	defer logging.SetLevel(logrus.GetLevel())
+	logging.SetLevel(logrus.InfoLevel)
+	server := newServer(&conf.AdminConf{}, &MockProcessor{}, nil)
+
+	recorder := serve(server, http.MethodPost, "/log-level?level=debug&duration=15m", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	response := logLevelResponse{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, "debug", response.Level)
+	assert.NotNil(t, response.RevertsAt)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), *response.RevertsAt, time.Minute)
+
+	recorder = serve(server, http.MethodPost, "/log-level?level=debug&duration=forever", "")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.JSONEq(t, `{"error":"Duration[forever] should be a positive duration such as 15m."}`, recorder.Body.String())
+
+	recorder = serve(server, http.MethodPost, "/log-level?level=warn", "")
+	assert.JSONEq(t, `{"level":"warning"}`, recorder.Body.String())
+}
+
+func TestCancelJob(t *testing.T) {
+
+	server := newServer(&conf.AdminConf{}, &MockProcessor{
+		CancelJobFunc: func(id string) bool {
+			return id == "jobId"
+		},
+	}, nil)
+
+	recorder := serve(server, http.MethodPost, "/jobs/cancel?id=jobId", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	recorder = serve(server, http.MethodPost, "/jobs/cancel?id=unknown", "")
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.JSONEq(t, `{"error":"Job[unknown] is not running."}`, recorder.Body.String())
+
+	recorder = serve(server, http.MethodPost, "/jobs/cancel", "")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestListHistory(t *testing.T) {
+
+	server := newServer(&conf.AdminConf{}, &MockProcessor{}, nil)
+
+	recorder := serve(server, http.MethodGet, "/history", "")
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	directory, err := ioutil.TempDir("", "oecHistory")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	assert.Nil(t, history.Configure(&conf.HistoryConf{Enabled: true, Directory: directory}))
+	defer history.Close()
+
+	now := time.Now()
+	history.Add(&history.Entry{MessageId: "first", Action: "Restart", StartedAt: now.Add(-2 * time.Hour), Outcome: history.Failed})
+	history.Add(&history.Entry{MessageId: "second", Action: "Restart", StartedAt: now.Add(-time.Minute), Outcome: history.Succeeded})
+	history.Add(&history.Entry{MessageId: "third", Action: "Create", StartedAt: now, Outcome: history.Failed})
+
+	recorder = serve(server, http.MethodGet, "/history?action=Restart&since=3h", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	entries := make([]history.Entry, 0)
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &entries))
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "second", entries[0].MessageId)
+
+	recorder = serve(server, http.MethodGet, "/history?failed=true&limit=1", "")
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &entries))
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "third", entries[0].MessageId)
+
+	recorder = serve(server, http.MethodGet, "/history?limit=-1", "")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = serve(server, http.MethodPost, "/history", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestStartOnAddressRequiresToken(t *testing.T) {
+
+	server := newServer(&conf.AdminConf{Address: "127.0.0.1:0"}, &MockProcessor{}, nil)
+
+	err := server.Start()
+	assert.EqualError(t, err, "Admin API could not listen on tcp[127.0.0.1:0]: admin token is not set.")
+
+	server = newServer(&conf.AdminConf{Address: "127.0.0.1:0", Token: "token"}, &MockProcessor{}, nil)
+
+	err = server.Start()
+	assert.Nil(t, err)
+
+	err = server.Stop(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestMutatingEndpointsRejectGet(t *testing.T) {
+
+	server := newServer(&conf.AdminConf{}, &MockProcessor{}, func() error {
+		return nil
+	})
+
+	for _, target := range []string{"/pollers/pause", "/pollers/resume", "/repositories/pull", "/config/reload", "/jobs/cancel?id=id"} {
+		recorder := serve(server, http.MethodGet, target, "")
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code, target)
+	}
+}
+
+func TestStartAndStopOnUnixSocket(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "oecAdmin")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	socketPath := filepath.Join(directory, "admin.sock")
+	server := newServer(&conf.AdminConf{SocketPath: socketPath}, &MockProcessor{}, nil)
+
+	err = server.Start()
+	assert.Nil(t, err)
+
+	info, err := os.Stat(socketPath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	err = server.Start()
+	assert.EqualError(t, err, "Admin API is already running.")
+
+	err = server.Stop(context.Background())
+	assert.Nil(t, err)
+
+	_, err = os.Stat(socketPath)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,325 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/redaction"
+	"github.com/opsgenie/oec/runbook"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Types of the audit records.
+const (
+	ExecutionRecord = "execution"
+	ResultRecord    = "result"
+)
+
+// Result statuses of the result records.
+const (
+	ResultSent    = "sent"
+	ResultFailed  = "failed"
+	ResultDropped = "dropped"
+)
+
+// Record is a line of the audit log. An execution record is written for every action execution and a result record
+// is written once its result is sent to Opsgenie or given up on. Each record has the hash of the previous one, so
+// that a changed, inserted or removed record breaks the chain.
+type Record struct {
+	Sequence      uint64     `json:"sequence"`
+	Type          string     `json:"type"`
+	Time          time.Time  `json:"time"`
+	Integration   string     `json:"integration,omitempty"`
+	MessageId     string     `json:"messageId,omitempty"`
+	RequestId     string     `json:"requestId,omitempty"`
+	EntityId      string     `json:"entityId,omitempty"`
+	EntityType    string     `json:"entityType,omitempty"`
+	Action        string     `json:"action,omitempty"`
+	ActionType    string     `json:"actionType,omitempty"`
+	Command       []string   `json:"command,omitempty"`
+	PayloadSha256 string     `json:"payloadSha256,omitempty"`
+	SourceType    string     `json:"sourceType,omitempty"`
+	ScriptSha256  string     `json:"scriptSha256,omitempty"`
+	GitUrl        string     `json:"gitUrl,omitempty"`
+	GitCommit     string     `json:"gitCommit,omitempty"`
+	RunAsUser     string     `json:"runAsUser,omitempty"`
+	StartedAt     *time.Time `json:"startedAt,omitempty"`
+	EndedAt       *time.Time `json:"endedAt,omitempty"`
+	ExitCode      *int       `json:"exitCode,omitempty"`
+	CaptureFile   string     `json:"captureFile,omitempty"`
+	IsSuccessful  bool       `json:"isSuccessful"`
+	Error         string     `json:"error,omitempty"`
+	ResultStatus  string     `json:"resultStatus,omitempty"`
+	Attempts      int        `json:"attempts,omitempty"`
+	PreviousHash  string     `json:"previousHash"`
+	Hash          string     `json:"hash"`
+}
+
+// Log appends the records to a JSON lines file, chaining each record to the previous one by its hash.
+type Log struct {
+	path     string
+	file     *os.File
+	sequence uint64
+	lastHash string
+	mu       *sync.Mutex
+}
+
+// Open opens the audit log to append records after the last one in the file.
+func Open(path string) (*Log, error) {
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, errors.Errorf("Audit log directory[%s] could not be created: %s", filepath.Dir(path), err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Errorf("Audit log[%s] could not be opened: %s", path, err)
+	}
+
+	last, err := lastRecord(file)
+	if err != nil {
+		file.Close()
+		return nil, errors.Errorf("Audit log[%s] could not be continued: %s", path, err)
+	}
+
+	log := &Log{
+		path: path,
+		file: file,
+		mu:   &sync.Mutex{},
+	}
+	if last != nil {
+		log.sequence = last.Sequence
+		log.lastHash = last.Hash
+	}
+	return log, nil
+}
+
+func lastRecord(file *os.File) (*Record, error) {
+	var last []byte
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+
+	record := &Record{}
+	err := json.Unmarshal(last, record)
+	if err != nil {
+		return nil, errors.Errorf("Last record could not be parsed: %s", err)
+	}
+	return record, nil
+}
+
+// Append chains the record to the previous one and writes it to the file.
+func (l *Log) Append(record *Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record.Sequence = l.sequence + 1
+	record.Time = time.Now().UTC()
+	record.PreviousHash = l.lastHash
+	record.StartedAt = utc(record.StartedAt)
+	record.EndedAt = utc(record.EndedAt)
+
+	line, err := encode(record)
+	if err != nil {
+		return errors.Errorf("Audit record could not be encoded: %s", err)
+	}
+
+	_, err = l.file.Write(append(line, '\n'))
+	if err != nil {
+		return errors.Errorf("Audit record could not be written to audit log[%s]: %s", l.path, err)
+	}
+	err = l.file.Sync()
+	if err != nil {
+		return errors.Errorf("Audit log[%s] could not be synced: %s", l.path, err)
+	}
+
+	l.sequence = record.Sequence
+	l.lastHash = record.Hash
+	return nil
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+// encode sets the hash of the record, which is computed over its encoding without the hash, and returns its line.
+func encode(record *Record) ([]byte, error) {
+	record.Hash = ""
+	unhashed, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(unhashed)
+	record.Hash = hex.EncodeToString(sum[:])
+	return json.Marshal(record)
+}
+
+/******************************************************************************************/
+
+var (
+	current   *Log
+	currentMu = &sync.RWMutex{}
+
+	runAsUser     string
+	runAsUserOnce = &sync.Once{}
+)
+
+// Configure opens the configured audit log, which the records are written to until Close is called.
+// Records are not written unless the audit log is enabled.
+func Configure(auditConf *conf.AuditConf) error {
+
+	if !auditConf.Enabled {
+		return nil
+	}
+
+	if auditConf.Filepath == "" {
+		defaultFilepath, err := DefaultFilepath()
+		if err != nil {
+			return err
+		}
+		auditConf.Filepath = defaultFilepath
+		logrus.Infof("Audit log filepath is not set, default filepath[%s] is set.", auditConf.Filepath)
+	}
+
+	log, err := Open(auditConf.Filepath)
+	if err != nil {
+		return err
+	}
+
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = log
+	return nil
+}
+
+// DefaultFilepath returns the filepath of the audit log if it is not configured.
+func DefaultFilepath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, "oec", "audit", "audit.jsonl"), nil
+}
+
+func Close() error {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+
+	if current == nil {
+		return nil
+	}
+	err := current.Close()
+	current = nil
+	return err
+}
+
+func IsEnabled() bool {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current != nil
+}
+
+// Write appends the record to the configured audit log. It does nothing if the audit log is not enabled.
+func Write(record *Record) {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+
+	if current == nil {
+		return
+	}
+
+	record.Error = redaction.String(record.Error)
+	for i, arg := range record.Command {
+		record.Command[i] = redaction.String(arg)
+	}
+
+	err := current.Append(record)
+	if err != nil {
+		logrus.Errorf("Audit record of message[%s] could not be written: %s", record.MessageId, err)
+	}
+}
+
+// WriteResult appends a result record, which tells whether the result of the message is sent to Opsgenie.
+func WriteResult(integration, messageId string, result *runbook.ActionResultPayload, status string, attempts int, err error) {
+	if !IsEnabled() {
+		return
+	}
+
+	record := &Record{
+		Type:         ResultRecord,
+		Integration:  integration,
+		MessageId:    messageId,
+		RequestId:    result.RequestId,
+		EntityId:     result.EntityId,
+		EntityType:   result.EntityType,
+		Action:       result.Action,
+		ActionType:   result.ActionType,
+		IsSuccessful: result.IsSuccessful,
+		ResultStatus: status,
+		Attempts:     attempts,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	Write(record)
+}
+
+// RunAsUser returns the user the actions are run as, which is the user running OEC.
+func RunAsUser() string {
+	runAsUserOnce.Do(func() {
+		if current, err := user.Current(); err == nil {
+			runAsUser = current.Username
+		} else {
+			logrus.Warnf("User running the actions could not be found: %s", err)
+		}
+	})
+	return runAsUser
+}
+
+// FileSha256 returns the hash of the content of the file.
+func FileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,170 @@
+package audit
+
+import (
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/runbook"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func newLogTest(t *testing.T) (string, func()) {
+	directory, err := ioutil.TempDir("", "oecAudit")
+	assert.Nil(t, err)
+	return filepath.Join(directory, "audit", "audit.jsonl"), func() {
+		os.RemoveAll(directory)
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func verifyLines(lines []string) (int, error) {
+	return Verify(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+}
+
+func TestAppendChainsRecords(t *testing.T) {
+	path, cleanup := newLogTest(t)
+	defer cleanup()
+
+	log, err := Open(path)
+	assert.Nil(t, err)
+
+	startedAt := time.Now()
+	exitCode := 2
+	first := &Record{Type: ExecutionRecord, MessageId: "first", StartedAt: &startedAt, ExitCode: &exitCode}
+	assert.Nil(t, log.Append(first))
+	second := &Record{Type: ResultRecord, MessageId: "first", ResultStatus: ResultSent}
+	assert.Nil(t, log.Append(second))
+	assert.Nil(t, log.Close())
+
+	assert.Equal(t, uint64(1), first.Sequence)
+	assert.Equal(t, "", first.PreviousHash)
+	assert.Len(t, first.Hash, 64)
+	assert.Equal(t, uint64(2), second.Sequence)
+	assert.Equal(t, first.Hash, second.PreviousHash)
+
+	log, err = Open(path)
+	assert.Nil(t, err)
+	third := &Record{Type: ExecutionRecord, MessageId: "second"}
+	assert.Nil(t, log.Append(third))
+	assert.Nil(t, log.Close())
+
+	assert.Equal(t, uint64(3), third.Sequence)
+	assert.Equal(t, second.Hash, third.PreviousHash)
+
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	count, err := Verify(file)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestOpenFailsIfLastRecordIsCorrupt(t *testing.T) {
+	path, cleanup := newLogTest(t)
+	defer cleanup()
+
+	log, err := Open(path)
+	assert.Nil(t, err)
+	assert.Nil(t, log.Append(&Record{Type: ExecutionRecord}))
+	assert.Nil(t, log.Close())
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	assert.Nil(t, err)
+	file.WriteString(`{"sequence":2,"type":"exec`)
+	file.Close()
+
+	_, err = Open(path)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "could not be continued")
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	path, cleanup := newLogTest(t)
+	defer cleanup()
+
+	log, err := Open(path)
+	assert.Nil(t, err)
+	for _, messageId := range []string{"first", "second", "third"} {
+		assert.Nil(t, log.Append(&Record{Type: ExecutionRecord, MessageId: messageId, IsSuccessful: true}))
+	}
+	assert.Nil(t, log.Close())
+
+	lines := readLines(t, path)
+
+	count, err := verifyLines(lines)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+
+	modified := append([]string{}, lines...)
+	modified[1] = strings.Replace(modified[1], `"isSuccessful":true`, `"isSuccessful":false`, 1)
+	count, err = verifyLines(modified)
+	assert.EqualError(t, err, "Record[2] at line[2] is modified, its hash does not match its content.")
+	assert.Equal(t, 1, count)
+
+	count, err = verifyLines([]string{lines[0], lines[2]})
+	assert.EqualError(t, err, "Record[3] at line[2] does not follow record[1].")
+	assert.Equal(t, 1, count)
+
+	_, err = verifyLines(lines[1:])
+	assert.EqualError(t, err, "Record[2] at line[1] is not the first record of the audit log.")
+
+	rehashed := &Record{Sequence: 2, Type: ExecutionRecord, MessageId: "inserted", PreviousHash: strings.Repeat("0", 64)}
+	line, err := encode(rehashed)
+	assert.Nil(t, err)
+	_, err = verifyLines([]string{lines[0], string(line)})
+	assert.EqualError(t, err, "Record[2] at line[2] is not chained to record[1].")
+
+	_, err = verifyLines([]string{lines[0], "not json"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Record at line[2] could not be parsed")
+}
+
+func TestWriteIsNoOpIfNotEnabled(t *testing.T) {
+	assert.Nil(t, Configure(&conf.AuditConf{}))
+	assert.False(t, IsEnabled())
+
+	Write(&Record{Type: ExecutionRecord})
+	WriteResult("", "messageId", &runbook.ActionResultPayload{}, ResultSent, 1, nil)
+	assert.Nil(t, Close())
+}
+
+func TestWriteResult(t *testing.T) {
+	path, cleanup := newLogTest(t)
+	defer cleanup()
+
+	assert.Nil(t, Configure(&conf.AuditConf{Enabled: true, Filepath: path}))
+	assert.True(t, IsEnabled())
+
+	result := &runbook.ActionResultPayload{RequestId: "requestId", EntityId: "entityId", Action: "Create", IsSuccessful: true}
+	WriteResult("first", "messageId", result, ResultDropped, 3, errors.New("Test error"))
+	assert.Nil(t, Close())
+	assert.False(t, IsEnabled())
+
+	lines := readLines(t, path)
+	assert.Len(t, lines, 1)
+
+	content := lines[0]
+	assert.Contains(t, content, `"type":"result"`)
+	assert.Contains(t, content, `"integration":"first"`)
+	assert.Contains(t, content, `"requestId":"requestId"`)
+	assert.Contains(t, content, `"resultStatus":"dropped"`)
+	assert.Contains(t, content, `"attempts":3`)
+	assert.Contains(t, content, `"error":"Test error"`)
+}
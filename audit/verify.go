@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+)
+
+const maxLineLength = 1024 * 1024
+
+// Verify checks that every record in the audit log is unchanged and chained to the one before it, and returns
+// the number of the records.
+func Verify(reader io.Reader) (int, error) {
+
+	var previous *Record
+	count := 0
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		record := &Record{}
+		err := json.Unmarshal(line, record)
+		if err != nil {
+			return count, errors.Errorf("Record at line[%d] could not be parsed: %s", lineNumber, err)
+		}
+
+		hash := record.Hash
+		encoded, err := encode(record)
+		if err != nil {
+			return count, errors.Errorf("Record at line[%d] could not be encoded: %s", lineNumber, err)
+		}
+		if hash != record.Hash || !bytes.Equal(encoded, line) {
+			return count, errors.Errorf("Record[%d] at line[%d] is modified, its hash does not match its content.", record.Sequence, lineNumber)
+		}
+
+		if previous == nil {
+			if record.Sequence != 1 || record.PreviousHash != "" {
+				return count, errors.Errorf("Record[%d] at line[%d] is not the first record of the audit log.", record.Sequence, lineNumber)
+			}
+		} else {
+			if record.Sequence != previous.Sequence+1 {
+				return count, errors.Errorf("Record[%d] at line[%d] does not follow record[%d].", record.Sequence, lineNumber, previous.Sequence)
+			}
+			if record.PreviousHash != previous.Hash {
+				return count, errors.Errorf("Record[%d] at line[%d] is not chained to record[%d].", record.Sequence, lineNumber, previous.Sequence)
+			}
+		}
+
+		previous = record
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, errors.Errorf("Audit log could not be read: %s", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,63 @@
+package command
+
+import (
+	"fmt"
+	"github.com/opsgenie/oec/audit"
+	"github.com/opsgenie/oec/conf"
+	"github.com/pkg/errors"
+	"os"
+)
+
+const auditUsage = "audit verify [-file path]"
+
+func Audit(args []string) error {
+
+	if len(args) == 0 {
+		newFlagSet("audit", auditUsage).Usage()
+		return errors.New("Audit command is not specified.")
+	}
+
+	switch args[0] {
+	case "verify":
+		return verifyAuditLog(args[1:])
+	default:
+		newFlagSet("audit", auditUsage).Usage()
+		return errors.Errorf("Unknown audit command[%s].", args[0])
+	}
+}
+
+func verifyAuditLog(args []string) error {
+	flagSet := newFlagSet("audit verify", auditUsage)
+	path := flagSet.String("file", "", "Audit log to verify, the one in the configuration is verified if it is not given.")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	if *path == "" {
+		configuration, err := conf.Read()
+		if err != nil {
+			return errors.Errorf("Could not read configuration: %s", err)
+		}
+		*path = configuration.AuditConf.Filepath
+		if *path == "" {
+			*path, err = audit.DefaultFilepath()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	file, err := os.Open(*path)
+	if err != nil {
+		return errors.Errorf("Audit log[%s] could not be opened: %s", *path, err)
+	}
+	defer file.Close()
+
+	count, err := audit.Verify(file)
+	if err != nil {
+		return errors.Errorf("Audit log[%s] is not valid after %d records: %s", *path, count, err)
+	}
+
+	fmt.Fprintf(output, "Audit log[%s] is verified, %d records are chained.\n", *path, count)
+	return nil
+}
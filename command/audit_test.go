@@ -0,0 +1,50 @@
+package command
+
+import (
+	"bytes"
+	"github.com/opsgenie/oec/audit"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyAuditLog(t *testing.T) {
+	directory, err := ioutil.TempDir("", "oecAudit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	path := filepath.Join(directory, "audit.jsonl")
+	log, err := audit.Open(path)
+	assert.Nil(t, err)
+	for _, messageId := range []string{"first", "second"} {
+		assert.Nil(t, log.Append(&audit.Record{Type: audit.ExecutionRecord, MessageId: messageId}))
+	}
+	assert.Nil(t, log.Close())
+
+	buffer := &bytes.Buffer{}
+	output = buffer
+	defer func() { output = os.Stdout }()
+
+	err = Run("audit", []string{"verify", "-file", path})
+	assert.Nil(t, err)
+	assert.Equal(t, "Audit log["+path+"] is verified, 2 records are chained.\n", buffer.String())
+
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	tampered := strings.Replace(string(content), `"messageId":"second"`, `"messageId":"third"`, 1)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(tampered), 0600))
+
+	err = Run("audit", []string{"verify", "-file", path})
+	assert.EqualError(t, err, "Audit log["+path+"] is not valid after 1 records: Record[2] at line[2] is modified, its hash does not match its content.")
+}
+
+func TestAuditCommandIsNotSpecified(t *testing.T) {
+	output = &bytes.Buffer{}
+	defer func() { output = os.Stdout }()
+
+	assert.EqualError(t, Run("audit", nil), "Audit command is not specified.")
+	assert.EqualError(t, Run("audit", []string{"rotate"}), "Unknown audit command[rotate].")
+}
@@ -0,0 +1,50 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+type Command func(args []string) error
+
+var commands = map[string]Command{
+	"audit":   Audit,
+	"ctl":     Ctl,
+	"dlq":     DeadLetter,
+	"history": History,
+}
+
+var output io.Writer = os.Stdout
+
+func Exists(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+func Run(name string, args []string) error {
+	command, ok := commands[name]
+	if !ok {
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return errors.Errorf("Unknown command[%s], valid commands are: %s.", name, strings.Join(names, ", "))
+	}
+	return command(args)
+}
+
+func newFlagSet(name, usage string) *flag.FlagSet {
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagSet.SetOutput(output)
+	flagSet.Usage = func() {
+		fmt.Fprintf(output, "Usage: oec %s\n", usage)
+		flagSet.PrintDefaults()
+	}
+	return flagSet
+}
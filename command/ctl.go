@@ -0,0 +1,305 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/opsgenie/oec/admin"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/git"
+	"github.com/opsgenie/oec/queue"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"net/url"
+	"text/tabwriter"
+	"time"
+)
+
+const ctlUsage = "ctl <pollers|pause|resume|pull|reload|log-level|jobs|cancel> [options]"
+
+const adminRequestTimeout = 2 * time.Minute
+
+var newAdminClientFunc = newAdminClient
+
+type adminClient struct {
+	baseUrl    string
+	token      string
+	httpClient *http.Client
+}
+
+func Ctl(args []string) error {
+
+	if len(args) == 0 {
+		newFlagSet("ctl", ctlUsage).Usage()
+		return errors.New("Ctl command is not specified.")
+	}
+
+	switch args[0] {
+	case "pollers":
+		return listPollers(args[1:])
+	case "pause":
+		return changePollers(args[1:], "pause")
+	case "resume":
+		return changePollers(args[1:], "resume")
+	case "pull":
+		return pullRepositories(args[1:])
+	case "reload":
+		return reloadConfiguration(args[1:])
+	case "log-level":
+		return changeLogLevel(args[1:])
+	case "jobs":
+		return listJobs(args[1:])
+	case "cancel":
+		return cancelJob(args[1:])
+	default:
+		newFlagSet("ctl", ctlUsage).Usage()
+		return errors.Errorf("Unknown ctl command[%s].", args[0])
+	}
+}
+
+func listPollers(args []string) error {
+	flagSet := newFlagSet("ctl pollers", "ctl pollers [options]")
+	client, err := parseCtlFlags(flagSet, args)
+	if err != nil {
+		return err
+	}
+
+	pollers := make([]admin.Poller, 0)
+	if err := client.do(http.MethodGet, "/pollers", nil, &pollers); err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "INTEGRATION\tQUEUE URL\tRUNNING\tPAUSED\tCIRCUIT\tCREDENTIALS EXPIRE AT")
+	for _, poller := range pollers {
+		fmt.Fprintf(writer, "%s\t%s\t%t\t%t\t%s\t%s\n", poller.Integration, poller.QueueUrl,
+			poller.IsRunning, poller.IsPaused, poller.CircuitState, poller.CredentialsExpireAt.Format(time.RFC3339))
+	}
+	return writer.Flush()
+}
+
+func changePollers(args []string, action string) error {
+	flagSet := newFlagSet("ctl "+action, "ctl "+action+" [-integration name] [-queueUrl url] [options]")
+	integration := flagSet.String("integration", "", "Name of the integration whose pollers are changed, all integrations if not given.")
+	queueUrl := flagSet.String("queueUrl", "", "Queue url of the poller which is changed, all pollers if not given.")
+	client, err := parseCtlFlags(flagSet, args)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if *integration != "" {
+		query.Set("integration", *integration)
+	}
+	if *queueUrl != "" {
+		query.Set("queueUrl", *queueUrl)
+	}
+
+	response := struct {
+		Count int `json:"count"`
+	}{}
+	if err := client.do(http.MethodPost, "/pollers/"+action, query, &response); err != nil {
+		return err
+	}
+
+	if action == "pause" {
+		fmt.Fprintf(output, "%d pollers are paused.\n", response.Count)
+	} else {
+		fmt.Fprintf(output, "%d pollers are resumed.\n", response.Count)
+	}
+	return nil
+}
+
+func pullRepositories(args []string) error {
+	flagSet := newFlagSet("ctl pull", "ctl pull [options]")
+	client, err := parseCtlFlags(flagSet, args)
+	if err != nil {
+		return err
+	}
+
+	repositories := make([]git.RepositoryStatus, 0)
+	if err := client.do(http.MethodPost, "/repositories/pull", nil, &repositories); err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "URL\tUPDATED AT\tERROR")
+	for _, repository := range repositories {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", repository.Url, repository.UpdatedAt.Format(time.RFC3339), repository.LastPullError)
+	}
+	return writer.Flush()
+}
+
+func reloadConfiguration(args []string) error {
+	flagSet := newFlagSet("ctl reload", "ctl reload [options]")
+	client, err := parseCtlFlags(flagSet, args)
+	if err != nil {
+		return err
+	}
+
+	if err := client.do(http.MethodPost, "/config/reload", nil, nil); err != nil {
+		return err
+	}
+	fmt.Fprintln(output, "Configuration is reloaded.")
+	return nil
+}
+
+func changeLogLevel(args []string) error {
+	flagSet := newFlagSet("ctl log-level", "ctl log-level [-duration 15m] [options] [level]")
+	duration := flagSet.Duration("duration", 0, "Duration of the level, after which the level before is set again. The level is kept if not given.")
+	client, err := parseCtlFlags(flagSet, args)
+	if err != nil {
+		return err
+	}
+
+	method, query := http.MethodGet, url.Values(nil)
+	if flagSet.NArg() > 0 {
+		method, query = http.MethodPost, url.Values{"level": {flagSet.Arg(0)}}
+		if *duration > 0 {
+			query.Set("duration", duration.String())
+		}
+	}
+
+	response := struct {
+		Level     string     `json:"level"`
+		RevertsAt *time.Time `json:"revertsAt"`
+	}{}
+	if err := client.do(method, "/log-level", query, &response); err != nil {
+		return err
+	}
+	if response.RevertsAt != nil {
+		fmt.Fprintf(output, "Log level is %s until %s.\n", response.Level, response.RevertsAt.Format(time.RFC3339))
+		return nil
+	}
+	fmt.Fprintf(output, "Log level is %s.\n", response.Level)
+	return nil
+}
+
+func listJobs(args []string) error {
+	flagSet := newFlagSet("ctl jobs", "ctl jobs [options]")
+	client, err := parseCtlFlags(flagSet, args)
+	if err != nil {
+		return err
+	}
+
+	jobs := make([]queue.JobStatus, 0)
+	if err := client.do(http.MethodGet, "/jobs", nil, &jobs); err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tINTEGRATION\tSTARTED AT\tELAPSED")
+	for _, job := range jobs {
+		elapsed := time.Duration(job.ElapsedInSeconds * float64(time.Second)).Round(time.Second)
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", job.Id, job.Integration, job.StartedAt.Format(time.RFC3339), elapsed)
+	}
+	return writer.Flush()
+}
+
+func cancelJob(args []string) error {
+	flagSet := newFlagSet("ctl cancel", "ctl cancel [options] id")
+	client, err := parseCtlFlags(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if flagSet.NArg() != 1 {
+		return errors.New("Id of the job should be given.")
+	}
+
+	id := flagSet.Arg(0)
+	if err := client.do(http.MethodPost, "/jobs/cancel", url.Values{"id": {id}}, nil); err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "Job[%s] is cancelled.\n", id)
+	return nil
+}
+
+func parseCtlFlags(flagSet *flag.FlagSet, args []string) (*adminClient, error) {
+	address := flagSet.String("address", "", "Address of the admin API, read from the configuration if not given.")
+	socketPath := flagSet.String("socket", "", "Unix socket of the admin API, read from the configuration if not given.")
+	token := flagSet.String("token", "", "Token of the admin API, read from the configuration if not given.")
+	if err := flagSet.Parse(args); err != nil {
+		return nil, err
+	}
+
+	adminConf := &conf.AdminConf{Address: *address, SocketPath: *socketPath, Token: *token}
+	if adminConf.Address == "" && adminConf.SocketPath == "" {
+		configuration, err := conf.Read()
+		if err != nil {
+			return nil, errors.Errorf("Could not read configuration: %s", err)
+		}
+		adminConf.Address = configuration.AdminConf.Address
+		adminConf.SocketPath = configuration.AdminConf.SocketPath
+		if adminConf.Token == "" {
+			adminConf.Token = configuration.AdminConf.Token
+		}
+	}
+
+	return newAdminClientFunc(adminConf), nil
+}
+
+func newAdminClient(adminConf *conf.AdminConf) *adminClient {
+
+	client := &adminClient{
+		token:      adminConf.Token,
+		httpClient: &http.Client{Timeout: adminRequestTimeout},
+	}
+
+	switch {
+	case adminConf.SocketPath != "":
+		socketPath := adminConf.SocketPath
+		client.baseUrl = "http://unix"
+		client.httpClient.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		}
+	case adminConf.Address != "":
+		client.baseUrl = "http://" + adminConf.Address
+	default:
+		client.baseUrl = "http://" + conf.DefaultAdminAddress
+	}
+	return client
+}
+
+func (c *adminClient) do(method, path string, query url.Values, response interface{}) error {
+
+	requestUrl := c.baseUrl + path
+	if len(query) > 0 {
+		requestUrl += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequest(method, requestUrl, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	httpResponse, err := c.httpClient.Do(request)
+	if err != nil {
+		return errors.Errorf("Admin API could not be reached: %s", err)
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		failure := struct {
+			Error string `json:"error"`
+		}{}
+		if err := json.NewDecoder(httpResponse.Body).Decode(&failure); err != nil || failure.Error == "" {
+			return errors.Errorf("Admin API returned %s.", httpResponse.Status)
+		}
+		return errors.New(failure.Error)
+	}
+
+	if response == nil {
+		return nil
+	}
+	if err := json.NewDecoder(httpResponse.Body).Decode(response); err != nil {
+		return errors.Errorf("Response of admin API could not be read: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package command
+
+import (
+	"bytes"
+	"github.com/opsgenie/oec/conf"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func setupCtlTest(t *testing.T, handler http.HandlerFunc) (*bytes.Buffer, func()) {
+	server := httptest.NewServer(handler)
+
+	newAdminClientFunc = func(adminConf *conf.AdminConf) *adminClient {
+		client := newAdminClient(adminConf)
+		client.baseUrl = server.URL
+		return client
+	}
+
+	buffer := &bytes.Buffer{}
+	output = buffer
+
+	return buffer, func() {
+		newAdminClientFunc = newAdminClient
+		output = os.Stdout
+		server.Close()
+	}
+}
+
+func TestCtlUnknownCommand(t *testing.T) {
+	_, teardown := setupCtlTest(t, nil)
+	defer teardown()
+
+	err := Run("ctl", []string{"unknown"})
+	assert.EqualError(t, err, "Unknown ctl command[unknown].")
+}
+
+func TestCtlListPollers(t *testing.T) {
+	buffer, teardown := setupCtlTest(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/pollers", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		w.Write([]byte(`[{"integration":"first","queueUrl":"queueUrl1","isRunning":true,"isPaused":true,"circuitState":"closed"}]`))
+	})
+	defer teardown()
+
+	err := Run("ctl", []string{"pollers", "-address", "127.0.0.1:7071", "-token", "token"})
+	assert.Nil(t, err)
+	assert.Contains(t, buffer.String(), "first")
+	assert.Contains(t, buffer.String(), "queueUrl1")
+	assert.Contains(t, buffer.String(), "true")
+}
+
+func TestCtlPausePollers(t *testing.T) {
+	buffer, teardown := setupCtlTest(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/pollers/pause", r.URL.Path)
+		assert.Equal(t, "first", r.URL.Query().Get("integration"))
+		w.Write([]byte(`{"count":2}`))
+	})
+	defer teardown()
+
+	err := Run("ctl", []string{"pause", "-address", "127.0.0.1:7071", "-integration", "first"})
+	assert.Nil(t, err)
+	assert.Equal(t, "2 pollers are paused.\n", buffer.String())
+}
+
+func TestCtlChangeLogLevel(t *testing.T) {
+	buffer, teardown := setupCtlTest(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "debug", r.URL.Query().Get("level"))
+		w.Write([]byte(`{"level":"debug"}`))
+	})
+	defer teardown()
+
+	err := Run("ctl", []string{"log-level", "-address", "127.0.0.1:7071", "debug"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Log level is debug.\n", buffer.String())
+}
+
+func TestCtlChangeLogLevelTemporarily(t *testing.T) {
+	buffer, teardown := setupCtlTest(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "debug", r.URL.Query().Get("level"))
+		assert.Equal(t, "15m0s", r.URL.Query().Get("duration"))
+		w.Write([]byte(`{"level":"debug","revertsAt":"2026-01-01T12:15:00Z"}`))
+	})
+	defer teardown()
+
+	err := Run("ctl", []string{"log-level", "-address", "127.0.0.1:7071", "-duration", "15m", "debug"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Log level is debug until 2026-01-01T12:15:00Z.\n", buffer.String())
+}
+
+func TestCtlListJobs(t *testing.T) {
+	buffer, teardown := setupCtlTest(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id":"jobId","integration":"first","startedAt":"2026-01-01T12:00:00Z","elapsedInSeconds":90.4}]`))
+	})
+	defer teardown()
+
+	err := Run("ctl", []string{"jobs", "-address", "127.0.0.1:7071"})
+	assert.Nil(t, err)
+	assert.Contains(t, buffer.String(), "jobId")
+	assert.Contains(t, buffer.String(), "1m30s")
+}
+
+func TestCtlCancelJobReturnsApiError(t *testing.T) {
+	_, teardown := setupCtlTest(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "unknown", r.URL.Query().Get("id"))
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"Job[unknown] is not running."}`))
+	})
+	defer teardown()
+
+	err := Run("ctl", []string{"cancel", "-address", "127.0.0.1:7071", "unknown"})
+	assert.EqualError(t, err, "Job[unknown] is not running.")
+
+	err = Run("ctl", []string{"cancel", "-address", "127.0.0.1:7071"})
+	assert.EqualError(t, err, "Id of the job should be given.")
+}
+
+func TestNewAdminClient(t *testing.T) {
+
+	client := newAdminClient(&conf.AdminConf{})
+	assert.Equal(t, "http://"+conf.DefaultAdminAddress, client.baseUrl)
+
+	client = newAdminClient(&conf.AdminConf{Address: "127.0.0.1:7072", SocketPath: "/tmp/oec.sock"})
+	assert.True(t, strings.HasPrefix(client.baseUrl, "http://unix"))
+	assert.NotNil(t, client.httpClient.Transport)
+}
@@ -0,0 +1,288 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/deadletter"
+	"github.com/opsgenie/oec/git"
+	"github.com/opsgenie/oec/network"
+	"github.com/opsgenie/oec/queue"
+	"github.com/opsgenie/oec/redaction"
+	"github.com/opsgenie/oec/runbook"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"text/tabwriter"
+	"time"
+)
+
+const deadLetterUsage = "dlq <list|show|replay|purge> [options] [id...]"
+
+const maxErrorLengthInList = 80
+
+var newDeadLetterStoreFunc = newDeadLetterStore
+var newReplayHandlerFunc = newReplayHandler
+
+func DeadLetter(args []string) error {
+
+	if len(args) == 0 {
+		newFlagSet("dlq", deadLetterUsage).Usage()
+		return errors.New("Dead letter command is not specified.")
+	}
+
+	switch args[0] {
+	case "list":
+		return listDeadLetters(args[1:])
+	case "show":
+		return showDeadLetters(args[1:])
+	case "replay":
+		return replayDeadLetters(args[1:])
+	case "purge":
+		return purgeDeadLetters(args[1:])
+	default:
+		newFlagSet("dlq", deadLetterUsage).Usage()
+		return errors.Errorf("Unknown dead letter command[%s].", args[0])
+	}
+}
+
+func listDeadLetters(args []string) error {
+	flagSet := newFlagSet("dlq list", "dlq list")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	store, _, err := newDeadLetterStoreFunc()
+	if err != nil {
+		return err
+	}
+
+	entries, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tFAILED AT\tACTION\tENTITY ID\tREPLAYS\tERROR")
+	for _, entry := range entries {
+		failure := entry.Error
+		if len(failure) > maxErrorLengthInList {
+			failure = failure[:maxErrorLengthInList] + "..."
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			entry.Id, entry.FailedAt.Format(time.RFC3339), entry.Action, entry.EntityId, entry.ReplayCount, failure)
+	}
+	return writer.Flush()
+}
+
+func showDeadLetters(args []string) error {
+	flagSet := newFlagSet("dlq show", "dlq show id...")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() == 0 {
+		return errors.New("At least one dead letter id should be given.")
+	}
+
+	store, _, err := newDeadLetterStoreFunc()
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	for _, id := range flagSet.Args() {
+		entry, err := store.Get(id)
+		if err != nil {
+			return err
+		}
+		// bodies are stored as they are received to be replayed, they are redacted only to be shown
+		entry.Body = redaction.Json(entry.Body)
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replayDeadLetters(args []string) error {
+	flagSet := newFlagSet("dlq replay", "dlq replay [-all] [-skipResult] [id...]")
+	all := flagSet.Bool("all", false, "Replay all dead letters.")
+	skipResult := flagSet.Bool("skipResult", false, "Do not send the results of replayed actions to Opsgenie.")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	store, configuration, err := newDeadLetterStoreFunc()
+	if err != nil {
+		return err
+	}
+
+	entries, err := selectDeadLetters(store, *all, flagSet.Args())
+	if err != nil {
+		return err
+	}
+
+	configurations := configuration.IntegrationConfigurations()
+	messageHandlers := make(map[string]queue.MessageHandler)
+
+	failedCount := 0
+	for _, entry := range entries {
+		integrationConf, err := findIntegration(configurations, entry.Integration)
+		if err != nil {
+			failedCount++
+			fmt.Fprintf(output, "Dead letter[%s] could not be replayed: %s\n", entry.Id, err)
+			continue
+		}
+
+		messageHandler, ok := messageHandlers[integrationConf.IntegrationName]
+		if !ok {
+			var cleanup func()
+			messageHandler, cleanup, err = newReplayHandlerFunc(integrationConf)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			messageHandlers[integrationConf.IntegrationName] = messageHandler
+		}
+
+		result, err := messageHandler.Handle(context.Background(), sqs.Message{MessageId: &entry.MessageId, Body: &entry.Body})
+		if err == nil && !*skipResult {
+			if sendErr := runbook.SendResultToOpsGenieFunc(result, integrationConf.ApiKey, integrationConf.BaseUrl); sendErr != nil {
+				logrus.Warnf("Could not send action result of dead letter[%s] to Opsgenie: %s", entry.Id, sendErr)
+			}
+		}
+
+		if err == nil && result.IsSuccessful {
+			if err := store.Remove(entry.Id); err != nil {
+				return err
+			}
+			fmt.Fprintf(output, "Dead letter[%s] is replayed successfully.\n", entry.Id)
+			continue
+		}
+
+		failedCount++
+		if err != nil {
+			entry.Error = redaction.String(err.Error())
+		} else {
+			entry.Error = result.FailureMessage
+		}
+		replayedAt := time.Now()
+		entry.ReplayCount++
+		entry.LastReplayedAt = &replayedAt
+		if err := store.Put(entry); err != nil {
+			return err
+		}
+		fmt.Fprintf(output, "Dead letter[%s] could not be replayed: %s\n", entry.Id, entry.Error)
+	}
+
+	if failedCount > 0 {
+		return errors.Errorf("%d of %d dead letters could not be replayed.", failedCount, len(entries))
+	}
+	return nil
+}
+
+func purgeDeadLetters(args []string) error {
+	flagSet := newFlagSet("dlq purge", "dlq purge [-all] [id...]")
+	all := flagSet.Bool("all", false, "Purge all dead letters.")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	store, _, err := newDeadLetterStoreFunc()
+	if err != nil {
+		return err
+	}
+
+	if *all {
+		count, err := store.Purge()
+		fmt.Fprintf(output, "%d dead letters are purged.\n", count)
+		return err
+	}
+
+	if flagSet.NArg() == 0 {
+		return errors.New("Dead letter ids or -all flag should be given.")
+	}
+	for _, id := range flagSet.Args() {
+		if err := store.Remove(id); err != nil {
+			return err
+		}
+		fmt.Fprintf(output, "Dead letter[%s] is purged.\n", id)
+	}
+	return nil
+}
+
+// findIntegration returns the configuration of the integration which the dead letter belongs to.
+// Without integrations, all dead letters belong to the configuration itself.
+func findIntegration(configurations []*conf.Configuration, integrationName string) (*conf.Configuration, error) {
+	if len(configurations) == 1 && configurations[0].IntegrationName == "" {
+		return configurations[0], nil
+	}
+	for _, configuration := range configurations {
+		if configuration.IntegrationName == integrationName {
+			return configuration, nil
+		}
+	}
+	return nil, errors.Errorf("Integration[%s] could not be found in the configuration.", integrationName)
+}
+
+func selectDeadLetters(store deadletter.Store, all bool, ids []string) ([]*deadletter.Entry, error) {
+	if all {
+		return store.List()
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("Dead letter ids or -all flag should be given.")
+	}
+
+	entries := make([]*deadletter.Entry, 0, len(ids))
+	for _, id := range ids {
+		entry, err := store.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func newDeadLetterStore() (deadletter.Store, *conf.Configuration, error) {
+	configuration, err := conf.Read()
+	if err != nil {
+		return nil, nil, errors.Errorf("Could not read configuration: %s", err)
+	}
+
+	err = redaction.Configure(configuration)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, err := deadletter.NewStore(&configuration.DeadLetterConf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, configuration, nil
+}
+
+func newReplayHandler(configuration *conf.Configuration) (queue.MessageHandler, func(), error) {
+	err := network.Configure(&configuration.HttpClientConf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repositories := git.NewRepositories()
+
+	err = repositories.DownloadAll(configuration.ActionMappings.GitActions())
+	if err != nil {
+		repositories.RemoveAll()
+		return nil, nil, err
+	}
+	conf.AddRepositoryPathToGitActionFilepaths(configuration.ActionMappings, repositories)
+
+	messageHandler := queue.NewMessageHandler(
+		repositories,
+		configuration.ActionSpecifications,
+		queue.NewActionLoggers(&configuration.LogConf, configuration.ActionMappings),
+	)
+	return messageHandler, repositories.RemoveAll, nil
+}
@@ -0,0 +1,223 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/deadletter"
+	"github.com/opsgenie/oec/queue"
+	"github.com/opsgenie/oec/redaction"
+	"github.com/opsgenie/oec/runbook"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+type mockMessageHandler struct {
+	HandleFunc func(message sqs.Message) (*runbook.ActionResultPayload, error)
+}
+
+func (m *mockMessageHandler) Handle(ctx context.Context, message sqs.Message) (*runbook.ActionResultPayload, error) {
+	return m.HandleFunc(message)
+}
+
+func (m *mockMessageHandler) ActionPriority(action string) (int, bool) {
+	return 0, false
+}
+
+func setupDeadLetterTest(t *testing.T, handleFunc func(message sqs.Message) (*runbook.ActionResultPayload, error)) (deadletter.Store, *bytes.Buffer, func()) {
+	directory, err := ioutil.TempDir("", "oecDeadLetters")
+	assert.Nil(t, err)
+
+	store, err := deadletter.NewStore(&conf.DeadLetterConf{Directory: directory})
+	assert.Nil(t, err)
+
+	configuration := &conf.Configuration{ApiKey: "ApiKey", BaseUrl: "BaseUrl"}
+	newDeadLetterStoreFunc = func() (deadletter.Store, *conf.Configuration, error) {
+		return store, configuration, nil
+	}
+	newReplayHandlerFunc = func(configuration *conf.Configuration) (queue.MessageHandler, func(), error) {
+		return &mockMessageHandler{HandleFunc: handleFunc}, func() {}, nil
+	}
+	runbook.SendResultToOpsGenieFunc = func(resultPayload *runbook.ActionResultPayload, apiKey, baseUrl string) error {
+		return nil
+	}
+
+	buffer := &bytes.Buffer{}
+	output = buffer
+
+	return store, buffer, func() {
+		newDeadLetterStoreFunc = newDeadLetterStore
+		newReplayHandlerFunc = newReplayHandler
+		runbook.SendResultToOpsGenieFunc = runbook.SendResultToOpsGenie
+		output = os.Stdout
+		os.RemoveAll(directory)
+	}
+}
+
+func TestDeadLetterUnknownCommand(t *testing.T) {
+	_, _, teardown := setupDeadLetterTest(t, nil)
+	defer teardown()
+
+	err := Run("dlq", []string{"unknown"})
+	assert.EqualError(t, err, "Unknown dead letter command[unknown].")
+}
+
+func TestDeadLetterList(t *testing.T) {
+	store, buffer, teardown := setupDeadLetterTest(t, nil)
+	defer teardown()
+
+	store.Put(&deadletter.Entry{Id: "entryId", Action: "Create", EntityId: "EntityId", Error: "Process Error"})
+
+	err := Run("dlq", []string{"list"})
+	assert.Nil(t, err)
+	assert.Contains(t, buffer.String(), "entryId")
+	assert.Contains(t, buffer.String(), "Create")
+	assert.Contains(t, buffer.String(), "Process Error")
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	store, _, teardown := setupDeadLetterTest(t, func(message sqs.Message) (*runbook.ActionResultPayload, error) {
+		if *message.MessageId == "failingMessage" {
+			return nil, errors.New("Process Error")
+		}
+		return &runbook.ActionResultPayload{IsSuccessful: true}, nil
+	})
+	defer teardown()
+
+	store.Put(&deadletter.Entry{Id: "succeeding", MessageId: "succeedingMessage"})
+	store.Put(&deadletter.Entry{Id: "failing", MessageId: "failingMessage", Error: "Old Error"})
+
+	sentResultCount := 0
+	runbook.SendResultToOpsGenieFunc = func(resultPayload *runbook.ActionResultPayload, apiKey, baseUrl string) error {
+		sentResultCount++
+		assert.Equal(t, "ApiKey", apiKey)
+		return nil
+	}
+
+	err := Run("dlq", []string{"replay", "-all"})
+	assert.EqualError(t, err, "1 of 2 dead letters could not be replayed.")
+	assert.Equal(t, 1, sentResultCount)
+
+	entries, _ := store.List()
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "failing", entries[0].Id)
+	assert.Equal(t, "Process Error", entries[0].Error)
+	assert.Equal(t, 1, entries[0].ReplayCount)
+	assert.NotNil(t, entries[0].LastReplayedAt)
+}
+
+func TestDeadLetterReplayWithIntegrations(t *testing.T) {
+	store, buffer, teardown := setupDeadLetterTest(t, func(message sqs.Message) (*runbook.ActionResultPayload, error) {
+		return &runbook.ActionResultPayload{IsSuccessful: true}, nil
+	})
+	defer teardown()
+
+	configuration := &conf.Configuration{
+		Integrations: []conf.IntegrationConf{
+			{Name: "first", ApiKey: "FirstApiKey", BaseUrl: "BaseUrl"},
+			{Name: "second", ApiKey: "SecondApiKey", BaseUrl: "BaseUrl"},
+		},
+	}
+	newDeadLetterStoreFunc = func() (deadletter.Store, *conf.Configuration, error) {
+		return store, configuration, nil
+	}
+
+	store.Put(&deadletter.Entry{Id: "second", MessageId: "secondMessage", Integration: "second"})
+	store.Put(&deadletter.Entry{Id: "unknown", MessageId: "unknownMessage", Integration: "unknown"})
+
+	var apiKeys []string
+	runbook.SendResultToOpsGenieFunc = func(resultPayload *runbook.ActionResultPayload, apiKey, baseUrl string) error {
+		apiKeys = append(apiKeys, apiKey)
+		return nil
+	}
+
+	err := Run("dlq", []string{"replay", "-all"})
+	assert.EqualError(t, err, "1 of 2 dead letters could not be replayed.")
+	assert.Equal(t, []string{"SecondApiKey"}, apiKeys)
+	assert.Contains(t, buffer.String(), "Integration[unknown] could not be found in the configuration.")
+
+	entries, _ := store.List()
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "unknown", entries[0].Id)
+}
+
+func TestDeadLetterReplayRunsActionWithOriginalBody(t *testing.T) {
+	store, buffer, teardown := setupDeadLetterTest(t, nil)
+	defer teardown()
+
+	err := redaction.Configure(&conf.Configuration{ApiKey: "ApiKey"})
+	assert.Nil(t, err)
+	defer redaction.Configure(&conf.Configuration{RedactionConf: conf.RedactionConf{Disabled: true}})
+
+	newReplayHandlerFunc = func(configuration *conf.Configuration) (queue.MessageHandler, func(), error) {
+		return queue.NewMessageHandler(nil, conf.ActionSpecifications{
+			ActionMappings: conf.ActionMappings{
+				"Create": conf.MappedAction{SourceType: conf.LocalSourceType, Filepath: "/path/to/create.sh"},
+			},
+		}, nil), func() {}, nil
+	}
+
+	var executedArgs []string
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+		executedArgs = args
+		return nil
+	}
+	defer func() {
+		runbook.ExecuteFunc = runbook.Execute
+	}()
+
+	body := `{"action":"Create","alert":{"details":{"apiKey":"secret-key"}}}`
+	store.Put(&deadletter.Entry{Id: "entryId", MessageId: "MessageId", Body: body})
+
+	err = Run("dlq", []string{"show", "entryId"})
+	assert.Nil(t, err)
+	assert.NotContains(t, buffer.String(), "secret-key")
+	assert.Contains(t, buffer.String(), redaction.Replacement)
+
+	err = Run("dlq", []string{"replay", "entryId"})
+	assert.Nil(t, err)
+	assert.Contains(t, executedArgs, body)
+
+	entries, _ := store.List()
+	assert.Empty(t, entries)
+}
+
+func TestDeadLetterReplayWithoutIds(t *testing.T) {
+	_, _, teardown := setupDeadLetterTest(t, nil)
+	defer teardown()
+
+	err := Run("dlq", []string{"replay"})
+	assert.EqualError(t, err, "Dead letter ids or -all flag should be given.")
+}
+
+func TestDeadLetterPurge(t *testing.T) {
+	store, _, teardown := setupDeadLetterTest(t, nil)
+	defer teardown()
+
+	store.Put(&deadletter.Entry{Id: "first"})
+	store.Put(&deadletter.Entry{Id: "second"})
+	store.Put(&deadletter.Entry{Id: "third"})
+
+	err := Run("dlq", []string{"purge", "first"})
+	assert.Nil(t, err)
+
+	entries, _ := store.List()
+	assert.Equal(t, 2, len(entries))
+
+	err = Run("dlq", []string{"purge", "-all"})
+	assert.Nil(t, err)
+
+	entries, _ = store.List()
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,95 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/history"
+	"github.com/pkg/errors"
+	"text/tabwriter"
+	"time"
+)
+
+const historyUsage = "history [-action <action>] [-entityId <id>] [-requestId <id>] [-integration <name>] [-since <time>] [-until <time>] [-failed] [-limit <n>] [-json]"
+
+var newHistoryStoreFunc = newHistoryStore
+
+// History lists the executions in the history, from the newest to the oldest.
+func History(args []string) error {
+	flagSet := newFlagSet("history", historyUsage)
+	action := flagSet.String("action", "", "List the executions of the action.")
+	entityId := flagSet.String("entityId", "", "List the executions for the entity, such as an alert.")
+	requestId := flagSet.String("requestId", "", "List the executions of the request.")
+	integration := flagSet.String("integration", "", "List the executions of the integration.")
+	since := flagSet.String("since", "", "List the executions started at or after the time, in RFC 3339 format or a duration before now such as 12h.")
+	until := flagSet.String("until", "", "List the executions started before the time, in RFC 3339 format or a duration before now such as 1h.")
+	failedOnly := flagSet.Bool("failed", false, "List only the executions which have not succeeded.")
+	limit := flagSet.Int("limit", 50, "Maximum number of executions to list, 0 lists all.")
+	asJson := flagSet.Bool("json", false, "Print the executions as JSON, with their output excerpts.")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	filter := &history.Filter{
+		Integration: *integration,
+		Action:      *action,
+		EntityId:    *entityId,
+		RequestId:   *requestId,
+		FailedOnly:  *failedOnly,
+		Limit:       *limit,
+	}
+
+	now := time.Now()
+	var err error
+	if *since != "" {
+		if filter.Since, err = history.ParseTime(*since, now); err != nil {
+			return err
+		}
+	}
+	if *until != "" {
+		if filter.Until, err = history.ParseTime(*until, now); err != nil {
+			return err
+		}
+	}
+
+	store, err := newHistoryStoreFunc()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	entries, err := store.Query(filter)
+	if err != nil {
+		return err
+	}
+
+	if *asJson {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "STARTED AT\tDURATION\tACTION\tENTITY ID\tREQUEST ID\tOUTCOME\tFAILURE")
+	for _, entry := range entries {
+		failure := entry.FailureMessage
+		if len(failure) > maxErrorLengthInList {
+			failure = failure[:maxErrorLengthInList] + "..."
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.StartedAt.Format(time.RFC3339), entry.Duration().Round(time.Millisecond),
+			entry.Action, entry.EntityId, entry.RequestId, entry.Outcome, failure)
+	}
+	return writer.Flush()
+}
+
+func newHistoryStore() (*history.Store, error) {
+	configuration, err := conf.Read()
+	if err != nil {
+		return nil, errors.Errorf("Could not read configuration: %s", err)
+	}
+
+	if !configuration.HistoryConf.Enabled {
+		return nil, errors.New("Execution history is not enabled in the configuration.")
+	}
+	return history.Open(&configuration.HistoryConf)
+}
@@ -0,0 +1,83 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/history"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupHistoryTest(t *testing.T) (*bytes.Buffer, func()) {
+	directory, err := ioutil.TempDir("", "oecHistory")
+	assert.Nil(t, err)
+
+	store, err := history.Open(&conf.HistoryConf{Directory: directory})
+	assert.Nil(t, err)
+
+	startedAt := time.Now().Add(-2 * time.Hour)
+	exitCode := 1
+	assert.Nil(t, store.Add(&history.Entry{MessageId: "first", RequestId: "request1", EntityId: "alert1", Action: "Restart",
+		StartedAt: startedAt, EndedAt: startedAt.Add(1500 * time.Millisecond), Outcome: history.Succeeded}))
+	assert.Nil(t, store.Add(&history.Entry{MessageId: "second", RequestId: "request2", EntityId: "alert2", Action: "Restart",
+		StartedAt: startedAt.Add(time.Hour), EndedAt: startedAt.Add(time.Hour + time.Second), Outcome: history.Failed,
+		ExitCode: &exitCode, FailureMessage: "Err: exit status 1", Stderr: "service not found\n"}))
+	assert.Nil(t, store.Close())
+
+	newHistoryStoreFunc = func() (*history.Store, error) {
+		return history.Open(&conf.HistoryConf{Directory: directory})
+	}
+
+	buffer := &bytes.Buffer{}
+	output = buffer
+
+	return buffer, func() {
+		newHistoryStoreFunc = newHistoryStore
+		output = os.Stdout
+		os.RemoveAll(directory)
+	}
+}
+
+func TestListHistory(t *testing.T) {
+	buffer, cleanup := setupHistoryTest(t)
+	defer cleanup()
+
+	err := Run("history", []string{"-action", "Restart"})
+	assert.Nil(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "STARTED AT"))
+	assert.Contains(t, lines[1], "alert2")
+	assert.Contains(t, lines[1], "failure")
+	assert.Contains(t, lines[1], "Err: exit status 1")
+	assert.Contains(t, lines[2], "1.5s")
+	assert.Contains(t, lines[2], "alert1")
+}
+
+func TestListHistoryWithFilters(t *testing.T) {
+	buffer, cleanup := setupHistoryTest(t)
+	defer cleanup()
+
+	err := Run("history", []string{"-failed", "-json"})
+	assert.Nil(t, err)
+
+	entries := make([]*history.Entry, 0)
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), &entries))
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "second", entries[0].MessageId)
+	assert.Equal(t, "service not found\n", entries[0].Stderr)
+
+	buffer.Reset()
+	err = Run("history", []string{"-since", "90m", "-entityId", "alert1"})
+	assert.Nil(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(buffer.String()), "\n"), 1)
+
+	err = Run("history", []string{"-until", "last week"})
+	assert.EqualError(t, err, "Time[last week] should be in RFC 3339 format or a duration such as 12h.")
+}
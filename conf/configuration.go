@@ -12,13 +12,65 @@ import (
 
 type Configuration struct {
 	ActionSpecifications `yaml:",inline"`
-	AppName              string     `json:"appName" yaml:"appName"`
-	ApiKey               string     `json:"apiKey" yaml:"apiKey"`
-	BaseUrl              string     `json:"baseUrl" yaml:"baseUrl"`
-	PollerConf           PollerConf `json:"pollerConf" yaml:"pollerConf"`
-	PoolConf             PoolConf   `json:"poolConf" yaml:"poolConf"`
-	LogLevel             string     `json:"logLevel" yaml:"logLevel"`
+	AppName              string               `json:"appName" yaml:"appName"`
+	ApiKey               string               `json:"apiKey" yaml:"apiKey"`
+	BaseUrl              string               `json:"baseUrl" yaml:"baseUrl"`
+	PollerConf           PollerConf           `json:"pollerConf" yaml:"pollerConf"`
+	PoolConf             PoolConf             `json:"poolConf" yaml:"poolConf"`
+	DeadLetterConf       DeadLetterConf       `json:"deadLetterConf" yaml:"deadLetterConf"`
+	HttpClientConf       HttpClientConf       `json:"httpClientConf" yaml:"httpClientConf"`
+	TokenCacheConf       TokenCacheConf       `json:"tokenCacheConf" yaml:"tokenCacheConf"`
+	ShutdownConf         ShutdownConf         `json:"shutdownConf" yaml:"shutdownConf"`
+	OutboxConf           OutboxConf           `json:"outboxConf" yaml:"outboxConf"`
+	HighAvailabilityConf HighAvailabilityConf `json:"highAvailabilityConf" yaml:"highAvailabilityConf"`
+	HealthConf           HealthConf           `json:"healthConf" yaml:"healthConf"`
+	AdminConf            AdminConf            `json:"adminConf" yaml:"adminConf"`
+	SignalConf           SignalConf           `json:"signalConf" yaml:"signalConf"`
+	TracingConf          TracingConf          `json:"tracingConf" yaml:"tracingConf"`
+	MetricsConf          MetricsConf          `json:"metricsConf" yaml:"metricsConf"`
+	AuditConf            AuditConf            `json:"auditConf" yaml:"auditConf"`
+	HistoryConf          HistoryConf          `json:"historyConf" yaml:"historyConf"`
+	LogConf              LogConf              `json:"logConf" yaml:"logConf"`
+	RedactionConf        RedactionConf        `json:"redactionConf" yaml:"redactionConf"`
+	ActionOutputConf     ActionOutputConf     `json:"actionOutputConf" yaml:"actionOutputConf"`
+	Integrations         []IntegrationConf    `json:"integrations" yaml:"integrations"`
+	LogLevel             string               `json:"logLevel" yaml:"logLevel"`
 	LogrusLevel          logrus.Level
+	IntegrationName      string `json:"-" yaml:"-"`
+}
+
+// IntegrationConf defines an integration processed alongside the others in the same process.
+// Pool limits of the configuration are used if the integration does not define its own.
+type IntegrationConf struct {
+	ActionSpecifications `yaml:",inline"`
+	Name                 string    `json:"name" yaml:"name"`
+	ApiKey               string    `json:"apiKey" yaml:"apiKey"`
+	BaseUrl              string    `json:"baseUrl" yaml:"baseUrl"`
+	PoolConf             *PoolConf `json:"poolConf" yaml:"poolConf"`
+}
+
+// IntegrationConfigurations returns a configuration per integration, sharing the settings which are not
+// integration specific. If no integration is defined, the configuration itself is the only integration.
+func (c *Configuration) IntegrationConfigurations() []*Configuration {
+
+	if len(c.Integrations) == 0 {
+		return []*Configuration{c}
+	}
+
+	configurations := make([]*Configuration, 0, len(c.Integrations))
+	for _, integration := range c.Integrations {
+		configuration := *c
+		configuration.Integrations = nil
+		configuration.IntegrationName = integration.Name
+		configuration.ApiKey = integration.ApiKey
+		configuration.BaseUrl = integration.BaseUrl
+		configuration.ActionSpecifications = integration.ActionSpecifications
+		if integration.PoolConf != nil {
+			configuration.PoolConf = *integration.PoolConf
+		}
+		configurations = append(configurations, &configuration)
+	}
+	return configurations
 }
 
 type ActionSpecifications struct {
@@ -51,6 +103,7 @@ type MappedAction struct {
 	Env        []string    `json:"env" yaml:"env"`
 	Stdout     string      `json:"stdout" yaml:"stdout"`
 	Stderr     string      `json:"stderr" yaml:"stderr"`
+	Priority   string      `json:"priority" yaml:"priority"`
 }
 type httpFields struct {
 	Url     string            `json:"url" yaml:"url"`
@@ -157,9 +210,14 @@ func (f Flags) Args() []string {
 }
 
 type PollerConf struct {
-	PollingWaitIntervalInMillis time.Duration `json:"pollingWaitIntervalInMillis" yaml:"pollingWaitIntervalInMillis"`
-	VisibilityTimeoutInSeconds  int64         `json:"visibilityTimeoutInSeconds" yaml:"visibilityTimeoutInSeconds"`
-	MaxNumberOfMessages         int64         `json:"maxNumberOfMessages" yaml:"maxNumberOfMessages"`
+	PollingWaitIntervalInMillis        time.Duration `json:"pollingWaitIntervalInMillis" yaml:"pollingWaitIntervalInMillis"`
+	VisibilityTimeoutInSeconds         int64         `json:"visibilityTimeoutInSeconds" yaml:"visibilityTimeoutInSeconds"`
+	MaxNumberOfMessages                int64         `json:"maxNumberOfMessages" yaml:"maxNumberOfMessages"`
+	CircuitBreakerThreshold            int           `json:"circuitBreakerThreshold" yaml:"circuitBreakerThreshold"`
+	CircuitBreakerOpenIntervalInMillis time.Duration `json:"circuitBreakerOpenIntervalInMillis" yaml:"circuitBreakerOpenIntervalInMillis"`
+	BatchFlushIntervalInMillis         time.Duration `json:"batchFlushIntervalInMillis" yaml:"batchFlushIntervalInMillis"`
+	CredentialRefreshMarginInSeconds   int64         `json:"credentialRefreshMarginInSeconds" yaml:"credentialRefreshMarginInSeconds"`
+	PrefetchEnabled                    bool          `json:"prefetchEnabled" yaml:"prefetchEnabled"`
 }
 
 type PoolConf struct {
@@ -168,4 +226,275 @@ type PoolConf struct {
 	QueueSize                int32         `json:"queueSize" yaml:"queueSize"`
 	KeepAliveTimeInMillis    time.Duration `json:"keepAliveTimeInMillis" yaml:"keepAliveTimeInMillis"`
 	MonitoringPeriodInMillis time.Duration `json:"monitoringPeriodInMillis" yaml:"monitoringPeriodInMillis"`
+	AgingPeriodInMillis      time.Duration `json:"agingPeriodInMillis" yaml:"agingPeriodInMillis"`
+}
+
+type DeadLetterConf struct {
+	Enabled            bool   `json:"enabled" yaml:"enabled"`
+	Directory          string `json:"directory" yaml:"directory"`
+	MaxNumberOfEntries int    `json:"maxNumberOfEntries" yaml:"maxNumberOfEntries"`
+	RetentionInDays    int    `json:"retentionInDays" yaml:"retentionInDays"`
+}
+
+// OutboxConf defines the outbox, which stores the action results until they are sent to Opsgenie. It is enabled
+// unless Disabled is set; without it the results are tried once and the ones being sent are lost on a crash.
+type OutboxConf struct {
+	Disabled         bool   `json:"disabled" yaml:"disabled"`
+	Directory        string `json:"directory" yaml:"directory"`
+	MaxConcurrency   int    `json:"maxConcurrency" yaml:"maxConcurrency"`
+	RetentionInHours int    `json:"retentionInHours" yaml:"retentionInHours"`
+}
+
+// HealthConf defines the thresholds of the health endpoints. Zero values are replaced with the defaults.
+type HealthConf struct {
+	MaxTokenRefreshFailures   int     `json:"maxTokenRefreshFailures" yaml:"maxTokenRefreshFailures"`
+	MaxRepositoryAgeInSeconds int64   `json:"maxRepositoryAgeInSeconds" yaml:"maxRepositoryAgeInSeconds"`
+	MaxPoolSaturation         float64 `json:"maxPoolSaturation" yaml:"maxPoolSaturation"`
+	MaxOutboxDepth            int     `json:"maxOutboxDepth" yaml:"maxOutboxDepth"`
+}
+
+// AdminConf enables the admin API, which is served on a loopback address or on a unix socket. Requests
+// should have the token as a bearer token, if it is set. The token is required unless a socket path is set.
+type AdminConf struct {
+	Enabled    bool   `json:"enabled" yaml:"enabled"`
+	Address    string `json:"address" yaml:"address"`
+	SocketPath string `json:"socketPath" yaml:"socketPath"`
+	Token      string `json:"token" yaml:"token"`
+}
+
+// SignalConf defines how OEC handles the signals other than the ones stopping it. SIGUSR1 and SIGUSR2 make the logs
+// more or less verbose, for the given duration unless it is zero. SIGHUP reopens the log files, and reloads the
+// configuration if ReloadOnHangup is set. Signals are not handled on Windows.
+type SignalConf struct {
+	LevelDurationInMinutes int  `json:"levelDurationInMinutes" yaml:"levelDurationInMinutes"`
+	ReloadOnHangup         bool `json:"reloadOnHangup" yaml:"reloadOnHangup"`
+}
+
+// LevelDuration returns the duration of the log levels changed by the signals, zero keeps them until changed again.
+func (c SignalConf) LevelDuration() time.Duration {
+	return time.Duration(c.LevelDurationInMinutes) * time.Minute
+}
+
+// TracingConf enables tracing of the messages from their receipt to their result callbacks. Spans are exported
+// to an OTLP/HTTP endpoint, the traces are sampled by the given ratio.
+type TracingConf struct {
+	Enabled               bool              `json:"enabled" yaml:"enabled"`
+	Endpoint              string            `json:"endpoint" yaml:"endpoint"`
+	Headers               map[string]string `json:"headers" yaml:"headers"`
+	ServiceName           string            `json:"serviceName" yaml:"serviceName"`
+	SampleRatio           float64           `json:"sampleRatio" yaml:"sampleRatio"`
+	ExportPeriodInSeconds int64             `json:"exportPeriodInSeconds" yaml:"exportPeriodInSeconds"`
+}
+
+// MetricsConf defines the exporters which push the metrics served on /metrics, for the hosts which could not be
+// scraped. Metric names are prefixed with the given prefix, and the given tags are added to all metrics.
+type MetricsConf struct {
+	Prefix      string            `json:"prefix" yaml:"prefix"`
+	Tags        map[string]string `json:"tags" yaml:"tags"`
+	Statsd      StatsdConf        `json:"statsd" yaml:"statsd"`
+	PushGateway PushGatewayConf   `json:"pushGateway" yaml:"pushGateway"`
+}
+
+// StatsdConf enables pushing the metrics to a StatsD server over UDP. Tags are sent in DogStatsD format if
+// Dogstatsd is set, otherwise they are appended to the metric names.
+type StatsdConf struct {
+	Enabled             bool   `json:"enabled" yaml:"enabled"`
+	Address             string `json:"address" yaml:"address"`
+	Dogstatsd           bool   `json:"dogstatsd" yaml:"dogstatsd"`
+	PushPeriodInSeconds int64  `json:"pushPeriodInSeconds" yaml:"pushPeriodInSeconds"`
+}
+
+// PushGatewayConf enables pushing the metrics to a Prometheus push gateway over HTTP, grouped by the job and
+// the instance.
+type PushGatewayConf struct {
+	Enabled             bool              `json:"enabled" yaml:"enabled"`
+	Url                 string            `json:"url" yaml:"url"`
+	Job                 string            `json:"job" yaml:"job"`
+	Instance            string            `json:"instance" yaml:"instance"`
+	Headers             map[string]string `json:"headers" yaml:"headers"`
+	PushPeriodInSeconds int64             `json:"pushPeriodInSeconds" yaml:"pushPeriodInSeconds"`
+}
+
+// AuditConf enables the audit log, which has a hash chained JSON line for every action execution and its result.
+type AuditConf struct {
+	Enabled  bool   `json:"enabled" yaml:"enabled"`
+	Filepath string `json:"filepath" yaml:"filepath"`
+}
+
+// HistoryConf enables the execution history, which keeps the executions of the actions with their outcomes and
+// output excerpts up to its size and for its retention, so that they can be queried later.
+type HistoryConf struct {
+	Enabled                  bool   `json:"enabled" yaml:"enabled"`
+	Directory                string `json:"directory" yaml:"directory"`
+	MaxSizeInMb              int    `json:"maxSizeInMb" yaml:"maxSizeInMb"`
+	RetentionInDays          int    `json:"retentionInDays" yaml:"retentionInDays"`
+	OutputExcerptSizeInBytes int    `json:"outputExcerptSizeInBytes" yaml:"outputExcerptSizeInBytes"`
+}
+
+// Outputs of the logs.
+const (
+	LogOutputFile     = "file"
+	LogOutputStdout   = "stdout"
+	LogOutputBoth     = "both"
+	LogOutputSyslog   = "syslog"
+	LogOutputJournald = "journald"
+)
+
+// Networks of syslog.
+const (
+	SyslogNetworkUdp  = "udp"
+	SyslogNetworkTcp  = "tcp"
+	SyslogNetworkTls  = "tls"
+	SyslogNetworkUnix = "unix"
+)
+
+// LogConf defines where the logs of OEC, the queue messages and the outputs of the actions are written.
+// Main log is written to both stdout and its file by default, the others only to their files. When the output is
+// stdout, no log file is created, which suits the containers. When the output is syslog or journald, the main log
+// and the queue messages are sent there, and the outputs of the actions too if they are sent.
+type LogConf struct {
+	Directory         string        `json:"directory" yaml:"directory"`
+	Output            string        `json:"output" yaml:"output"`
+	Main              LogStreamConf `json:"main" yaml:"main"`
+	QueueMessages     LogStreamConf `json:"queueMessages" yaml:"queueMessages"`
+	Actions           LogStreamConf `json:"actions" yaml:"actions"`
+	SendActionOutputs bool          `json:"sendActionOutputs" yaml:"sendActionOutputs"`
+	Syslog            SyslogConf    `json:"syslog" yaml:"syslog"`
+	Journald          JournaldConf  `json:"journald" yaml:"journald"`
+}
+
+// SyslogConf defines the syslog server the logs are sent to in RFC 5424 format. Local syslog socket is used by
+// default. TLS configuration is used when the network is tls.
+type SyslogConf struct {
+	Network          string  `json:"network" yaml:"network"`
+	Address          string  `json:"address" yaml:"address"`
+	Facility         int     `json:"facility" yaml:"facility"`
+	AppName          string  `json:"appName" yaml:"appName"`
+	StructuredDataId string  `json:"structuredDataId" yaml:"structuredDataId"`
+	TlsConf          TlsConf `json:"tlsConf" yaml:"tlsConf"`
+}
+
+// JournaldConf defines the socket of journald, the default socket of systemd is used if it is not set.
+type JournaldConf struct {
+	SocketPath string `json:"socketPath" yaml:"socketPath"`
+	Identifier string `json:"identifier" yaml:"identifier"`
+}
+
+// LogStreamConf defines the file of a log stream and how it is rotated. Zero values are replaced with the defaults
+// of the stream. Filenames of the actions are given by their stdout and stderr in the action mappings.
+type LogStreamConf struct {
+	Disabled     bool   `json:"disabled" yaml:"disabled"`
+	Filename     string `json:"filename" yaml:"filename"`
+	MaxSizeInMb  int    `json:"maxSizeInMb" yaml:"maxSizeInMb"`
+	MaxAgeInDays int    `json:"maxAgeInDays" yaml:"maxAgeInDays"`
+	MaxBackups   int    `json:"maxBackups" yaml:"maxBackups"`
+	Compress     bool   `json:"compress" yaml:"compress"`
+}
+
+// RedactionConf defines what is redacted from the logs, the dead letters, the audit records and the errors, in
+// addition to the API keys, git passphrases and other secrets in the configuration. Values of the keys matching a
+// key pattern and of the JSON paths are redacted in JSON documents; matches of the regexes are redacted anywhere.
+type RedactionConf struct {
+	Disabled    bool     `json:"disabled" yaml:"disabled"`
+	JsonPaths   []string `json:"jsonPaths" yaml:"jsonPaths"`
+	KeyPatterns []string `json:"keyPatterns" yaml:"keyPatterns"`
+	Regexes     []string `json:"regexes" yaml:"regexes"`
+}
+
+// ActionOutputConf defines how the outputs of the actions are kept. Lines written to the stdout and stderr files of
+// the actions are framed with their time, request id, action and stream, unless framing is disabled. When capturing
+// is enabled, outputs of each execution are also written to a file of their own, up to the size limit. Failure results
+// have the tails of the outputs if the tail size is set, instead of the whole stderr.
+type ActionOutputConf struct {
+	DisableFraming          bool   `json:"disableFraming" yaml:"disableFraming"`
+	CaptureEnabled          bool   `json:"captureEnabled" yaml:"captureEnabled"`
+	CaptureDirectory        string `json:"captureDirectory" yaml:"captureDirectory"`
+	CaptureMaxSizeInKb      int64  `json:"captureMaxSizeInKb" yaml:"captureMaxSizeInKb"`
+	CaptureRetentionInHours int    `json:"captureRetentionInHours" yaml:"captureRetentionInHours"`
+	TailSizeInBytes         int    `json:"tailSizeInBytes" yaml:"tailSizeInBytes"`
+}
+
+type TokenCacheConf struct {
+	Disabled  bool   `json:"disabled" yaml:"disabled"`
+	Directory string `json:"directory" yaml:"directory"`
+}
+
+const (
+	defaultDrainTimeout    = 30 * time.Second
+	defaultResultTimeout   = 10 * time.Second
+	defaultKillGracePeriod = 10 * time.Second
+
+	defaultLeaseDuration  = 30 * time.Second
+	defaultRenewPeriod    = 10 * time.Second
+	defaultTakeoverWindow = 5 * time.Second
+)
+
+// ShutdownConf limits how long OEC waits while stopping; first for the running actions to complete, then for
+// the cancelled ones to exit after they are signalled and lastly for the results to be sent to Opsgenie.
+type ShutdownConf struct {
+	DrainTimeoutInSeconds    int64 `json:"drainTimeoutInSeconds" yaml:"drainTimeoutInSeconds"`
+	KillGracePeriodInSeconds int64 `json:"killGracePeriodInSeconds" yaml:"killGracePeriodInSeconds"`
+	ResultTimeoutInSeconds   int64 `json:"resultTimeoutInSeconds" yaml:"resultTimeoutInSeconds"`
+}
+
+func (c ShutdownConf) DrainTimeout() time.Duration {
+	return durationOrDefault(c.DrainTimeoutInSeconds, defaultDrainTimeout)
+}
+
+func (c ShutdownConf) KillGracePeriod() time.Duration {
+	return durationOrDefault(c.KillGracePeriodInSeconds, defaultKillGracePeriod)
+}
+
+func (c ShutdownConf) ResultTimeout() time.Duration {
+	return durationOrDefault(c.ResultTimeoutInSeconds, defaultResultTimeout)
+}
+
+// HighAvailabilityConf makes the instances sharing the same lease directory compete for a lease; only the
+// one holding it polls the queues. The others check the lease in every takeover window and take over once
+// it expires.
+type HighAvailabilityConf struct {
+	Enabled                 bool   `json:"enabled" yaml:"enabled"`
+	LeaseDirectory          string `json:"leaseDirectory" yaml:"leaseDirectory"`
+	InstanceId              string `json:"instanceId" yaml:"instanceId"`
+	LeaseDurationInSeconds  int64  `json:"leaseDurationInSeconds" yaml:"leaseDurationInSeconds"`
+	RenewPeriodInSeconds    int64  `json:"renewPeriodInSeconds" yaml:"renewPeriodInSeconds"`
+	TakeoverWindowInSeconds int64  `json:"takeoverWindowInSeconds" yaml:"takeoverWindowInSeconds"`
+}
+
+func (c HighAvailabilityConf) LeaseDuration() time.Duration {
+	return durationOrDefault(c.LeaseDurationInSeconds, defaultLeaseDuration)
+}
+
+func (c HighAvailabilityConf) RenewPeriod() time.Duration {
+	return durationOrDefault(c.RenewPeriodInSeconds, defaultRenewPeriod)
+}
+
+func (c HighAvailabilityConf) TakeoverWindow() time.Duration {
+	return durationOrDefault(c.TakeoverWindowInSeconds, defaultTakeoverWindow)
+}
+
+func durationOrDefault(seconds int64, defaultDuration time.Duration) time.Duration {
+	if seconds <= 0 {
+		return defaultDuration
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+type HttpClientConf struct {
+	ProxyConf ProxyConf `json:"proxyConf" yaml:"proxyConf"`
+	TlsConf   TlsConf   `json:"tlsConf" yaml:"tlsConf"`
+}
+
+type ProxyConf struct {
+	HttpUrl  string   `json:"httpUrl" yaml:"httpUrl"`
+	HttpsUrl string   `json:"httpsUrl" yaml:"httpsUrl"`
+	Username string   `json:"username" yaml:"username"`
+	Password string   `json:"password" yaml:"password"`
+	NoProxy  []string `json:"noProxy" yaml:"noProxy"`
+}
+
+type TlsConf struct {
+	RootCaFilepaths    []string `json:"rootCaFilepaths" yaml:"rootCaFilepaths"`
+	ClientCertFilepath string   `json:"clientCertFilepath" yaml:"clientCertFilepath"`
+	ClientKeyFilepath  string   `json:"clientKeyFilepath" yaml:"clientKeyFilepath"`
 }
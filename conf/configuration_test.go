@@ -5,6 +5,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestHttpFieldsFilledCorrectly(t *testing.T) {
@@ -21,3 +22,290 @@ func TestHttpFieldsFilledCorrectly(t *testing.T) {
 	assert.Equal(t, conf.ActionMappings["WithHttpAction"].Flags["headers"], "{\"Authentication\":\"Basic JNjDkNsKaMs\"}")
 	assert.Equal(t, conf.ActionMappings["WithHttpAction"].Flags["params"], "{\"Key1\":\"Value1\"}")
 }
+
+var mockIntegrationsYamlFileContent = []byte(`
+---
+logLevel: debug
+poolConf:
+  maxNumberOfWorker: 12
+integrations:
+- name: first
+  apiKey: FirstApiKey
+  actionMappings:
+    Create:
+      filepath: "/path/to/create.sh"
+      sourceType: local
+- name: second
+  apiKey: SecondApiKey
+  baseUrl: https://api.eu.opsgenie.com
+  poolConf:
+    maxNumberOfWorker: 4
+  globalEnv:
+  - e1=v1
+  actionMappings:
+    Close:
+      filepath: "/path/to/close.sh"
+      sourceType: local
+`)
+
+func TestIntegrationConfigurations(t *testing.T) {
+
+	confPath, err := util.CreateTempTestFile(mockIntegrationsYamlFileContent, ".yaml")
+	assert.Nil(t, err)
+	defer os.Remove(confPath)
+
+	conf, err := readFileFromLocal(confPath)
+	assert.Nil(t, err)
+	assert.Nil(t, validate(conf))
+
+	configurations := conf.IntegrationConfigurations()
+	assert.Equal(t, 2, len(configurations))
+
+	first := configurations[0]
+	assert.Equal(t, "first", first.IntegrationName)
+	assert.Equal(t, "FirstApiKey", first.ApiKey)
+	assert.Equal(t, DefaultBaseUrl, first.BaseUrl)
+	assert.Equal(t, int32(12), first.PoolConf.MaxNumberOfWorker)
+	assert.Contains(t, first.ActionMappings, ActionName("Create"))
+	assert.Empty(t, first.Integrations)
+
+	second := configurations[1]
+	assert.Equal(t, "second", second.IntegrationName)
+	assert.Equal(t, "SecondApiKey", second.ApiKey)
+	assert.Equal(t, "https://api.eu.opsgenie.com", second.BaseUrl)
+	assert.Equal(t, int32(4), second.PoolConf.MaxNumberOfWorker)
+	assert.Equal(t, []string{"e1=v1"}, second.GlobalEnv)
+	assert.Contains(t, second.ActionMappings, ActionName("Close"))
+}
+
+func TestSingleIntegrationConfiguration(t *testing.T) {
+
+	configurations := mockConf.IntegrationConfigurations()
+
+	assert.Equal(t, 1, len(configurations))
+	assert.True(t, mockConf == configurations[0])
+	assert.Empty(t, configurations[0].IntegrationName)
+}
+
+func TestValidateIntegrations(t *testing.T) {
+
+	err := validate(&Configuration{
+		Integrations: []IntegrationConf{
+			{Name: "first", ApiKey: "ApiKey", ActionSpecifications: ActionSpecifications{ActionMappings: mockActionMappings}},
+			{Name: "first", ApiKey: "ApiKey", ActionSpecifications: ActionSpecifications{ActionMappings: mockActionMappings}},
+		},
+	})
+	assert.EqualError(t, err, "Integration name[first] is used more than once.")
+
+	err = validate(&Configuration{
+		Integrations: []IntegrationConf{
+			{ApiKey: "ApiKey", ActionSpecifications: ActionSpecifications{ActionMappings: mockActionMappings}},
+		},
+	})
+	assert.EqualError(t, err, "Name of the integration at index[0] is empty.")
+
+	err = validate(&Configuration{
+		Integrations: []IntegrationConf{
+			{Name: "../first", ApiKey: "ApiKey", ActionSpecifications: ActionSpecifications{ActionMappings: mockActionMappings}},
+		},
+	})
+	assert.EqualError(t, err, "Integration name[../first] should consist of letters, digits, underscores and hyphens, since it is used in file names.")
+
+	err = validate(&Configuration{
+		Integrations: []IntegrationConf{
+			{Name: "first", ActionSpecifications: ActionSpecifications{ActionMappings: mockActionMappings}},
+		},
+	})
+	assert.EqualError(t, err, "Integration[first] is not valid: ApiKey is not found in the configuration file.")
+
+	err = validate(&Configuration{
+		Integrations: []IntegrationConf{
+			{Name: "first", ApiKey: "ApiKey", ActionSpecifications: ActionSpecifications{ActionMappings: ActionMappings{
+				"Restart": MappedAction{SourceType: LocalSourceType, Filepath: "/path/to/restart.sh", Priority: "P0"},
+			}}},
+		},
+	})
+	assert.EqualError(t, err, "Integration[first] is not valid: Priority[P0] of action[Restart] should be one of P1, P2, P3, P4 or P5.")
+}
+
+func TestValidatePrefetch(t *testing.T) {
+
+	err := validate(&Configuration{
+		ApiKey:               "ApiKey",
+		ActionSpecifications: ActionSpecifications{ActionMappings: mockActionMappings},
+		PollerConf:           PollerConf{PrefetchEnabled: true},
+	})
+	assert.EqualError(t, err, "Queue size of the pool should be greater than zero to prefetch messages.")
+
+	err = validate(&Configuration{
+		PollerConf: PollerConf{PrefetchEnabled: true},
+		PoolConf:   PoolConf{QueueSize: 10},
+		Integrations: []IntegrationConf{
+			{Name: "first", ApiKey: "ApiKey", ActionSpecifications: ActionSpecifications{ActionMappings: mockActionMappings}},
+			{Name: "second", ApiKey: "ApiKey", ActionSpecifications: ActionSpecifications{ActionMappings: mockActionMappings}, PoolConf: &PoolConf{}},
+		},
+	})
+	assert.EqualError(t, err, "Integration[second] is not valid: Queue size of the pool should be greater than zero to prefetch messages.")
+
+	err = validate(&Configuration{
+		ApiKey:               "ApiKey",
+		ActionSpecifications: ActionSpecifications{ActionMappings: mockActionMappings},
+		PollerConf:           PollerConf{PrefetchEnabled: true},
+		PoolConf:             PoolConf{QueueSize: 10},
+	})
+	assert.Nil(t, err)
+}
+
+func TestValidateSignals(t *testing.T) {
+
+	err := validate(&Configuration{
+		ApiKey:               "ApiKey",
+		ActionSpecifications: ActionSpecifications{ActionMappings: mockActionMappings},
+		SignalConf:           SignalConf{LevelDurationInMinutes: -1},
+	})
+	assert.EqualError(t, err, "Level duration[-1] of the signals should not be negative.")
+
+	assert.Equal(t, 15*time.Minute, SignalConf{LevelDurationInMinutes: 15}.LevelDuration())
+}
+
+func TestValidateAdmin(t *testing.T) {
+
+	assert.Nil(t, validateAdmin(&AdminConf{Address: "0.0.0.0:7071"}))
+	assert.Nil(t, validateAdmin(&AdminConf{Enabled: true, Token: "token"}))
+	assert.Nil(t, validateAdmin(&AdminConf{Enabled: true, Address: "127.0.0.1:7071", Token: "token"}))
+	assert.Nil(t, validateAdmin(&AdminConf{Enabled: true, Address: "[::1]:7071", Token: "token"}))
+	assert.Nil(t, validateAdmin(&AdminConf{Enabled: true, Address: "localhost:7071", Token: "token"}))
+	assert.Nil(t, validateAdmin(&AdminConf{Enabled: true, Address: "0.0.0.0:7071", SocketPath: "/var/run/oec.sock"}))
+
+	err := validateAdmin(&AdminConf{Enabled: true})
+	assert.EqualError(t, err, "Admin token should be set to serve the admin API on an address, or a socket path should be set.")
+
+	err = validateAdmin(&AdminConf{Enabled: true, Address: "127.0.0.1:7071"})
+	assert.EqualError(t, err, "Admin token should be set to serve the admin API on an address, or a socket path should be set.")
+
+	err = validateAdmin(&AdminConf{Enabled: true, Address: "0.0.0.0:7071", Token: "token"})
+	assert.EqualError(t, err, "Admin address[0.0.0.0:7071] should be a loopback address.")
+
+	err = validateAdmin(&AdminConf{Enabled: true, Address: ":7071", Token: "token"})
+	assert.EqualError(t, err, "Admin address[:7071] should be a loopback address.")
+
+	err = validateAdmin(&AdminConf{Enabled: true, Address: "7071", Token: "token"})
+	assert.EqualError(t, err, "Admin address[7071] is not valid: address 7071: missing port in address")
+}
+
+func TestValidateTracing(t *testing.T) {
+
+	assert.Nil(t, validateTracing(&TracingConf{}))
+	assert.Nil(t, validateTracing(&TracingConf{Enabled: true, Endpoint: "http://localhost:4318"}))
+	assert.Nil(t, validateTracing(&TracingConf{Enabled: true, Endpoint: "https://collector.example.com/v1/traces", SampleRatio: 0.1}))
+
+	err := validateTracing(&TracingConf{Enabled: true})
+	assert.EqualError(t, err, "Tracing endpoint[] should be an http or https url.")
+
+	err = validateTracing(&TracingConf{Enabled: true, Endpoint: "localhost:4317"})
+	assert.EqualError(t, err, "Tracing endpoint[localhost:4317] should be an http or https url.")
+
+	err = validateTracing(&TracingConf{Enabled: true, Endpoint: "http://localhost:4318", SampleRatio: 2})
+	assert.EqualError(t, err, "Sample ratio[2] of tracing should be between 0 and 1.")
+}
+
+func TestValidateMetrics(t *testing.T) {
+
+	assert.Nil(t, validateMetrics(&MetricsConf{}))
+	assert.Nil(t, validateMetrics(&MetricsConf{
+		Prefix:      "prod_",
+		Tags:        map[string]string{"region": "eu-west-1"},
+		Statsd:      StatsdConf{Enabled: true, Address: "127.0.0.1:8125"},
+		PushGateway: PushGatewayConf{Enabled: true, Url: "http://pushgateway:9091"},
+	}))
+
+	err := validateMetrics(&MetricsConf{Prefix: "prod."})
+	assert.EqualError(t, err, "Metric prefix[prod.] should have only letters, digits, underscores and colons, and should not start with a digit.")
+
+	err = validateMetrics(&MetricsConf{Tags: map[string]string{"__name__": "oec"}})
+	assert.EqualError(t, err, "Metric tag[__name__] should have only letters, digits and underscores, and should not start with a digit or two underscores.")
+
+	err = validateMetrics(&MetricsConf{Statsd: StatsdConf{Enabled: true, Address: "localhost"}})
+	assert.EqualError(t, err, "StatsD address[localhost] should be in host:port format.")
+
+	err = validateMetrics(&MetricsConf{PushGateway: PushGatewayConf{Enabled: true, Url: "pushgateway:9091"}})
+	assert.EqualError(t, err, "Push gateway url[pushgateway:9091] should be an http or https url.")
+
+	err = validateMetrics(&MetricsConf{Statsd: StatsdConf{PushPeriodInSeconds: -1}})
+	assert.EqualError(t, err, "Push periods of the metrics should not be negative.")
+}
+
+func TestValidateLog(t *testing.T) {
+
+	assert.Nil(t, validateLog(&LogConf{}))
+	assert.Nil(t, validateLog(&LogConf{Output: LogOutputStdout, Main: LogStreamConf{Filename: "oec.log", MaxSizeInMb: 10}}))
+
+	assert.Nil(t, validateLog(&LogConf{Output: LogOutputJournald}))
+	assert.Nil(t, validateLog(&LogConf{Output: LogOutputSyslog, Syslog: SyslogConf{Network: SyslogNetworkTls, Address: "logs.example.com:6514", Facility: 16}}))
+
+	err := validateLog(&LogConf{Output: "kafka"})
+	assert.EqualError(t, err, "Log output[kafka] should be one of file, stdout, both, syslog or journald.")
+
+	err = validateLog(&LogConf{Output: LogOutputSyslog, Syslog: SyslogConf{Network: SyslogNetworkTcp}})
+	assert.EqualError(t, err, "Syslog address should be provided for network[tcp].")
+
+	err = validateLog(&LogConf{Output: LogOutputSyslog, Syslog: SyslogConf{Network: "sctp"}})
+	assert.EqualError(t, err, "Syslog network[sctp] should be one of udp, tcp, tls or unix.")
+
+	err = validateLog(&LogConf{Output: LogOutputSyslog, Syslog: SyslogConf{Facility: 24}})
+	assert.EqualError(t, err, "Syslog facility[24] should be between 0 and 23.")
+
+	err = validateLog(&LogConf{QueueMessages: LogStreamConf{MaxBackups: -1}})
+	assert.EqualError(t, err, "Rotation limits of queueMessages logs should not be negative.")
+
+	err = validateLog(&LogConf{Main: LogStreamConf{Filename: "logs/oec.log"}})
+	assert.EqualError(t, err, "Filename[logs/oec.log] of main logs should not have a directory, it is written to the log directory.")
+}
+
+func TestValidateRedaction(t *testing.T) {
+
+	assert.Nil(t, validateRedaction(&RedactionConf{}))
+	assert.Nil(t, validateRedaction(&RedactionConf{JsonPaths: []string{"$.alert.details.*", "alert.tags"}, KeyPatterns: []string{"(?i)secret"}, Regexes: []string{`\d{16}`}}))
+
+	err := validateRedaction(&RedactionConf{KeyPatterns: []string{"(secret"}})
+	assert.EqualError(t, err, "Redaction key pattern[(secret] is not valid: error parsing regexp: missing closing ): `(secret`")
+
+	err = validateRedaction(&RedactionConf{Regexes: []string{"[0-9"}})
+	assert.EqualError(t, err, "Redaction regex[[0-9] is not valid: error parsing regexp: missing closing ]: `[0-9`")
+
+	err = validateRedaction(&RedactionConf{JsonPaths: []string{"$."}})
+	assert.EqualError(t, err, "Redaction JSON path[$.] is not valid.")
+
+	err = validateRedaction(&RedactionConf{JsonPaths: []string{"alert..details"}})
+	assert.EqualError(t, err, "Redaction JSON path[alert..details] is not valid.")
+}
+
+func TestValidateActionOutput(t *testing.T) {
+
+	assert.Nil(t, validateActionOutput(&ActionOutputConf{}))
+	assert.Nil(t, validateActionOutput(&ActionOutputConf{CaptureEnabled: true, CaptureMaxSizeInKb: 512, TailSizeInBytes: 1024}))
+
+	err := validateActionOutput(&ActionOutputConf{TailSizeInBytes: -1})
+	assert.EqualError(t, err, "Tail size[-1] of the action outputs should not be negative.")
+
+	err = validateActionOutput(&ActionOutputConf{CaptureRetentionInHours: -1})
+	assert.EqualError(t, err, "Size limit and retention of the captured action outputs should not be negative.")
+}
+
+func TestValidateHighAvailability(t *testing.T) {
+
+	err := validateHighAvailability(&HighAvailabilityConf{})
+	assert.Nil(t, err)
+
+	err = validateHighAvailability(&HighAvailabilityConf{Enabled: true})
+	assert.EqualError(t, err, "Lease directory should be provided for high availability.")
+
+	err = validateHighAvailability(&HighAvailabilityConf{Enabled: true, LeaseDirectory: "/mnt/shared", RenewPeriodInSeconds: 30})
+	assert.EqualError(t, err, "Renew period[30s] of the lease should be shorter than its duration[30s].")
+
+	haConf := &HighAvailabilityConf{Enabled: true, LeaseDirectory: "/mnt/shared"}
+	assert.Nil(t, validateHighAvailability(haConf))
+	assert.Equal(t, 30*time.Second, haConf.LeaseDuration())
+	assert.Equal(t, 10*time.Second, haConf.RenewPeriod())
+	assert.Equal(t, 5*time.Second, haConf.TakeoverWindow())
+}
@@ -4,8 +4,11 @@ import (
 	"github.com/opsgenie/oec/git"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -14,6 +17,8 @@ const (
 	GitSourceType   = "git"
 
 	DefaultBaseUrl = "https://api.opsgenie.com"
+
+	DefaultAdminAddress = "127.0.0.1:7071"
 )
 
 var readFileFromGitFunc = readFileFromGit
@@ -38,10 +43,25 @@ func Read() (*Configuration, error) {
 		return nil, err
 	}
 
-	addHomeDirPrefixToActionMappings(conf.ActionMappings)
-	chmodLocalActions(conf.ActionMappings, 0700)
+	conf.DeadLetterConf.Directory = addHomeDirPrefix(conf.DeadLetterConf.Directory)
+	conf.TokenCacheConf.Directory = addHomeDirPrefix(conf.TokenCacheConf.Directory)
+	conf.OutboxConf.Directory = addHomeDirPrefix(conf.OutboxConf.Directory)
+	conf.HighAvailabilityConf.LeaseDirectory = addHomeDirPrefix(conf.HighAvailabilityConf.LeaseDirectory)
+	conf.AdminConf.SocketPath = addHomeDirPrefix(conf.AdminConf.SocketPath)
+	conf.AuditConf.Filepath = addHomeDirPrefix(conf.AuditConf.Filepath)
+	conf.HistoryConf.Directory = addHomeDirPrefix(conf.HistoryConf.Directory)
+	conf.LogConf.Directory = addHomeDirPrefix(conf.LogConf.Directory)
+	conf.ActionOutputConf.CaptureDirectory = addHomeDirPrefix(conf.ActionOutputConf.CaptureDirectory)
+	addHomeDirPrefixToTlsConf(&conf.HttpClientConf.TlsConf)
+	addHomeDirPrefixToTlsConf(&conf.LogConf.Syslog.TlsConf)
 
-	conf.addDefaultFlags()
+	if len(conf.Integrations) == 0 {
+		prepareActionSpecifications(&conf.ActionSpecifications, conf.ApiKey, conf.BaseUrl, conf.LogLevel)
+	}
+	for index := range conf.Integrations {
+		integration := &conf.Integrations[index]
+		prepareActionSpecifications(&integration.ActionSpecifications, integration.ApiKey, integration.BaseUrl, conf.LogLevel)
+	}
 
 	return conf, nil
 }
@@ -81,34 +101,336 @@ func readFileFromSource(confSourceType string) (*Configuration, error) {
 	}
 }
 
-func (c *Configuration) addDefaultFlags() {
-	c.GlobalArgs = append(
+func prepareActionSpecifications(specs *ActionSpecifications, apiKey, baseUrl, logLevel string) {
+	addHomeDirPrefixToActionMappings(specs.ActionMappings)
+	chmodLocalActions(specs.ActionMappings, 0700)
+	addDefaultFlags(specs, apiKey, baseUrl, logLevel)
+}
+
+func addDefaultFlags(specs *ActionSpecifications, apiKey, baseUrl, logLevel string) {
+	specs.GlobalArgs = append(
 		[]string{
-			"-apiKey", c.ApiKey,
-			"-opsgenieUrl", c.BaseUrl,
-			"-logLevel", strings.ToUpper(c.LogLevel),
+			"-apiKey", apiKey,
+			"-opsgenieUrl", baseUrl,
+			"-logLevel", strings.ToUpper(logLevel),
 		},
-		c.GlobalArgs...,
+		specs.GlobalArgs...,
 	)
 }
 
+// integrationNamePattern matches the names which can be used as they are in the names of the queue message logs and
+// the directories of the outbox and the dead letters.
+var integrationNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 func validate(conf *Configuration) error {
 
 	if conf == nil || conf == (&Configuration{}) {
 		return errors.New("The configuration is empty.")
 	}
-	if conf.ApiKey == "" {
+
+	if len(conf.Integrations) == 0 {
+		err := validateIntegration(conf.ApiKey, &conf.BaseUrl, conf.ActionMappings)
+		if err != nil {
+			return err
+		}
+	}
+
+	integrationNames := make(map[string]struct{}, len(conf.Integrations))
+	for index := range conf.Integrations {
+		integration := &conf.Integrations[index]
+		if integration.Name == "" {
+			return errors.Errorf("Name of the integration at index[%d] is empty.", index)
+		}
+		if !integrationNamePattern.MatchString(integration.Name) {
+			return errors.Errorf("Integration name[%s] should consist of letters, digits, underscores and hyphens, since it is used in file names.", integration.Name)
+		}
+		if _, contains := integrationNames[integration.Name]; contains {
+			return errors.Errorf("Integration name[%s] is used more than once.", integration.Name)
+		}
+		integrationNames[integration.Name] = struct{}{}
+
+		err := validateIntegration(integration.ApiKey, &integration.BaseUrl, integration.ActionMappings)
+		if err != nil {
+			return errors.Errorf("Integration[%s] is not valid: %s", integration.Name, err)
+		}
+	}
+
+	// prefetched messages wait in the queue of the pool, which does not exist with the default queue size
+	if conf.PollerConf.PrefetchEnabled {
+		for _, configuration := range conf.IntegrationConfigurations() {
+			if configuration.PoolConf.QueueSize > 0 {
+				continue
+			}
+			if configuration.IntegrationName == "" {
+				return errors.New("Queue size of the pool should be greater than zero to prefetch messages.")
+			}
+			return errors.Errorf("Integration[%s] is not valid: Queue size of the pool should be greater than zero to prefetch messages.", configuration.IntegrationName)
+		}
+	}
+
+	err := validateHighAvailability(&conf.HighAvailabilityConf)
+	if err != nil {
+		return err
+	}
+
+	err = validateAdmin(&conf.AdminConf)
+	if err != nil {
+		return err
+	}
+
+	if conf.SignalConf.LevelDurationInMinutes < 0 {
+		return errors.Errorf("Level duration[%d] of the signals should not be negative.", conf.SignalConf.LevelDurationInMinutes)
+	}
+
+	err = validateTracing(&conf.TracingConf)
+	if err != nil {
+		return err
+	}
+
+	err = validateMetrics(&conf.MetricsConf)
+	if err != nil {
+		return err
+	}
+
+	err = validateLog(&conf.LogConf)
+	if err != nil {
+		return err
+	}
+
+	err = validateRedaction(&conf.RedactionConf)
+	if err != nil {
+		return err
+	}
+
+	err = validateActionOutput(&conf.ActionOutputConf)
+	if err != nil {
+		return err
+	}
+
+	level, err := logrus.ParseLevel(conf.LogLevel)
+	if err != nil {
+		conf.LogrusLevel = logrus.InfoLevel
+		conf.LogLevel = "info"
+	} else {
+		conf.LogrusLevel = level
+	}
+
+	return nil
+}
+
+func validateHighAvailability(haConf *HighAvailabilityConf) error {
+
+	if !haConf.Enabled {
+		return nil
+	}
+
+	if haConf.LeaseDirectory == "" {
+		return errors.New("Lease directory should be provided for high availability.")
+	}
+
+	if haConf.RenewPeriod() >= haConf.LeaseDuration() {
+		return errors.Errorf("Renew period[%s] of the lease should be shorter than its duration[%s].",
+			haConf.RenewPeriod().String(), haConf.LeaseDuration().String())
+	}
+
+	return nil
+}
+
+// validateAdmin allows only loopback addresses with a token, since the admin API can change a running OEC and any
+// local user can connect to a loopback address. The admin API is served without a token only on a unix socket.
+func validateAdmin(adminConf *AdminConf) error {
+
+	if !adminConf.Enabled || adminConf.SocketPath != "" {
+		return nil
+	}
+
+	if adminConf.Token == "" {
+		return errors.New("Admin token should be set to serve the admin API on an address, or a socket path should be set.")
+	}
+
+	if adminConf.Address == "" {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(adminConf.Address)
+	if err != nil {
+		return errors.Errorf("Admin address[%s] is not valid: %s", adminConf.Address, err)
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return errors.Errorf("Admin address[%s] should be a loopback address.", adminConf.Address)
+	}
+
+	return nil
+}
+
+var (
+	metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+func validateMetrics(metricsConf *MetricsConf) error {
+
+	if metricsConf.Prefix != "" && !metricNamePattern.MatchString(metricsConf.Prefix) {
+		return errors.Errorf("Metric prefix[%s] should have only letters, digits, underscores and colons, and should not start with a digit.", metricsConf.Prefix)
+	}
+
+	for name := range metricsConf.Tags {
+		if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			return errors.Errorf("Metric tag[%s] should have only letters, digits and underscores, and should not start with a digit or two underscores.", name)
+		}
+	}
+
+	if metricsConf.Statsd.Enabled && metricsConf.Statsd.Address != "" {
+		_, _, err := net.SplitHostPort(metricsConf.Statsd.Address)
+		if err != nil {
+			return errors.Errorf("StatsD address[%s] should be in host:port format.", metricsConf.Statsd.Address)
+		}
+	}
+
+	if metricsConf.PushGateway.Enabled {
+		pushGatewayUrl, err := url.Parse(metricsConf.PushGateway.Url)
+		if err != nil || pushGatewayUrl.Host == "" || (pushGatewayUrl.Scheme != "http" && pushGatewayUrl.Scheme != "https") {
+			return errors.Errorf("Push gateway url[%s] should be an http or https url.", metricsConf.PushGateway.Url)
+		}
+	}
+
+	if metricsConf.Statsd.PushPeriodInSeconds < 0 || metricsConf.PushGateway.PushPeriodInSeconds < 0 {
+		return errors.New("Push periods of the metrics should not be negative.")
+	}
+
+	return nil
+}
+
+func validateTracing(tracingConf *TracingConf) error {
+
+	if !tracingConf.Enabled {
+		return nil
+	}
+
+	endpoint, err := url.Parse(tracingConf.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return errors.Errorf("Tracing endpoint[%s] should be an http or https url.", tracingConf.Endpoint)
+	}
+
+	if tracingConf.SampleRatio < 0 || tracingConf.SampleRatio > 1 {
+		return errors.Errorf("Sample ratio[%g] of tracing should be between 0 and 1.", tracingConf.SampleRatio)
+	}
+
+	return nil
+}
+
+func validateLog(logConf *LogConf) error {
+
+	switch logConf.Output {
+	case "", LogOutputFile, LogOutputStdout, LogOutputBoth, LogOutputJournald:
+	case LogOutputSyslog:
+		err := validateSyslog(&logConf.Syslog)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("Log output[%s] should be one of %s, %s, %s, %s or %s.", logConf.Output,
+			LogOutputFile, LogOutputStdout, LogOutputBoth, LogOutputSyslog, LogOutputJournald)
+	}
+
+	streams := []struct {
+		name string
+		conf LogStreamConf
+	}{
+		{"main", logConf.Main},
+		{"queueMessages", logConf.QueueMessages},
+		{"actions", logConf.Actions},
+	}
+	for _, stream := range streams {
+		if stream.conf.MaxSizeInMb < 0 || stream.conf.MaxAgeInDays < 0 || stream.conf.MaxBackups < 0 {
+			return errors.Errorf("Rotation limits of %s logs should not be negative.", stream.name)
+		}
+		if strings.ContainsAny(stream.conf.Filename, `/\`) {
+			return errors.Errorf("Filename[%s] of %s logs should not have a directory, it is written to the log directory.", stream.conf.Filename, stream.name)
+		}
+	}
+
+	return nil
+}
+
+func validateSyslog(syslogConf *SyslogConf) error {
+
+	switch syslogConf.Network {
+	case "", SyslogNetworkUnix:
+	case SyslogNetworkUdp, SyslogNetworkTcp, SyslogNetworkTls:
+		if syslogConf.Address == "" {
+			return errors.Errorf("Syslog address should be provided for network[%s].", syslogConf.Network)
+		}
+	default:
+		return errors.Errorf("Syslog network[%s] should be one of %s, %s, %s or %s.", syslogConf.Network,
+			SyslogNetworkUdp, SyslogNetworkTcp, SyslogNetworkTls, SyslogNetworkUnix)
+	}
+
+	if syslogConf.Facility < 0 || syslogConf.Facility > 23 {
+		return errors.Errorf("Syslog facility[%d] should be between 0 and 23.", syslogConf.Facility)
+	}
+
+	return nil
+}
+
+func validateRedaction(redactionConf *RedactionConf) error {
+
+	for _, pattern := range redactionConf.KeyPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return errors.Errorf("Redaction key pattern[%s] is not valid: %s", pattern, err)
+		}
+	}
+
+	for _, pattern := range redactionConf.Regexes {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return errors.Errorf("Redaction regex[%s] is not valid: %s", pattern, err)
+		}
+	}
+
+	for _, path := range redactionConf.JsonPaths {
+		if strings.Trim(strings.TrimPrefix(path, "$"), ".") == "" || strings.Contains(path, "..") {
+			return errors.Errorf("Redaction JSON path[%s] is not valid.", path)
+		}
+	}
+
+	return nil
+}
+
+func validateActionOutput(outputConf *ActionOutputConf) error {
+
+	if outputConf.TailSizeInBytes < 0 {
+		return errors.Errorf("Tail size[%d] of the action outputs should not be negative.", outputConf.TailSizeInBytes)
+	}
+
+	if outputConf.CaptureMaxSizeInKb < 0 || outputConf.CaptureRetentionInHours < 0 {
+		return errors.New("Size limit and retention of the captured action outputs should not be negative.")
+	}
+
+	return nil
+}
+
+var priorityPattern = regexp.MustCompile(`^P[1-5]$`)
+
+// IsValidPriority returns whether the priority is one of the priorities of the alerts, from P1 to P5.
+func IsValidPriority(priority string) bool {
+	return priorityPattern.MatchString(priority)
+}
+
+func validateIntegration(apiKey string, baseUrl *string, actionMappings ActionMappings) error {
+
+	if apiKey == "" {
 		return errors.New("ApiKey is not found in the configuration file.")
 	}
-	if conf.BaseUrl == "" {
-		conf.BaseUrl = DefaultBaseUrl
+	if *baseUrl == "" {
+		*baseUrl = DefaultBaseUrl
 		logrus.Infof("BaseUrl is not found in the configuration file, default url[%s] is set.", DefaultBaseUrl)
 	}
 
-	if len(conf.ActionMappings) == 0 {
+	if len(actionMappings) == 0 {
 		return errors.New("Action mappings configuration is not found in the configuration file.")
 	} else {
-		for actionName, action := range conf.ActionMappings {
+		for actionName, action := range actionMappings {
 			if action.SourceType != LocalSourceType &&
 				action.SourceType != GitSourceType {
 				return errors.Errorf("Action source type of action[%s] should be either local or git.", actionName)
@@ -120,17 +442,12 @@ func validate(conf *Configuration) error {
 					action.GitOptions == (git.Options{}) {
 					return errors.Errorf("Git options of action[%s] is empty.", actionName)
 				}
+				if action.Priority != "" && !IsValidPriority(action.Priority) {
+					return errors.Errorf("Priority[%s] of action[%s] should be one of P1, P2, P3, P4 or P5.", action.Priority, actionName)
+				}
 			}
 		}
 	}
 
-	level, err := logrus.ParseLevel(conf.LogLevel)
-	if err != nil {
-		conf.LogrusLevel = logrus.InfoLevel
-		conf.LogLevel = "info"
-	} else {
-		conf.LogrusLevel = level
-	}
-
 	return nil
 }
@@ -1,6 +1,8 @@
 package conf
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"github.com/opsgenie/oec/git"
 	"github.com/pkg/errors"
@@ -83,6 +85,14 @@ func addHomeDirPrefixToActionMappings(mappings ActionMappings) {
 	}
 }
 
+func addHomeDirPrefixToTlsConf(tlsConf *TlsConf) {
+	for index, rootCaFilepath := range tlsConf.RootCaFilepaths {
+		tlsConf.RootCaFilepaths[index] = addHomeDirPrefix(rootCaFilepath)
+	}
+	tlsConf.ClientCertFilepath = addHomeDirPrefix(tlsConf.ClientCertFilepath)
+	tlsConf.ClientKeyFilepath = addHomeDirPrefix(tlsConf.ClientKeyFilepath)
+}
+
 func AddRepositoryPathToGitActionFilepaths(mappings ActionMappings, repositories git.Repositories) {
 	for index, action := range mappings {
 		if action.SourceType == GitSourceType {
@@ -140,3 +150,45 @@ func copyActionMappings(mappings ActionMappings) ActionMappings {
 	}
 	return copyActionMappings
 }
+
+// NewTlsConfig returns the TLS configuration which trusts the root CAs in addition to the system ones, and which
+// presents the client certificate if it is given.
+func NewTlsConfig(tlsConf *TlsConf) (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{}
+
+	if len(tlsConf.RootCaFilepaths) > 0 {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			logrus.Warnf("System certificate pool could not be loaded, only the given root CAs will be trusted: %v", err)
+			rootCAs = x509.NewCertPool()
+		}
+
+		for _, rootCaFilepath := range tlsConf.RootCaFilepaths {
+			pem, err := ioutil.ReadFile(rootCaFilepath)
+			if err != nil {
+				return nil, errors.Errorf("Root CA file[%s] could not be read: %s", rootCaFilepath, err)
+			}
+			if !rootCAs.AppendCertsFromPEM(pem) {
+				return nil, errors.Errorf("Root CA file[%s] does not contain any PEM encoded certificate.", rootCaFilepath)
+			}
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if tlsConf.ClientCertFilepath != "" || tlsConf.ClientKeyFilepath != "" {
+		if tlsConf.ClientCertFilepath == "" || tlsConf.ClientKeyFilepath == "" {
+			return nil, errors.New("Both client certificate and client key files should be given.")
+		}
+
+		certificate, err := tls.LoadX509KeyPair(tlsConf.ClientCertFilepath, tlsConf.ClientKeyFilepath)
+		if err != nil {
+			return nil, errors.Errorf("Client certificate could not be loaded: %s", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"github.com/opsgenie/oec/util"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -69,3 +71,22 @@ func TestCheckFileExtension(t *testing.T) {
 	err = checkFileExtension("/dummy.yaml")
 	assert.Nil(t, err)
 }
+
+func TestTlsConfigWithInvalidFiles(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "oecCerts")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	invalidFilepath := filepath.Join(directory, "invalid.pem")
+	assert.Nil(t, ioutil.WriteFile(invalidFilepath, []byte("invalid"), 0600))
+
+	_, err = NewTlsConfig(&TlsConf{RootCaFilepaths: []string{invalidFilepath}})
+	assert.EqualError(t, err, "Root CA file["+invalidFilepath+"] does not contain any PEM encoded certificate.")
+
+	_, err = NewTlsConfig(&TlsConf{ClientCertFilepath: invalidFilepath})
+	assert.EqualError(t, err, "Both client certificate and client key files should be given.")
+
+	_, err = NewTlsConfig(&TlsConf{ClientCertFilepath: invalidFilepath, ClientKeyFilepath: invalidFilepath})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,284 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/opsgenie/oec/conf"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxNumberOfEntries = 1000
+	retentionInDays    = 7
+
+	entryFileExtension = ".json"
+)
+
+type Entry struct {
+	Id             string             `json:"id"`
+	MessageId      string             `json:"messageId"`
+	Integration    string             `json:"integration,omitempty"`
+	Body           string             `json:"body"`
+	RequestId      string             `json:"requestId,omitempty"`
+	EntityId       string             `json:"entityId,omitempty"`
+	Action         string             `json:"action,omitempty"`
+	MappedAction   *conf.MappedAction `json:"mappedAction,omitempty"`
+	Error          string             `json:"error"`
+	ProcessedAt    time.Time          `json:"processedAt"`
+	FailedAt       time.Time          `json:"failedAt"`
+	ReplayCount    int                `json:"replayCount,omitempty"`
+	LastReplayedAt *time.Time         `json:"lastReplayedAt,omitempty"`
+}
+
+type Store interface {
+	Put(entry *Entry) error
+	Get(id string) (*Entry, error)
+	List() ([]*Entry, error)
+	Remove(id string) error
+	Purge() (int, error)
+}
+
+// fileStore keeps each entry in a file of its directory. The failure times of the entries are indexed in memory
+// once the store is opened, so the retention is applied without scanning the directory on each put. Entries put by
+// another process, like a replay of the dlq command, are indexed when the store is opened again.
+type fileStore struct {
+	directory          string
+	maxNumberOfEntries int
+	retention          time.Duration
+
+	index []indexedEntry // ordered from the oldest failure to the newest one
+	mu    *sync.Mutex
+}
+
+type indexedEntry struct {
+	id       string
+	failedAt time.Time
+}
+
+func NewStore(deadLetterConf *conf.DeadLetterConf) (Store, error) {
+
+	if deadLetterConf.Directory == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		deadLetterConf.Directory = filepath.Join(homeDir, "oec", "deadLetters")
+		logrus.Infof("Dead letter directory is not set, default directory[%s] is set.", deadLetterConf.Directory)
+	}
+
+	if deadLetterConf.MaxNumberOfEntries <= 0 {
+		logrus.Infof("Max number of dead letter entries should be greater than zero, default value[%d] is set.", maxNumberOfEntries)
+		deadLetterConf.MaxNumberOfEntries = maxNumberOfEntries
+	}
+
+	if deadLetterConf.RetentionInDays <= 0 {
+		logrus.Infof("Dead letter retention should be greater than zero, default value[%d days] is set.", retentionInDays)
+		deadLetterConf.RetentionInDays = retentionInDays
+	}
+
+	err := os.MkdirAll(deadLetterConf.Directory, 0700)
+	if err != nil {
+		return nil, errors.Errorf("Dead letter directory[%s] could not be created: %s", deadLetterConf.Directory, err)
+	}
+
+	store := &fileStore{
+		directory:          deadLetterConf.Directory,
+		maxNumberOfEntries: deadLetterConf.MaxNumberOfEntries,
+		retention:          time.Duration(deadLetterConf.RetentionInDays) * 24 * time.Hour,
+		mu:                 &sync.Mutex{},
+	}
+
+	entries, err := store.list()
+	if err != nil {
+		return nil, errors.Errorf("Dead letter directory[%s] could not be read: %s", deadLetterConf.Directory, err)
+	}
+	store.index = make([]indexedEntry, 0, len(entries))
+	for _, entry := range entries {
+		store.index = append(store.index, indexedEntry{id: entry.Id, failedAt: entry.FailedAt})
+	}
+
+	return store, nil
+}
+
+func (s *fileStore) Put(entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry.Id == "" {
+		entry.Id = uuid.New().String()
+	}
+	if entry.FailedAt.IsZero() {
+		entry.FailedAt = time.Now()
+	}
+
+	content, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFilepath := s.filepath(entry.Id) + ".tmp"
+	err = ioutil.WriteFile(tmpFilepath, content, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpFilepath, s.filepath(entry.Id))
+	if err != nil {
+		os.Remove(tmpFilepath)
+		return err
+	}
+
+	s.addToIndex(entry)
+	s.applyRetention()
+	return nil
+}
+
+func (s *fileStore) Get(id string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read(id)
+}
+
+func (s *fileStore) List() ([]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list()
+}
+
+func (s *fileStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeFromIndex(id)
+	err := os.Remove(s.filepath(id))
+	if os.IsNotExist(err) {
+		return errors.Errorf("Dead letter[%s] could not be found.", id)
+	}
+	return err
+}
+
+func (s *fileStore) Purge() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.list()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, entry := range entries {
+		s.removeFromIndex(entry.Id)
+		err := os.Remove(s.filepath(entry.Id))
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (s *fileStore) filepath(id string) string {
+	return filepath.Join(s.directory, filepath.Base(id)+entryFileExtension)
+}
+
+func (s *fileStore) read(id string) (*Entry, error) {
+
+	content, err := ioutil.ReadFile(s.filepath(id))
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("Dead letter[%s] could not be found.", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{}
+	err = json.Unmarshal(content, entry)
+	if err != nil {
+		return nil, errors.Errorf("Dead letter[%s] could not be parsed: %s", id, err)
+	}
+	return entry, nil
+}
+
+// list returns the entries ordered from the oldest failure to the newest one.
+func (s *fileStore) list() ([]*Entry, error) {
+
+	files, err := ioutil.ReadDir(s.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != entryFileExtension {
+			continue
+		}
+
+		entry, err := s.read(strings.TrimSuffix(file.Name(), entryFileExtension))
+		if err != nil {
+			logrus.Warn(err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].FailedAt.Before(entries[j].FailedAt)
+	})
+
+	return entries, nil
+}
+
+func (s *fileStore) addToIndex(entry *Entry) {
+	s.removeFromIndex(entry.Id)
+
+	i := sort.Search(len(s.index), func(i int) bool {
+		return s.index[i].failedAt.After(entry.FailedAt)
+	})
+	s.index = append(s.index, indexedEntry{})
+	copy(s.index[i+1:], s.index[i:])
+	s.index[i] = indexedEntry{id: entry.Id, failedAt: entry.FailedAt}
+}
+
+func (s *fileStore) removeFromIndex(id string) {
+	for i := range s.index {
+		if s.index[i].id == id {
+			s.index = append(s.index[:i], s.index[i+1:]...)
+			return
+		}
+	}
+}
+
+// applyRetention removes the oldest entries while the store exceeds its max number of entries or they are expired.
+func (s *fileStore) applyRetention() {
+
+	expireTime := time.Now().Add(-s.retention)
+
+	for len(s.index) > 0 {
+		oldest := s.index[0]
+		if len(s.index) <= s.maxNumberOfEntries && oldest.failedAt.After(expireTime) {
+			break
+		}
+		s.index = s.index[1:]
+
+		err := os.Remove(s.filepath(oldest.id))
+		if os.IsNotExist(err) { // removed by another process
+			continue
+		}
+		if err != nil {
+			logrus.Warnf("Dead letter[%s] could not be removed: %s", oldest.id, err)
+			continue
+		}
+		logrus.Debugf("Dead letter[%s] is removed due to retention policy.", oldest.id)
+	}
+}
@@ -0,0 +1,175 @@
+package deadletter
+
+import (
+	"github.com/opsgenie/oec/conf"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func newStoreTest(t *testing.T, maxNumberOfEntries, retentionInDays int) *fileStore {
+	directory, err := ioutil.TempDir("", "oecDeadLetters")
+	assert.Nil(t, err)
+
+	store, err := NewStore(&conf.DeadLetterConf{
+		Directory:          directory,
+		MaxNumberOfEntries: maxNumberOfEntries,
+		RetentionInDays:    retentionInDays,
+	})
+	assert.Nil(t, err)
+
+	return store.(*fileStore)
+}
+
+func TestValidateNewStore(t *testing.T) {
+	directory, err := ioutil.TempDir("", "oecDeadLetters")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	deadLetterConf := &conf.DeadLetterConf{
+		Directory:          filepath.Join(directory, "nested"),
+		MaxNumberOfEntries: -1,
+		RetentionInDays:    -1,
+	}
+	_, err = NewStore(deadLetterConf)
+
+	assert.Nil(t, err)
+	assert.Equal(t, maxNumberOfEntries, deadLetterConf.MaxNumberOfEntries)
+	assert.Equal(t, retentionInDays, deadLetterConf.RetentionInDays)
+	assert.DirExists(t, deadLetterConf.Directory)
+}
+
+func TestPutAndGet(t *testing.T) {
+	store := newStoreTest(t, 10, 1)
+	defer os.RemoveAll(store.directory)
+
+	entry := &Entry{
+		MessageId:    "messageId",
+		Body:         `{"action":"Create"}`,
+		Action:       "Create",
+		MappedAction: &conf.MappedAction{Type: "custom", SourceType: "local", Filepath: "/path/to/action.sh"},
+		Error:        "Process Error",
+	}
+
+	err := store.Put(entry)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, entry.Id)
+	assert.False(t, entry.FailedAt.IsZero())
+
+	actual, err := store.Get(entry.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, entry.MessageId, actual.MessageId)
+	assert.Equal(t, entry.Body, actual.Body)
+	assert.Equal(t, entry.Error, actual.Error)
+	assert.Equal(t, entry.MappedAction, actual.MappedAction)
+	assert.True(t, entry.FailedAt.Equal(actual.FailedAt))
+}
+
+func TestGetNotExistingEntry(t *testing.T) {
+	store := newStoreTest(t, 10, 1)
+	defer os.RemoveAll(store.directory)
+
+	_, err := store.Get("notExisting")
+	assert.EqualError(t, err, "Dead letter[notExisting] could not be found.")
+}
+
+func TestListOrdersByFailureTime(t *testing.T) {
+	store := newStoreTest(t, 10, 1)
+	defer os.RemoveAll(store.directory)
+
+	now := time.Now()
+	store.Put(&Entry{Id: "second", FailedAt: now.Add(-time.Minute)})
+	store.Put(&Entry{Id: "third", FailedAt: now})
+	store.Put(&Entry{Id: "first", FailedAt: now.Add(-time.Hour)})
+
+	entries, err := store.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "first", entries[0].Id)
+	assert.Equal(t, "second", entries[1].Id)
+	assert.Equal(t, "third", entries[2].Id)
+}
+
+func TestPutAppliesMaxNumberOfEntries(t *testing.T) {
+	store := newStoreTest(t, 2, 1)
+	defer os.RemoveAll(store.directory)
+
+	now := time.Now()
+	store.Put(&Entry{Id: "oldest", FailedAt: now.Add(-time.Hour)})
+	store.Put(&Entry{Id: "older", FailedAt: now.Add(-time.Minute)})
+	store.Put(&Entry{Id: "newest", FailedAt: now})
+
+	entries, err := store.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "older", entries[0].Id)
+	assert.Equal(t, "newest", entries[1].Id)
+}
+
+func TestPutAppliesMaxNumberOfEntriesToExistingEntries(t *testing.T) {
+	store := newStoreTest(t, 2, 1)
+	defer os.RemoveAll(store.directory)
+
+	now := time.Now()
+	store.Put(&Entry{Id: "oldest", FailedAt: now.Add(-time.Hour)})
+	store.Put(&Entry{Id: "older", FailedAt: now.Add(-time.Minute)})
+
+	reopened, err := NewStore(&conf.DeadLetterConf{
+		Directory:          store.directory,
+		MaxNumberOfEntries: 2,
+		RetentionInDays:    1,
+	})
+	assert.Nil(t, err)
+	reopened.Put(&Entry{Id: "newest", FailedAt: now})
+
+	entries, err := reopened.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "older", entries[0].Id)
+	assert.Equal(t, "newest", entries[1].Id)
+}
+
+func TestPutAppliesRetention(t *testing.T) {
+	store := newStoreTest(t, 10, 1)
+	defer os.RemoveAll(store.directory)
+
+	store.Put(&Entry{Id: "expired", FailedAt: time.Now().Add(-48 * time.Hour)})
+	store.Put(&Entry{Id: "fresh"})
+
+	entries, err := store.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "fresh", entries[0].Id)
+}
+
+func TestRemoveAndPurge(t *testing.T) {
+	store := newStoreTest(t, 10, 1)
+	defer os.RemoveAll(store.directory)
+
+	store.Put(&Entry{Id: "first"})
+	store.Put(&Entry{Id: "second"})
+	store.Put(&Entry{Id: "third"})
+
+	err := store.Remove("first")
+	assert.Nil(t, err)
+
+	err = store.Remove("first")
+	assert.EqualError(t, err, "Dead letter[first] could not be found.")
+
+	count, err := store.Purge()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	entries, err := store.List()
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,27 @@
+package git
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	repositoryPulls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oec_git_pulls_total",
+			Help: "Number of git repository pulls by outcome: updated, up_to_date or failure.",
+		},
+		[]string{"outcome"},
+	)
+	repositoryPullDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "oec_git_pull_duration_seconds",
+			Help:    "Duration of the git repository pulls.",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		repositoryPulls,
+		repositoryPullDuration,
+	)
+}
@@ -79,3 +79,17 @@ func FetchAndReset(repositoryPath, privateKeyFilePath, passPhrase string) error
 		Mode:   git.HardReset,
 	})
 }
+
+// HeadCommit returns the hash of the commit the repository is checked out at.
+func HeadCommit(repositoryPath string) (string, error) {
+	r, err := git.PlainOpen(repositoryPath)
+	if err != nil {
+		return "", err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Hash().String(), nil
+}
@@ -6,7 +6,9 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"os"
+	"sort"
 	"sync"
+	"time"
 )
 
 type Options struct {
@@ -79,6 +81,18 @@ func (r Repositories) PullAll() {
 	}
 }
 
+// Status returns the status of the repositories, sorted by their urls.
+func (r Repositories) Status() []RepositoryStatus {
+	statusList := make([]RepositoryStatus, 0, len(r))
+	for _, repository := range r {
+		statusList = append(statusList, repository.Status())
+	}
+	sort.Slice(statusList, func(i, j int) bool {
+		return statusList[i].Url < statusList[j].Url
+	})
+	return statusList
+}
+
 func (r Repositories) RemoveAll() {
 	for _, repository := range r {
 		err := repository.Remove()
@@ -94,13 +108,26 @@ type Repository struct {
 	Path    string
 	Options Options
 	rw      *sync.RWMutex
+
+	updatedAt     time.Time
+	lastPullError error
+	statusMu      *sync.Mutex
+}
+
+// RepositoryStatus shows when a repository was last synchronized with its remote.
+type RepositoryStatus struct {
+	Url           string    `json:"url"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	LastPullError string    `json:"lastPullError,omitempty"`
 }
 
 func NewRepository(path string, options Options) *Repository {
 	repository := &Repository{
-		rw:      &sync.RWMutex{},
-		Path:    path,
-		Options: options,
+		rw:        &sync.RWMutex{},
+		Path:      path,
+		Options:   options,
+		updatedAt: time.Now(),
+		statusMu:  &sync.Mutex{},
 	}
 
 	err := repository.Chmod(0700)
@@ -120,7 +147,50 @@ func (r *Repository) Pull() error {
 			logrus.Warnf("Git repository[%s] chmod failed: %s", r.Options.Url, err)
 		}
 	}()
-	return FetchAndReset(r.Path, r.Options.PrivateKeyFilepath, r.Options.Passphrase)
+	start := time.Now()
+	err := FetchAndReset(r.Path, r.Options.PrivateKeyFilepath, r.Options.Passphrase)
+	repositoryPullDuration.Observe(time.Since(start).Seconds())
+
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	switch err {
+	case nil:
+		repositoryPulls.WithLabelValues("updated").Inc()
+	case git.NoErrAlreadyUpToDate:
+		repositoryPulls.WithLabelValues("up_to_date").Inc()
+	default:
+		repositoryPulls.WithLabelValues("failure").Inc()
+		r.lastPullError = err
+		return err
+	}
+	r.updatedAt = time.Now()
+	r.lastPullError = nil
+	return err
+}
+
+func (r *Repository) Status() RepositoryStatus {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	status := RepositoryStatus{
+		Url:       r.Options.Url,
+		UpdatedAt: r.updatedAt,
+	}
+	if r.lastPullError != nil {
+		status.LastPullError = r.lastPullError.Error()
+	}
+	return status
+}
+
+// Commit returns the commit the repository is checked out at, or an empty string if it could not be read. It should
+// be called while the repository is locked for reading, so that the commit is the one the actions are run from.
+func (r *Repository) Commit() string {
+	commit, err := HeadCommit(r.Path)
+	if err != nil {
+		logrus.Debugf("Commit of git repository[%s] could not be read: %s", r.Options.Url, err)
+		return ""
+	}
+	return commit
 }
 
 func (r *Repository) Remove() error {
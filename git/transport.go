@@ -0,0 +1,13 @@
+package git
+
+import (
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"net/http"
+)
+
+// SetHttpClient makes the repositories cloned and pulled over HTTP(S) use the given client.
+func SetHttpClient(httpClient *http.Client) {
+	client.InstallProtocol("https", githttp.NewClient(httpClient))
+	client.InstallProtocol("http", githttp.NewClient(httpClient))
+}
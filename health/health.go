@@ -0,0 +1,216 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/queue"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+const (
+	maxTokenRefreshFailures   = 5
+	maxRepositoryAgeInSeconds = 15 * 60
+	maxPoolSaturation         = 1.0
+	maxOutboxDepth            = 1000
+)
+
+type statusProvider interface {
+	Status() queue.Status
+}
+
+// Report is the result of a health check. The check passes if there is no problem.
+type Report struct {
+	Status   string   `json:"status"`
+	Problems []string `json:"problems"`
+}
+
+// StatusReport is the detailed state of the processor along with the results of both health checks.
+type StatusReport struct {
+	Liveness  Report       `json:"liveness"`
+	Readiness Report       `json:"readiness"`
+	Processor queue.Status `json:"processor"`
+}
+
+// Checker evaluates the state of a processor against the thresholds of the health configuration.
+//
+// OEC is not live if it has failed to refresh its token too many times in a row or a poller has kept its
+// expired credentials, so restarting it is expected to help. It is not ready if it is not live, if it is not
+// running, if a git repository has not been pulled for too long or if it is falling behind on the actions
+// and the results. A standby instance of high availability is ready as long as it can check the lease.
+type Checker struct {
+	processor               statusProvider
+	maxTokenRefreshFailures int
+	maxRepositoryAge        time.Duration
+	maxPoolSaturation       float64
+	maxOutboxDepth          int
+	nowFunc                 func() time.Time
+}
+
+func NewChecker(healthConf *conf.HealthConf, processor queue.Processor) *Checker {
+	return newChecker(healthConf, processor)
+}
+
+func newChecker(healthConf *conf.HealthConf, processor statusProvider) *Checker {
+
+	if healthConf.MaxTokenRefreshFailures <= 0 {
+		logrus.Infof("Max token refresh failures should be greater than zero, default value[%d] is set.", maxTokenRefreshFailures)
+		healthConf.MaxTokenRefreshFailures = maxTokenRefreshFailures
+	}
+
+	if healthConf.MaxRepositoryAgeInSeconds <= 0 {
+		logrus.Infof("Max repository age should be greater than zero, default value[%d seconds] is set.", maxRepositoryAgeInSeconds)
+		healthConf.MaxRepositoryAgeInSeconds = maxRepositoryAgeInSeconds
+	}
+
+	if healthConf.MaxPoolSaturation <= 0 {
+		logrus.Infof("Max pool saturation should be greater than zero, default value[%.2f] is set.", maxPoolSaturation)
+		healthConf.MaxPoolSaturation = maxPoolSaturation
+	}
+
+	if healthConf.MaxOutboxDepth <= 0 {
+		logrus.Infof("Max outbox depth should be greater than zero, default value[%d] is set.", maxOutboxDepth)
+		healthConf.MaxOutboxDepth = maxOutboxDepth
+	}
+
+	return &Checker{
+		processor:               processor,
+		maxTokenRefreshFailures: healthConf.MaxTokenRefreshFailures,
+		maxRepositoryAge:        time.Duration(healthConf.MaxRepositoryAgeInSeconds) * time.Second,
+		maxPoolSaturation:       healthConf.MaxPoolSaturation,
+		maxOutboxDepth:          healthConf.MaxOutboxDepth,
+		nowFunc:                 time.Now,
+	}
+}
+
+// Handle registers /healthz, /readyz and /status to the mux.
+func (c *Checker) Handle(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", c.serveLiveness)
+	mux.HandleFunc("/readyz", c.serveReadiness)
+	mux.HandleFunc("/status", c.serveStatus)
+}
+
+func (c *Checker) Liveness(status queue.Status) Report {
+	return newReport(c.livenessProblems(status))
+}
+
+func (c *Checker) Readiness(status queue.Status) Report {
+	return newReport(c.readinessProblems(status))
+}
+
+func (c *Checker) livenessProblems(status queue.Status) []string {
+
+	problems := []string{}
+	if isStandby(status) {
+		return problems
+	}
+
+	now := c.nowFunc()
+	for _, integration := range status.Integrations {
+		tokenRefresh := integration.TokenRefresh
+		if tokenRefresh.ConsecutiveFailures >= c.maxTokenRefreshFailures {
+			problems = append(problems, fmt.Sprintf("%sToken could not be refreshed %d times in a row: %s",
+				prefix(integration), tokenRefresh.ConsecutiveFailures, tokenRefresh.LastError))
+		}
+		for _, poller := range integration.Pollers {
+			if poller.IsRunning && poller.IsTokenExpired {
+				problems = append(problems, fmt.Sprintf("%sCredentials of poller[%s] have expired %s ago.",
+					prefix(integration), poller.QueueUrl, now.Sub(poller.CredentialsExpireAt).Round(time.Second).String()))
+			}
+		}
+	}
+	return problems
+}
+
+func (c *Checker) readinessProblems(status queue.Status) []string {
+
+	problems := c.livenessProblems(status)
+
+	if !status.IsRunning {
+		problems = append(problems, "Queue processor is not running.")
+	}
+
+	if status.Lease != nil && status.Lease.LastError != "" {
+		problems = append(problems, fmt.Sprintf("Lease could not be checked: %s", status.Lease.LastError))
+	}
+
+	now := c.nowFunc()
+	for _, repository := range status.Repositories {
+		age := now.Sub(repository.UpdatedAt)
+		if age > c.maxRepositoryAge {
+			problems = append(problems, fmt.Sprintf("Git repository[%s] has not been pulled for %s: %s",
+				repository.Url, age.Round(time.Second).String(), repository.LastPullError))
+		}
+	}
+
+	if isStandby(status) {
+		return problems
+	}
+
+	for _, integration := range status.Integrations {
+		if status.IsRunning && !integration.IsRunning {
+			problems = append(problems, fmt.Sprintf("%sQueue processor is not running.", prefix(integration)))
+		}
+		pool := integration.Pool
+		if pool.Saturation >= c.maxPoolSaturation && pool.NumberOfQueuedJob >= pool.QueueSize {
+			problems = append(problems, fmt.Sprintf("%sWorker pool is saturated, %d of %d workers are busy and %d jobs are queued.",
+				prefix(integration), pool.NumberOfBusyWorker, pool.MaxNumberOfWorker, pool.NumberOfQueuedJob))
+		}
+		if integration.OutboxDepth > c.maxOutboxDepth {
+			problems = append(problems, fmt.Sprintf("%s%d results are waiting to be sent to Opsgenie.",
+				prefix(integration), integration.OutboxDepth))
+		}
+	}
+	return problems
+}
+
+func (c *Checker) serveLiveness(w http.ResponseWriter, r *http.Request) {
+	report := c.Liveness(c.processor.Status())
+	writeReport(w, report, report)
+}
+
+func (c *Checker) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	report := c.Readiness(c.processor.Status())
+	writeReport(w, report, report)
+}
+
+func (c *Checker) serveStatus(w http.ResponseWriter, r *http.Request) {
+	status := c.processor.Status()
+	report := StatusReport{
+		Liveness:  c.Liveness(status),
+		Readiness: c.Readiness(status),
+		Processor: status,
+	}
+	writeReport(w, report.Liveness, report)
+}
+
+func writeReport(w http.ResponseWriter, report Report, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if len(report.Problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		logrus.Debugf("Health report could not be written: %s", err)
+	}
+}
+
+func newReport(problems []string) Report {
+	if len(problems) > 0 {
+		return Report{Status: "unhealthy", Problems: problems}
+	}
+	return Report{Status: "ok", Problems: problems}
+}
+
+func isStandby(status queue.Status) bool {
+	return status.Lease != nil && !status.Lease.IsLeader
+}
+
+func prefix(integration queue.IntegrationStatus) string {
+	if integration.Name == "" {
+		return ""
+	}
+	return fmt.Sprintf("Integration[%s]: ", integration.Name)
+}
@@ -0,0 +1,196 @@
+package health
+
+import (
+	"encoding/json"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/git"
+	"github.com/opsgenie/oec/lease"
+	"github.com/opsgenie/oec/queue"
+	"github.com/opsgenie/oec/worker_pool"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+var mockNow = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+type MockProcessor struct {
+	status queue.Status
+}
+
+func (m *MockProcessor) Status() queue.Status {
+	return m.status
+}
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func newMockStatus() queue.Status {
+	lastSuccessAt := mockNow.Add(-time.Minute)
+	return queue.Status{
+		IsRunning: true,
+		Integrations: []queue.IntegrationStatus{
+			{
+				IsRunning:    true,
+				TokenRefresh: queue.TokenRefreshStatus{LastSuccessAt: &lastSuccessAt},
+				Pollers: []queue.PollerStatus{
+					{QueueUrl: "queueUrl", IsRunning: true, CredentialsExpireAt: mockNow.Add(time.Hour)},
+				},
+				Pool: worker_pool.Status{MaxNumberOfWorker: 4, NumberOfBusyWorker: 1, QueueSize: 2, Saturation: 0.25},
+			},
+		},
+		Repositories: []git.RepositoryStatus{
+			{Url: "repositoryUrl", UpdatedAt: mockNow.Add(-time.Minute)},
+		},
+	}
+}
+
+func newMockChecker(status queue.Status) *Checker {
+	checker := newChecker(&conf.HealthConf{}, &MockProcessor{status: status})
+	checker.nowFunc = func() time.Time { return mockNow }
+	return checker
+}
+
+func TestNewCheckerSetsDefaults(t *testing.T) {
+
+	healthConf := &conf.HealthConf{}
+	checker := newChecker(healthConf, &MockProcessor{})
+
+	assert.Equal(t, maxTokenRefreshFailures, checker.maxTokenRefreshFailures)
+	assert.Equal(t, 15*time.Minute, checker.maxRepositoryAge)
+	assert.Equal(t, maxPoolSaturation, checker.maxPoolSaturation)
+	assert.Equal(t, maxOutboxDepth, checker.maxOutboxDepth)
+	assert.Equal(t, int64(maxRepositoryAgeInSeconds), healthConf.MaxRepositoryAgeInSeconds)
+}
+
+func TestHealthyProcessor(t *testing.T) {
+
+	checker := newMockChecker(newMockStatus())
+
+	liveness := checker.Liveness(newMockStatus())
+	assert.Equal(t, "ok", liveness.Status)
+	assert.Empty(t, liveness.Problems)
+
+	readiness := checker.Readiness(newMockStatus())
+	assert.Equal(t, "ok", readiness.Status)
+	assert.Empty(t, readiness.Problems)
+}
+
+func TestLivenessFailsOnTokenRefreshFailures(t *testing.T) {
+
+	status := newMockStatus()
+	status.Integrations[0].Name = "first"
+	status.Integrations[0].TokenRefresh.ConsecutiveFailures = maxTokenRefreshFailures
+	status.Integrations[0].TokenRefresh.LastError = "Test error"
+
+	checker := newMockChecker(status)
+
+	liveness := checker.Liveness(status)
+	assert.Equal(t, "unhealthy", liveness.Status)
+	assert.Equal(t, []string{"Integration[first]: Token could not be refreshed 5 times in a row: Test error"}, liveness.Problems)
+
+	readiness := checker.Readiness(status)
+	assert.Equal(t, liveness.Problems, readiness.Problems)
+}
+
+func TestLivenessFailsOnExpiredCredentials(t *testing.T) {
+
+	status := newMockStatus()
+	status.Integrations[0].Pollers[0].IsTokenExpired = true
+	status.Integrations[0].Pollers[0].CredentialsExpireAt = mockNow.Add(-time.Minute)
+
+	checker := newMockChecker(status)
+
+	liveness := checker.Liveness(status)
+	assert.Equal(t, []string{"Credentials of poller[queueUrl] have expired 1m0s ago."}, liveness.Problems)
+}
+
+func TestReadinessFailsOnStaleRepository(t *testing.T) {
+
+	status := newMockStatus()
+	status.Repositories[0].UpdatedAt = mockNow.Add(-time.Hour)
+	status.Repositories[0].LastPullError = "Test error"
+
+	checker := newMockChecker(status)
+
+	assert.Empty(t, checker.Liveness(status).Problems)
+	assert.Equal(t, []string{"Git repository[repositoryUrl] has not been pulled for 1h0m0s: Test error"},
+		checker.Readiness(status).Problems)
+}
+
+func TestReadinessFailsOnBacklog(t *testing.T) {
+
+	status := newMockStatus()
+	status.Integrations[0].Pool = worker_pool.Status{MaxNumberOfWorker: 4, NumberOfBusyWorker: 4, QueueSize: 2, NumberOfQueuedJob: 2, Saturation: 1}
+	status.Integrations[0].OutboxDepth = maxOutboxDepth + 1
+
+	checker := newMockChecker(status)
+
+	assert.Empty(t, checker.Liveness(status).Problems)
+	assert.Equal(t, []string{
+		"Worker pool is saturated, 4 of 4 workers are busy and 2 jobs are queued.",
+		"1001 results are waiting to be sent to Opsgenie.",
+	}, checker.Readiness(status).Problems)
+}
+
+func TestReadinessFailsIfNotRunning(t *testing.T) {
+
+	status := queue.Status{Integrations: []queue.IntegrationStatus{}}
+
+	checker := newMockChecker(status)
+
+	assert.Empty(t, checker.Liveness(status).Problems)
+	assert.Equal(t, []string{"Queue processor is not running."}, checker.Readiness(status).Problems)
+}
+
+func TestStandbySkipsIntegrationChecks(t *testing.T) {
+
+	status := newMockStatus()
+	status.Integrations = []queue.IntegrationStatus{}
+	status.Lease = &lease.Status{InstanceId: "standby", Leader: "leader"}
+
+	checker := newMockChecker(status)
+	assert.Empty(t, checker.Readiness(status).Problems)
+
+	status.Lease.LastError = "Test error"
+	assert.Equal(t, []string{"Lease could not be checked: Test error"}, checker.Readiness(status).Problems)
+}
+
+func TestHandlers(t *testing.T) {
+
+	status := newMockStatus()
+	status.Integrations[0].OutboxDepth = maxOutboxDepth + 1
+
+	checker := newMockChecker(status)
+	mux := http.NewServeMux()
+	checker.Handle(mux)
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"status":"ok","problems":[]}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.JSONEq(t, `{"status":"unhealthy","problems":["1001 results are waiting to be sent to Opsgenie."]}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	report := StatusReport{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &report)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", report.Liveness.Status)
+	assert.Equal(t, "unhealthy", report.Readiness.Status)
+	assert.Equal(t, maxOutboxDepth+1, report.Processor.Integrations[0].OutboxDepth)
+	assert.Equal(t, "queueUrl", report.Processor.Integrations[0].Pollers[0].QueueUrl)
+}
@@ -0,0 +1,396 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/opsgenie/oec/conf"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxSizeInMb              = 50
+	retentionInDays          = 30
+	outputExcerptSizeInBytes = 1024
+
+	// segmentsPerStore is the number of segments a store is split into, the oldest segment is removed at once
+	// when the store exceeds its size. A segment is not written after segmentPeriod, so that it expires in time.
+	segmentsPerStore     = 10
+	segmentPeriod        = 24 * time.Hour
+	segmentFileExtension = ".jsonl"
+)
+
+// Outcomes of the executions.
+const (
+	Succeeded = "success"
+	Failed    = "failure"
+	Cancelled = "cancelled"
+	Errored   = "error"
+)
+
+// Entry is an execution of an action.
+type Entry struct {
+	Integration    string    `json:"integration,omitempty"`
+	MessageId      string    `json:"messageId"`
+	RequestId      string    `json:"requestId,omitempty"`
+	EntityId       string    `json:"entityId,omitempty"`
+	EntityType     string    `json:"entityType,omitempty"`
+	Action         string    `json:"action"`
+	ActionType     string    `json:"actionType,omitempty"`
+	StartedAt      time.Time `json:"startedAt"`
+	EndedAt        time.Time `json:"endedAt"`
+	Outcome        string    `json:"outcome"`
+	ExitCode       *int      `json:"exitCode,omitempty"`
+	FailureMessage string    `json:"failureMessage,omitempty"`
+	Stdout         string    `json:"stdout,omitempty"`
+	Stderr         string    `json:"stderr,omitempty"`
+	CaptureFile    string    `json:"captureFile,omitempty"`
+}
+
+func (e *Entry) Duration() time.Duration {
+	return e.EndedAt.Sub(e.StartedAt)
+}
+
+// Filter selects the entries of a query. Empty fields match all the entries, entries are matched by their start
+// time. At most Limit entries are returned unless it is zero.
+type Filter struct {
+	Integration string
+	Action      string
+	EntityId    string
+	RequestId   string
+	Since       time.Time
+	Until       time.Time
+	FailedOnly  bool
+	Limit       int
+}
+
+func (f *Filter) matches(entry *Entry) bool {
+	return (f.Integration == "" || f.Integration == entry.Integration) &&
+		(f.Action == "" || f.Action == entry.Action) &&
+		(f.EntityId == "" || f.EntityId == entry.EntityId) &&
+		(f.RequestId == "" || f.RequestId == entry.RequestId) &&
+		(f.Since.IsZero() || !entry.StartedAt.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.StartedAt.Before(f.Until)) &&
+		(!f.FailedOnly || entry.Outcome != Succeeded)
+}
+
+// Store keeps the entries as JSON lines in the segment files of its directory. A new segment is started once the
+// current one reaches its share of the store size; the oldest segments are removed while the store exceeds its
+// size, or once they are older than the retention. A new segment is also started every day.
+type Store struct {
+	directory   string
+	maxSize     int64
+	segmentSize int64
+	retention   time.Duration
+
+	segment          *os.File
+	segmentUsed      int64
+	segmentStartedAt time.Time
+	mu               *sync.Mutex
+}
+
+func Open(historyConf *conf.HistoryConf) (*Store, error) {
+
+	if historyConf.Directory == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		historyConf.Directory = filepath.Join(homeDir, "oec", "history")
+		logrus.Infof("History directory is not set, default directory[%s] is set.", historyConf.Directory)
+	}
+
+	if historyConf.MaxSizeInMb <= 0 {
+		logrus.Infof("Max size of the history should be greater than zero, default value[%dMB] is set.", maxSizeInMb)
+		historyConf.MaxSizeInMb = maxSizeInMb
+	}
+
+	if historyConf.RetentionInDays <= 0 {
+		logrus.Infof("History retention should be greater than zero, default value[%d days] is set.", retentionInDays)
+		historyConf.RetentionInDays = retentionInDays
+	}
+
+	err := os.MkdirAll(historyConf.Directory, 0700)
+	if err != nil {
+		return nil, errors.Errorf("History directory[%s] could not be created: %s", historyConf.Directory, err)
+	}
+
+	maxSize := int64(historyConf.MaxSizeInMb) * 1024 * 1024
+	return &Store{
+		directory:   historyConf.Directory,
+		maxSize:     maxSize,
+		segmentSize: maxSize / segmentsPerStore,
+		retention:   time.Duration(historyConf.RetentionInDays) * 24 * time.Hour,
+		mu:          &sync.Mutex{},
+	}, nil
+}
+
+// Add appends the entry to the current segment.
+func (s *Store) Add(entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if s.segment == nil || s.segmentUsed+int64(len(line)) > s.segmentSize || time.Since(s.segmentStartedAt) > segmentPeriod {
+		err := s.startSegment()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := s.segment.Write(line)
+	s.segmentUsed += int64(n)
+	return err
+}
+
+// startSegment closes the current segment and creates a new one, after applying the retention.
+func (s *Store) startSegment() error {
+	if s.segment != nil {
+		s.segment.Close()
+		s.segment = nil
+	}
+
+	s.applyRetention()
+
+	path := filepath.Join(s.directory, fmt.Sprintf("%020d", time.Now().UnixNano())+segmentFileExtension)
+	segment, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Errorf("History segment[%s] could not be created: %s", path, err)
+	}
+
+	s.segment = segment
+	s.segmentUsed = 0
+	s.segmentStartedAt = time.Now()
+	return nil
+}
+
+// Query returns the entries matching the filter, from the newest to the oldest.
+func (s *Store) Query(filter *Filter) ([]*Entry, error) {
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0)
+	for i := len(segments) - 1; i >= 0; i-- {
+		// segments are written in order, so the ones last written before the range have no entry in it
+		if !filter.Since.IsZero() && segments[i].ModTime().Before(filter.Since) {
+			break
+		}
+
+		segmentEntries, err := s.read(segments[i].Name(), filter)
+		if err != nil {
+			return nil, err
+		}
+		for j := len(segmentEntries) - 1; j >= 0; j-- {
+			entries = append(entries, segmentEntries[j])
+			if filter.Limit > 0 && len(entries) == filter.Limit {
+				return entries, nil
+			}
+		}
+	}
+	return entries, nil
+}
+
+// read returns the entries of the segment matching the filter, in the order they are added. Lines which could not
+// be parsed, like the one being written, are skipped.
+func (s *Store) read(name string, filter *Filter) ([]*Entry, error) {
+	file, err := os.Open(filepath.Join(s.directory, name))
+	if os.IsNotExist(err) { // removed by the retention
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]*Entry, 0)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &Entry{}
+		if err := json.Unmarshal(line, entry); err != nil {
+			logrus.Debugf("History entry in segment[%s] could not be parsed: %s", name, err)
+			continue
+		}
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+}
+
+// segments returns the segment files from the oldest to the newest.
+func (s *Store) segments() ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(s.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]os.FileInfo, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), segmentFileExtension) {
+			segments = append(segments, file)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Name() < segments[j].Name()
+	})
+	return segments, nil
+}
+
+func (s *Store) applyRetention() {
+	segments, err := s.segments()
+	if err != nil {
+		logrus.Warnf("Retention of the history could not be applied: %s", err)
+		return
+	}
+
+	totalSize := int64(0)
+	for _, segment := range segments {
+		totalSize += segment.Size()
+	}
+
+	// the new segment takes a share of the size as well
+	expireTime := time.Now().Add(-s.retention)
+	for _, segment := range segments {
+		if totalSize+s.segmentSize <= s.maxSize && segment.ModTime().After(expireTime) {
+			break
+		}
+
+		err := os.Remove(filepath.Join(s.directory, segment.Name()))
+		if err != nil {
+			logrus.Warnf("History segment[%s] could not be removed: %s", segment.Name(), err)
+			continue
+		}
+		totalSize -= segment.Size()
+		logrus.Debugf("History segment[%s] is removed due to retention policy.", segment.Name())
+	}
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.segment == nil {
+		return nil
+	}
+	err := s.segment.Close()
+	s.segment = nil
+	return err
+}
+
+// ParseTime parses the time either in RFC 3339 format or as a duration before now, such as 12h.
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("Time[%s] should be in RFC 3339 format or a duration such as 12h.", value)
+	}
+	return parsed, nil
+}
+
+/******************************************************************************************/
+
+var (
+	current            *Store
+	currentExcerptSize int
+	currentMu          = &sync.RWMutex{}
+)
+
+// Configure opens the configured history, which the executions are added to until Close is called.
+// Executions are not kept unless the history is enabled.
+func Configure(historyConf *conf.HistoryConf) error {
+
+	if !historyConf.Enabled {
+		return nil
+	}
+
+	if historyConf.OutputExcerptSizeInBytes <= 0 {
+		logrus.Infof("Output excerpt size of the history should be greater than zero, default value[%d bytes] is set.", outputExcerptSizeInBytes)
+		historyConf.OutputExcerptSizeInBytes = outputExcerptSizeInBytes
+	}
+
+	store, err := Open(historyConf)
+	if err != nil {
+		return err
+	}
+
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = store
+	currentExcerptSize = historyConf.OutputExcerptSizeInBytes
+	return nil
+}
+
+func Close() error {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+
+	if current == nil {
+		return nil
+	}
+	err := current.Close()
+	current = nil
+	return err
+}
+
+// OutputExcerptSize returns the size of the last part of the outputs kept with the executions, which is zero if
+// the history is not enabled.
+func OutputExcerptSize() int {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+
+	if current == nil {
+		return 0
+	}
+	return currentExcerptSize
+}
+
+// Add adds the entry to the configured history. It does nothing if the history is not enabled.
+func Add(entry *Entry) {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+
+	if current == nil {
+		return
+	}
+
+	err := current.Add(entry)
+	if err != nil {
+		logrus.Errorf("History entry of message[%s] could not be added: %s", entry.MessageId, err)
+	}
+}
+
+// Query returns the entries of the configured history matching the filter.
+func Query(filter *Filter) ([]*Entry, error) {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+
+	if current == nil {
+		return nil, errors.New("Execution history is not enabled.")
+	}
+	return current.Query(filter)
+}
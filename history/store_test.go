@@ -0,0 +1,179 @@
+package history
+
+import (
+	"fmt"
+	"github.com/opsgenie/oec/conf"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func newStoreTest(t *testing.T, historyConf *conf.HistoryConf) (*Store, func()) {
+	directory, err := ioutil.TempDir("", "oecHistory")
+	assert.Nil(t, err)
+
+	historyConf.Directory = directory
+	store, err := Open(historyConf)
+	assert.Nil(t, err)
+
+	return store, func() {
+		store.Close()
+		os.RemoveAll(directory)
+	}
+}
+
+func TestQueryFiltersEntriesFromNewestToOldest(t *testing.T) {
+	store, cleanup := newStoreTest(t, &conf.HistoryConf{})
+	defer cleanup()
+
+	startedAt := time.Now().Add(-time.Hour)
+	for i, outcome := range []string{Succeeded, Failed, Succeeded, Cancelled} {
+		action := "Restart"
+		if i%2 == 1 {
+			action = "Create"
+		}
+		assert.Nil(t, store.Add(&Entry{
+			MessageId: fmt.Sprintf("message%d", i),
+			EntityId:  fmt.Sprintf("alert%d", i/2),
+			Action:    action,
+			StartedAt: startedAt.Add(time.Duration(i) * time.Minute),
+			EndedAt:   startedAt.Add(time.Duration(i)*time.Minute + time.Second),
+			Outcome:   outcome,
+		}))
+	}
+
+	messageIds := func(entries []*Entry) []string {
+		ids := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			ids = append(ids, entry.MessageId)
+		}
+		return ids
+	}
+
+	entries, err := store.Query(&Filter{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"message3", "message2", "message1", "message0"}, messageIds(entries))
+	assert.Equal(t, time.Second, entries[0].Duration())
+
+	entries, err = store.Query(&Filter{Action: "Restart"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"message2", "message0"}, messageIds(entries))
+
+	entries, err = store.Query(&Filter{EntityId: "alert1", FailedOnly: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"message3"}, messageIds(entries))
+
+	entries, err = store.Query(&Filter{Since: startedAt.Add(time.Minute), Until: startedAt.Add(3 * time.Minute)})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"message2", "message1"}, messageIds(entries))
+
+	entries, err = store.Query(&Filter{Limit: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"message3", "message2", "message1"}, messageIds(entries))
+}
+
+func TestQuerySkipsLinesWhichCouldNotBeParsed(t *testing.T) {
+	store, cleanup := newStoreTest(t, &conf.HistoryConf{})
+	defer cleanup()
+
+	assert.Nil(t, store.Add(&Entry{MessageId: "first", StartedAt: time.Now()}))
+	_, err := store.segment.WriteString(`{"messageId":"partial`)
+	assert.Nil(t, err)
+
+	entries, err := store.Query(&Filter{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "first", entries[0].MessageId)
+}
+
+func TestStoreRemovesOldestSegmentsWhenItExceedsItsSize(t *testing.T) {
+	store, cleanup := newStoreTest(t, &conf.HistoryConf{MaxSizeInMb: 1})
+	defer cleanup()
+
+	excerpt := strings.Repeat("a", 10*1024)
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, store.Add(&Entry{MessageId: fmt.Sprintf("message%d", i), StartedAt: time.Now(), Stdout: excerpt}))
+	}
+
+	segments, err := store.segments()
+	assert.Nil(t, err)
+	assert.True(t, len(segments) <= segmentsPerStore, "%d segments", len(segments))
+
+	totalSize := int64(0)
+	for _, segment := range segments {
+		totalSize += segment.Size()
+	}
+	assert.True(t, totalSize <= 1024*1024, "size is %d", totalSize)
+
+	entries, err := store.Query(&Filter{Limit: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, "message299", entries[0].MessageId)
+
+	entries, err = store.Query(&Filter{})
+	assert.Nil(t, err)
+	assert.True(t, len(entries) < 300)
+}
+
+func TestStoreRemovesExpiredSegments(t *testing.T) {
+	store, cleanup := newStoreTest(t, &conf.HistoryConf{RetentionInDays: 1})
+	defer cleanup()
+
+	expired := filepath.Join(store.directory, fmt.Sprintf("%020d", 1)+segmentFileExtension)
+	assert.Nil(t, ioutil.WriteFile(expired, []byte(`{"messageId":"expired"}`+"\n"), 0600))
+	old := time.Now().Add(-48 * time.Hour)
+	assert.Nil(t, os.Chtimes(expired, old, old))
+
+	assert.Nil(t, store.Add(&Entry{MessageId: "recent", StartedAt: time.Now()}))
+
+	_, err := os.Stat(expired)
+	assert.True(t, os.IsNotExist(err))
+
+	entries, err := store.Query(&Filter{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	parsed, err := ParseTime("12h", now)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), parsed)
+
+	parsed, err = ParseTime("2026-10-17T22:30:00Z", now)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2026, 10, 17, 22, 30, 0, 0, time.UTC), parsed)
+
+	_, err = ParseTime("yesterday", now)
+	assert.EqualError(t, err, "Time[yesterday] should be in RFC 3339 format or a duration such as 12h.")
+}
+
+func TestConfigure(t *testing.T) {
+	assert.Nil(t, Configure(&conf.HistoryConf{}))
+	assert.Equal(t, 0, OutputExcerptSize())
+	_, err := Query(&Filter{})
+	assert.EqualError(t, err, "Execution history is not enabled.")
+
+	directory, err := ioutil.TempDir("", "oecHistory")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	assert.Nil(t, Configure(&conf.HistoryConf{Enabled: true, Directory: directory}))
+	defer Close()
+	assert.Equal(t, 1024, OutputExcerptSize())
+
+	Add(&Entry{MessageId: "MessageId", StartedAt: time.Now()})
+	entries, err := Query(&Filter{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,307 @@
+package lease
+
+import (
+	"encoding/json"
+	"github.com/opsgenie/oec/conf"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Status is the lease state seen by an instance.
+type Status struct {
+	InstanceId   string     `json:"instanceId"`
+	IsLeader     bool       `json:"isLeader"`
+	Leader       string     `json:"leader,omitempty"`
+	FencingToken uint64     `json:"fencingToken,omitempty"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	CheckedAt    *time.Time `json:"checkedAt,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+}
+
+// Elector competes for the lease on behalf of an instance. The leader renews the lease in every renew
+// period and gives up leadership if it cannot renew it before it expires. A standby checks the lease in
+// every takeover window and acquires it once it expires.
+type Elector struct {
+	store          *Store
+	instanceId     string
+	leaseDuration  time.Duration
+	renewPeriod    time.Duration
+	takeoverWindow time.Duration
+
+	lease        *Lease    // held lease, accessed only by the run loop
+	standbyUntil time.Time // the lease is not acquired again until then after resigning
+
+	status   Status
+	statusMu *sync.RWMutex
+	changes  chan struct{}
+	resign   chan struct{}
+
+	isRunning   bool
+	isRunningWg *sync.WaitGroup
+	startStopMu *sync.Mutex
+	quit        chan struct{}
+}
+
+func NewElector(haConf *conf.HighAvailabilityConf) (*Elector, error) {
+
+	if haConf.LeaseDirectory == "" {
+		return nil, errors.New("Lease directory should be provided for high availability.")
+	}
+
+	if haConf.InstanceId == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, errors.Errorf("Instance id could not be set from the hostname: %s", err)
+		}
+		haConf.InstanceId = hostname
+		logrus.Infof("Instance id is not set, hostname[%s] is set.", hostname)
+	}
+
+	return &Elector{
+		store:          NewStore(haConf.LeaseDirectory),
+		instanceId:     haConf.InstanceId,
+		leaseDuration:  haConf.LeaseDuration(),
+		renewPeriod:    haConf.RenewPeriod(),
+		takeoverWindow: haConf.TakeoverWindow(),
+		status:         Status{InstanceId: haConf.InstanceId},
+		statusMu:       &sync.RWMutex{},
+		changes:        make(chan struct{}, 1),
+		resign:         make(chan struct{}, 1),
+		isRunningWg:    &sync.WaitGroup{},
+		startStopMu:    &sync.Mutex{},
+		quit:           make(chan struct{}),
+	}, nil
+}
+
+func (e *Elector) Start() error {
+	defer e.startStopMu.Unlock()
+	e.startStopMu.Lock()
+
+	if e.isRunning {
+		return errors.New("Lease elector is already running.")
+	}
+
+	logrus.Infof("Instance[%s] is competing for the lease in directory[%s], lease duration is %s.",
+		e.instanceId, e.store.directory, e.leaseDuration.String())
+
+	e.isRunningWg.Add(1)
+	go e.run()
+
+	e.isRunning = true
+	return nil
+}
+
+// Stop stops competing for the lease and releases it if it is held.
+func (e *Elector) Stop() error {
+	defer e.startStopMu.Unlock()
+	e.startStopMu.Lock()
+
+	if !e.isRunning {
+		return errors.New("Lease elector is not running.")
+	}
+
+	close(e.quit)
+	e.isRunningWg.Wait()
+
+	e.isRunning = false
+	return nil
+}
+
+// Resign releases the lease so that another instance can take over. This instance does not
+// acquire it again within the lease duration.
+func (e *Elector) Resign() {
+	select {
+	case e.resign <- struct{}{}:
+	default:
+	}
+}
+
+func (e *Elector) Status() Status {
+	e.statusMu.RLock()
+	defer e.statusMu.RUnlock()
+	return e.status
+}
+
+// Changes is notified whenever the instance becomes the leader or stops being the leader.
+func (e *Elector) Changes() <-chan struct{} {
+	return e.changes
+}
+
+// ServeHTTP writes the lease status as JSON. The instance is unhealthy if the lease could not be checked.
+func (e *Elector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := e.Status()
+
+	w.Header().Set("Content-Type", "application/json")
+	if status.LastError != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+func (e *Elector) run() {
+	defer e.isRunningWg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-e.quit:
+			e.release(time.Now())
+			return
+		case <-e.resign:
+			now := time.Now()
+			e.release(now)
+			e.standbyUntil = now.Add(e.leaseDuration)
+		case <-timer.C:
+		}
+
+		var wait time.Duration
+		if e.lease != nil {
+			wait = e.renew(time.Now())
+		} else {
+			wait = e.acquire(time.Now())
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+func (e *Elector) acquire(now time.Time) time.Duration {
+
+	if now.Before(e.standbyUntil) {
+		return e.standbyUntil.Sub(now)
+	}
+
+	lease, err := e.store.Acquire(e.instanceId, e.leaseDuration, now)
+	switch {
+	case err == nil:
+		e.lease = lease
+		logrus.Infof("Instance[%s] has acquired the lease with fencing token[%d] and is the leader now.", e.instanceId, lease.Token)
+		e.setStatus(lease, now, nil)
+		return e.renewPeriod
+	case err == ErrLeaseHeld:
+		if lease != nil {
+			logrus.Tracef("Lease is held by instance[%s] until %s.", lease.Holder, lease.ExpiresAt.Format(time.RFC3339))
+		}
+		e.setStatus(lease, now, nil)
+	default:
+		logrus.Warnf("Lease could not be checked: %s", err)
+		e.setStatus(nil, now, err)
+	}
+	return e.takeoverWindow
+}
+
+func (e *Elector) renew(now time.Time) time.Duration {
+
+	lease, err := e.store.Renew(e.lease, e.leaseDuration, now)
+	switch {
+	case err == nil:
+		e.lease = lease
+		e.setStatus(lease, now, nil)
+		return e.renewPeriod
+	case err == ErrLeaseLost:
+		logrus.Warnf("Instance[%s] has lost the lease with fencing token[%d] and is not the leader anymore.", e.instanceId, e.lease.Token)
+		e.lease = nil
+		current, currentErr := e.store.Current()
+		e.setStatus(current, now, currentErr)
+		return e.takeoverWindow
+	}
+
+	leaseRenewalFailures.Inc()
+
+	// another instance may take over once the lease expires, so leadership is given up before that
+	if !now.Add(e.renewPeriod).Before(e.lease.ExpiresAt) {
+		logrus.Errorf("Instance[%s] could not renew the lease with fencing token[%d] before it expires and is not the leader anymore: %s",
+			e.instanceId, e.lease.Token, err)
+		e.lease = nil
+		e.setStatus(nil, now, err)
+		return e.takeoverWindow
+	}
+
+	logrus.Warnf("Instance[%s] could not renew the lease with fencing token[%d], will be retried: %s", e.instanceId, e.lease.Token, err)
+	e.setStatus(e.lease, now, err)
+	return e.renewPeriod
+}
+
+func (e *Elector) release(now time.Time) {
+
+	if e.lease == nil {
+		return
+	}
+
+	err := e.store.Release(e.lease, now)
+	if err != nil {
+		logrus.Warnf("Lease with fencing token[%d] could not be released: %s", e.lease.Token, err)
+	} else {
+		logrus.Infof("Instance[%s] has released the lease with fencing token[%d].", e.instanceId, e.lease.Token)
+	}
+
+	e.lease = nil
+	current, currentErr := e.store.Current()
+	e.setStatus(current, now, currentErr)
+}
+
+// setStatus updates the status with the lease last seen, which is nil if it is not known.
+func (e *Elector) setStatus(lease *Lease, now time.Time, err error) {
+
+	status := Status{
+		InstanceId: e.instanceId,
+		IsLeader:   e.lease != nil,
+		CheckedAt:  &now,
+	}
+	if lease != nil {
+		expiresAt := lease.ExpiresAt
+		status.Leader = lease.Holder
+		status.FencingToken = lease.Token
+		status.ExpiresAt = &expiresAt
+		if lease.IsExpired(now) {
+			status.Leader = ""
+		}
+	}
+	if err != nil {
+		status.LastError = err.Error()
+	}
+
+	e.statusMu.Lock()
+	isChanged := e.status.IsLeader != status.IsLeader || (status.IsLeader && e.status.FencingToken != status.FencingToken)
+	e.status = status
+	e.statusMu.Unlock()
+
+	e.updateMetrics(status, now)
+
+	if isChanged {
+		leaseLeadershipChanges.Inc()
+		select {
+		case e.changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *Elector) updateMetrics(status Status, now time.Time) {
+
+	if status.IsLeader {
+		leaseIsLeader.Set(1)
+	} else {
+		leaseIsLeader.Set(0)
+	}
+
+	leaseFencingToken.Set(float64(status.FencingToken))
+
+	expiresIn := 0.0
+	if status.ExpiresAt != nil && status.ExpiresAt.After(now) {
+		expiresIn = status.ExpiresAt.Sub(now).Seconds()
+	}
+	leaseExpiresIn.Set(expiresIn)
+}
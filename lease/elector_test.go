@@ -0,0 +1,164 @@
+package lease
+
+import (
+	"encoding/json"
+	"github.com/opsgenie/oec/conf"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func newElectorTest(t *testing.T, directory, instanceId string) *Elector {
+	elector, err := NewElector(&conf.HighAvailabilityConf{LeaseDirectory: directory, InstanceId: instanceId})
+	assert.Nil(t, err)
+	elector.leaseDuration = 300 * time.Millisecond
+	elector.renewPeriod = 50 * time.Millisecond
+	elector.takeoverWindow = 20 * time.Millisecond
+	return elector
+}
+
+func waitForChange(t *testing.T, elector *Elector) Status {
+	select {
+	case <-elector.Changes():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Leadership has not changed.")
+	}
+	return elector.Status()
+}
+
+func TestNewElectorWithoutLeaseDirectory(t *testing.T) {
+	_, err := NewElector(&conf.HighAvailabilityConf{})
+	assert.EqualError(t, err, "Lease directory should be provided for high availability.")
+}
+
+func TestNewElectorSetsHostnameAsInstanceId(t *testing.T) {
+	haConf := &conf.HighAvailabilityConf{LeaseDirectory: "/tmp/oecLease"}
+	_, err := NewElector(haConf)
+	assert.Nil(t, err)
+
+	hostname, _ := os.Hostname()
+	assert.Equal(t, hostname, haConf.InstanceId)
+}
+
+func TestStandbyTakesOverWhenLeaderStops(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	first := newElectorTest(t, directory, "first")
+	assert.Nil(t, first.Start())
+	status := waitForChange(t, first)
+	assert.True(t, status.IsLeader)
+	assert.Equal(t, uint64(1), status.FencingToken)
+
+	second := newElectorTest(t, directory, "second")
+	assert.Nil(t, second.Start())
+	assert.Eventually(t, func() bool { return second.Status().Leader == "first" }, time.Second, 5*time.Millisecond)
+
+	// renewals keep the lease of the leader
+	time.Sleep(2 * first.leaseDuration)
+	assert.True(t, first.Status().IsLeader)
+	assert.False(t, second.Status().IsLeader)
+
+	assert.Nil(t, first.Stop())
+	assert.False(t, first.Status().IsLeader)
+
+	status = waitForChange(t, second)
+	assert.True(t, status.IsLeader)
+	assert.Equal(t, "second", status.Leader)
+	assert.Equal(t, uint64(2), status.FencingToken)
+
+	assert.Nil(t, second.Stop())
+	assert.EqualError(t, second.Stop(), "Lease elector is not running.")
+}
+
+func TestElectorDemotesWhenLeaseIsTakenOver(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	elector := newElectorTest(t, directory, "first")
+	elector.renewPeriod = time.Hour // only renewed once another instance took over
+	assert.Nil(t, elector.Start())
+	assert.True(t, waitForChange(t, elector).IsLeader)
+
+	lease, err := elector.store.Acquire("second", time.Minute, time.Now().Add(elector.leaseDuration))
+	assert.Nil(t, err)
+
+	elector.lease.ExpiresAt = time.Now().Add(time.Hour)
+	elector.renew(time.Now())
+
+	status := waitForChange(t, elector)
+	assert.False(t, status.IsLeader)
+	assert.Equal(t, "second", status.Leader)
+	assert.Equal(t, lease.Token, status.FencingToken)
+}
+
+func TestElectorDemotesWhenLeaseCannotBeRenewedInTime(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	elector := newElectorTest(t, directory, "first")
+	now := time.Now()
+	elector.acquire(now)
+	assert.True(t, waitForChange(t, elector).IsLeader)
+
+	assert.Nil(t, os.RemoveAll(directory))
+	assert.Nil(t, ioutil.WriteFile(directory, []byte{}, 0600)) // lease directory is not readable anymore
+	defer os.Remove(directory)
+
+	assert.Equal(t, elector.renewPeriod, elector.renew(now.Add(elector.renewPeriod)))
+	assert.True(t, elector.Status().IsLeader)
+	assert.NotEmpty(t, elector.Status().LastError)
+
+	elector.renew(now.Add(elector.leaseDuration - elector.renewPeriod))
+	assert.False(t, waitForChange(t, elector).IsLeader)
+}
+
+func TestResignedElectorDoesNotAcquireLease(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	elector := newElectorTest(t, directory, "first")
+	elector.leaseDuration = time.Hour
+	assert.Nil(t, elector.Start())
+	assert.True(t, waitForChange(t, elector).IsLeader)
+
+	elector.Resign()
+	assert.False(t, waitForChange(t, elector).IsLeader)
+
+	current, err := elector.store.Current()
+	assert.Nil(t, err)
+	assert.True(t, current.IsExpired(time.Now()))
+
+	assert.Nil(t, elector.Stop())
+}
+
+func TestServeLeaseStatus(t *testing.T) {
+	elector := newElectorTest(t, "/tmp/oecLease", "first")
+	elector.setStatus(&Lease{Holder: "second", Token: 3, ExpiresAt: time.Now().Add(time.Minute)}, time.Now(), nil)
+
+	recorder := httptest.NewRecorder()
+	elector.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/lease", nil))
+
+	status := Status{}
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&status))
+	assert.Equal(t, "first", status.InstanceId)
+	assert.False(t, status.IsLeader)
+	assert.Equal(t, "second", status.Leader)
+	assert.Equal(t, uint64(3), status.FencingToken)
+
+	elector.setStatus(nil, time.Now(), os.ErrPermission)
+	recorder = httptest.NewRecorder()
+	elector.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/lease", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
@@ -0,0 +1,46 @@
+package lease
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	leaseIsLeader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "oec_lease_is_leader",
+			Help: "Whether this instance holds the lease: 1 leader, 0 standby.",
+		},
+	)
+	leaseFencingToken = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "oec_lease_fencing_token",
+			Help: "Fencing token of the current lease, as last seen by this instance.",
+		},
+	)
+	leaseExpiresIn = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "oec_lease_expires_in_seconds",
+			Help: "Time left until the current lease expires, as last seen by this instance.",
+		},
+	)
+	leaseRenewalFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "oec_lease_renewal_failures_total",
+			Help: "Number of failed attempts of the leader to renew the lease.",
+		},
+	)
+	leaseLeadershipChanges = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "oec_lease_leadership_changes_total",
+			Help: "Number of times this instance has become the leader or stopped being the leader.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		leaseIsLeader,
+		leaseFencingToken,
+		leaseExpiresIn,
+		leaseRenewalFailures,
+		leaseLeadershipChanges,
+	)
+}
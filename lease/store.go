@@ -0,0 +1,284 @@
+package lease
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	claimFilePrefix    = "lease-"
+	claimFileExtension = ".json"
+)
+
+var (
+	ErrLeaseHeld = errors.New("Lease is held by another instance.")
+	ErrLeaseLost = errors.New("Lease has been taken over by another instance.")
+
+	errVersionWritten = errors.New("Lease version has been written by another instance.")
+)
+
+// Lease is the claim of an instance for leadership. Every claim gets a greater fencing token than the
+// previous one, so a former leader can tell that it has been replaced. The version is increased by each
+// claim, renewal and release, the token only by claims.
+type Lease struct {
+	Holder     string    `json:"holder"`
+	Token      uint64    `json:"token"`
+	Version    uint64    `json:"version"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	RenewedAt  time.Time `json:"renewedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+func (l *Lease) IsExpired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
+
+// Store keeps the lease in a directory on the shared storage, one file per version. A version file is
+// linked into place only if it does not exist, so only one of the instances competing for the next version
+// can change the lease: a renewal fails if another instance has claimed the lease in the meantime, and
+// vice versa. The version with the greatest number is the current lease.
+type Store struct {
+	directory string
+}
+
+func NewStore(directory string) *Store {
+	return &Store{directory: directory}
+}
+
+// Current returns the current lease, or nil if the lease has never been acquired.
+func (s *Store) Current() (*Lease, error) {
+
+	version, err := s.lastVersion()
+	if err != nil || version == 0 {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(s.versionFilepath(version))
+	if err != nil {
+		return nil, errors.Errorf("Lease version[%d] could not be read: %s", version, err)
+	}
+
+	lease := &Lease{}
+	err = json.Unmarshal(content, lease)
+	if err != nil {
+		return nil, errors.Errorf("Lease version[%d] could not be parsed: %s", version, err)
+	}
+	lease.Version = version
+	return lease, nil
+}
+
+// Acquire claims the lease for the holder if it has expired. If it is held by another instance,
+// the current lease is returned together with ErrLeaseHeld.
+func (s *Store) Acquire(holder string, duration time.Duration, now time.Time) (*Lease, error) {
+
+	err := os.MkdirAll(s.directory, 0700)
+	if err != nil {
+		return nil, errors.Errorf("Lease directory[%s] could not be created: %s", s.directory, err)
+	}
+
+	current, err := s.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	token, version := uint64(1), uint64(1)
+	if current != nil {
+		if !current.IsExpired(now) {
+			return current, ErrLeaseHeld
+		}
+		token, version = current.Token+1, current.Version+1
+	}
+
+	lease := &Lease{
+		Holder:     holder,
+		Token:      token,
+		Version:    version,
+		AcquiredAt: now,
+		RenewedAt:  now,
+		ExpiresAt:  now.Add(duration),
+	}
+
+	err = s.write(lease)
+	if err == errVersionWritten {
+		current, currentErr := s.Current()
+		if currentErr != nil {
+			return nil, ErrLeaseHeld
+		}
+		return current, ErrLeaseHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// Renew extends the lease if it is still the current one and has not expired yet, otherwise
+// it returns ErrLeaseLost.
+func (s *Store) Renew(lease *Lease, duration time.Duration, now time.Time) (*Lease, error) {
+
+	err := s.checkHeld(lease, now)
+	if err != nil {
+		return nil, err
+	}
+
+	renewed := *lease
+	renewed.Version++
+	renewed.RenewedAt = now
+	renewed.ExpiresAt = now.Add(duration)
+
+	err = s.write(&renewed)
+	if err == errVersionWritten {
+		return nil, ErrLeaseLost
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &renewed, nil
+}
+
+// Release expires the lease at once, so that a standby instance can take over without waiting for it.
+func (s *Store) Release(lease *Lease, now time.Time) error {
+
+	err := s.checkHeld(lease, now)
+	if err != nil {
+		return err
+	}
+
+	released := *lease
+	released.Version++
+	released.ExpiresAt = now
+
+	err = s.write(&released)
+	if err == errVersionWritten {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// checkHeld fails fast if the lease has been changed by another instance, the write of the next version
+// fails anyway in that case.
+func (s *Store) checkHeld(lease *Lease, now time.Time) error {
+
+	version, err := s.lastVersion()
+	if err != nil {
+		return err
+	}
+
+	if version != lease.Version || lease.IsExpired(now) {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// write links the version of the lease into place if it does not exist, then removes the versions before
+// the previous one. It returns errVersionWritten if another instance has written the version.
+func (s *Store) write(lease *Lease) error {
+
+	tmpFilepath, err := s.writeTmp(lease)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFilepath)
+
+	err = os.Link(tmpFilepath, s.versionFilepath(lease.Version))
+	if os.IsExist(err) {
+		return errVersionWritten
+	}
+	if err != nil {
+		return errors.Errorf("Lease[%d] could not be written: %s", lease.Token, err)
+	}
+
+	s.removeVersionsBefore(lease.Version - 1)
+	return nil
+}
+
+func (s *Store) writeTmp(lease *Lease) (string, error) {
+
+	content, err := json.Marshal(lease)
+	if err != nil {
+		return "", err
+	}
+
+	tmpFile, err := ioutil.TempFile(s.directory, "."+claimFilePrefix+"*.tmp")
+	if err != nil {
+		return "", errors.Errorf("Lease[%d] could not be written: %s", lease.Token, err)
+	}
+
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", errors.Errorf("Lease[%d] could not be written: %s", lease.Token, err)
+	}
+	return tmpFile.Name(), nil
+}
+
+func (s *Store) lastVersion() (uint64, error) {
+
+	versions, err := s.versions()
+	if err != nil {
+		return 0, err
+	}
+
+	var lastVersion uint64
+	for _, version := range versions {
+		if version > lastVersion {
+			lastVersion = version
+		}
+	}
+	return lastVersion, nil
+}
+
+func (s *Store) versions() ([]uint64, error) {
+
+	files, err := ioutil.ReadDir(s.directory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Errorf("Lease directory[%s] could not be read: %s", s.directory, err)
+	}
+
+	versions := make([]uint64, 0, len(files))
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, claimFilePrefix) || filepath.Ext(name) != claimFileExtension {
+			continue
+		}
+
+		version, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, claimFilePrefix), claimFileExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// removeVersionsBefore removes the former versions of the lease, except the given one which is kept for diagnosis.
+func (s *Store) removeVersionsBefore(keptVersion uint64) {
+
+	versions, err := s.versions()
+	if err != nil {
+		return
+	}
+
+	for _, version := range versions {
+		if version < keptVersion {
+			os.Remove(s.versionFilepath(version))
+		}
+	}
+}
+
+func (s *Store) versionFilepath(version uint64) string {
+	return filepath.Join(s.directory, fmt.Sprintf("%s%020d%s", claimFilePrefix, version, claimFileExtension))
+}
@@ -0,0 +1,241 @@
+package lease
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newDirectoryTest(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "oecLease")
+	assert.Nil(t, err)
+	return directory
+}
+
+func TestAcquireLease(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	store := NewStore(filepath.Join(directory, "lease"))
+	now := time.Now()
+
+	current, err := store.Current()
+	assert.Nil(t, err)
+	assert.Nil(t, current)
+
+	lease, err := store.Acquire("first", time.Minute, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", lease.Holder)
+	assert.Equal(t, uint64(1), lease.Token)
+	assert.Equal(t, now.Add(time.Minute), lease.ExpiresAt)
+
+	current, err = store.Current()
+	assert.Nil(t, err)
+	assert.Equal(t, "first", current.Holder)
+	assert.Equal(t, uint64(1), current.Token)
+}
+
+func TestAcquireLeaseHeldByAnotherInstance(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	store := NewStore(directory)
+	now := time.Now()
+
+	_, err := store.Acquire("first", time.Minute, now)
+	assert.Nil(t, err)
+
+	current, err := store.Acquire("second", time.Minute, now.Add(time.Second))
+	assert.Equal(t, ErrLeaseHeld, err)
+	assert.Equal(t, "first", current.Holder)
+}
+
+func TestAcquireExpiredLease(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	store := NewStore(directory)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		_, err := store.Acquire("first", time.Minute, now.Add(time.Duration(i)*time.Minute))
+		assert.Nil(t, err)
+	}
+
+	lease, err := store.Acquire("second", time.Minute, now.Add(3*time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), lease.Token)
+
+	versions, err := store.versions()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []uint64{3, 4}, versions)
+}
+
+func TestAcquireLeaseConcurrently(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	store := NewStore(directory)
+	now := time.Now()
+
+	var winners, held int
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Acquire("instance", time.Minute, now)
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				winners++
+			} else if err == ErrLeaseHeld {
+				held++
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, winners)
+	assert.Equal(t, 9, held)
+}
+
+func TestRenewLease(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	store := NewStore(directory)
+	now := time.Now()
+
+	lease, err := store.Acquire("first", time.Minute, now)
+	assert.Nil(t, err)
+
+	renewed, err := store.Renew(lease, time.Minute, now.Add(30*time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, lease.Token, renewed.Token)
+	assert.Equal(t, lease.Version+1, renewed.Version)
+	assert.Equal(t, now.Add(90*time.Second), renewed.ExpiresAt)
+
+	current, err := store.Current()
+	assert.Nil(t, err)
+	assert.True(t, renewed.ExpiresAt.Equal(current.ExpiresAt))
+}
+
+func TestRenewExpiredLease(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	store := NewStore(directory)
+	now := time.Now()
+
+	lease, err := store.Acquire("first", time.Minute, now)
+	assert.Nil(t, err)
+
+	_, err = store.Renew(lease, time.Minute, now.Add(time.Minute))
+	assert.Equal(t, ErrLeaseLost, err)
+}
+
+func TestRenewLeaseTakenOver(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	store := NewStore(directory)
+	now := time.Now()
+
+	lease, err := store.Acquire("first", time.Minute, now)
+	assert.Nil(t, err)
+
+	_, err = store.Acquire("second", time.Minute, now.Add(time.Minute))
+	assert.Nil(t, err)
+
+	_, err = store.Renew(lease, time.Minute, now.Add(30*time.Second))
+	assert.Equal(t, ErrLeaseLost, err)
+
+	err = store.Release(lease, now.Add(30*time.Second))
+	assert.Equal(t, ErrLeaseLost, err)
+}
+
+func TestRenewLeaseWhileTakenOver(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	store := NewStore(directory)
+	now := time.Now()
+
+	lease, err := store.Acquire("first", time.Minute, now)
+	assert.Nil(t, err)
+
+	// another instance claims the lease after the holder has checked it, before the renewal is written
+	taken := *lease
+	taken.Holder = "second"
+	taken.Token++
+	taken.Version++
+	err = store.write(&taken)
+	assert.Nil(t, err)
+
+	renewed := *lease
+	renewed.Version++
+	err = store.write(&renewed)
+	assert.Equal(t, errVersionWritten, err)
+
+	current, err := store.Current()
+	assert.Nil(t, err)
+	assert.Equal(t, "second", current.Holder)
+}
+
+func TestRenewAndAcquireLeaseConcurrently(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	store := NewStore(directory)
+	now := time.Now()
+
+	for i := 0; i < 20; i++ {
+		lease, err := store.Acquire("first", time.Minute, now)
+		assert.Nil(t, err)
+
+		// the clock of the second instance is ahead, so it sees the lease as expired
+		var renewErr, acquireErr error
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, renewErr = store.Renew(lease, time.Minute, now.Add(30*time.Second))
+		}()
+		go func() {
+			defer wg.Done()
+			_, acquireErr = store.Acquire("second", time.Minute, now.Add(time.Minute))
+		}()
+		wg.Wait()
+
+		assert.True(t, (renewErr == nil) != (acquireErr == nil), "renew: %v, acquire: %v", renewErr, acquireErr)
+
+		current, err := store.Current()
+		assert.Nil(t, err)
+		store.Release(current, now.Add(time.Minute))
+		now = now.Add(2 * time.Minute)
+	}
+}
+
+func TestReleaseLease(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	store := NewStore(directory)
+	now := time.Now()
+
+	lease, err := store.Acquire("first", time.Minute, now)
+	assert.Nil(t, err)
+
+	err = store.Release(lease, now.Add(time.Second))
+	assert.Nil(t, err)
+
+	next, err := store.Acquire("second", time.Minute, now.Add(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), next.Token)
+}
@@ -0,0 +1,109 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/opsgenie/oec/conf"
+	"github.com/sirupsen/logrus"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	defaultJournaldSocketPath = "/run/systemd/journal/socket"
+	defaultJournaldIdentifier = "oec"
+
+	// journaldFieldPrefix keeps the fields of OEC apart from the fields of journald, such as MESSAGE_ID.
+	journaldFieldPrefix = "OEC_"
+)
+
+// newJournaldSink returns the sink which sends the logs of the stream to journald over its native protocol, each
+// message in a datagram.
+func newJournaldSink(journaldConf *conf.JournaldConf, stream string) *Sink {
+	socketPath := journaldConf.SocketPath
+	if socketPath == "" {
+		socketPath = defaultJournaldSocketPath
+	}
+
+	return &Sink{
+		formatter: newJournaldFormatter(journaldConf, stream),
+		dial: func() (net.Conn, error) {
+			return net.DialTimeout("unixgram", socketPath, sinkDialTimeout)
+		},
+		frame: func(network string, message []byte) []byte {
+			return message
+		},
+		mu: &sync.Mutex{},
+	}
+}
+
+// journaldFormatter formats the log entries as the messages of the native protocol of journald. Fields of the
+// entries are sent as journald fields, upper cased and prefixed with OEC_; requestId is sent as OEC_REQUEST_ID.
+type journaldFormatter struct {
+	identifier string
+	stream     string
+}
+
+func newJournaldFormatter(journaldConf *conf.JournaldConf, stream string) *journaldFormatter {
+	identifier := journaldConf.Identifier
+	if identifier == "" {
+		identifier = defaultJournaldIdentifier
+	}
+	return &journaldFormatter{identifier: identifier, stream: stream}
+}
+
+func (f *journaldFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	writeJournaldField(buffer, "MESSAGE", strings.TrimRight(entry.Message, "\n"))
+	writeJournaldField(buffer, "PRIORITY", strconv.Itoa(syslogSeverity(entry.Level)))
+	writeJournaldField(buffer, "SYSLOG_IDENTIFIER", f.identifier)
+	writeJournaldField(buffer, journaldFieldPrefix+"LOG_STREAM", f.stream)
+
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeJournaldField(buffer, journaldFieldName(key), fieldValue(entry.Data[key]))
+	}
+	return buffer.Bytes(), nil
+}
+
+// writeJournaldField writes the field as NAME=value, or in the binary form if the value has a new line.
+func writeJournaldField(buffer *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		buffer.WriteString(name + "=" + value + "\n")
+		return
+	}
+
+	buffer.WriteString(name + "\n")
+	binary.Write(buffer, binary.LittleEndian, uint64(len(value)))
+	buffer.WriteString(value + "\n")
+}
+
+// journaldFieldName converts the key to a journald field name, which has only upper case letters, digits and
+// underscores. Words of camel case keys are separated by underscores.
+func journaldFieldName(key string) string {
+	name := &strings.Builder{}
+	name.WriteString(journaldFieldPrefix)
+
+	previous := rune(0)
+	for _, r := range key {
+		switch {
+		case r <= unicode.MaxASCII && unicode.IsUpper(r) && (unicode.IsLower(previous) || unicode.IsDigit(previous)):
+			name.WriteRune('_')
+			name.WriteRune(unicode.ToUpper(r))
+		case r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			name.WriteRune(unicode.ToUpper(r))
+		default:
+			name.WriteRune('_')
+		}
+		previous = r
+	}
+	return name.String()
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/opsgenie/oec/conf"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournaldFormatter(t *testing.T) {
+	formatter := newJournaldFormatter(&conf.JournaldConf{}, queueMessagesStream)
+
+	message, err := formatter.Format(&logrus.Entry{
+		Time:    time.Now(),
+		Level:   logrus.ErrorLevel,
+		Message: "first\nsecond",
+		Data:    logrus.Fields{"requestId": "RequestId", "messageId": "MessageId", "region": "us-west-2"},
+	})
+	assert.Nil(t, err)
+
+	expected := &bytes.Buffer{}
+	expected.WriteString("MESSAGE\n")
+	binary.Write(expected, binary.LittleEndian, uint64(len("first\nsecond")))
+	expected.WriteString("first\nsecond\n")
+	expected.WriteString("PRIORITY=3\nSYSLOG_IDENTIFIER=oec\nOEC_LOG_STREAM=queueMessages\n")
+	expected.WriteString("OEC_MESSAGE_ID=MessageId\nOEC_REGION=us-west-2\nOEC_REQUEST_ID=RequestId\n")
+	assert.Equal(t, expected.String(), string(message))
+}
+
+func TestJournaldFieldName(t *testing.T) {
+	assert.Equal(t, "OEC_REQUEST_ID", journaldFieldName("requestId"))
+	assert.Equal(t, "OEC_INTEGRATION", journaldFieldName("integration"))
+	assert.Equal(t, "OEC_HTTP_STATUS", journaldFieldName("http-status"))
+	assert.Equal(t, "OEC_SHA256_SUM", journaldFieldName("sha256Sum"))
+}
+
+func TestJournaldSink(t *testing.T) {
+	directory, err := ioutil.TempDir("", "oecJournald")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	socketPath := filepath.Join(directory, "socket")
+	listener, err := net.ListenPacket("unixgram", socketPath)
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	logConf := &conf.LogConf{Output: conf.LogOutputJournald, Journald: conf.JournaldConf{SocketPath: socketPath, Identifier: "oec-test"}}
+	logger := logrus.New()
+	logger.SetFormatter(MainFormatter(logConf, &logrus.TextFormatter{}))
+	logger.SetOutput(MainWriter(logConf))
+	defer logger.Out.(*Sink).Close()
+
+	logger.WithField("action", "Create").Warn("Action is retried.")
+
+	buffer := make([]byte, 1024)
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := listener.ReadFrom(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "MESSAGE=Action is retried.\nPRIORITY=4\nSYSLOG_IDENTIFIER=oec-test\nOEC_LOG_STREAM=main\nOEC_ACTION=Create\n", string(buffer[:n]))
+}
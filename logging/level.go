@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Levels which the level is stepped between, the logs of errors are never turned off.
+const (
+	quietestLevel = logrus.ErrorLevel
+	loudestLevel  = logrus.TraceLevel
+)
+
+// levelController keeps the level which a temporary level reverts to. A revert is ignored if the level has been
+// changed since the temporary level was set.
+type levelController struct {
+	base       logrus.Level
+	revertsAt  time.Time
+	timer      *time.Timer
+	generation int
+	mu         *sync.Mutex
+}
+
+var level = &levelController{mu: &sync.Mutex{}}
+
+// SetLevel sets the level of the logs until it is changed again, a temporary level is cancelled.
+func SetLevel(newLevel logrus.Level) {
+	level.mu.Lock()
+	defer level.mu.Unlock()
+
+	level.set(newLevel)
+}
+
+// SetLevelFor sets the level of the logs for the given duration, then the level is reverted to the one before.
+// If a temporary level is already set, it is reverted to the level before that one.
+func SetLevelFor(newLevel logrus.Level, duration time.Duration) {
+	level.mu.Lock()
+	defer level.mu.Unlock()
+
+	level.setFor(newLevel, duration)
+}
+
+// StepLevel makes the logs more verbose for a positive step, less verbose for a negative step, between error and
+// trace levels. The level is set for the given duration unless it is zero. It returns the new level.
+func StepLevel(step int, duration time.Duration) logrus.Level {
+	level.mu.Lock()
+	defer level.mu.Unlock()
+
+	newLevel := int(logrus.GetLevel()) + step
+	if newLevel < int(quietestLevel) {
+		newLevel = int(quietestLevel)
+	} else if newLevel > int(loudestLevel) {
+		newLevel = int(loudestLevel)
+	}
+
+	if duration > 0 {
+		level.setFor(logrus.Level(newLevel), duration)
+	} else {
+		level.set(logrus.Level(newLevel))
+	}
+	return logrus.Level(newLevel)
+}
+
+// LevelRevertsAt returns the time the temporary level reverts, which is zero if the level is not temporary.
+func LevelRevertsAt() time.Time {
+	level.mu.Lock()
+	defer level.mu.Unlock()
+
+	return level.revertsAt
+}
+
+// set and setFor should be called while holding the lock of the controller.
+func (c *levelController) set(newLevel logrus.Level) {
+	c.cancelTemporary()
+	logrus.SetLevel(newLevel)
+}
+
+func (c *levelController) setFor(newLevel logrus.Level, duration time.Duration) {
+	if c.timer == nil {
+		c.base = logrus.GetLevel()
+	}
+	c.cancelTemporary()
+
+	generation := c.generation
+	c.revertsAt = time.Now().Add(duration)
+	c.timer = time.AfterFunc(duration, func() {
+		c.revert(generation)
+	})
+	logrus.SetLevel(newLevel)
+}
+
+func (c *levelController) revert(generation int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	c.cancelTemporary()
+	logrus.SetLevel(c.base)
+	logrus.Warnf("Temporary log level has expired, log level is reverted to %s.", c.base.String())
+}
+
+func (c *levelController) cancelTemporary() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.revertsAt = time.Time{}
+	c.generation++
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStepLevel(t *testing.T) {
+	defer SetLevel(logrus.GetLevel())
+	SetLevel(logrus.InfoLevel)
+
+	assert.Equal(t, logrus.DebugLevel, StepLevel(1, 0))
+	assert.Equal(t, logrus.TraceLevel, StepLevel(1, 0))
+	assert.Equal(t, logrus.TraceLevel, StepLevel(1, 0))
+	assert.Equal(t, logrus.TraceLevel, logrus.GetLevel())
+
+	SetLevel(logrus.WarnLevel)
+	assert.Equal(t, logrus.ErrorLevel, StepLevel(-1, 0))
+	assert.Equal(t, logrus.ErrorLevel, StepLevel(-1, 0))
+	assert.True(t, LevelRevertsAt().IsZero())
+}
+
+func TestStepLevelConcurrently(t *testing.T) {
+	defer SetLevel(logrus.GetLevel())
+	SetLevel(logrus.ErrorLevel)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < int(loudestLevel-quietestLevel); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			StepLevel(1, time.Minute)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, logrus.TraceLevel, logrus.GetLevel())
+
+	SetLevel(logrus.ErrorLevel)
+	assert.True(t, LevelRevertsAt().IsZero())
+}
+
+func TestSetLevelForReverts(t *testing.T) {
+	defer SetLevel(logrus.GetLevel())
+	SetLevel(logrus.InfoLevel)
+
+	SetLevelFor(logrus.DebugLevel, 50*time.Millisecond)
+	assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+	assert.False(t, LevelRevertsAt().IsZero())
+
+	// a temporary level set on top of another one reverts to the level before both
+	assert.Equal(t, logrus.TraceLevel, StepLevel(1, 50*time.Millisecond))
+
+	assert.Eventually(t, func() bool {
+		return logrus.GetLevel() == logrus.InfoLevel
+	}, time.Second, 10*time.Millisecond)
+	assert.True(t, LevelRevertsAt().IsZero())
+}
+
+func TestSetLevelCancelsTemporaryLevel(t *testing.T) {
+	defer SetLevel(logrus.GetLevel())
+	SetLevel(logrus.InfoLevel)
+
+	SetLevelFor(logrus.DebugLevel, 20*time.Millisecond)
+	SetLevel(logrus.WarnLevel)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, logrus.WarnLevel, logrus.GetLevel())
+	assert.True(t, LevelRevertsAt().IsZero())
+}
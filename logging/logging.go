@@ -0,0 +1,234 @@
+package logging
+
+import (
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Placeholders of the queue messages filename.
+const (
+	IntegrationPlaceholder = "{integration}"
+	RegionPlaceholder      = "{region}"
+)
+
+const checkLogFileInterval = 10 * time.Second
+
+// fileLoggers are the latest loggers of the log files by their paths, which are reopened by Reopen.
+var (
+	fileLoggers   = make(map[string]*lumberjack.Logger)
+	fileLoggersMu = &sync.Mutex{}
+)
+
+var DefaultDirectory = filepath.Join("/var", "log", "opsgenie")
+
+type rotation struct {
+	maxSizeInMb  int
+	maxAgeInDays int
+}
+
+var (
+	mainRotation          = rotation{maxSizeInMb: 10, maxAgeInDays: 10}
+	queueMessagesRotation = rotation{maxSizeInMb: 3, maxAgeInDays: 10}
+	actionsRotation       = rotation{maxSizeInMb: 3, maxAgeInDays: 1}
+)
+
+const (
+	defaultMainFilename                     = "oec.log"
+	defaultQueueMessagesFilename            = "oecQueueMessages-" + RegionPlaceholder + ".log"
+	defaultIntegrationQueueMessagesFilename = "oecQueueMessages-" + IntegrationPlaceholder + "-" + RegionPlaceholder + ".log"
+)
+
+// MainWriter returns the writer of the OEC logs, which writes to stdout and to the main log file unless the
+// output is only one of them.
+func MainWriter(logConf *conf.LogConf) io.Writer {
+	stream := logConf.Main
+	if stream.Disabled {
+		return ioutil.Discard
+	}
+
+	filename := stream.Filename
+	if filename == "" {
+		filename = defaultMainFilename
+	}
+
+	switch logConf.Output {
+	case conf.LogOutputStdout:
+		return os.Stdout
+	case conf.LogOutputFile:
+		return newFileLogger(directory(logConf), filename, stream, mainRotation, true)
+	case conf.LogOutputSyslog, conf.LogOutputJournald:
+		return sinkWriter(logConf, mainStream, os.Stdout)
+	default:
+		return io.MultiWriter(os.Stdout, newFileLogger(directory(logConf), filename, stream, mainRotation, true))
+	}
+}
+
+// MainFormatter returns the formatter of the OEC logs, which is the given one unless the logs are sent to syslog or
+// journald.
+func MainFormatter(logConf *conf.LogConf, formatter logrus.Formatter) logrus.Formatter {
+	return sinkFormatter(logConf, mainStream, formatter)
+}
+
+// QueueMessagesFormatter returns the formatter of the queue messages, which is the given one unless they are sent
+// to syslog or journald.
+func QueueMessagesFormatter(logConf *conf.LogConf, formatter logrus.Formatter) logrus.Formatter {
+	return sinkFormatter(logConf, queueMessagesStream, formatter)
+}
+
+// QueueMessagesWriter returns the writer of the bodies of the messages received from the queue of the region.
+// It returns nil if the queue messages are not logged. Close closes the log file or the connection of the writer.
+func QueueMessagesWriter(logConf *conf.LogConf, integrationName, region string) io.WriteCloser {
+	stream := logConf.QueueMessages
+	if stream.Disabled {
+		return nil
+	}
+
+	filename := stream.Filename
+	if filename == "" && integrationName == "" {
+		filename = defaultQueueMessagesFilename
+	} else if filename == "" {
+		filename = defaultIntegrationQueueMessagesFilename
+	}
+	filename = strings.NewReplacer(IntegrationPlaceholder, integrationName, RegionPlaceholder, region).Replace(filename)
+
+	if isSinkOutput(logConf.Output) {
+		writer := sinkWriter(logConf, queueMessagesStream, os.Stdout)
+		if sink, ok := writer.(*Sink); ok {
+			return sink
+		}
+		return writeCloser{Writer: writer}
+	}
+
+	return newStreamWriter(logConf, filepath.Join(directory(logConf), filename), stream, queueMessagesRotation, true)
+}
+
+// ActionWriter returns the writer of the stdout or stderr file of the actions. It returns nil if the outputs of
+// the actions are not logged. Outputs are written to their files when the logs are sent to syslog or journald,
+// unless the outputs are sent too; the writer is a LineWriter then.
+func ActionWriter(logConf *conf.LogConf, filename string) io.Writer {
+	stream := logConf.Actions
+	if stream.Disabled {
+		return nil
+	}
+	if isSinkOutput(logConf.Output) && logConf.SendActionOutputs {
+		return sinkWriter(logConf, actionsStream, ioutil.Discard)
+	}
+	return newStreamWriter(logConf, filename, stream, actionsRotation, false)
+}
+
+// newStreamWriter returns the writer of a stream which is written only to its file by default. Close closes only
+// the file, stdout is left open.
+func newStreamWriter(logConf *conf.LogConf, path string, stream conf.LogStreamConf, defaults rotation, checked bool) io.WriteCloser {
+	switch logConf.Output {
+	case conf.LogOutputStdout:
+		return writeCloser{Writer: os.Stdout}
+	case conf.LogOutputBoth:
+		logger := newFileLogger(filepath.Dir(path), filepath.Base(path), stream, defaults, checked)
+		return writeCloser{Writer: io.MultiWriter(os.Stdout, logger), closer: logger}
+	default:
+		return newFileLogger(filepath.Dir(path), filepath.Base(path), stream, defaults, checked)
+	}
+}
+
+// writeCloser closes only the given closer, if any.
+type writeCloser struct {
+	io.Writer
+	closer io.Closer
+}
+
+func (w writeCloser) Close() error {
+	if w.closer == nil {
+		return nil
+	}
+	return w.closer.Close()
+}
+
+func directory(logConf *conf.LogConf) string {
+	if logConf.Directory == "" {
+		return DefaultDirectory
+	}
+	return logConf.Directory
+}
+
+// fileLogger is a log file which is reopened by Reopen and checked, if it is checked, until it is closed.
+type fileLogger struct {
+	*lumberjack.Logger
+	quit      chan struct{}
+	closeOnce *sync.Once
+}
+
+// Close stops checking the log file and closes it, it is not reopened by Reopen anymore.
+func (l *fileLogger) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.quit)
+	})
+
+	fileLoggersMu.Lock()
+	if fileLoggers[l.Filename] == l.Logger {
+		delete(fileLoggers, l.Filename)
+	}
+	fileLoggersMu.Unlock()
+
+	return l.Logger.Close()
+}
+
+// newFileLogger returns a logger which rotates the file by its size and removes the old ones by their age and
+// count. Log file is watched and recreated if it is removed, when it is checked.
+func newFileLogger(directory, filename string, stream conf.LogStreamConf, defaults rotation, checked bool) *fileLogger {
+	logger := &lumberjack.Logger{
+		Filename:   filepath.Join(directory, filename),
+		MaxSize:    valueOrDefault(stream.MaxSizeInMb, defaults.maxSizeInMb),
+		MaxAge:     valueOrDefault(stream.MaxAgeInDays, defaults.maxAgeInDays),
+		MaxBackups: stream.MaxBackups,
+		Compress:   stream.Compress,
+		LocalTime:  true,
+	}
+
+	quit := make(chan struct{})
+	if checked {
+		_, err := logger.Write(nil) // opens the file, so that it is not mistaken as removed
+		if err != nil {
+			logrus.Warnf("Log file[%s] could not be opened: %s", logger.Filename, err)
+		}
+		go util.CheckLogFile(logger, checkLogFileInterval, quit)
+	}
+
+	fileLoggersMu.Lock()
+	fileLoggers[logger.Filename] = logger
+	fileLoggersMu.Unlock()
+
+	return &fileLogger{Logger: logger, quit: quit, closeOnce: &sync.Once{}}
+}
+
+// Reopen closes the log files, so that they are opened again on their next writes. Files which have been moved,
+// such as by logrotate, are created again.
+func Reopen() error {
+	fileLoggersMu.Lock()
+	defer fileLoggersMu.Unlock()
+
+	var reopenErr error
+	for path, logger := range fileLoggers {
+		err := logger.Close()
+		if err != nil && reopenErr == nil {
+			reopenErr = errors.Errorf("Log file[%s] could not be reopened: %s", path, err)
+		}
+	}
+	return reopenErr
+}
+
+func valueOrDefault(value, defaultValue int) int {
+	if value > 0 {
+		return value
+	}
+	return defaultValue
+}
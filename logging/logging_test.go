@@ -0,0 +1,141 @@
+package logging
+
+import (
+	"github.com/opsgenie/oec/conf"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func TestMainWriter(t *testing.T) {
+	directory, err := ioutil.TempDir("", "oecLogs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	writer := MainWriter(&conf.LogConf{Directory: directory, Output: conf.LogOutputFile})
+	logger, ok := writer.(*fileLogger)
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(directory, "oec.log"), logger.Filename)
+	assert.Equal(t, 10, logger.MaxSize)
+	assert.Equal(t, 10, logger.MaxAge)
+	assert.FileExists(t, logger.Filename)
+
+	writer = MainWriter(&conf.LogConf{
+		Directory: directory,
+		Output:    conf.LogOutputFile,
+		Main:      conf.LogStreamConf{Filename: "main.log", MaxSizeInMb: 50, MaxAgeInDays: 7, MaxBackups: 5, Compress: true},
+	})
+	logger = writer.(*fileLogger)
+	assert.Equal(t, filepath.Join(directory, "main.log"), logger.Filename)
+	assert.Equal(t, 50, logger.MaxSize)
+	assert.Equal(t, 7, logger.MaxAge)
+	assert.Equal(t, 5, logger.MaxBackups)
+	assert.True(t, logger.Compress)
+
+	assert.Equal(t, os.Stdout, MainWriter(&conf.LogConf{Directory: directory, Output: conf.LogOutputStdout}))
+	assert.Equal(t, ioutil.Discard, MainWriter(&conf.LogConf{Directory: directory, Main: conf.LogStreamConf{Disabled: true}}))
+}
+
+func TestQueueMessagesWriter(t *testing.T) {
+	directory, err := ioutil.TempDir("", "oecLogs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	logConf := &conf.LogConf{Directory: directory}
+
+	logger := QueueMessagesWriter(logConf, "", "us-west-2").(*fileLogger)
+	assert.Equal(t, filepath.Join(directory, "oecQueueMessages-us-west-2.log"), logger.Filename)
+	assert.Equal(t, 3, logger.MaxSize)
+	assert.Equal(t, 10, logger.MaxAge)
+
+	logger = QueueMessagesWriter(logConf, "first", "us-west-2").(*fileLogger)
+	assert.Equal(t, filepath.Join(directory, "oecQueueMessages-first-us-west-2.log"), logger.Filename)
+
+	logConf.QueueMessages.Filename = "messages-{region}-{integration}.log"
+	logger = QueueMessagesWriter(logConf, "first", "us-west-2").(*fileLogger)
+	assert.Equal(t, filepath.Join(directory, "messages-us-west-2-first.log"), logger.Filename)
+
+	logConf.Output = conf.LogOutputStdout
+	assert.Equal(t, writeCloser{Writer: os.Stdout}, QueueMessagesWriter(logConf, "first", "us-west-2"))
+
+	logConf.QueueMessages.Disabled = true
+	assert.Nil(t, QueueMessagesWriter(logConf, "first", "us-west-2"))
+}
+
+func TestActionWriter(t *testing.T) {
+
+	logger := ActionWriter(&conf.LogConf{}, "/path/to/stdout").(*fileLogger)
+	assert.Equal(t, "/path/to/stdout", logger.Filename)
+	assert.Equal(t, 3, logger.MaxSize)
+	assert.Equal(t, 1, logger.MaxAge)
+
+	logger = ActionWriter(&conf.LogConf{Actions: conf.LogStreamConf{MaxAgeInDays: 3}}, "/path/to/stdout").(*fileLogger)
+	assert.Equal(t, 3, logger.MaxAge)
+
+	assert.Equal(t, writeCloser{Writer: os.Stdout}, ActionWriter(&conf.LogConf{Output: conf.LogOutputStdout}, "/path/to/stdout"))
+	assert.Nil(t, ActionWriter(&conf.LogConf{Actions: conf.LogStreamConf{Disabled: true}}, "/path/to/stdout"))
+}
+
+func TestReopenCreatesMovedFile(t *testing.T) {
+	directory, err := ioutil.TempDir("", "oecLogs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	logger := MainWriter(&conf.LogConf{Directory: directory, Output: conf.LogOutputFile}).(*fileLogger)
+	logger.Write([]byte("before\n"))
+
+	rotated := filepath.Join(directory, "oec.log.1")
+	assert.Nil(t, os.Rename(logger.Filename, rotated))
+
+	assert.Nil(t, Reopen())
+	logger.Write([]byte("after\n"))
+
+	content, err := ioutil.ReadFile(rotated)
+	assert.Nil(t, err)
+	assert.Equal(t, "before\n", string(content))
+
+	content, err = ioutil.ReadFile(logger.Filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "after\n", string(content))
+}
+
+func TestCloseQueueMessagesWriter(t *testing.T) {
+	directory, err := ioutil.TempDir("", "oecLogs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	logger := QueueMessagesWriter(&conf.LogConf{Directory: directory}, "", "us-west-2").(*fileLogger)
+
+	fileLoggersMu.Lock()
+	_, ok := fileLoggers[logger.Filename]
+	fileLoggersMu.Unlock()
+	assert.True(t, ok)
+
+	assert.Nil(t, logger.Close())
+	assert.Nil(t, logger.Close())
+
+	fileLoggersMu.Lock()
+	_, ok = fileLoggers[logger.Filename]
+	fileLoggersMu.Unlock()
+	assert.False(t, ok)
+
+	select {
+	case <-logger.quit:
+	default:
+		assert.Fail(t, "Log file is still checked.")
+	}
+
+	logConf := &conf.LogConf{Directory: directory, Output: conf.LogOutputBoth}
+	writer := QueueMessagesWriter(logConf, "", "us-west-2").(writeCloser)
+	assert.Nil(t, writer.Close())
+	_, err = os.Stdout.Stat()
+	assert.Nil(t, err)
+}
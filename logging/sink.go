@@ -0,0 +1,165 @@
+package logging
+
+import (
+	"fmt"
+	"github.com/opsgenie/oec/conf"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Names of the log streams, which are sent as the message id of syslog and as a field of journald.
+const (
+	mainStream          = "main"
+	queueMessagesStream = "queueMessages"
+	actionsStream       = "actions"
+)
+
+const sinkDialTimeout = 5 * time.Second
+
+// Bounds of the time which messages are dropped for after they could not be sent, so that a server which is down
+// is not dialled on every write while the lock of the logger is held.
+const (
+	sinkMinBackoff = time.Second
+	sinkMaxBackoff = time.Minute
+)
+
+// LineWriter is implemented by the writers of syslog and journald, which send the fields of a line apart from its
+// text, rather than framing the line with them.
+type LineWriter interface {
+	WriteLine(level logrus.Level, fields map[string]string, line string) error
+}
+
+// Sink sends each write to syslog or journald as a message. Connection is opened when the first message is sent,
+// and opened again once if sending a message fails, since the server may have been restarted. If the message still
+// cannot be sent, the messages are dropped for a backoff which is doubled up to a minute while the server is down.
+type Sink struct {
+	formatter logrus.Formatter
+	dial      func() (net.Conn, error)
+	frame     func(network string, message []byte) []byte
+	conn      net.Conn
+	backoff   time.Duration
+	retryAt   time.Time
+	mu        *sync.Mutex
+}
+
+func isSinkOutput(output string) bool {
+	return output == conf.LogOutputSyslog || output == conf.LogOutputJournald
+}
+
+// newSink returns the sink of the output of the configuration, the stream of which is one of the log streams.
+func newSink(logConf *conf.LogConf, stream string) (*Sink, error) {
+	if logConf.Output == conf.LogOutputJournald {
+		return newJournaldSink(&logConf.Journald, stream), nil
+	}
+	return newSyslogSink(&logConf.Syslog, stream)
+}
+
+// sinkWriter returns the sink of the stream, or the fallback writer if the sink could not be created.
+func sinkWriter(logConf *conf.LogConf, stream string, fallback io.Writer) io.Writer {
+	sink, err := newSink(logConf, stream)
+	if err != nil {
+		logrus.Errorf("Logs of %s stream could not be sent to %s, they are written to stdout: %s", stream, logConf.Output, err)
+		return fallback
+	}
+	return sink
+}
+
+// sinkFormatter returns the formatter of the stream if the logs are sent to syslog or journald, the given formatter
+// otherwise.
+func sinkFormatter(logConf *conf.LogConf, stream string, formatter logrus.Formatter) logrus.Formatter {
+	switch logConf.Output {
+	case conf.LogOutputSyslog:
+		return newSyslogFormatter(&logConf.Syslog, stream)
+	case conf.LogOutputJournald:
+		return newJournaldFormatter(&logConf.Journald, stream)
+	default:
+		return formatter
+	}
+}
+
+func (s *Sink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil && time.Now().Before(s.retryAt) {
+		return len(p), nil
+	}
+
+	connected := s.conn != nil
+	err := s.send(p)
+	if err != nil && connected {
+		s.reset()
+		err = s.send(p)
+	}
+	if err != nil {
+		s.reset()
+		s.backOff()
+		return 0, errors.Errorf("Messages are dropped for %s, since they could not be sent: %s", s.backoff, err)
+	}
+	s.backoff = 0
+	return len(p), nil
+}
+
+func (s *Sink) backOff() {
+	s.backoff *= 2
+	if s.backoff < sinkMinBackoff {
+		s.backoff = sinkMinBackoff
+	}
+	if s.backoff > sinkMaxBackoff {
+		s.backoff = sinkMaxBackoff
+	}
+	s.retryAt = time.Now().Add(s.backoff)
+}
+
+func (s *Sink) send(message []byte) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	_, err := s.conn.Write(s.frame(s.conn.LocalAddr().Network(), message))
+	return err
+}
+
+// WriteLine sends the line as a message of the level with the fields.
+func (s *Sink) WriteLine(level logrus.Level, fields map[string]string, line string) error {
+	data := make(logrus.Fields, len(fields))
+	for key, value := range fields {
+		data[key] = value
+	}
+
+	message, err := s.formatter.Format(&logrus.Entry{Time: time.Now(), Level: level, Message: line, Data: data})
+	if err != nil {
+		return err
+	}
+	_, err = s.Write(message)
+	return err
+}
+
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reset()
+}
+
+func (s *Sink) reset() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func fieldValue(value interface{}) string {
+	if value, ok := value.(string); ok {
+		return value
+	}
+	return fmt.Sprint(value)
+}
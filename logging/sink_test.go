@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSinkDropsMessagesWhileServerIsDown(t *testing.T) {
+	dials := 0
+	sink := &Sink{
+		dial: func() (net.Conn, error) {
+			dials++
+			return nil, errors.New("Test dial error")
+		},
+		frame: frameSyslog,
+		mu:    &sync.Mutex{},
+	}
+
+	_, err := sink.Write([]byte("message"))
+	assert.EqualError(t, err, "Messages are dropped for 1s, since they could not be sent: Test dial error")
+	assert.Equal(t, 1, dials)
+
+	n, err := sink.Write([]byte("message"))
+	assert.Nil(t, err)
+	assert.Equal(t, len("message"), n)
+	assert.Equal(t, 1, dials)
+
+	sink.retryAt = time.Now()
+	_, err = sink.Write([]byte("message"))
+	assert.EqualError(t, err, "Messages are dropped for 2s, since they could not be sent: Test dial error")
+	assert.Equal(t, 2, dials)
+
+	sink.backoff = sinkMaxBackoff
+	sink.retryAt = time.Now()
+	_, err = sink.Write([]byte("message"))
+	assert.NotNil(t, err)
+	assert.Equal(t, sinkMaxBackoff, sink.backoff)
+}
+
+func TestSinkResetsBackoffOnceMessageIsSent(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			if _, err := server.Read(buffer); err != nil {
+				return
+			}
+		}
+	}()
+
+	sink := &Sink{
+		dial: func() (net.Conn, error) {
+			return client, nil
+		},
+		frame: func(network string, message []byte) []byte {
+			return message
+		},
+		backoff: 4 * time.Second,
+		mu:      &sync.Mutex{},
+	}
+	defer sink.Close()
+
+	_, err := sink.Write([]byte("message"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), sink.backoff)
+}
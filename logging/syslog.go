@@ -0,0 +1,183 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"github.com/opsgenie/oec/conf"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultSyslogAddress = "/dev/log"
+	defaultSyslogAppName = "oec"
+	defaultFacility      = 3 // daemon
+
+	// defaultStructuredDataId has the private enterprise number reserved for documentation by RFC 5612, it should be
+	// replaced with an id of your own enterprise if the structured data are processed by its id.
+	defaultStructuredDataId = "oec@32473"
+
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+	maxParamNameLen  = 32
+)
+
+// newSyslogSink returns the sink which sends the logs of the stream to the syslog server in RFC 5424 format.
+// Messages are framed by octet counting over tcp and tls, and by a new line over a unix stream socket.
+func newSyslogSink(syslogConf *conf.SyslogConf, stream string) (*Sink, error) {
+	var tlsConfig *tls.Config
+	if syslogConf.Network == conf.SyslogNetworkTls {
+		var err error
+		tlsConfig, err = conf.NewTlsConfig(&syslogConf.TlsConf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Sink{
+		formatter: newSyslogFormatter(syslogConf, stream),
+		dial: func() (net.Conn, error) {
+			return dialSyslog(syslogConf, tlsConfig)
+		},
+		frame: frameSyslog,
+		mu:    &sync.Mutex{},
+	}, nil
+}
+
+func dialSyslog(syslogConf *conf.SyslogConf, tlsConfig *tls.Config) (net.Conn, error) {
+	switch syslogConf.Network {
+	case conf.SyslogNetworkUdp, conf.SyslogNetworkTcp:
+		return net.DialTimeout(syslogConf.Network, syslogConf.Address, sinkDialTimeout)
+	case conf.SyslogNetworkTls:
+		return tls.DialWithDialer(&net.Dialer{Timeout: sinkDialTimeout}, "tcp", syslogConf.Address, tlsConfig)
+	default:
+		address := syslogConf.Address
+		if address == "" {
+			address = defaultSyslogAddress
+		}
+		// local syslog listens on a datagram socket mostly, on a stream socket otherwise
+		conn, err := net.DialTimeout("unixgram", address, sinkDialTimeout)
+		if err != nil {
+			conn, err = net.DialTimeout("unix", address, sinkDialTimeout)
+		}
+		return conn, err
+	}
+}
+
+func frameSyslog(network string, message []byte) []byte {
+	switch network {
+	case "tcp":
+		return append([]byte(strconv.Itoa(len(message))+" "), message...)
+	case "unix":
+		return append(message, '\n')
+	default:
+		return message
+	}
+}
+
+// syslogFormatter formats the log entries as RFC 5424 messages, fields of the entries are the parameters of their
+// structured data.
+type syslogFormatter struct {
+	facility         int
+	hostname         string
+	appName          string
+	procId           string
+	msgId            string
+	structuredDataId string
+}
+
+func newSyslogFormatter(syslogConf *conf.SyslogConf, stream string) *syslogFormatter {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	facility := syslogConf.Facility
+	if facility == 0 {
+		facility = defaultFacility
+	}
+
+	appName := syslogConf.AppName
+	if appName == "" {
+		appName = defaultSyslogAppName
+	}
+
+	structuredDataId := syslogConf.StructuredDataId
+	if structuredDataId == "" {
+		structuredDataId = defaultStructuredDataId
+	}
+
+	return &syslogFormatter{
+		facility:         facility,
+		hostname:         hostname,
+		appName:          appName,
+		procId:           strconv.Itoa(os.Getpid()),
+		msgId:            stream,
+		structuredDataId: structuredDataId,
+	}
+}
+
+func (f *syslogFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "<%d>1 %s %s %s %s %s ", f.facility*8+syslogSeverity(entry.Level),
+		entry.Time.Format(syslogTimeFormat), f.hostname, f.appName, f.procId, f.msgId)
+
+	if len(entry.Data) == 0 {
+		buffer.WriteString("-")
+	} else {
+		keys := make([]string, 0, len(entry.Data))
+		for key := range entry.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buffer.WriteString("[" + f.structuredDataId)
+		for _, key := range keys {
+			fmt.Fprintf(buffer, ` %s="%s"`, paramName(key), paramValueEscaper.Replace(fieldValue(entry.Data[key])))
+		}
+		buffer.WriteString("]")
+	}
+
+	if message := strings.TrimRight(entry.Message, "\n"); message != "" {
+		buffer.WriteString(" " + message)
+	}
+	return buffer.Bytes(), nil
+}
+
+var paramValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// paramName replaces the characters which are not allowed in the names of the structured data parameters.
+func paramName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+	if len(name) > maxParamNameLen {
+		name = name[:maxParamNameLen]
+	}
+	return name
+}
+
+func syslogSeverity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel:
+		return 1 // alert
+	case logrus.FatalLevel:
+		return 2 // critical
+	case logrus.ErrorLevel:
+		return 3
+	case logrus.WarnLevel:
+		return 4
+	case logrus.InfoLevel:
+		return 6
+	default:
+		return 7 // debug
+	}
+}
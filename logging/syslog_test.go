@@ -0,0 +1,140 @@
+package logging
+
+import (
+	"bufio"
+	"github.com/opsgenie/oec/conf"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogFormatter(t *testing.T) {
+	formatter := newSyslogFormatter(&conf.SyslogConf{Facility: 16, AppName: "oec-test"}, mainStream)
+	hostname, _ := os.Hostname()
+
+	entryTime := time.Date(2026, 10, 18, 21, 42, 40, 123456789, time.UTC)
+	message, err := formatter.Format(&logrus.Entry{
+		Time:    entryTime,
+		Level:   logrus.WarnLevel,
+		Message: "Action failed.\n",
+		Data:    logrus.Fields{"requestId": "RequestId", "action": `Cre"ate]`, "bad name=": 3},
+	})
+	assert.Nil(t, err)
+
+	expected := "<132>1 2026-10-18T21:42:40.123456Z " + hostname + " oec-test " + strconv.Itoa(os.Getpid()) + " main " +
+		`[oec@32473 action="Cre\"ate\]" bad_name_="3" requestId="RequestId"] Action failed.`
+	assert.Equal(t, expected, string(message))
+
+	message, err = formatter.Format(&logrus.Entry{Time: entryTime, Level: logrus.DebugLevel, Message: "Polling."})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(message), "<135>1 "))
+	assert.True(t, strings.HasSuffix(string(message), " main - Polling."))
+}
+
+func TestSyslogSinkOverUdp(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	logConf := &conf.LogConf{Output: conf.LogOutputSyslog, Syslog: conf.SyslogConf{Network: conf.SyslogNetworkUdp, Address: listener.LocalAddr().String()}}
+	_, ok := ActionWriter(logConf, "/path/to/stdout").(*Sink)
+	assert.False(t, ok)
+
+	logConf.SendActionOutputs = true
+	sink, ok := ActionWriter(logConf, "/path/to/stdout").(*Sink)
+	assert.True(t, ok)
+	defer sink.Close()
+
+	err = sink.WriteLine(logrus.InfoLevel, map[string]string{"requestId": "RequestId", "stream": "stdout"}, "line")
+	assert.Nil(t, err)
+
+	buffer := make([]byte, 1024)
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := listener.ReadFrom(buffer)
+	assert.Nil(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^<30>1 \S+ \S+ oec \d+ actions \[oec@32473 requestId="RequestId" stream="stdout"\] line$`), string(buffer[:n]))
+}
+
+func TestSyslogSinkOverTcpUsesOctetCounting(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	received := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			for {
+				length, err := reader.ReadString(' ')
+				if err != nil {
+					break
+				}
+				size, _ := strconv.Atoi(strings.TrimSpace(length))
+				message := make([]byte, size)
+				if _, err := io.ReadFull(reader, message); err != nil {
+					break
+				}
+				received <- string(message)
+			}
+			conn.Close()
+		}
+	}()
+
+	logConf := &conf.LogConf{Output: conf.LogOutputSyslog, Syslog: conf.SyslogConf{Network: conf.SyslogNetworkTcp, Address: listener.Addr().String()}}
+	logger := logrus.New()
+	logger.SetFormatter(MainFormatter(logConf, &logrus.JSONFormatter{}))
+	logger.SetOutput(MainWriter(logConf))
+
+	logger.WithField("region", "us-west-2").Info("first\nsecond")
+	logger.Error("third")
+
+	assert.True(t, strings.HasSuffix(<-received, ` oec `+strconv.Itoa(os.Getpid())+` main [oec@32473 region="us-west-2"] first`+"\n"+`second`))
+	assert.True(t, strings.HasSuffix(<-received, ` main - third`))
+	logger.Out.(*Sink).Close()
+}
+
+func TestSyslogSinkOverUnixSocket(t *testing.T) {
+	directory, err := ioutil.TempDir("", "oecSyslog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	address := filepath.Join(directory, "log")
+	listener, err := net.ListenPacket("unixgram", address)
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	logConf := &conf.LogConf{Output: conf.LogOutputSyslog, Syslog: conf.SyslogConf{Address: address}}
+	sink := QueueMessagesWriter(logConf, "", "us-west-2").(*Sink)
+	defer sink.Close()
+
+	_, err = sink.Write([]byte("message"))
+	assert.Nil(t, err)
+
+	buffer := make([]byte, 1024)
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := listener.ReadFrom(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "message", string(buffer[:n]))
+}
+
+func TestSyslogSinkWithInvalidTlsConfigFallsBack(t *testing.T) {
+	logConf := &conf.LogConf{Output: conf.LogOutputSyslog, Syslog: conf.SyslogConf{
+		Network: conf.SyslogNetworkTls,
+		Address: "127.0.0.1:6514",
+		TlsConf: conf.TlsConf{RootCaFilepaths: []string{"/path/to/not/existing/ca.pem"}},
+	}}
+	assert.Equal(t, os.Stdout, MainWriter(logConf))
+}
@@ -1,60 +1,97 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"github.com/opsgenie/oec/admin"
+	"github.com/opsgenie/oec/audit"
+	"github.com/opsgenie/oec/command"
 	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/health"
+	"github.com/opsgenie/oec/history"
+	"github.com/opsgenie/oec/lease"
+	"github.com/opsgenie/oec/logging"
+	"github.com/opsgenie/oec/metrics"
+	"github.com/opsgenie/oec/network"
 	"github.com/opsgenie/oec/queue"
-	"github.com/opsgenie/oec/util"
+	"github.com/opsgenie/oec/redaction"
+	"github.com/opsgenie/oec/runbook"
+	"github.com/opsgenie/oec/tracing"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/natefinch/lumberjack.v2"
-	"io"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"runtime"
-	"strconv"
 	"syscall"
-	"time"
 )
 
 var metricAddr = flag.String("oec-metrics", "7070", "The address to listen on for HTTP requests.")
-var defaultLogFilepath = filepath.Join("/var", "log", "opsgenie", "oec"+strconv.Itoa(os.Getpid())+".log")
 
 var OECVersion string
 var OECCommitVersion string
 
 func main() {
 
-	logrus.SetFormatter(conf.PrepareLogFormat())
+	logrus.SetFormatter(redaction.NewFormatter(conf.PrepareLogFormat()))
 
-	err := os.Chmod(filepath.Join("/var", "log", "opsgenie"), 0744)
+	if len(os.Args) > 1 && command.Exists(os.Args[1]) {
+		logrus.SetLevel(logrus.WarnLevel)
+		err := command.Run(os.Args[1], os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// logs are written only to stdout until the log files are known
+	logrus.SetOutput(os.Stdout)
+
+	configuration, err := conf.Read()
 	if err != nil {
-		logrus.Warn(err)
+		logrus.Fatalf("Could not read configuration: %s", err)
 	}
 
-	logger := &lumberjack.Logger{
-		Filename:  defaultLogFilepath,
-		MaxSize:   10, // MB
-		MaxAge:    10, // Days
-		LocalTime: true,
+	err = redaction.Configure(configuration)
+	if err != nil {
+		logrus.Fatalf("Could not configure redaction: %s", err)
 	}
 
-	logrus.SetOutput(io.MultiWriter(os.Stdout, logger))
+	if configuration.LogConf.Output != conf.LogOutputStdout && configuration.LogConf.Directory == "" {
+		err = os.Chmod(logging.DefaultDirectory, 0744)
+		if err != nil {
+			logrus.Warn(err)
+		}
+	}
+	logrus.SetFormatter(redaction.NewFormatter(logging.MainFormatter(&configuration.LogConf, conf.PrepareLogFormat())))
+	logrus.SetOutput(logging.MainWriter(&configuration.LogConf))
 
 	logrus.Infof("OEC version is %s", OECVersion)
 	logrus.Infof("OEC commit version is %s", OECCommitVersion)
 
-	go util.CheckLogFile(logger, time.Second*10)
+	logging.SetLevel(configuration.LogrusLevel)
 
-	configuration, err := conf.Read()
+	runbook.KillGracePeriod = configuration.ShutdownConf.KillGracePeriod()
+
+	err = network.Configure(&configuration.HttpClientConf)
 	if err != nil {
-		logrus.Fatalf("Could not read configuration: %s", err)
+		logrus.Fatalf("Could not configure http clients: %s", err)
+	}
+
+	tracing.Configure(&configuration.TracingConf)
+	metrics.Configure(&configuration.MetricsConf)
+
+	err = audit.Configure(&configuration.AuditConf)
+	if err != nil {
+		logrus.Fatalf("Could not open audit log: %s", err)
 	}
 
-	logrus.SetLevel(configuration.LogrusLevel)
+	err = history.Configure(&configuration.HistoryConf)
+	if err != nil {
+		logrus.Fatalf("Could not open execution history: %s", err)
+	}
 
 	flag.Parse()
 	go func() {
@@ -63,29 +100,96 @@ func main() {
 		logrus.Error("OEC-metrics error: ", http.ListenAndServe(":"+*metricAddr, nil))
 	}()
 
-	queueProcessor := queue.NewProcessor(configuration)
+	var queueProcessor queue.Processor
+	if configuration.HighAvailabilityConf.Enabled {
+		elector, err := lease.NewElector(&configuration.HighAvailabilityConf)
+		if err != nil {
+			logrus.Fatalf("Could not configure high availability: %s", err)
+		}
+		http.Handle("/lease", elector)
+		queueProcessor = queue.NewHighAvailabilityProcessor(elector, configuration.IntegrationConfigurations())
+	} else {
+		queueProcessor = queue.NewProcessors(configuration.IntegrationConfigurations())
+	}
+	health.NewChecker(&configuration.HealthConf, queueProcessor).Handle(http.DefaultServeMux)
 	queue.UserAgentHeader = fmt.Sprintf("%s/%s %s (%s/%s)", OECVersion, OECCommitVersion, runtime.Version(), runtime.GOOS, runtime.GOARCH)
 
+	reload := func() error {
+		newConfiguration, err := conf.Read()
+		if err != nil {
+			return err
+		}
+		err = queueProcessor.Reload(newConfiguration.IntegrationConfigurations())
+		if err != nil {
+			return err
+		}
+		err = redaction.Configure(newConfiguration)
+		if err != nil {
+			return err
+		}
+		logging.SetLevel(newConfiguration.LogrusLevel)
+		return nil
+	}
+
+	var adminServer *admin.Server
+	if configuration.AdminConf.Enabled {
+		adminServer = admin.NewServer(&configuration.AdminConf, queueProcessor, reload)
+		err = adminServer.Start()
+		if err != nil {
+			logrus.Fatalf("Could not start admin API: %s", err)
+		}
+	}
+
 	go func() {
 		if configuration.AppName != "" {
 			logrus.Infof("%s is starting.", configuration.AppName)
 		}
-		err = queueProcessor.Start()
+		err = queueProcessor.Start(context.Background())
 		if err != nil {
 			logrus.Fatalln(err)
 		}
 	}()
 
+	handleSignals(configuration.SignalConf, reload)
+
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
 	select {
 	case <-signals:
-		logrus.Infof("OEC will be stopped gracefully.")
-		err := queueProcessor.Stop()
+		drainTimeout := configuration.ShutdownConf.DrainTimeout()
+		logrus.Infof("OEC will be stopped gracefully, running actions will be cancelled after %s.", drainTimeout.String())
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		if adminServer != nil {
+			adminServer.Stop(ctx)
+		}
+		err := queueProcessor.Stop(ctx)
 		if err != nil {
 			logrus.Fatalln(err)
 		}
+
+		tracingCtx, cancelTracing := context.WithTimeout(context.Background(), configuration.ShutdownConf.ResultTimeout())
+		defer cancelTracing()
+		err = tracing.Shutdown(tracingCtx)
+		if err != nil {
+			logrus.Warn(err)
+		}
+
+		err = metrics.Shutdown(tracingCtx)
+		if err != nil {
+			logrus.Warn(err)
+		}
+
+		err = audit.Close()
+		if err != nil {
+			logrus.Warnf("Audit log could not be closed: %s", err)
+		}
+
+		err = history.Close()
+		if err != nil {
+			logrus.Warnf("Execution history could not be closed: %s", err)
+		}
 	}
 
 	os.Exit(0)
@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/logging"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals makes the logs more verbose on SIGUSR1 and less verbose on SIGUSR2, and reopens the log files on
+// SIGHUP so that they can be rotated by logrotate. The configuration is reloaded on SIGHUP too, if it is set to.
+func handleSignals(signalConf conf.SignalConf, reload func() error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
+
+	go func() {
+		for received := range signals {
+			switch received {
+			case syscall.SIGUSR1:
+				stepLevel(1, signalConf, "SIGUSR1")
+			case syscall.SIGUSR2:
+				stepLevel(-1, signalConf, "SIGUSR2")
+			case syscall.SIGHUP:
+				hangup(signalConf, reload)
+			}
+		}
+	}()
+}
+
+func stepLevel(step int, signalConf conf.SignalConf, signalName string) {
+	level := logging.StepLevel(step, signalConf.LevelDuration())
+	if signalConf.LevelDurationInMinutes > 0 {
+		logrus.Warnf("Log level is set to %s for %s by %s.", level.String(), signalConf.LevelDuration().String(), signalName)
+	} else {
+		logrus.Warnf("Log level is set to %s by %s.", level.String(), signalName)
+	}
+}
+
+func hangup(signalConf conf.SignalConf, reload func() error) {
+	err := logging.Reopen()
+	if err != nil {
+		logrus.Warn(err)
+	} else {
+		logrus.Infof("Log files are reopened by SIGHUP.")
+	}
+
+	if !signalConf.ReloadOnHangup {
+		return
+	}
+
+	err = reload()
+	if err != nil {
+		logrus.Warnf("Configuration could not be reloaded by SIGHUP: %s", err)
+		return
+	}
+	logrus.Infof("Configuration is reloaded by SIGHUP.")
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "github.com/opsgenie/oec/conf"
+
+// handleSignals does nothing, since Windows does not support the signals changing the log level and reopening
+// the log files.
+func handleSignals(signalConf conf.SignalConf, reload func() error) {
+}
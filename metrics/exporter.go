@@ -0,0 +1,233 @@
+package metrics
+
+import (
+	"context"
+	"github.com/opsgenie/oec/conf"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStatsdAddress  = "127.0.0.1:8125"
+	defaultPushGatewayJob = "oec"
+	defaultPushPeriod     = 10 * time.Second
+	pushTimeout           = 40 * time.Second
+)
+
+// HttpClient is used to push the metrics to the push gateway, its transport is replaced with the one using the
+// configured proxy and certificates.
+var HttpClient = &http.Client{Timeout: pushTimeout}
+
+// gatherer gathers the metrics served on /metrics.
+var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+
+var (
+	current   []*exporter
+	currentMu = &sync.Mutex{}
+)
+
+// pusher sends the gathered metrics to where they are collected.
+type pusher interface {
+	push(families []*dto.MetricFamily) error
+	String() string
+}
+
+// exporter pushes the metrics of the gatherer on every period, and once more when it is stopped. Metric names are
+// prefixed with the prefix, and the tags are added to the labels of all metrics.
+type exporter struct {
+	pusher   pusher
+	gatherer prometheus.Gatherer
+	prefix   string
+	tags     map[string]string
+	period   time.Duration
+
+	quit   chan struct{}
+	done   chan struct{}
+	stopMu *sync.Mutex
+}
+
+func newExporter(pusher pusher, gatherer prometheus.Gatherer, prefix string, tags map[string]string, period time.Duration) *exporter {
+	return &exporter{
+		pusher:   pusher,
+		gatherer: gatherer,
+		prefix:   prefix,
+		tags:     tags,
+		period:   period,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+		stopMu:   &sync.Mutex{},
+	}
+}
+
+func (e *exporter) start() {
+	go e.run()
+}
+
+// stop pushes the metrics for the last time, until the given context is done.
+func (e *exporter) stop(ctx context.Context) error {
+	e.stopMu.Lock()
+	select {
+	case <-e.quit:
+	default:
+		close(e.quit)
+	}
+	e.stopMu.Unlock()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return errors.Errorf("Metrics could not be pushed to %s before shutdown: %s", e.pusher, ctx.Err())
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.export()
+		case <-e.quit:
+			e.export()
+			return
+		}
+	}
+}
+
+func (e *exporter) export() {
+	families, err := e.gather()
+	if err != nil {
+		logrus.Warnf("Metrics could not be gathered: %s", err)
+		if len(families) == 0 {
+			return
+		}
+	}
+
+	err = e.pusher.push(families)
+	if err != nil {
+		logrus.Warnf("Metrics could not be pushed to %s: %s", e.pusher, err)
+		return
+	}
+	logrus.Tracef("%d metric families are pushed to %s.", len(families), e.pusher)
+}
+
+// gather returns the metrics with the prefix and the tags, the metrics gathered despite an error are returned too.
+func (e *exporter) gather() ([]*dto.MetricFamily, error) {
+	families, err := e.gatherer.Gather()
+
+	for _, family := range families {
+		family.Name = stringPtr(e.prefix + family.GetName())
+		for _, metric := range family.Metric {
+			metric.Label = withTags(metric.Label, e.tags)
+		}
+	}
+	return families, err
+}
+
+// withTags adds the tags to the labels unless a label has the same name, labels are sorted by their names.
+func withTags(labels []*dto.LabelPair, tags map[string]string) []*dto.LabelPair {
+	for name, value := range tags {
+		found := false
+		for _, label := range labels {
+			if label.GetName() == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			labels = append(labels, &dto.LabelPair{Name: stringPtr(name), Value: stringPtr(value)})
+		}
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].GetName() < labels[j].GetName()
+	})
+	return labels
+}
+
+func stringPtr(value string) *string {
+	return &value
+}
+
+/******************************************************************************************/
+
+// Configure starts the configured exporters, which push the metrics served on /metrics until Shutdown is called.
+func Configure(metricsConf *conf.MetricsConf) {
+
+	exporters := make([]*exporter, 0, 2)
+
+	if metricsConf.Statsd.Enabled {
+		if metricsConf.Statsd.Address == "" {
+			logrus.Infof("StatsD address is not set, default address[%s] is set.", defaultStatsdAddress)
+			metricsConf.Statsd.Address = defaultStatsdAddress
+		}
+		pusher := newStatsdPusher(metricsConf.Statsd.Address, metricsConf.Statsd.Dogstatsd)
+		exporters = append(exporters, newExporter(pusher, gatherer, metricsConf.Prefix, metricsConf.Tags,
+			pushPeriod(metricsConf.Statsd.PushPeriodInSeconds)))
+	}
+
+	if metricsConf.PushGateway.Enabled {
+		if metricsConf.PushGateway.Job == "" {
+			metricsConf.PushGateway.Job = defaultPushGatewayJob
+		}
+		if metricsConf.PushGateway.Instance == "" {
+			hostname, err := os.Hostname()
+			if err == nil {
+				metricsConf.PushGateway.Instance = hostname
+			}
+		}
+		pusher := newPushGateway(metricsConf.PushGateway.Url, metricsConf.PushGateway.Job,
+			metricsConf.PushGateway.Instance, metricsConf.PushGateway.Headers)
+		exporters = append(exporters, newExporter(pusher, gatherer, metricsConf.Prefix, metricsConf.Tags,
+			pushPeriod(metricsConf.PushGateway.PushPeriodInSeconds)))
+	}
+
+	for _, exporter := range exporters {
+		exporter.start()
+		logrus.Infof("Metrics are pushed to %s every %s.", exporter.pusher, exporter.period)
+	}
+
+	currentMu.Lock()
+	previous := current
+	current = exporters
+	currentMu.Unlock()
+
+	for _, exporter := range previous {
+		exporter.stop(context.Background())
+	}
+}
+
+// Shutdown pushes the metrics for the last time until the given context is done, then stops the exporters.
+func Shutdown(ctx context.Context) error {
+	currentMu.Lock()
+	exporters := current
+	current = nil
+	currentMu.Unlock()
+
+	var shutdownErr error
+	for _, exporter := range exporters {
+		err := exporter.stop(ctx)
+		if err != nil && shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+	return shutdownErr
+}
+
+func pushPeriod(periodInSeconds int64) time.Duration {
+	if periodInSeconds > 0 {
+		return time.Duration(periodInSeconds) * time.Second
+	}
+	return defaultPushPeriod
+}
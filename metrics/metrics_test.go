@@ -0,0 +1,198 @@
+package metrics
+
+import (
+	"context"
+	"github.com/opsgenie/oec/conf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+type testMetrics struct {
+	registry *prometheus.Registry
+	received *prometheus.CounterVec
+	inFlight prometheus.Gauge
+	duration prometheus.Histogram
+}
+
+func newMetricsTest() *testMetrics {
+	m := &testMetrics{
+		registry: prometheus.NewRegistry(),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "messages_received_total", Help: "Received."}, []string{"integration"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{Name: "actions_in_flight", Help: "In flight."}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "action_duration_seconds", Help: "Duration."}),
+	}
+	m.registry.MustRegister(m.received, m.inFlight, m.duration)
+	return m
+}
+
+func listenUdp(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	return conn
+}
+
+func readLines(t *testing.T, conn *net.UDPConn) []string {
+	buffer := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buffer)
+	assert.Nil(t, err)
+
+	lines := strings.Split(string(buffer[:n]), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestStatsdPushesIncreasesOfCounters(t *testing.T) {
+	conn := listenUdp(t)
+	defer conn.Close()
+
+	m := newMetricsTest()
+	m.received.WithLabelValues("default").Add(3)
+	m.inFlight.Set(-2)
+	m.duration.Observe(0.5)
+
+	e := newExporter(newStatsdPusher(conn.LocalAddr().String(), false), m.registry, "oec_", map[string]string{"region": "eu"}, time.Minute)
+	e.export()
+
+	assert.Equal(t, []string{
+		"oec_action_duration_seconds_count.region.eu:1|c",
+		"oec_action_duration_seconds_sum.region.eu:0.5|c",
+		"oec_actions_in_flight.region.eu:-2|g",
+		"oec_actions_in_flight.region.eu:0|g",
+		"oec_messages_received_total.integration.default.region.eu:3|c",
+	}, readLines(t, conn))
+
+	m.received.WithLabelValues("default").Add(2)
+	m.inFlight.Set(1)
+	e.export()
+
+	assert.Equal(t, []string{
+		"oec_actions_in_flight.region.eu:1|g",
+		"oec_messages_received_total.integration.default.region.eu:2|c",
+	}, readLines(t, conn))
+}
+
+func TestDogstatsdPushesLabelsAsTags(t *testing.T) {
+	conn := listenUdp(t)
+	defer conn.Close()
+
+	m := newMetricsTest()
+	m.received.WithLabelValues("a,b").Inc()
+
+	e := newExporter(newStatsdPusher(conn.LocalAddr().String(), true), m.registry, "", map[string]string{"integration": "static", "env": "prod"}, time.Minute)
+	e.export()
+
+	assert.Equal(t, []string{
+		"actions_in_flight:0|g|#env:prod,integration:static",
+		"messages_received_total:1|c|#env:prod,integration:a_b",
+	}, readLines(t, conn))
+}
+
+func TestPackets(t *testing.T) {
+	lines := []string{strings.Repeat("a", 6), strings.Repeat("b", 3), strings.Repeat("c", 12), "d"}
+
+	packets := packets(lines, 10)
+
+	assert.Equal(t, 3, len(packets))
+	assert.Equal(t, "aaaaaa\nbbb", string(packets[0]))
+	assert.Equal(t, "cccccccccccc", string(packets[1]))
+	assert.Equal(t, "d", string(packets[2]))
+}
+
+type mockPushGateway struct {
+	server  *httptest.Server
+	methods []string
+	paths   []string
+	headers []http.Header
+	bodies  []string
+	mu      *sync.Mutex
+}
+
+func newMockPushGateway() *mockPushGateway {
+	gateway := &mockPushGateway{mu: &sync.Mutex{}}
+	gateway.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		gateway.mu.Lock()
+		defer gateway.mu.Unlock()
+		gateway.methods = append(gateway.methods, r.Method)
+		gateway.paths = append(gateway.paths, r.URL.EscapedPath())
+		gateway.headers = append(gateway.headers, r.Header)
+		gateway.bodies = append(gateway.bodies, string(body))
+	}))
+	return gateway
+}
+
+func (g *mockPushGateway) pushes() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.bodies)
+}
+
+func TestPushGatewayPutsTextFormat(t *testing.T) {
+	gateway := newMockPushGateway()
+	defer gateway.server.Close()
+
+	m := newMetricsTest()
+	m.received.WithLabelValues("default").Add(3)
+
+	pusher := newPushGateway(gateway.server.URL+"/", "oec", "host-1", map[string]string{"Authorization": "Basic b2VjOm9lYw=="})
+	e := newExporter(pusher, m.registry, "oec_", map[string]string{"region": "eu"}, time.Minute)
+	e.export()
+
+	assert.Equal(t, []string{http.MethodPut}, gateway.methods)
+	assert.Equal(t, []string{"/metrics/job/oec/instance/host-1"}, gateway.paths)
+	assert.Equal(t, "Basic b2VjOm9lYw==", gateway.headers[0].Get("Authorization"))
+	assert.Equal(t, string(expfmt.FmtText), gateway.headers[0].Get("Content-Type"))
+
+	families, err := (&expfmt.TextParser{}).TextToMetricFamilies(strings.NewReader(gateway.bodies[0]))
+	assert.Nil(t, err)
+	received := families["oec_messages_received_total"]
+	assert.NotNil(t, received)
+	assert.Equal(t, 3.0, received.Metric[0].GetCounter().GetValue())
+	assert.Equal(t, 2, len(received.Metric[0].Label))
+	assert.Equal(t, "region", received.Metric[0].Label[1].GetName())
+	assert.Equal(t, "eu", received.Metric[0].Label[1].GetValue())
+	assert.NotNil(t, families["oec_action_duration_seconds"])
+}
+
+func TestGroupingPathEncodesSlashes(t *testing.T) {
+	assert.Equal(t, "instance/host%201", groupingPath("instance", "host 1"))
+	assert.Equal(t, "job@base64/b2VjL3Byb2Q", groupingPath("job", "oec/prod"))
+}
+
+func TestShutdownPushesForTheLastTime(t *testing.T) {
+	gateway := newMockPushGateway()
+	defer gateway.server.Close()
+
+	defer func(previous prometheus.Gatherer) {
+		gatherer = previous
+	}(gatherer)
+	gatherer = newMetricsTest().registry
+
+	Configure(&conf.MetricsConf{PushGateway: conf.PushGatewayConf{Enabled: true, Url: gateway.server.URL, PushPeriodInSeconds: 3600}})
+	assert.Equal(t, 0, gateway.pushes())
+
+	err := Shutdown(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, gateway.pushes())
+
+	assert.Nil(t, Shutdown(context.Background()))
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// pushGateway puts the metrics to a Prometheus push gateway in the text format, replacing the metrics which were
+// pushed before by the same job and instance.
+type pushGateway struct {
+	url     string
+	headers map[string]string
+}
+
+func newPushGateway(baseUrl, job, instance string, headers map[string]string) *pushGateway {
+	pushUrl := strings.TrimSuffix(baseUrl, "/") + "/metrics/" + groupingPath("job", job)
+	if instance != "" {
+		pushUrl += "/" + groupingPath("instance", instance)
+	}
+	return &pushGateway{
+		url:     pushUrl,
+		headers: headers,
+	}
+}
+
+// groupingPath returns the path of the grouping label, values with a slash are encoded in base64 as the push
+// gateway requires.
+func groupingPath(name, value string) string {
+	if strings.Contains(value, "/") {
+		return name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return name + "/" + url.PathEscape(value)
+}
+
+func (g *pushGateway) String() string {
+	return "push gateway[" + g.url + "]"
+}
+
+func (g *pushGateway) push(families []*dto.MetricFamily) error {
+	body := &bytes.Buffer{}
+	for _, family := range families {
+		_, err := expfmt.MetricFamilyToText(body, family)
+		if err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequest(http.MethodPut, g.url, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", string(expfmt.FmtText))
+	for key, value := range g.headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := HttpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	defer io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected response status: %d", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"bytes"
+	dto "github.com/prometheus/client_model/go"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// maxPacketSize keeps the datagrams within the payload of an ethernet frame, lines are sent in as few of them as fit.
+const maxPacketSize = 1432
+
+// statsdPusher sends the metrics to a StatsD server over UDP. Gauges are sent as they are; counters, and the counts
+// and sums of histograms and summaries, are sent as their increases since the last push.
+type statsdPusher struct {
+	address   string
+	dogstatsd bool
+
+	conn net.Conn
+	last map[string]float64
+}
+
+func newStatsdPusher(address string, dogstatsd bool) *statsdPusher {
+	return &statsdPusher{
+		address:   address,
+		dogstatsd: dogstatsd,
+		last:      make(map[string]float64),
+	}
+}
+
+func (p *statsdPusher) String() string {
+	if p.dogstatsd {
+		return "dogstatsd[" + p.address + "]"
+	}
+	return "statsd[" + p.address + "]"
+}
+
+func (p *statsdPusher) push(families []*dto.MetricFamily) error {
+	if p.conn == nil {
+		conn, err := net.Dial("udp", p.address)
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+	}
+
+	for _, packet := range packets(p.lines(families), maxPacketSize) {
+		_, err := p.conn.Write(packet)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *statsdPusher) lines(families []*dto.MetricFamily) []string {
+	lines := make([]string, 0)
+	for _, family := range families {
+		name := family.GetName()
+		for _, metric := range family.Metric {
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				lines = p.appendCounter(lines, name, metric.Label, metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				lines = p.appendGauge(lines, name, metric.Label, metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				lines = p.appendGauge(lines, name, metric.Label, metric.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				lines = p.appendCounter(lines, name+"_count", metric.Label, float64(metric.GetSummary().GetSampleCount()))
+				lines = p.appendCounter(lines, name+"_sum", metric.Label, metric.GetSummary().GetSampleSum())
+			case dto.MetricType_HISTOGRAM:
+				lines = p.appendCounter(lines, name+"_count", metric.Label, float64(metric.GetHistogram().GetSampleCount()))
+				lines = p.appendCounter(lines, name+"_sum", metric.Label, metric.GetHistogram().GetSampleSum())
+			}
+		}
+	}
+	return lines
+}
+
+// appendCounter appends the increase of the counter since the last push, unless it has not increased. A counter
+// lower than its last value has been reset, so all of its value is the increase.
+func (p *statsdPusher) appendCounter(lines []string, name string, labels []*dto.LabelPair, value float64) []string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return lines
+	}
+
+	key := name + statsdTags(labels)
+	increase := value - p.last[key]
+	if increase < 0 {
+		increase = value
+	}
+	p.last[key] = value
+
+	if increase == 0 {
+		return lines
+	}
+	return append(lines, p.line(name, labels, increase, "c"))
+}
+
+// appendGauge appends the gauge. A negative value is preceded by zero, since a signed value changes the gauge by
+// that amount instead of setting it.
+func (p *statsdPusher) appendGauge(lines []string, name string, labels []*dto.LabelPair, value float64) []string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return lines
+	}
+
+	if value < 0 {
+		lines = append(lines, p.line(name, labels, 0, "g"))
+	}
+	return append(lines, p.line(name, labels, value, "g"))
+}
+
+// line formats the metric as name:value|type. Labels are sent as the tags of DogStatsD, or appended to the name as
+// .label.value otherwise.
+func (p *statsdPusher) line(name string, labels []*dto.LabelPair, value float64, metricType string) string {
+	formattedValue := strconv.FormatFloat(value, 'f', -1, 64)
+	if p.dogstatsd {
+		line := name + ":" + formattedValue + "|" + metricType
+		if len(labels) > 0 {
+			tags := make([]string, 0, len(labels))
+			for _, label := range labels {
+				tags = append(tags, label.GetName()+":"+dogstatsdTagEscaper.Replace(label.GetValue()))
+			}
+			line += "|#" + strings.Join(tags, ",")
+		}
+		return line
+	}
+	return name + statsdTags(labels) + ":" + formattedValue + "|" + metricType
+}
+
+var (
+	statsdNameEscaper   = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+	dogstatsdTagEscaper = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_")
+)
+
+func statsdTags(labels []*dto.LabelPair) string {
+	tags := &strings.Builder{}
+	for _, label := range labels {
+		tags.WriteString("." + statsdNameEscaper.Replace(label.GetName()) + "." + statsdNameEscaper.Replace(label.GetValue()))
+	}
+	return tags.String()
+}
+
+// packets joins the lines by new lines into packets of the given size, a longer line is sent in a packet of its own.
+func packets(lines []string, size int) [][]byte {
+	packets := make([][]byte, 0)
+	packet := &bytes.Buffer{}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > size {
+			packets = append(packets, packet.Bytes())
+			packet = &bytes.Buffer{}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, packet.Bytes())
+	}
+	return packets
+}
@@ -0,0 +1,239 @@
+package network
+
+import (
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/git"
+	"github.com/opsgenie/oec/metrics"
+	"github.com/opsgenie/oec/queue"
+	"github.com/opsgenie/oec/retryer"
+	"github.com/opsgenie/oec/tracing"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Configure applies the proxy and TLS settings to the http clients used for token retrieval,
+// result callbacks, SQS, git over HTTPS, exporting spans and pushing metrics.
+func Configure(httpClientConf *conf.HttpClientConf) error {
+
+	transport, err := NewTransport(httpClientConf)
+	if err != nil {
+		return err
+	}
+
+	retryer.DefaultClient.Transport = transport
+	queue.HttpClient = &http.Client{Transport: transport}
+	git.SetHttpClient(&http.Client{Transport: transport})
+	tracing.HttpClient.Transport = transport
+	metrics.HttpClient.Transport = transport
+
+	return nil
+}
+
+// NewTransport returns a transport using the configured proxy and certificates. If no proxy is configured,
+// the proxy is taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func NewTransport(httpClientConf *conf.HttpClientConf) (*http.Transport, error) {
+
+	proxy, err := newProxyFunc(&httpClientConf.ProxyConf)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := conf.NewTlsConfig(&httpClientConf.TlsConf)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
+
+func newProxyFunc(proxyConf *conf.ProxyConf) (func(*http.Request) (*url.URL, error), error) {
+
+	if proxyConf.HttpUrl == "" && proxyConf.HttpsUrl == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	httpProxy, err := parseProxyUrl(proxyConf.HttpUrl, proxyConf)
+	if err != nil {
+		return nil, err
+	}
+
+	httpsProxy := httpProxy
+	if proxyConf.HttpsUrl != "" {
+		httpsProxy, err = parseProxyUrl(proxyConf.HttpsUrl, proxyConf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	noProxy := newNoProxyList(proxyConf.NoProxy)
+
+	logrus.Infof("Outbound requests will be sent through proxy, http: %s, https: %s, no proxy: %v", redact(httpProxy), redact(httpsProxy), proxyConf.NoProxy)
+
+	return func(request *http.Request) (*url.URL, error) {
+		if noProxy.matches(request.URL) {
+			return nil, nil
+		}
+		if request.URL.Scheme == "https" {
+			return httpsProxy, nil
+		}
+		return httpProxy, nil
+	}, nil
+}
+
+func parseProxyUrl(rawUrl string, proxyConf *conf.ProxyConf) (*url.URL, error) {
+
+	if rawUrl == "" {
+		return nil, nil
+	}
+
+	if !strings.Contains(rawUrl, "://") {
+		rawUrl = "http://" + rawUrl
+	}
+
+	proxyUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, errors.Errorf("Proxy url[%s] is not valid: %s", rawUrl, err)
+	}
+
+	switch proxyUrl.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.Errorf("Scheme of proxy url[%s] should be one of http, https or socks5.", redact(proxyUrl))
+	}
+
+	if proxyUrl.Hostname() == "" {
+		return nil, errors.Errorf("Proxy url[%s] does not have a host.", redact(proxyUrl))
+	}
+
+	if proxyConf.Username != "" {
+		proxyUrl.User = url.UserPassword(proxyConf.Username, proxyConf.Password)
+	}
+
+	return proxyUrl, nil
+}
+
+func redact(proxyUrl *url.URL) string {
+	if proxyUrl == nil {
+		return "-"
+	}
+	return proxyUrl.Redacted()
+}
+
+// noProxyList matches the hosts which should be reached directly. Entries are either "*", ip addresses,
+// CIDR blocks or domain names optionally with a port. A domain also matches its subdomains,
+// while a domain with a leading dot matches only its subdomains.
+type noProxyList struct {
+	matchAll bool
+	ips      []net.IP
+	networks []*net.IPNet
+	domains  []noProxyDomain
+}
+
+type noProxyDomain struct {
+	name           string
+	port           string
+	subdomainsOnly bool
+}
+
+func newNoProxyList(entries []string) *noProxyList {
+
+	list := &noProxyList{}
+
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+
+		if entry == "*" {
+			list.matchAll = true
+			continue
+		}
+
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			list.networks = append(list.networks, network)
+			continue
+		}
+
+		host, port := entry, ""
+		if splitHost, splitPort, err := net.SplitHostPort(entry); err == nil {
+			host, port = splitHost, splitPort
+		}
+
+		if ip := net.ParseIP(host); ip != nil {
+			list.ips = append(list.ips, ip)
+			continue
+		}
+
+		list.domains = append(list.domains, noProxyDomain{
+			name:           strings.TrimPrefix(host, "."),
+			port:           port,
+			subdomainsOnly: strings.HasPrefix(host, "."),
+		})
+	}
+
+	return list
+}
+
+func (l *noProxyList) matches(requestUrl *url.URL) bool {
+
+	if l.matchAll {
+		return true
+	}
+
+	host := strings.ToLower(requestUrl.Hostname())
+	if host == "localhost" {
+		return true
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.IsLoopback() {
+			return true
+		}
+		for _, noProxyIp := range l.ips {
+			if noProxyIp.Equal(ip) {
+				return true
+			}
+		}
+		for _, network := range l.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	port := requestUrl.Port()
+	if port == "" {
+		port = defaultPort(requestUrl.Scheme)
+	}
+
+	for _, domain := range l.domains {
+		if domain.port != "" && domain.port != port {
+			continue
+		}
+		if strings.HasSuffix(host, "."+domain.name) {
+			return true
+		}
+		if !domain.subdomainsOnly && host == domain.name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func defaultPort(scheme string) string {
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
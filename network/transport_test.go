@@ -0,0 +1,141 @@
+package network
+
+import (
+	"encoding/pem"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/metrics"
+	"github.com/opsgenie/oec/tracing"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func TestProxyWithCredentials(t *testing.T) {
+
+	var proxyAuthorization, requestUri string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyAuthorization = r.Header.Get("Proxy-Authorization")
+		requestUri = r.RequestURI
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	transport, err := NewTransport(&conf.HttpClientConf{
+		ProxyConf: conf.ProxyConf{
+			HttpUrl:  proxy.Listener.Addr().String(),
+			Username: "user",
+			Password: "pass",
+		},
+	})
+	assert.Nil(t, err)
+
+	response, err := (&http.Client{Transport: transport}).Get("http://api.opsgenie.com/v1/oec/credentials")
+	assert.Nil(t, err)
+	response.Body.Close()
+
+	assert.Equal(t, "Basic dXNlcjpwYXNz", proxyAuthorization)
+	assert.Equal(t, "http://api.opsgenie.com/v1/oec/credentials", requestUri)
+}
+
+func TestProxySelection(t *testing.T) {
+
+	proxy, err := newProxyFunc(&conf.ProxyConf{
+		HttpUrl:  "http://httpProxy:3128",
+		HttpsUrl: "https://httpsProxy:3129",
+		NoProxy:  []string{"internal.com", ".corp.net", "10.0.0.0/8", "192.168.1.1", "git.local:8443"},
+	})
+	assert.Nil(t, err)
+
+	testCases := []struct {
+		url   string
+		proxy string
+	}{
+		{"http://api.opsgenie.com", "http://httpProxy:3128"},
+		{"https://api.opsgenie.com", "https://httpsProxy:3129"},
+		{"https://internal.com", ""},
+		{"https://git.internal.com", ""},
+		{"https://notinternal.com", "https://httpsProxy:3129"},
+		{"https://corp.net", "https://httpsProxy:3129"},
+		{"https://sqs.corp.net", ""},
+		{"http://10.1.2.3:8080", ""},
+		{"http://11.1.2.3:8080", "http://httpProxy:3128"},
+		{"http://192.168.1.1", ""},
+		{"https://git.local:8443", ""},
+		{"https://git.local", "https://httpsProxy:3129"},
+		{"http://localhost:7070", ""},
+		{"http://127.0.0.1:7070", ""},
+	}
+
+	for _, testCase := range testCases {
+		requestUrl, _ := url.Parse(testCase.url)
+		proxyUrl, err := proxy(&http.Request{URL: requestUrl})
+		assert.Nil(t, err)
+
+		actual := ""
+		if proxyUrl != nil {
+			actual = proxyUrl.String()
+		}
+		assert.Equal(t, testCase.proxy, actual, testCase.url)
+	}
+}
+
+func TestProxyWithInvalidScheme(t *testing.T) {
+
+	_, err := newProxyFunc(&conf.ProxyConf{HttpUrl: "ftp://proxy:21"})
+	assert.EqualError(t, err, "Scheme of proxy url[ftp://proxy:21] should be one of http, https or socks5.")
+}
+
+func TestTransportTrustsRootCa(t *testing.T) {
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	directory, err := ioutil.TempDir("", "oecCerts")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	rootCaFilepath := filepath.Join(directory, "rootCa.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.Nil(t, ioutil.WriteFile(rootCaFilepath, certificate, 0600))
+
+	defaultTransport, err := NewTransport(&conf.HttpClientConf{})
+	assert.Nil(t, err)
+
+	_, err = (&http.Client{Transport: defaultTransport}).Get(server.URL)
+	assert.NotNil(t, err)
+
+	transport, err := NewTransport(&conf.HttpClientConf{
+		TlsConf: conf.TlsConf{RootCaFilepaths: []string{rootCaFilepath}},
+	})
+	assert.Nil(t, err)
+
+	response, err := (&http.Client{Transport: transport}).Get(server.URL)
+	assert.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+func TestConfigureKeepsTimeouts(t *testing.T) {
+
+	err := Configure(&conf.HttpClientConf{})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 40*time.Second, tracing.HttpClient.Timeout)
+	assert.NotNil(t, tracing.HttpClient.Transport)
+	assert.Equal(t, 40*time.Second, metrics.HttpClient.Timeout)
+	assert.NotNil(t, metrics.HttpClient.Transport)
+}
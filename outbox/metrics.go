@@ -0,0 +1,43 @@
+package outbox
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	outboxDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "oec_outbox_entries",
+			Help: "Number of action results waiting in the outbox to be sent to Opsgenie.",
+		},
+		[]string{"integration"},
+	)
+	outboxOldestEntryAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "oec_outbox_oldest_entry_age_seconds",
+			Help: "Age of the oldest action result waiting in the outbox.",
+		},
+		[]string{"integration"},
+	)
+	outboxSendFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oec_outbox_send_failures_total",
+			Help: "Number of failed attempts to send an action result to Opsgenie.",
+		},
+		[]string{"integration"},
+	)
+	outboxDroppedEntries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oec_outbox_dropped_entries_total",
+			Help: "Number of action results dropped since they could not be sent within the retention period.",
+		},
+		[]string{"integration"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		outboxDepth,
+		outboxOldestEntryAge,
+		outboxSendFailures,
+		outboxDroppedEntries,
+	)
+}
@@ -0,0 +1,485 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/opsgenie/oec/audit"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/runbook"
+	"github.com/opsgenie/oec/tracing"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxConcurrency   = 4
+	retentionInHours = 24
+
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+
+	metricsUpdatePeriod = 5 * time.Second
+	flushCheckPeriod    = 20 * time.Millisecond
+
+	entryFileExtension = ".json"
+)
+
+// Entry is an action result waiting to be sent to Opsgenie.
+type Entry struct {
+	Id            string                       `json:"id"`
+	MessageId     string                       `json:"messageId"`
+	Result        *runbook.ActionResultPayload `json:"result"`
+	CreatedAt     time.Time                    `json:"createdAt"`
+	Attempts      int                          `json:"attempts,omitempty"`
+	NextAttemptAt time.Time                    `json:"nextAttemptAt"`
+	LastError     string                       `json:"lastError,omitempty"`
+	TraceParent   string                       `json:"traceParent,omitempty"`
+}
+
+// Outbox persists action results before they are sent to Opsgenie, so that a result whose sending
+// has failed is retried with backoff, also after a restart, until the retention period is over.
+type Outbox struct {
+	directory   string
+	integration string
+	apiKey      string
+	baseUrl     string
+	retention   time.Duration
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	logger      *logrus.Entry
+
+	entries   map[string]*Entry
+	inFlight  map[string]struct{}
+	semaphore chan struct{}
+	mu        *sync.Mutex
+
+	isRunning   bool
+	isRunningWg *sync.WaitGroup
+	startStopMu *sync.Mutex
+	quit        chan struct{}
+	wakeUp      chan struct{}
+}
+
+// New returns the outbox of the integration. Entries of each named integration are kept in its own directory,
+// so that they are sent with the api key of the integration which is not stored on disk.
+func New(outboxConf *conf.OutboxConf, integration, apiKey, baseUrl string, logger *logrus.Entry) (*Outbox, error) {
+
+	if outboxConf.Directory == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		outboxConf.Directory = filepath.Join(homeDir, "oec", "outbox")
+		logger.Infof("Outbox directory is not set, default directory[%s] is set.", outboxConf.Directory)
+	}
+
+	if outboxConf.MaxConcurrency <= 0 {
+		logger.Infof("Max concurrency of outbox should be greater than zero, default value[%d] is set.", maxConcurrency)
+		outboxConf.MaxConcurrency = maxConcurrency
+	}
+
+	if outboxConf.RetentionInHours <= 0 {
+		logger.Infof("Outbox retention should be greater than zero, default value[%d hours] is set.", retentionInHours)
+		outboxConf.RetentionInHours = retentionInHours
+	}
+
+	directory := outboxConf.Directory
+	if integration != "" {
+		directory = filepath.Join(directory, filepath.Base(integration))
+	}
+
+	err := os.MkdirAll(directory, 0700)
+	if err != nil {
+		return nil, errors.Errorf("Outbox directory[%s] could not be created: %s", directory, err)
+	}
+
+	return &Outbox{
+		directory:   directory,
+		integration: integration,
+		apiKey:      apiKey,
+		baseUrl:     baseUrl,
+		retention:   time.Duration(outboxConf.RetentionInHours) * time.Hour,
+		minBackoff:  minBackoff,
+		maxBackoff:  maxBackoff,
+		logger:      logger,
+		entries:     make(map[string]*Entry),
+		inFlight:    make(map[string]struct{}),
+		semaphore:   make(chan struct{}, outboxConf.MaxConcurrency),
+		mu:          &sync.Mutex{},
+		isRunningWg: &sync.WaitGroup{},
+		startStopMu: &sync.Mutex{},
+		quit:        make(chan struct{}),
+		wakeUp:      make(chan struct{}, 1),
+	}, nil
+}
+
+// Start loads the entries left from the previous runs and starts sending them.
+func (o *Outbox) Start() error {
+	defer o.startStopMu.Unlock()
+	o.startStopMu.Lock()
+
+	if o.isRunning {
+		return errors.New("Outbox is already running.")
+	}
+
+	entries, err := o.load()
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	for _, entry := range entries {
+		o.entries[entry.Id] = entry
+	}
+	o.mu.Unlock()
+
+	if len(entries) > 0 {
+		o.logger.Infof("Outbox has %d results left from the previous run, they will be sent to Opsgenie.", len(entries))
+	}
+
+	o.isRunningWg.Add(1)
+	go o.run()
+
+	o.isRunning = true
+	return nil
+}
+
+// Stop tries to send all waiting results at once until the given context is done. Results which could not be
+// sent are kept on disk to be sent after the next start.
+func (o *Outbox) Stop(ctx context.Context) error {
+	defer o.startStopMu.Unlock()
+	o.startStopMu.Lock()
+
+	if !o.isRunning {
+		return errors.New("Outbox is not running.")
+	}
+
+	err := o.flush(ctx)
+
+	close(o.quit)
+	o.isRunningWg.Wait()
+	o.isRunning = false
+
+	if err != nil {
+		return errors.Errorf("Outbox could not be flushed, %d results will be sent after restart: %s", o.Depth(), err)
+	}
+	return nil
+}
+
+// Send stores the result and sends it in the background. If it could not be stored, it is still tried to be sent.
+func (o *Outbox) Send(ctx context.Context, messageId string, result *runbook.ActionResultPayload) {
+
+	now := time.Now()
+	entry := &Entry{
+		Id:            uuid.New().String(),
+		MessageId:     messageId,
+		Result:        result,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+		TraceParent:   tracing.TraceParent(ctx),
+	}
+
+	err := o.write(entry)
+	if err != nil {
+		o.logger.Warnf("Result of message[%s] could not be stored in the outbox, it will not be retried after restart: %s", messageId, err)
+	}
+
+	o.mu.Lock()
+	o.entries[entry.Id] = entry
+	o.mu.Unlock()
+
+	o.notify()
+}
+
+// Depth returns the number of results waiting to be sent.
+func (o *Outbox) Depth() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+func (o *Outbox) run() {
+	defer o.isRunningWg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-o.quit:
+			return
+		case <-o.wakeUp:
+		case <-timer.C:
+		}
+
+		nextAttempt := o.dispatch(time.Now())
+		o.updateMetrics()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(nextAttempt)
+	}
+}
+
+// dispatch starts sending the due entries, oldest first, as long as the concurrency limit allows.
+// It returns the duration until the next entry is due.
+func (o *Outbox) dispatch(now time.Time) time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	wait := metricsUpdatePeriod
+	expireTime := now.Add(-o.retention)
+
+	for _, entry := range o.sortedEntries() {
+		if _, ok := o.inFlight[entry.Id]; ok {
+			continue
+		}
+
+		if entry.CreatedAt.Before(expireTime) {
+			o.drop(entry)
+			o.logger.Errorf("Result[%+v] of message[%s] could not be sent to Opsgenie in %s after %d attempts and is dropped. Last error: %s",
+				entry.Result, entry.MessageId, o.retention.String(), entry.Attempts, entry.LastError)
+			continue
+		}
+
+		if entry.NextAttemptAt.After(now) {
+			if untilAttempt := entry.NextAttemptAt.Sub(now); untilAttempt < wait {
+				wait = untilAttempt
+			}
+			continue
+		}
+
+		select {
+		case o.semaphore <- struct{}{}:
+		default:
+			return wait // remaining ones are dispatched when a sending completes
+		}
+
+		o.inFlight[entry.Id] = struct{}{}
+		go o.send(entry)
+	}
+
+	return wait
+}
+
+func (o *Outbox) send(entry *Entry) {
+	defer func() {
+		<-o.semaphore
+		o.notify()
+	}()
+
+	ctx := context.Background()
+	if spanContext, err := tracing.ParseTraceParent(entry.TraceParent); err == nil {
+		ctx = tracing.ContextWithRemoteSpanContext(ctx, spanContext)
+	}
+	_, span := runbook.StartSendResultSpan(ctx, entry.Result)
+	span.SetAttribute("oec.outbox.attempts", entry.Attempts+1)
+
+	start := time.Now()
+	err := runbook.SendResultToOpsGenieFunc(entry.Result, o.apiKey, o.baseUrl)
+	span.SetError(err)
+	span.End()
+	runbook.ObserveResultCallback(o.integration, time.Since(start), err)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.inFlight, entry.Id)
+
+	if err == nil {
+		delete(o.entries, entry.Id)
+		o.remove(entry.Id)
+		audit.WriteResult(o.integration, entry.MessageId, entry.Result, audit.ResultSent, entry.Attempts+1, nil)
+		o.logger.Debugf("Successfully sent result of message[%s] to OpsGenie and it took %f seconds.", entry.MessageId, time.Since(start).Seconds())
+		return
+	}
+
+	outboxSendFailures.WithLabelValues(o.integration).Inc()
+
+	entry.Attempts++
+	entry.LastError = err.Error()
+
+	var resultErr *runbook.ResultError
+	if errors.As(err, &resultErr) && !resultErr.IsRetryable() {
+		o.drop(entry)
+		o.logger.Errorf("Result[%+v] of message[%s] is rejected by Opsgenie and is dropped: %s", entry.Result, entry.MessageId, err)
+		return
+	}
+	entry.NextAttemptAt = time.Now().Add(o.backoff(entry.Attempts))
+
+	if writeErr := o.write(entry); writeErr != nil {
+		o.logger.Warnf("Outbox entry of message[%s] could not be updated: %s", entry.MessageId, writeErr)
+	}
+
+	o.logger.Warnf("Could not send action result[%+v] of message[%s] to Opsgenie, attempt %d will be at %s: %s",
+		entry.Result, entry.MessageId, entry.Attempts+1, entry.NextAttemptAt.Format(time.RFC3339), err)
+}
+
+// flush makes all entries due and waits until each of them has been tried once.
+func (o *Outbox) flush(ctx context.Context) error {
+
+	flushTime := time.Now()
+
+	o.mu.Lock()
+	for _, entry := range o.entries {
+		entry.NextAttemptAt = flushTime
+	}
+	o.mu.Unlock()
+	o.notify()
+
+	ticker := time.NewTicker(flushCheckPeriod)
+	defer ticker.Stop()
+
+	for !o.isFlushed(flushTime) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (o *Outbox) isFlushed(flushTime time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.inFlight) > 0 {
+		return false
+	}
+	for _, entry := range o.entries {
+		if !entry.NextAttemptAt.After(flushTime) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *Outbox) notify() {
+	select {
+	case o.wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+// drop must be called while holding the lock.
+func (o *Outbox) drop(entry *Entry) {
+	delete(o.entries, entry.Id)
+	o.remove(entry.Id)
+	outboxDroppedEntries.WithLabelValues(o.integration).Inc()
+	audit.WriteResult(o.integration, entry.MessageId, entry.Result, audit.ResultDropped, entry.Attempts, errors.New(entry.LastError))
+}
+
+func (o *Outbox) updateMetrics() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	oldestAge := 0.0
+	for _, entry := range o.entries {
+		if age := time.Since(entry.CreatedAt).Seconds(); age > oldestAge {
+			oldestAge = age
+		}
+	}
+
+	outboxDepth.WithLabelValues(o.integration).Set(float64(len(o.entries)))
+	outboxOldestEntryAge.WithLabelValues(o.integration).Set(oldestAge)
+}
+
+// sortedEntries must be called while holding the lock.
+func (o *Outbox) sortedEntries() []*Entry {
+	entries := make([]*Entry, 0, len(o.entries))
+	for _, entry := range o.entries {
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries
+}
+
+func (o *Outbox) filepath(id string) string {
+	return filepath.Join(o.directory, filepath.Base(id)+entryFileExtension)
+}
+
+func (o *Outbox) write(entry *Entry) error {
+
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	tmpFilepath := o.filepath(entry.Id) + ".tmp"
+	err = ioutil.WriteFile(tmpFilepath, content, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpFilepath, o.filepath(entry.Id))
+	if err != nil {
+		os.Remove(tmpFilepath)
+		return err
+	}
+	return nil
+}
+
+func (o *Outbox) remove(id string) {
+	err := os.Remove(o.filepath(id))
+	if err != nil && !os.IsNotExist(err) {
+		o.logger.Warnf("Outbox entry[%s] could not be removed: %s", id, err)
+	}
+}
+
+func (o *Outbox) load() ([]*Entry, error) {
+
+	files, err := ioutil.ReadDir(o.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != entryFileExtension {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(o.directory, file.Name()))
+		if err != nil {
+			o.logger.Warnf("Outbox entry[%s] could not be read: %s", file.Name(), err)
+			continue
+		}
+
+		entry := &Entry{}
+		err = json.Unmarshal(content, entry)
+		if err != nil || entry.Id != strings.TrimSuffix(file.Name(), entryFileExtension) {
+			o.logger.Warnf("Outbox entry[%s] could not be parsed and is ignored: %v", file.Name(), err)
+			continue
+		}
+		entry.NextAttemptAt = time.Time{} // results of the previous run are tried right away
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// backoff doubles the wait after each failed attempt, up to the max backoff.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	wait := o.minBackoff
+	for i := 1; i < attempts && wait < o.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > o.maxBackoff {
+		return o.maxBackoff
+	}
+	return wait
+}
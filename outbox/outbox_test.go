@@ -0,0 +1,272 @@
+package outbox
+
+import (
+	"context"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/runbook"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testApiKey  = "ApiKey"
+	testBaseUrl = "BaseUrl"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	code := m.Run()
+	runbook.SendResultToOpsGenieFunc = runbook.SendResultToOpsGenie
+	os.Exit(code)
+}
+
+func newOutboxTest(t *testing.T, directory string, maxConcurrency int) *Outbox {
+	outbox, err := New(&conf.OutboxConf{Directory: directory, MaxConcurrency: maxConcurrency}, "", testApiKey, testBaseUrl, logrus.NewEntry(logrus.StandardLogger()))
+	assert.Nil(t, err)
+	outbox.minBackoff = time.Millisecond
+	outbox.maxBackoff = 4 * time.Millisecond
+	return outbox
+}
+
+func newDirectoryTest(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "oecOutbox")
+	assert.Nil(t, err)
+	return directory
+}
+
+func countEntryFiles(t *testing.T, directory string) int {
+	files, err := filepath.Glob(filepath.Join(directory, "*"+entryFileExtension))
+	assert.Nil(t, err)
+	return len(files)
+}
+
+func TestValidateNewOutbox(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	outboxConf := &conf.OutboxConf{Directory: directory, MaxConcurrency: -1, RetentionInHours: -1}
+
+	outbox, err := New(outboxConf, "integration", testApiKey, testBaseUrl, logrus.NewEntry(logrus.StandardLogger()))
+
+	assert.Nil(t, err)
+	assert.Equal(t, maxConcurrency, outboxConf.MaxConcurrency)
+	assert.Equal(t, retentionInHours, outboxConf.RetentionInHours)
+	assert.Equal(t, filepath.Join(directory, "integration"), outbox.directory)
+	assert.DirExists(t, outbox.directory)
+}
+
+func TestNewOutboxWithInvalidDirectory(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	filePath := filepath.Join(directory, "file")
+	err := ioutil.WriteFile(filePath, []byte{}, 0600)
+	assert.Nil(t, err)
+
+	_, err = New(&conf.OutboxConf{Directory: filePath}, "integration", testApiKey, testBaseUrl, logrus.NewEntry(logrus.StandardLogger()))
+	assert.NotNil(t, err)
+}
+
+func TestSendRemovesEntryAfterSuccess(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	sent := make(chan *runbook.ActionResultPayload, 1)
+	runbook.SendResultToOpsGenieFunc = func(resultPayload *runbook.ActionResultPayload, apiKey, baseUrl string) error {
+		assert.Equal(t, testApiKey, apiKey)
+		assert.Equal(t, testBaseUrl, baseUrl)
+		sent <- resultPayload
+		return nil
+	}
+
+	outbox := newOutboxTest(t, directory, 1)
+	assert.Nil(t, outbox.Start())
+
+	result := &runbook.ActionResultPayload{RequestId: "RequestId"}
+	outbox.Send(context.Background(), "MessageId", result)
+
+	assert.Equal(t, result, <-sent)
+	assert.Eventually(t, func() bool { return outbox.Depth() == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, 0, countEntryFiles(t, directory))
+
+	assert.Nil(t, outbox.Stop(context.Background()))
+}
+
+func TestSendRetriesFailedResults(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	var attempts int32
+	runbook.SendResultToOpsGenieFunc = func(resultPayload *runbook.ActionResultPayload, apiKey, baseUrl string) error {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return errors.New("Test send error")
+		}
+		return nil
+	}
+
+	outbox := newOutboxTest(t, directory, 1)
+	assert.Nil(t, outbox.Start())
+
+	outbox.Send(context.Background(), "MessageId", &runbook.ActionResultPayload{})
+
+	assert.Eventually(t, func() bool { return outbox.Depth() == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+
+	assert.Nil(t, outbox.Stop(context.Background()))
+}
+
+func TestStartSendsEntriesOfPreviousRun(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	var available int32
+	sent := make(chan *runbook.ActionResultPayload, 1)
+	runbook.SendResultToOpsGenieFunc = func(resultPayload *runbook.ActionResultPayload, apiKey, baseUrl string) error {
+		if atomic.LoadInt32(&available) == 0 {
+			return errors.New("Test send error")
+		}
+		sent <- resultPayload
+		return nil
+	}
+
+	previousOutbox := newOutboxTest(t, directory, 1)
+	previousOutbox.minBackoff = time.Hour
+	previousOutbox.maxBackoff = time.Hour
+	assert.Nil(t, previousOutbox.Start())
+	previousOutbox.Send(context.Background(), "MessageId", &runbook.ActionResultPayload{RequestId: "RequestId"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Nil(t, previousOutbox.Stop(ctx))
+	assert.Equal(t, 1, countEntryFiles(t, directory))
+
+	atomic.StoreInt32(&available, 1)
+
+	outbox := newOutboxTest(t, directory, 1)
+	assert.Nil(t, outbox.Start())
+
+	assert.Equal(t, "RequestId", (<-sent).RequestId)
+	assert.Nil(t, outbox.Stop(context.Background()))
+	assert.Equal(t, 0, countEntryFiles(t, directory))
+}
+
+func TestStopKeepsEntriesWhichCouldNotBeFlushed(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	release := make(chan struct{})
+	runbook.SendResultToOpsGenieFunc = func(resultPayload *runbook.ActionResultPayload, apiKey, baseUrl string) error {
+		<-release
+		return nil
+	}
+
+	outbox := newOutboxTest(t, directory, 1)
+	assert.Nil(t, outbox.Start())
+	outbox.Send(context.Background(), "MessageId", &runbook.ActionResultPayload{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := outbox.Stop(ctx)
+	assert.EqualError(t, err, "Outbox could not be flushed, 1 results will be sent after restart: context deadline exceeded")
+	assert.Equal(t, 1, countEntryFiles(t, directory))
+
+	close(release)
+	assert.Eventually(t, func() bool { return outbox.Depth() == 0 }, time.Second, time.Millisecond)
+}
+
+func TestSendLimitsConcurrency(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	var running, maxRunning int32
+	runbook.SendResultToOpsGenieFunc = func(resultPayload *runbook.ActionResultPayload, apiKey, baseUrl string) error {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	}
+
+	outbox := newOutboxTest(t, directory, 2)
+	assert.Nil(t, outbox.Start())
+
+	for i := 0; i < 10; i++ {
+		outbox.Send(context.Background(), "MessageId", &runbook.ActionResultPayload{})
+	}
+
+	assert.Nil(t, outbox.Stop(context.Background()))
+	assert.Equal(t, 0, outbox.Depth())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxRunning))
+}
+
+func TestSendDropsRejectedResults(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	var attempts int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		res.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer testServer.Close()
+	runbook.SendResultToOpsGenieFunc = runbook.SendResultToOpsGenie
+
+	outbox, err := New(&conf.OutboxConf{Directory: directory}, "", testApiKey, testServer.URL, logrus.NewEntry(logrus.StandardLogger()))
+	assert.Nil(t, err)
+	assert.Nil(t, outbox.Start())
+
+	outbox.Send(context.Background(), "MessageId", &runbook.ActionResultPayload{})
+
+	assert.Eventually(t, func() bool { return outbox.Depth() == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	assert.Equal(t, 0, countEntryFiles(t, directory))
+
+	assert.Nil(t, outbox.Stop(context.Background()))
+}
+
+func TestDispatchDropsExpiredEntries(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	outbox := newOutboxTest(t, directory, 1)
+	assert.Nil(t, os.MkdirAll(directory, 0700))
+
+	entry := &Entry{Id: "expired", CreatedAt: time.Now().Add(-25 * time.Hour)}
+	assert.Nil(t, outbox.write(entry))
+	outbox.entries[entry.Id] = entry
+
+	outbox.dispatch(time.Now())
+
+	assert.Equal(t, 0, outbox.Depth())
+	assert.Equal(t, 0, countEntryFiles(t, directory))
+}
+
+func TestBackoff(t *testing.T) {
+	directory := newDirectoryTest(t)
+	defer os.RemoveAll(directory)
+
+	outbox := newOutboxTest(t, directory, 1)
+	outbox.minBackoff = time.Second
+	outbox.maxBackoff = 5 * time.Second
+
+	assert.Equal(t, time.Second, outbox.backoff(1))
+	assert.Equal(t, 2*time.Second, outbox.backoff(2))
+	assert.Equal(t, 4*time.Second, outbox.backoff(3))
+	assert.Equal(t, 5*time.Second, outbox.backoff(4))
+	assert.Equal(t, 5*time.Second, outbox.backoff(100))
+}
@@ -0,0 +1,268 @@
+package queue
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/logging"
+	"github.com/opsgenie/oec/redaction"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	stdoutStream = "stdout"
+	stderrStream = "stderr"
+
+	defaultCaptureMaxSizeInKb      = 1024
+	defaultCaptureRetentionInHours = 72
+	captureCleanupPeriod           = time.Hour
+
+	// maxLineLength limits the partial line kept by a line framer, longer lines are framed in parts.
+	maxLineLength = 64 * 1024
+)
+
+// lineFramer writes each line of an output prefixed with its time, request id, action and stream, so that the
+// lines of the executions sharing a log file can be correlated. Writers of syslog and journald get them as the
+// fields of the line instead. A line is written once it is complete, the last one is written when the framer is
+// flushed.
+type lineFramer struct {
+	writer  io.Writer
+	prefix  string
+	fields  map[string]string
+	level   logrus.Level
+	partial *bytes.Buffer
+}
+
+func newLineFramer(writer io.Writer, requestId, action, stream string) *lineFramer {
+	level := logrus.InfoLevel
+	if stream == stderrStream {
+		level = logrus.WarnLevel
+	}
+
+	return &lineFramer{
+		writer:  writer,
+		prefix:  fmt.Sprintf(" requestId=%s action=%s stream=%s ", requestId, action, stream),
+		fields:  map[string]string{"requestId": requestId, "action": action, "stream": stream},
+		level:   level,
+		partial: &bytes.Buffer{},
+	}
+}
+
+func (f *lineFramer) Write(p []byte) (int, error) {
+	f.partial.Write(p)
+
+	for {
+		line, err := f.partial.ReadBytes('\n')
+		if err != nil { // line is not complete yet
+			f.partial.Write(line)
+			break
+		}
+		if err := f.writeLine(line[:len(line)-1]); err != nil {
+			return len(p), err
+		}
+	}
+
+	if f.partial.Len() > maxLineLength {
+		return len(p), f.Flush()
+	}
+	return len(p), nil
+}
+
+// Flush writes the incomplete line, if there is one.
+func (f *lineFramer) Flush() error {
+	if f.partial.Len() == 0 {
+		return nil
+	}
+	line := f.partial.String()
+	f.partial.Reset()
+	return f.writeLine([]byte(line))
+}
+
+func (f *lineFramer) writeLine(line []byte) error {
+	redacted := redaction.String(strings.TrimSuffix(string(line), "\r"))
+	if lineWriter, ok := f.writer.(logging.LineWriter); ok {
+		return lineWriter.WriteLine(f.level, f.fields, redacted)
+	}
+
+	framed := time.Now().UTC().Format(time.RFC3339Nano) + f.prefix + redacted + "\n"
+	_, err := io.WriteString(f.writer, framed)
+	return err
+}
+
+// tailBuffer keeps the last bytes written to it.
+type tailBuffer struct {
+	size int
+	data []byte
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{size: size}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	if len(p) >= t.size {
+		t.data = append(t.data[:0], p[len(p)-t.size:]...)
+		return len(p), nil
+	}
+	if overflow := len(t.data) + len(p) - t.size; overflow > 0 {
+		t.data = t.data[:copy(t.data, t.data[overflow:])]
+	}
+	t.data = append(t.data, p...)
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.data)
+}
+
+/******************************************************************************************/
+
+// captureStore creates a file for each execution to capture its outputs. Files older than the retention are
+// removed from time to time.
+type captureStore struct {
+	directory   string
+	maxSize     int64
+	retention   time.Duration
+	lastCleanup time.Time
+	mu          *sync.Mutex
+}
+
+func newCaptureStore(outputConf *conf.ActionOutputConf) (*captureStore, error) {
+
+	if outputConf.CaptureDirectory == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		outputConf.CaptureDirectory = filepath.Join(homeDir, "oec", "captures")
+		logrus.Infof("Capture directory is not set, default directory[%s] is set.", outputConf.CaptureDirectory)
+	}
+
+	if outputConf.CaptureMaxSizeInKb <= 0 {
+		logrus.Infof("Max size of the capture files should be greater than zero, default value[%dKB] is set.", defaultCaptureMaxSizeInKb)
+		outputConf.CaptureMaxSizeInKb = defaultCaptureMaxSizeInKb
+	}
+
+	if outputConf.CaptureRetentionInHours <= 0 {
+		logrus.Infof("Capture retention should be greater than zero, default value[%d hours] is set.", defaultCaptureRetentionInHours)
+		outputConf.CaptureRetentionInHours = defaultCaptureRetentionInHours
+	}
+
+	err := os.MkdirAll(outputConf.CaptureDirectory, 0700)
+	if err != nil {
+		return nil, errors.Errorf("Capture directory[%s] could not be created: %s", outputConf.CaptureDirectory, err)
+	}
+
+	return &captureStore{
+		directory: outputConf.CaptureDirectory,
+		maxSize:   outputConf.CaptureMaxSizeInKb * 1024,
+		retention: time.Duration(outputConf.CaptureRetentionInHours) * time.Hour,
+		mu:        &sync.Mutex{},
+	}, nil
+}
+
+func (s *captureStore) create(messageId string) (*captureFile, error) {
+	s.cleanup()
+
+	name := time.Now().UTC().Format("20060102T150405.000000000Z") + "-" + sanitizeFilename(messageId) + ".log"
+	path := filepath.Join(s.directory, name)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, errors.Errorf("Capture file[%s] could not be created: %s", path, err)
+	}
+
+	return &captureFile{
+		path:      path,
+		file:      file,
+		remaining: s.maxSize,
+		mu:        &sync.Mutex{},
+	}, nil
+}
+
+// cleanup removes the capture files older than the retention, at most once in the cleanup period.
+func (s *captureStore) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastCleanup) < captureCleanupPeriod {
+		return
+	}
+	s.lastCleanup = now
+
+	files, err := ioutil.ReadDir(s.directory)
+	if err != nil {
+		logrus.Warnf("Capture directory[%s] could not be read: %s", s.directory, err)
+		return
+	}
+	for _, file := range files {
+		if file.IsDir() || now.Sub(file.ModTime()) < s.retention {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.directory, file.Name())); err != nil {
+			logrus.Warnf("Capture file[%s] could not be removed: %s", file.Name(), err)
+		}
+	}
+}
+
+func sanitizeFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// captureFile has the outputs of an execution up to its size limit, the rest is discarded. Writes never fail,
+// so that capturing does not affect the execution.
+type captureFile struct {
+	path      string
+	file      *os.File
+	remaining int64
+	truncated bool
+	mu        *sync.Mutex
+}
+
+func (c *captureFile) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.truncated {
+		return len(p), nil
+	}
+
+	data := p
+	if int64(len(data)) > c.remaining {
+		data = data[:c.remaining]
+		c.truncated = true
+	}
+
+	n, err := c.file.Write(data)
+	c.remaining -= int64(n)
+	if err != nil {
+		logrus.Warnf("Output could not be written to capture file[%s]: %s", c.path, err)
+		c.truncated = true
+		return len(p), nil
+	}
+
+	if c.truncated {
+		c.file.WriteString("\n... output is truncated, capture file has reached its size limit.\n")
+	}
+	return len(p), nil
+}
+
+func (c *captureFile) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.file.Close()
+}
@@ -0,0 +1,127 @@
+package queue
+
+import (
+	"bytes"
+	"github.com/opsgenie/oec/conf"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineFramerFramesEachLine(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	framer := newLineFramer(buffer, "RequestId", "Create", stdoutStream)
+
+	framer.Write([]byte("first\r\nsec"))
+	framer.Write([]byte("ond\nthi"))
+	assert.Nil(t, framer.Flush())
+	assert.Nil(t, framer.Flush())
+
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	assert.Equal(t, 3, len(lines))
+	for i, expected := range []string{"first", "second", "thi"} {
+		assert.True(t, strings.HasSuffix(lines[i], " requestId=RequestId action=Create stream=stdout "+expected), lines[i])
+		_, err := time.Parse(time.RFC3339Nano, strings.SplitN(lines[i], " ", 2)[0])
+		assert.Nil(t, err)
+	}
+}
+
+type mockLineWriter struct {
+	bytes.Buffer
+	levels []logrus.Level
+	fields []map[string]string
+	lines  []string
+}
+
+func (w *mockLineWriter) WriteLine(level logrus.Level, fields map[string]string, line string) error {
+	w.levels = append(w.levels, level)
+	w.fields = append(w.fields, fields)
+	w.lines = append(w.lines, line)
+	return nil
+}
+
+func TestLineFramerSendsFieldsToLineWriters(t *testing.T) {
+	writer := &mockLineWriter{}
+	framer := newLineFramer(writer, "RequestId", "Create", stderrStream)
+
+	framer.Write([]byte("first\nsecond"))
+	assert.Nil(t, framer.Flush())
+
+	assert.Equal(t, 0, writer.Len())
+	assert.Equal(t, []string{"first", "second"}, writer.lines)
+	assert.Equal(t, []logrus.Level{logrus.WarnLevel, logrus.WarnLevel}, writer.levels)
+	assert.Equal(t, map[string]string{"requestId": "RequestId", "action": "Create", "stream": "stderr"}, writer.fields[0])
+}
+
+func TestTailBufferKeepsLastBytes(t *testing.T) {
+	tail := newTailBuffer(5)
+
+	tail.Write([]byte("abc"))
+	assert.Equal(t, "abc", tail.String())
+
+	tail.Write([]byte("def"))
+	assert.Equal(t, "bcdef", tail.String())
+
+	tail.Write([]byte("0123456789"))
+	assert.Equal(t, "56789", tail.String())
+}
+
+func TestCaptureFileIsTruncatedAtItsLimit(t *testing.T) {
+	directory, err := ioutil.TempDir("", "captures")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	store, err := newCaptureStore(&conf.ActionOutputConf{CaptureDirectory: directory, CaptureMaxSizeInKb: 1})
+	assert.Nil(t, err)
+
+	capture, err := store.create("message/Id")
+	assert.Nil(t, err)
+	assert.Equal(t, directory, filepath.Dir(capture.path))
+	assert.True(t, strings.HasSuffix(capture.path, "-message_Id.log"), capture.path)
+
+	n, err := capture.Write(bytes.Repeat([]byte("a"), 1000))
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, n)
+	n, err = capture.Write(bytes.Repeat([]byte("b"), 1000))
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, n)
+	capture.Write([]byte("c"))
+	assert.Nil(t, capture.Close())
+
+	captured, err := ioutil.ReadFile(capture.path)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(captured), strings.Repeat("a", 1000)+strings.Repeat("b", 24)+"\n"))
+	assert.True(t, strings.HasSuffix(string(captured), "b\n... output is truncated, capture file has reached its size limit.\n"))
+}
+
+func TestCaptureStoreRemovesExpiredFiles(t *testing.T) {
+	directory, err := ioutil.TempDir("", "captures")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	expired := filepath.Join(directory, "expired.log")
+	recent := filepath.Join(directory, "recent.log")
+	assert.Nil(t, ioutil.WriteFile(expired, nil, 0600))
+	assert.Nil(t, ioutil.WriteFile(recent, nil, 0600))
+	old := time.Now().Add(-2 * time.Hour)
+	assert.Nil(t, os.Chtimes(expired, old, old))
+
+	store, err := newCaptureStore(&conf.ActionOutputConf{CaptureDirectory: directory, CaptureRetentionInHours: 1})
+	assert.Nil(t, err)
+
+	capture, err := store.create("MessageId")
+	assert.Nil(t, err)
+	capture.Close()
+
+	_, err = os.Stat(expired)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(recent)
+	assert.Nil(t, err)
+	_, err = os.Stat(capture.path)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,232 @@
+package queue
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+type errorClass string
+
+const (
+	throttlingError   errorClass = "throttling"
+	authError         errorClass = "auth"
+	networkError      errorClass = "network"
+	expiredTokenError errorClass = "expiredToken"
+	unknownError      errorClass = "unknown"
+)
+
+type circuitState string
+
+const (
+	circuitClosed   circuitState = "closed"
+	circuitOpen     circuitState = "open"
+	circuitHalfOpen circuitState = "halfOpen"
+)
+
+type backoffInterval struct {
+	base time.Duration
+	max  time.Duration
+}
+
+var backoffIntervals = map[errorClass]backoffInterval{
+	throttlingError:   {base: time.Second, max: time.Minute},
+	authError:         {base: 15 * time.Second, max: 5 * time.Minute},
+	networkError:      {base: 500 * time.Millisecond, max: 30 * time.Second},
+	expiredTokenError: {base: 5 * time.Second, max: time.Minute},
+	unknownError:      {base: time.Second, max: 30 * time.Second},
+}
+
+const requestErrorCode = "RequestError"
+
+var authErrorCodes = map[string]struct{}{
+	"AccessDenied":                {},
+	"AccessDeniedException":       {},
+	"AuthFailure":                 {},
+	"IncompleteSignature":         {},
+	"InvalidClientTokenId":        {},
+	"InvalidSecurity":             {},
+	"MissingAuthenticationToken":  {},
+	"SignatureDoesNotMatch":       {},
+	"UnrecognizedClientException": {},
+}
+
+func classifyError(err error) errorClass {
+
+	if request.IsErrorExpiredCreds(err) {
+		return expiredTokenError
+	}
+	if request.IsErrorThrottle(err) {
+		return throttlingError
+	}
+
+	if awsErr, ok := err.(awserr.Error); ok {
+		if strings.Contains(awsErr.Code(), "ExpiredToken") {
+			return expiredTokenError
+		}
+		if _, ok := authErrorCodes[awsErr.Code()]; ok {
+			return authError
+		}
+		if requestFailure, ok := err.(awserr.RequestFailure); ok {
+			switch statusCode := requestFailure.StatusCode(); {
+			case statusCode == 429:
+				return throttlingError
+			case statusCode == 401 || statusCode == 403:
+				return authError
+			case statusCode >= 500:
+				return networkError
+			}
+		}
+		switch awsErr.Code() {
+		case requestErrorCode, request.ErrCodeResponseTimeout, request.ErrCodeRead:
+			return networkError
+		}
+		if _, ok := awsErr.OrigErr().(net.Error); ok {
+			return networkError
+		}
+	}
+
+	if _, ok := err.(net.Error); ok {
+		return networkError
+	}
+
+	return unknownError
+}
+
+// receiveBackoff decides how long a poller should wait after failed receives and
+// opens a circuit after repeated failures, letting a single probe through periodically.
+type receiveBackoff struct {
+	failureThreshold int
+	openInterval     time.Duration
+
+	state               circuitState
+	consecutiveFailures int
+	lastErrorClass      errorClass
+	lastSuccessAt       time.Time
+	openedAt            time.Time
+	nextWait            time.Duration
+
+	random *rand.Rand
+	mu     *sync.Mutex
+}
+
+func newReceiveBackoff(failureThreshold int, openInterval time.Duration) *receiveBackoff {
+	return &receiveBackoff{
+		failureThreshold: failureThreshold,
+		openInterval:     openInterval,
+		state:            circuitClosed,
+		random:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		mu:               &sync.Mutex{},
+	}
+}
+
+// allow reports whether a receive should be attempted, moving an open circuit
+// to half-open once its open interval has elapsed.
+func (b *receiveBackoff) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.openInterval {
+			return false
+		}
+		b.state = circuitHalfOpen
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *receiveBackoff) onSuccess() (previousState circuitState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	previousState = b.state
+	b.state = circuitClosed
+	b.consecutiveFailures = 0
+	b.lastErrorClass = ""
+	b.lastSuccessAt = time.Now()
+	b.nextWait = 0
+	return previousState
+}
+
+func (b *receiveBackoff) onFailure(class errorClass) (previousState circuitState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	previousState = b.state
+	b.consecutiveFailures++
+	b.lastErrorClass = class
+
+	if b.state == circuitHalfOpen || b.consecutiveFailures >= b.failureThreshold {
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+		b.nextWait = b.openInterval
+		return previousState
+	}
+
+	interval, ok := backoffIntervals[class]
+	if !ok {
+		interval = backoffIntervals[unknownError]
+	}
+	b.nextWait = b.jitter(interval, b.consecutiveFailures-1)
+	return previousState
+}
+
+// waitInterval returns the backoff wait if the last receive failed, otherwise the given default.
+func (b *receiveBackoff) waitInterval(defaultInterval time.Duration) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitOpen {
+		remaining := b.openInterval - time.Since(b.openedAt)
+		if remaining < defaultInterval {
+			return defaultInterval
+		}
+		return remaining
+	}
+	if b.nextWait > defaultInterval {
+		return b.nextWait
+	}
+	return defaultInterval
+}
+
+func (b *receiveBackoff) State() circuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *receiveBackoff) ConsecutiveFailures() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.consecutiveFailures
+}
+
+func (b *receiveBackoff) LastErrorClass() errorClass {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastErrorClass
+}
+
+func (b *receiveBackoff) LastSuccessAt() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastSuccessAt
+}
+
+// jitter returns a random duration between the half and the whole of the exponential backoff.
+func (b *receiveBackoff) jitter(interval backoffInterval, attempt int) time.Duration {
+	backoff := float64(interval.base) * math.Pow(2, float64(attempt))
+	if backoff > float64(interval.max) {
+		backoff = float64(interval.max)
+	}
+	half := backoff / 2
+	return time.Duration(half + b.random.Float64()*half)
+}
@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+
+	testCases := []struct {
+		err   error
+		class errorClass
+	}{
+		{awserr.New("ExpiredToken", "expired", nil), expiredTokenError},
+		{awserr.New("ThrottlingException", "throttled", nil), throttlingError},
+		{awserr.NewRequestFailure(awserr.New("Unknown", "too many", nil), 429, "requestId"), throttlingError},
+		{awserr.New("AccessDenied", "denied", nil), authError},
+		{awserr.NewRequestFailure(awserr.New("Unknown", "forbidden", nil), 403, "requestId"), authError},
+		{awserr.New("RequestError", "send request failed", errors.New("dial tcp")), networkError},
+		{awserr.NewRequestFailure(awserr.New("InternalError", "internal", nil), 503, "requestId"), networkError},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, networkError},
+		{errors.New("unknown"), unknownError},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.class, classifyError(testCase.err), testCase.err.Error())
+	}
+}
+
+func TestBackoffGrowsExponentiallyWithJitter(t *testing.T) {
+
+	backoff := newReceiveBackoff(10, time.Minute)
+	interval := backoffIntervals[throttlingError]
+
+	for attempt := 0; attempt < 8; attempt++ {
+		backoff.onFailure(throttlingError)
+
+		expected := interval.base << uint(attempt)
+		if expected > interval.max {
+			expected = interval.max
+		}
+
+		wait := backoff.waitInterval(time.Millisecond)
+		assert.True(t, wait >= expected/2 && wait <= expected, "attempt %d waits %s", attempt, wait)
+	}
+	assert.Equal(t, circuitClosed, backoff.State())
+
+	backoff.onSuccess()
+	assert.Equal(t, time.Millisecond, backoff.waitInterval(time.Millisecond))
+	assert.Equal(t, 0, backoff.ConsecutiveFailures())
+}
+
+func TestBackoffOpensCircuitAndProbes(t *testing.T) {
+
+	backoff := newReceiveBackoff(3, 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, backoff.allow())
+		backoff.onFailure(networkError)
+	}
+	assert.Equal(t, circuitOpen, backoff.State())
+	assert.False(t, backoff.allow())
+	assert.True(t, backoff.waitInterval(time.Millisecond) > time.Millisecond)
+
+	time.Sleep(60 * time.Millisecond)
+
+	assert.True(t, backoff.allow())
+	assert.Equal(t, circuitHalfOpen, backoff.State())
+
+	previousState := backoff.onFailure(networkError)
+	assert.Equal(t, circuitHalfOpen, previousState)
+	assert.Equal(t, circuitOpen, backoff.State())
+	assert.False(t, backoff.allow())
+
+	time.Sleep(60 * time.Millisecond)
+
+	assert.True(t, backoff.allow())
+	previousState = backoff.onSuccess()
+	assert.Equal(t, circuitHalfOpen, previousState)
+	assert.Equal(t, circuitClosed, backoff.State())
+}
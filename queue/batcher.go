@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync"
+	"time"
+)
+
+type batchRequest struct {
+	message *sqs.Message
+	result  chan error
+}
+
+// messageBatcher coalesces single message operations of concurrent callers into
+// batch calls, flushing when the batch is full or the flush interval has elapsed.
+type messageBatcher struct {
+	flushFunc     func(messages []*sqs.Message) []error
+	maxSize       int
+	flushInterval time.Duration
+
+	pending []*batchRequest
+	timer   *time.Timer
+	mu      *sync.Mutex
+}
+
+func newMessageBatcher(flushFunc func(messages []*sqs.Message) []error, maxSize int, flushInterval time.Duration) *messageBatcher {
+	return &messageBatcher{
+		flushFunc:     flushFunc,
+		maxSize:       maxSize,
+		flushInterval: flushInterval,
+		mu:            &sync.Mutex{},
+	}
+}
+
+// Do adds the message to the next batch and blocks until the batch is flushed.
+func (b *messageBatcher) Do(message *sqs.Message) error {
+	request := &batchRequest{
+		message: message,
+		result:  make(chan error, 1),
+	}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, request)
+	if len(b.pending) >= b.maxSize {
+		batch := b.take()
+		b.mu.Unlock()
+		b.execute(batch)
+	} else {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.flushInterval, b.flush)
+		}
+		b.mu.Unlock()
+	}
+
+	return <-request.result
+}
+
+func (b *messageBatcher) flush() {
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+
+	b.execute(batch)
+}
+
+// take must be called while holding the lock.
+func (b *messageBatcher) take() []*batchRequest {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	batch := b.pending
+	b.pending = nil
+	return batch
+}
+
+func (b *messageBatcher) execute(batch []*batchRequest) {
+	if len(batch) == 0 {
+		return
+	}
+
+	messages := make([]*sqs.Message, len(batch))
+	for i, request := range batch {
+		messages[i] = request.message
+	}
+
+	errs := b.flushFunc(messages)
+	for i, request := range batch {
+		request.result <- errs[i]
+	}
+}
+
+// batchingSQSProvider coalesces deletes and visibility changes of concurrent jobs into batch calls.
+type batchingSQSProvider struct {
+	SQSProvider
+
+	flushInterval      time.Duration
+	deleteBatcher      *messageBatcher
+	visibilityBatchers map[int64]*messageBatcher
+	visibilityMu       *sync.Mutex
+}
+
+func newBatchingSQSProvider(provider SQSProvider, flushInterval time.Duration) SQSProvider {
+	return &batchingSQSProvider{
+		SQSProvider:        provider,
+		flushInterval:      flushInterval,
+		deleteBatcher:      newMessageBatcher(provider.DeleteMessageBatch, maxBatchSize, flushInterval),
+		visibilityBatchers: make(map[int64]*messageBatcher),
+		visibilityMu:       &sync.Mutex{},
+	}
+}
+
+func (bp *batchingSQSProvider) DeleteMessage(message *sqs.Message) error {
+	return bp.deleteBatcher.Do(message)
+}
+
+func (bp *batchingSQSProvider) ChangeMessageVisibility(message *sqs.Message, visibilityTimeout int64) error {
+	bp.visibilityMu.Lock()
+	batcher, ok := bp.visibilityBatchers[visibilityTimeout]
+	if !ok {
+		batcher = newMessageBatcher(func(messages []*sqs.Message) []error {
+			return bp.SQSProvider.ChangeMessageVisibilityBatch(messages, visibilityTimeout)
+		}, maxBatchSize, bp.flushInterval)
+		bp.visibilityBatchers[visibilityTimeout] = batcher
+	}
+	bp.visibilityMu.Unlock()
+
+	return batcher.Do(message)
+}
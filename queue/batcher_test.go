@@ -0,0 +1,154 @@
+package queue
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatcherFlushesWhenFull(t *testing.T) {
+
+	var flushCount int32
+	batcher := newMessageBatcher(func(messages []*sqs.Message) []error {
+		atomic.AddInt32(&flushCount, 1)
+		assert.Equal(t, maxBatchSize, len(messages))
+		return make([]error, len(messages))
+	}, maxBatchSize, time.Hour)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < maxBatchSize; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, batcher.Do(&sqs.Message{}))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), flushCount)
+}
+
+func TestBatcherFlushesAfterInterval(t *testing.T) {
+
+	batcher := newMessageBatcher(func(messages []*sqs.Message) []error {
+		assert.Equal(t, 1, len(messages))
+		return make([]error, len(messages))
+	}, maxBatchSize, 10*time.Millisecond)
+
+	start := time.Now()
+	err := batcher.Do(&sqs.Message{})
+
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) >= 10*time.Millisecond)
+}
+
+func TestBatcherReturnsErrorPerEntry(t *testing.T) {
+
+	batcher := newMessageBatcher(func(messages []*sqs.Message) []error {
+		errs := make([]error, len(messages))
+		for i, message := range messages {
+			if *message.MessageId == "failing" {
+				errs[i] = errors.New("Entry Error")
+			}
+		}
+		return errs
+	}, 2, time.Hour)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		assert.EqualError(t, batcher.Do(&sqs.Message{MessageId: aws.String("failing")}), "Entry Error")
+	}()
+	go func() {
+		defer wg.Done()
+		assert.Nil(t, batcher.Do(&sqs.Message{MessageId: aws.String("succeeding")}))
+	}()
+	wg.Wait()
+}
+
+func TestBatchingProviderDelegatesToBatchCalls(t *testing.T) {
+
+	mockProvider := NewMockQueueProvider().(*MockSQSProvider)
+
+	var deletedCount, releasedCount int32
+	mockProvider.DeleteMessageBatchFunc = func(messages []*sqs.Message) []error {
+		atomic.AddInt32(&deletedCount, int32(len(messages)))
+		return make([]error, len(messages))
+	}
+	mockProvider.ChangeMessageVisibilityBatchFunc = func(messages []*sqs.Message, visibilityTimeout int64) []error {
+		assert.Equal(t, int64(0), visibilityTimeout)
+		atomic.AddInt32(&releasedCount, int32(len(messages)))
+		return make([]error, len(messages))
+	}
+
+	provider := newBatchingSQSProvider(mockProvider, time.Millisecond)
+
+	assert.Nil(t, provider.DeleteMessage(&sqs.Message{}))
+	assert.Nil(t, provider.ChangeMessageVisibility(&sqs.Message{}, 0))
+	assert.Equal(t, mockQueueProperties1, provider.Properties())
+
+	assert.Equal(t, int32(1), deletedCount)
+	assert.Equal(t, int32(1), releasedCount)
+}
+
+// fakeLatencySqsClient simulates the round trip time of each SQS call.
+type fakeLatencySqsClient struct {
+	mockSqsClient
+	latency   time.Duration
+	callCount int32
+}
+
+func (c *fakeLatencySqsClient) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	atomic.AddInt32(&c.callCount, 1)
+	time.Sleep(c.latency)
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (c *fakeLatencySqsClient) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	atomic.AddInt32(&c.callCount, 1)
+	time.Sleep(c.latency)
+	return &sqs.DeleteMessageBatchOutput{}, nil
+}
+
+func benchmarkDelete(b *testing.B, batching bool) {
+
+	client := &fakeLatencySqsClient{latency: 5 * time.Millisecond}
+	var provider SQSProvider = &sqsProvider{
+		queueProperties: mockQueueProperties1,
+		refreshClientMu: &sync.RWMutex{},
+		expirationMu:    &sync.RWMutex{},
+		client:          client,
+	}
+	if batching {
+		provider = newBatchingSQSProvider(provider, time.Millisecond)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		wg := &sync.WaitGroup{}
+		for i := 0; i < maxNumberOfMessages; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				provider.DeleteMessage(&sqs.Message{ReceiptHandle: aws.String(strconv.Itoa(i))})
+			}(i)
+		}
+		wg.Wait()
+	}
+	b.ReportMetric(float64(atomic.LoadInt32(&client.callCount))/float64(b.N), "calls/op")
+}
+
+func BenchmarkDeleteMessageOneByOne(b *testing.B) {
+	benchmarkDelete(b, false)
+}
+
+func BenchmarkDeleteMessageBatching(b *testing.B) {
+	benchmarkDelete(b, true)
+}
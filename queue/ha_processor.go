@@ -0,0 +1,356 @@
+package queue
+
+import (
+	"context"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/deadletter"
+	"github.com/opsgenie/oec/git"
+	"github.com/opsgenie/oec/lease"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"sync"
+	"time"
+)
+
+type leaseElector interface {
+	Start() error
+	Stop() error
+	Resign()
+	Status() lease.Status
+	Changes() <-chan struct{}
+}
+
+// haProcessor runs the queue processors only while the instance holds the lease. Git repositories are
+// downloaded and pulled all along, so that a standby instance can take over without cloning them.
+type haProcessor struct {
+	elector      leaseElector
+	newProcessor func(fencingToken uint64) Processor
+	processor    Processor // processor of the current term of leadership, changed only by the lead loop
+	fencingToken uint64
+	processorMu  *sync.RWMutex
+
+	// configurations of the last reload, which a processor started meanwhile is reloaded with
+	configurations []*conf.Configuration
+	reloads        uint64
+
+	repositories git.Repositories
+	gitActions   []git.Options
+	drainTimeout time.Duration
+
+	// shared by the processors of all terms, since they write to the same files
+	actionLoggers   map[string]io.Writer
+	deadLetterStore deadletter.Store
+
+	// state served by Status
+	isStarted          bool
+	activeRepositories git.Repositories
+	statusMu           *sync.RWMutex
+
+	isRunning   bool
+	isRunningWg *sync.WaitGroup
+	startStopMu *sync.Mutex
+	quit        chan struct{}
+}
+
+// NewHighAvailabilityProcessor returns a processor which polls the queues of the configurations only while
+// the elector holds the lease. The processors are stopped as soon as the lease is lost.
+func NewHighAvailabilityProcessor(elector *lease.Elector, configurations []*conf.Configuration) Processor {
+
+	repositories := git.NewRepositories()
+	actionLoggers := newIntegrationsActionLoggers(configurations)
+	deadLetterStore := newDeadLetterStore(configurations[0])
+
+	return &haProcessor{
+		elector:         elector,
+		newProcessor:    newProcessorsFunc(configurations, repositories, actionLoggers, deadLetterStore, false),
+		repositories:    repositories,
+		gitActions:      gitActions(configurations),
+		drainTimeout:    configurations[0].ShutdownConf.DrainTimeout(),
+		actionLoggers:   actionLoggers,
+		deadLetterStore: deadLetterStore,
+		processorMu:     &sync.RWMutex{},
+		statusMu:        &sync.RWMutex{},
+		isRunning:       false,
+		isRunningWg:     &sync.WaitGroup{},
+		startStopMu:     &sync.Mutex{},
+		quit:            make(chan struct{}),
+	}
+}
+
+func (hp *haProcessor) Start(ctx context.Context) error {
+	defer hp.startStopMu.Unlock()
+	hp.startStopMu.Lock()
+
+	if hp.isRunning {
+		return errors.New("Queue processor is already running.")
+	}
+
+	logrus.Infof("Queue processor is starting as a standby, queues will be polled once the lease is acquired.")
+
+	err := hp.repositories.DownloadAll(hp.gitActions)
+	if err != nil {
+		logrus.Errorf("Queue processor could not clone a git repository and will terminate.")
+		hp.repositories.RemoveAll()
+		return err
+	}
+
+	err = hp.elector.Start()
+	if err != nil {
+		hp.repositories.RemoveAll()
+		return err
+	}
+
+	if hp.repositories.NotEmpty() {
+		hp.isRunningWg.Add(1)
+		go hp.startPullingRepositories(repositoryRefreshPeriod)
+	}
+
+	hp.isRunningWg.Add(1)
+	go hp.lead(ctx)
+
+	hp.statusMu.Lock()
+	hp.isStarted = true
+	hp.activeRepositories = copyRepositories(hp.repositories)
+	hp.statusMu.Unlock()
+
+	hp.isRunning = true
+	return nil
+}
+
+// Stop stops the processor of the leader within the given context and releases the lease after that,
+// so that the standby does not poll while the running actions are being drained.
+func (hp *haProcessor) Stop(ctx context.Context) error {
+	defer hp.startStopMu.Unlock()
+	hp.startStopMu.Lock()
+
+	if !hp.isRunning {
+		return errors.New("Queue processor is not running.")
+	}
+
+	close(hp.quit)
+	hp.isRunningWg.Wait()
+
+	if processor := hp.currentProcessor(); processor != nil {
+		err := processor.Stop(ctx)
+		if err != nil {
+			logrus.Warnf("Queue processor of the leader could not be stopped: %s", err)
+		}
+		hp.setProcessor(nil, 0)
+	}
+
+	err := hp.elector.Stop()
+	if err != nil {
+		logrus.Warnf("Lease elector could not be stopped: %s", err)
+	}
+
+	hp.repositories.RemoveAll()
+
+	hp.statusMu.Lock()
+	hp.isStarted = false
+	hp.activeRepositories = nil
+	hp.statusMu.Unlock()
+
+	hp.isRunning = false
+	logrus.Infof("Queue processor has stopped.")
+	return nil
+}
+
+func (hp *haProcessor) lead(ctx context.Context) {
+	defer hp.isRunningWg.Done()
+
+	for {
+		select {
+		case <-hp.quit:
+			return
+		case <-hp.elector.Changes():
+		}
+
+		status := hp.elector.Status()
+		if status.IsLeader {
+			hp.promote(ctx, status.FencingToken)
+		} else {
+			hp.demote()
+		}
+	}
+}
+
+func (hp *haProcessor) promote(ctx context.Context, fencingToken uint64) {
+
+	if hp.processor != nil {
+		if hp.fencingToken == fencingToken {
+			return
+		}
+		hp.demote()
+	}
+
+	logrus.Infof("Instance is the leader with fencing token[%d], queue processor is starting.", fencingToken)
+
+	hp.processorMu.RLock()
+	processor := hp.newProcessor(fencingToken)
+	reloads := hp.reloads
+	hp.processorMu.RUnlock()
+
+	err := processor.Start(ctx)
+	if err != nil {
+		logrus.Errorf("Queue processor of the leader could not be started, the lease will be given up: %s", err)
+		hp.elector.Resign()
+		return
+	}
+
+	hp.processorMu.Lock()
+	defer hp.processorMu.Unlock()
+
+	if hp.reloads != reloads {
+		err := processor.Reload(hp.configurations)
+		if err != nil {
+			logrus.Errorf("Queue processor of the leader could not be reloaded with the configurations reloaded while it was starting: %s", err)
+		}
+	}
+	hp.processor = processor
+	hp.fencingToken = fencingToken
+}
+
+func (hp *haProcessor) demote() {
+
+	if hp.processor == nil {
+		return
+	}
+
+	logrus.Warnf("Instance is not the leader anymore, queue processor with fencing token[%d] is stopping.", hp.fencingToken)
+
+	ctx, cancel := context.WithTimeout(context.Background(), hp.drainTimeout)
+	defer cancel()
+
+	err := hp.processor.Stop(ctx)
+	if err != nil {
+		logrus.Warnf("Queue processor of the former leader could not be stopped: %s", err)
+	}
+	hp.setProcessor(nil, 0)
+}
+
+// Status returns the lease state and the state of the processor, if the instance is the leader.
+// A standby instance is running as long as it competes for the lease.
+func (hp *haProcessor) Status() Status {
+	status := Status{
+		Integrations: []IntegrationStatus{},
+	}
+	if processor := hp.currentProcessor(); processor != nil {
+		status.Integrations = processor.Status().Integrations
+	}
+
+	leaseStatus := hp.elector.Status()
+	status.Lease = &leaseStatus
+
+	hp.statusMu.RLock()
+	defer hp.statusMu.RUnlock()
+	status.IsRunning = hp.isStarted
+	status.Repositories = hp.activeRepositories.Status()
+	return status
+}
+
+func (hp *haProcessor) PausePollers(integration, queueUrl string) int {
+	if processor := hp.currentProcessor(); processor != nil {
+		return processor.PausePollers(integration, queueUrl)
+	}
+	return 0
+}
+
+func (hp *haProcessor) ResumePollers(integration, queueUrl string) int {
+	if processor := hp.currentProcessor(); processor != nil {
+		return processor.ResumePollers(integration, queueUrl)
+	}
+	return 0
+}
+
+// PullRepositories pulls the repositories at once, also while the instance is a standby.
+func (hp *haProcessor) PullRepositories() []git.RepositoryStatus {
+	hp.statusMu.RLock()
+	repositories := hp.activeRepositories
+	hp.statusMu.RUnlock()
+
+	repositories.PullAll()
+	return repositories.Status()
+}
+
+func (hp *haProcessor) Jobs() []JobStatus {
+	if processor := hp.currentProcessor(); processor != nil {
+		return processor.Jobs()
+	}
+	return []JobStatus{}
+}
+
+func (hp *haProcessor) CancelJob(id string) bool {
+	if processor := hp.currentProcessor(); processor != nil {
+		return processor.CancelJob(id)
+	}
+	return false
+}
+
+// Reload reloads the processor of the leader, and the processors of the next terms of leadership are created
+// with the given configurations. They share the action loggers and the dead letter store of the first ones.
+func (hp *haProcessor) Reload(configurations []*conf.Configuration) error {
+
+	for _, options := range gitActions(configurations) {
+		if _, err := hp.repositories.Get(options.Url); err != nil {
+			return errors.Errorf("Git repository[%s] is not cloned, OEC should be restarted to use it.", options.Url)
+		}
+	}
+
+	for _, configuration := range configurations {
+		for name, action := range configuration.ActionMappings {
+			for _, logFile := range []string{action.Stdout, action.Stderr} {
+				if _, ok := hp.actionLoggers[logFile]; logFile != "" && !ok {
+					return errors.Errorf("Log file[%s] of action[%s] is not open, OEC should be restarted to use it.", logFile, name)
+				}
+			}
+		}
+	}
+
+	hp.processorMu.Lock()
+	defer hp.processorMu.Unlock()
+
+	if hp.processor != nil {
+		err := hp.processor.Reload(configurations)
+		if err != nil {
+			return err
+		}
+	}
+
+	hp.newProcessor = newProcessorsFunc(configurations, hp.repositories, hp.actionLoggers, hp.deadLetterStore, false)
+	hp.configurations = configurations
+	hp.reloads++
+	return nil
+}
+
+func (hp *haProcessor) currentProcessor() Processor {
+	hp.processorMu.RLock()
+	defer hp.processorMu.RUnlock()
+	return hp.processor
+}
+
+func (hp *haProcessor) setProcessor(processor Processor, fencingToken uint64) {
+	hp.processorMu.Lock()
+	defer hp.processorMu.Unlock()
+	hp.processor = processor
+	hp.fencingToken = fencingToken
+}
+
+func (hp *haProcessor) startPullingRepositories(pullPeriod time.Duration) {
+	defer hp.isRunningWg.Done()
+
+	logrus.Infof("Repositories will be updated in every %s.", pullPeriod.String())
+
+	ticker := time.NewTicker(pullPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hp.quit:
+			logrus.Info("All git repositories will be removed.")
+			return
+		case <-ticker.C:
+			hp.repositories.PullAll()
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/git"
+	"github.com/opsgenie/oec/lease"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newHaProcessorTest(elector leaseElector, newProcessor func(fencingToken uint64) Processor) *haProcessor {
+	return &haProcessor{
+		elector:      elector,
+		newProcessor: newProcessor,
+		repositories: git.NewRepositories(),
+		gitActions:   []git.Options{},
+		drainTimeout: time.Second,
+		processorMu:  &sync.RWMutex{},
+		statusMu:     &sync.RWMutex{},
+		isRunning:    false,
+		isRunningWg:  &sync.WaitGroup{},
+		startStopMu:  &sync.Mutex{},
+		quit:         make(chan struct{}),
+	}
+}
+
+func TestHighAvailabilityProcessorPollsOnlyWhileLeader(t *testing.T) {
+
+	elector := NewMockElector()
+	var startedToken uint64
+	var numberOfStarts, numberOfStops int32
+	hp := newHaProcessorTest(elector, func(fencingToken uint64) Processor {
+		atomic.StoreUint64(&startedToken, fencingToken)
+		return &MockProcessor{
+			StartFunc: func(ctx context.Context) error {
+				atomic.AddInt32(&numberOfStarts, 1)
+				return nil
+			},
+			StopFunc: func(ctx context.Context) error {
+				atomic.AddInt32(&numberOfStops, 1)
+				return nil
+			},
+		}
+	})
+
+	err := hp.Start(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, elector.isStarted())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&numberOfStarts))
+
+	elector.change(lease.Status{IsLeader: true, FencingToken: 3})
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&numberOfStarts) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, uint64(3), atomic.LoadUint64(&startedToken))
+
+	elector.change(lease.Status{IsLeader: false})
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&numberOfStops) == 1 }, time.Second, time.Millisecond)
+
+	elector.change(lease.Status{IsLeader: true, FencingToken: 5})
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&numberOfStarts) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, uint64(5), atomic.LoadUint64(&startedToken))
+
+	err = hp.Stop(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&numberOfStops))
+	assert.False(t, elector.isStarted())
+
+	err = hp.Stop(context.Background())
+	assert.EqualError(t, err, "Queue processor is not running.")
+}
+
+func TestHighAvailabilityProcessorResignsIfProcessorCannotStart(t *testing.T) {
+
+	elector := NewMockElector()
+	hp := newHaProcessorTest(elector, func(fencingToken uint64) Processor {
+		return &MockProcessor{
+			StartFunc: func(ctx context.Context) error {
+				return errors.New("Test start error")
+			},
+		}
+	})
+
+	err := hp.Start(context.Background())
+	assert.Nil(t, err)
+
+	elector.change(lease.Status{IsLeader: true, FencingToken: 1})
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&elector.numberOfResigns) == 1 }, time.Second, time.Millisecond)
+
+	err = hp.Stop(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestHighAvailabilityProcessorSetsFencingToken(t *testing.T) {
+
+	configuration := *mockConf
+	newProcessor := newProcessorsFunc([]*conf.Configuration{&configuration}, git.NewRepositories(), map[string]io.Writer{}, nil, false)
+
+	qp := newProcessor(7).(*processor)
+	assert.Equal(t, uint64(7), qp.fencingToken)
+	assert.False(t, qp.ownsRepositories)
+}
+
+func TestHighAvailabilityProcessorReloadsProcessorStartedDuringReload(t *testing.T) {
+
+	starting := make(chan struct{})
+	reloaded := make(chan struct{})
+	var reloads int32
+
+	elector := NewMockElector()
+	hp := newHaProcessorTest(elector, func(fencingToken uint64) Processor {
+		return &MockProcessor{
+			StartFunc: func(ctx context.Context) error {
+				close(starting)
+				<-reloaded
+				return nil
+			},
+			ReloadFunc: func(configurations []*conf.Configuration) error {
+				atomic.AddInt32(&reloads, 1)
+				return nil
+			},
+		}
+	})
+
+	err := hp.Start(context.Background())
+	assert.Nil(t, err)
+
+	elector.change(lease.Status{IsLeader: true, FencingToken: 1})
+	<-starting
+
+	configuration := *mockConf
+	err = hp.Reload([]*conf.Configuration{&configuration})
+	assert.Nil(t, err)
+	close(reloaded)
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&reloads) == 1 }, time.Second, time.Millisecond)
+	assert.NotNil(t, hp.currentProcessor())
+
+	err = hp.Stop(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestHighAvailabilityProcessorReloadRequiresOpenLogFiles(t *testing.T) {
+
+	logger := &bytes.Buffer{}
+	hp := newHaProcessorTest(NewMockElector(), nil)
+	hp.actionLoggers = map[string]io.Writer{"/var/log/opsgenie/create.log": logger}
+
+	configuration := *mockConf
+	configuration.ActionMappings = conf.ActionMappings{
+		"Create": conf.MappedAction{Stdout: "/var/log/opsgenie/create.log"},
+	}
+	err := hp.Reload([]*conf.Configuration{&configuration})
+	assert.Nil(t, err)
+
+	qp := hp.newProcessor(1).(*processor)
+	assert.True(t, qp.actionLoggers["/var/log/opsgenie/create.log"] == logger)
+
+	configuration.ActionMappings = conf.ActionMappings{
+		"Close": conf.MappedAction{Stderr: "/var/log/opsgenie/close.log"},
+	}
+	err = hp.Reload([]*conf.Configuration{&configuration})
+	assert.EqualError(t, err, "Log file[/var/log/opsgenie/close.log] of action[Close] is not open, OEC should be restarted to use it.")
+}
+
+// Mock Elector
+type MockElector struct {
+	status          lease.Status
+	started         bool
+	numberOfResigns int32
+	changes         chan struct{}
+	mu              *sync.Mutex
+}
+
+func NewMockElector() *MockElector {
+	return &MockElector{
+		changes: make(chan struct{}, 1),
+		mu:      &sync.Mutex{},
+	}
+}
+
+func (e *MockElector) Start() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.started = true
+	return nil
+}
+
+func (e *MockElector) Stop() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.started = false
+	return nil
+}
+
+func (e *MockElector) Resign() {
+	atomic.AddInt32(&e.numberOfResigns, 1)
+}
+
+func (e *MockElector) Status() lease.Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status
+}
+
+func (e *MockElector) Changes() <-chan struct{} {
+	return e.changes
+}
+
+func (e *MockElector) change(status lease.Status) {
+	e.mu.Lock()
+	e.status = status
+	e.mu.Unlock()
+	e.changes <- struct{}{}
+}
+
+func (e *MockElector) isStarted() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.started
+}
+
+// Mock Processor
+type MockProcessor struct {
+	StartFunc            func(ctx context.Context) error
+	StopFunc             func(ctx context.Context) error
+	StatusFunc           func() Status
+	PausePollersFunc     func(integration, queueUrl string) int
+	ResumePollersFunc    func(integration, queueUrl string) int
+	PullRepositoriesFunc func() []git.RepositoryStatus
+	JobsFunc             func() []JobStatus
+	CancelJobFunc        func(id string) bool
+	ReloadFunc           func(configurations []*conf.Configuration) error
+}
+
+func (m *MockProcessor) Start(ctx context.Context) error {
+	if m.StartFunc != nil {
+		return m.StartFunc(ctx)
+	}
+	return nil
+}
+
+func (m *MockProcessor) Stop(ctx context.Context) error {
+	if m.StopFunc != nil {
+		return m.StopFunc(ctx)
+	}
+	return nil
+}
+
+func (m *MockProcessor) Status() Status {
+	if m.StatusFunc != nil {
+		return m.StatusFunc()
+	}
+	return Status{}
+}
+
+func (m *MockProcessor) PausePollers(integration, queueUrl string) int {
+	if m.PausePollersFunc != nil {
+		return m.PausePollersFunc(integration, queueUrl)
+	}
+	return 0
+}
+
+func (m *MockProcessor) ResumePollers(integration, queueUrl string) int {
+	if m.ResumePollersFunc != nil {
+		return m.ResumePollersFunc(integration, queueUrl)
+	}
+	return 0
+}
+
+func (m *MockProcessor) PullRepositories() []git.RepositoryStatus {
+	if m.PullRepositoriesFunc != nil {
+		return m.PullRepositoriesFunc()
+	}
+	return []git.RepositoryStatus{}
+}
+
+func (m *MockProcessor) Jobs() []JobStatus {
+	if m.JobsFunc != nil {
+		return m.JobsFunc()
+	}
+	return []JobStatus{}
+}
+
+func (m *MockProcessor) CancelJob(id string) bool {
+	if m.CancelJobFunc != nil {
+		return m.CancelJobFunc(id)
+	}
+	return false
+}
+
+func (m *MockProcessor) Reload(configurations []*conf.Configuration) error {
+	if m.ReloadFunc != nil {
+		return m.ReloadFunc(configurations)
+	}
+	return nil
+}
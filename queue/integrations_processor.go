@@ -0,0 +1,324 @@
+package queue
+
+import (
+	"context"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/deadletter"
+	"github.com/opsgenie/oec/git"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// integrationsProcessor runs a processor per integration. Git repositories, action loggers and
+// the dead letter store are shared among them, while each one has its own pollers and worker pool.
+type integrationsProcessor struct {
+	processors   []*processor
+	repositories git.Repositories
+	gitActions   []git.Options
+
+	// repositories are downloaded, pulled and removed by the owner if they are shared with a standby
+	ownsRepositories bool
+
+	retryStartPeriod time.Duration
+
+	activeRepositories git.Repositories // served by Status
+	statusMu           *sync.RWMutex
+
+	isRunning   bool
+	isRunningWg *sync.WaitGroup
+	startStopMu *sync.Mutex
+	quit        chan struct{}
+}
+
+// NewProcessors returns a processor for the given integration configurations. A single configuration
+// is processed exactly like NewProcessor does.
+func NewProcessors(configurations []*conf.Configuration) Processor {
+
+	if len(configurations) == 1 {
+		return NewProcessor(configurations[0])
+	}
+
+	actionLoggers := newIntegrationsActionLoggers(configurations)
+	deadLetterStore := newDeadLetterStore(configurations[0])
+	return newProcessorsFunc(configurations, git.NewRepositories(), actionLoggers, deadLetterStore, true)(0)
+}
+
+// newIntegrationsActionLoggers returns the action loggers shared by the processors of the configurations.
+func newIntegrationsActionLoggers(configurations []*conf.Configuration) map[string]io.Writer {
+
+	mappingsList := make([]conf.ActionMappings, 0, len(configurations))
+	for _, configuration := range configurations {
+		mappingsList = append(mappingsList, configuration.ActionMappings)
+	}
+	return NewActionLoggers(&configurations[0].LogConf, mappingsList...)
+}
+
+// newProcessorsFunc returns a function creating the processors of the configurations, which share the
+// repositories, the action loggers and the dead letter store with the ones created before.
+func newProcessorsFunc(configurations []*conf.Configuration, repositories git.Repositories, actionLoggers map[string]io.Writer,
+	deadLetterStore deadletter.Store, ownsRepositories bool) func(fencingToken uint64) Processor {
+
+	return func(fencingToken uint64) Processor {
+
+		processors := make([]*processor, 0, len(configurations))
+		for _, configuration := range configurations {
+			qp := newProcessor(configuration, repositories, actionLoggers, deadLetterStore, ownsRepositories && len(configurations) == 1)
+			qp.fencingToken = fencingToken
+			processors = append(processors, qp)
+		}
+
+		if len(processors) == 1 {
+			return processors[0]
+		}
+
+		return &integrationsProcessor{
+			processors:       processors,
+			repositories:     repositories,
+			gitActions:       gitActions(configurations),
+			ownsRepositories: ownsRepositories,
+			retryStartPeriod: errorRefreshPeriod,
+			statusMu:         &sync.RWMutex{},
+			isRunning:        false,
+			isRunningWg:      &sync.WaitGroup{},
+			startStopMu:      &sync.Mutex{},
+			quit:             make(chan struct{}),
+		}
+	}
+}
+
+func gitActions(configurations []*conf.Configuration) []git.Options {
+	gitActions := make([]git.Options, 0)
+	for _, configuration := range configurations {
+		gitActions = append(gitActions, configuration.ActionMappings.GitActions()...)
+	}
+	return gitActions
+}
+
+// Start starts the processors of all integrations. An integration which could not be started does not
+// prevent the others, it is retried in the background unless none of the integrations could be started.
+func (ip *integrationsProcessor) Start(ctx context.Context) error {
+	defer ip.startStopMu.Unlock()
+	ip.startStopMu.Lock()
+
+	if ip.isRunning {
+		return errors.New("Queue processor is already running.")
+	}
+
+	logrus.Infof("Queue processors of %d integrations are starting.", len(ip.processors))
+
+	if ip.ownsRepositories {
+		err := ip.repositories.DownloadAll(ip.gitActions)
+		if err != nil {
+			logrus.Errorf("Queue processors could not clone a git repository and will terminate.")
+			ip.repositories.RemoveAll()
+			return err
+		}
+	}
+
+	failedProcessors := make([]*processor, 0)
+	var lastErr error
+	for _, qp := range ip.processors {
+		err := qp.Start(ctx)
+		if err != nil {
+			qp.logger.Errorf("Queue processor of the integration could not be started: %s", err)
+			failedProcessors = append(failedProcessors, qp)
+			lastErr = err
+		}
+	}
+
+	if len(failedProcessors) == len(ip.processors) {
+		if ip.ownsRepositories {
+			ip.repositories.RemoveAll()
+		}
+		return errors.Errorf("None of the integrations could be started, last error: %s", lastErr)
+	}
+
+	if ip.ownsRepositories && ip.repositories.NotEmpty() {
+		ip.isRunningWg.Add(1)
+		go ip.startPullingRepositories(repositoryRefreshPeriod)
+
+		ip.statusMu.Lock()
+		ip.activeRepositories = copyRepositories(ip.repositories)
+		ip.statusMu.Unlock()
+	}
+
+	for _, qp := range failedProcessors {
+		ip.isRunningWg.Add(1)
+		go ip.retryStart(ctx, qp)
+	}
+
+	ip.isRunning = true
+	return nil
+}
+
+func (ip *integrationsProcessor) Stop(ctx context.Context) error {
+	defer ip.startStopMu.Unlock()
+	ip.startStopMu.Lock()
+
+	if !ip.isRunning {
+		return errors.New("Queue processor is not running.")
+	}
+
+	logrus.Infof("Queue processors are stopping.")
+
+	close(ip.quit)
+	ip.isRunningWg.Wait()
+
+	stopWg := &sync.WaitGroup{}
+	for _, qp := range ip.processors {
+		stopWg.Add(1)
+		go func(qp *processor) {
+			defer stopWg.Done()
+			err := qp.Stop(ctx)
+			if err != nil {
+				qp.logger.Debugf("Queue processor of the integration could not be stopped: %s", err)
+			}
+		}(qp)
+	}
+	stopWg.Wait()
+
+	if ip.ownsRepositories {
+		ip.repositories.RemoveAll()
+	}
+
+	ip.statusMu.Lock()
+	ip.activeRepositories = nil
+	ip.statusMu.Unlock()
+
+	ip.isRunning = false
+	logrus.Infof("Queue processors have stopped.")
+	return nil
+}
+
+// Status returns the state of all integrations. It is running if any of them is running.
+func (ip *integrationsProcessor) Status() Status {
+	status := Status{
+		Integrations: make([]IntegrationStatus, 0, len(ip.processors)),
+	}
+	for _, qp := range ip.processors {
+		integrationStatus := qp.integrationStatus()
+		status.IsRunning = status.IsRunning || integrationStatus.IsRunning
+		status.Integrations = append(status.Integrations, integrationStatus)
+	}
+
+	ip.statusMu.RLock()
+	defer ip.statusMu.RUnlock()
+	status.Repositories = ip.activeRepositories.Status()
+	return status
+}
+
+func (ip *integrationsProcessor) PausePollers(integration, queueUrl string) int {
+	count := 0
+	for _, qp := range ip.processors {
+		count += qp.PausePollers(integration, queueUrl)
+	}
+	return count
+}
+
+func (ip *integrationsProcessor) ResumePollers(integration, queueUrl string) int {
+	count := 0
+	for _, qp := range ip.processors {
+		count += qp.ResumePollers(integration, queueUrl)
+	}
+	return count
+}
+
+// PullRepositories pulls the repositories at once if the processor owns them, and returns their status.
+func (ip *integrationsProcessor) PullRepositories() []git.RepositoryStatus {
+	ip.statusMu.RLock()
+	repositories := ip.activeRepositories
+	ip.statusMu.RUnlock()
+
+	repositories.PullAll()
+	return repositories.Status()
+}
+
+func (ip *integrationsProcessor) Jobs() []JobStatus {
+	jobs := make([]JobStatus, 0)
+	for _, qp := range ip.processors {
+		jobs = append(jobs, qp.Jobs()...)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.Before(jobs[j].StartedAt)
+	})
+	return jobs
+}
+
+func (ip *integrationsProcessor) CancelJob(id string) bool {
+	for _, qp := range ip.processors {
+		if qp.CancelJob(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload replaces the action specifications of all integrations, or none of them if any could not be reloaded.
+func (ip *integrationsProcessor) Reload(configurations []*conf.Configuration) error {
+
+	if len(configurations) != len(ip.processors) {
+		return errors.Errorf("Configuration has %d integrations instead of %d, OEC should be restarted to run them.",
+			len(configurations), len(ip.processors))
+	}
+
+	actionSpecsList := make([]conf.ActionSpecifications, 0, len(ip.processors))
+	for _, qp := range ip.processors {
+		actionSpecs, err := qp.reloadableActionSpecs(configurations)
+		if err != nil {
+			return err
+		}
+		actionSpecsList = append(actionSpecsList, actionSpecs)
+	}
+
+	for i, qp := range ip.processors {
+		qp.actionSpecs.set(actionSpecsList[i])
+		qp.logger.Infof("Action mappings are reloaded.")
+	}
+	return nil
+}
+
+func (ip *integrationsProcessor) retryStart(ctx context.Context, qp *processor) {
+	defer ip.isRunningWg.Done()
+
+	qp.logger.Infof("Queue processor of the integration will be retried to start in every %s.", ip.retryStartPeriod.String())
+
+	ticker := time.NewTicker(ip.retryStartPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ip.quit:
+			return
+		case <-ticker.C:
+			err := qp.Start(ctx)
+			if err != nil {
+				qp.logger.Warnf("Queue processor of the integration could not be started: %s", err)
+				continue
+			}
+			return
+		}
+	}
+}
+
+func (ip *integrationsProcessor) startPullingRepositories(pullPeriod time.Duration) {
+	defer ip.isRunningWg.Done()
+
+	logrus.Infof("Repositories will be updated in every %s.", pullPeriod.String())
+
+	ticker := time.NewTicker(pullPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ip.quit:
+			logrus.Info("All git repositories will be removed.")
+			return
+		case <-ticker.C:
+			ip.repositories.PullAll()
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"context"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/retryer"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newIntegrationsProcessorTest(names ...string) *integrationsProcessor {
+
+	configurations := make([]*conf.Configuration, 0, len(names))
+	for _, name := range names {
+		configuration := *mockConf
+		configuration.IntegrationName = name
+		configurations = append(configurations, &configuration)
+	}
+
+	integrationsProcessor := NewProcessors(configurations).(*integrationsProcessor)
+	for _, qp := range integrationsProcessor.processors {
+		qp.tokenCache = nil
+	}
+	return integrationsProcessor
+}
+
+func TestStartIntegrationsProcessorRetriesFailedIntegration(t *testing.T) {
+
+	defer func() {
+		newPollerFunc = NewPoller
+	}()
+	newPollerFunc = NewMockPollerForQueueProcessor
+
+	integrationsProcessor := newIntegrationsProcessorTest("first", "second")
+	integrationsProcessor.retryStartPeriod = 10 * time.Millisecond
+
+	first, second := integrationsProcessor.processors[0], integrationsProcessor.processors[1]
+	assert.Equal(t, "first", first.logger.Data["integration"])
+	assert.Equal(t, "second", second.logger.Data["integration"])
+	assert.False(t, first.ownsRepositories)
+
+	var isSecondReachable int32
+	first.retryer.DoFunc = mockHttpGet
+	second.retryer.DoFunc = func(retryer *retryer.Retryer, request *retryer.Request) (*http.Response, error) {
+		if atomic.LoadInt32(&isSecondReachable) == 0 {
+			return mockHttpGetError(retryer, request)
+		}
+		return mockHttpGet(retryer, request)
+	}
+
+	err := integrationsProcessor.Start(context.Background())
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, len(first.pollers))
+	assert.False(t, isProcessorRunning(second))
+
+	atomic.StoreInt32(&isSecondReachable, 1)
+	assert.Eventually(t, func() bool {
+		return isProcessorRunning(second)
+	}, time.Second, 10*time.Millisecond)
+
+	err = integrationsProcessor.Stop(context.Background())
+	assert.Nil(t, err)
+	assert.False(t, isProcessorRunning(first))
+	assert.False(t, isProcessorRunning(second))
+}
+
+func TestStartIntegrationsProcessorWithoutAnyIntegration(t *testing.T) {
+
+	defer func() {
+		newPollerFunc = NewPoller
+	}()
+	newPollerFunc = NewMockPollerForQueueProcessor
+
+	integrationsProcessor := newIntegrationsProcessorTest("first", "second")
+	for _, qp := range integrationsProcessor.processors {
+		qp.retryer.DoFunc = mockHttpGetError
+	}
+
+	err := integrationsProcessor.Start(context.Background())
+	assert.EqualError(t, err, "None of the integrations could be started, last error: Test http error has occurred while getting token.")
+
+	err = integrationsProcessor.Stop(context.Background())
+	assert.EqualError(t, err, "Queue processor is not running.")
+}
+
+func TestNewProcessorsWithSingleIntegration(t *testing.T) {
+
+	configuration := *mockConf
+	queueProcessor := NewProcessors([]*conf.Configuration{&configuration})
+
+	_, isSingle := queueProcessor.(*processor)
+	assert.True(t, isSingle)
+}
+
+func isProcessorRunning(qp *processor) bool {
+	qp.startStopMu.Lock()
+	defer qp.startStopMu.Unlock()
+	return qp.isRunning
+}
@@ -1,12 +1,18 @@
 package queue
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/opsgenie/oec/redaction"
 	"github.com/opsgenie/oec/runbook"
+	"github.com/opsgenie/oec/tracing"
+	"github.com/opsgenie/oec/worker_pool"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"sync"
-	"time"
 )
 
 const (
@@ -14,43 +20,74 @@ const (
 	jobExecuting
 	jobFinished
 	jobError
+	jobReleased
 )
 
 type job struct {
 	queueProvider  SQSProvider
 	messageHandler MessageHandler
+	resultSender   ResultSender
 
-	message sqs.Message
-	ownerId string
-	apiKey  string
-	baseUrl string
+	message     sqs.Message
+	payload     payload
+	priority    int
+	ownerId     string
+	integration string
 
 	state        int32
+	deleted      bool
 	executeMutex *sync.Mutex
+	logger       *logrus.Entry
+
+	receiveSpan *tracing.Span
+	submitSpan  *tracing.Span
 }
 
-func newJob(queueProvider SQSProvider, messageHandler MessageHandler, message sqs.Message, apiKey, baseUrl, ownerId string) *job {
-	return &job{
+func newJob(queueProvider SQSProvider, messageHandler MessageHandler, resultSender ResultSender, message sqs.Message, ownerId, integration string, logger *logrus.Entry) *job {
+	j := &job{
 		queueProvider:  queueProvider,
 		messageHandler: messageHandler,
+		resultSender:   resultSender,
 		message:        message,
 		ownerId:        ownerId,
-		apiKey:         apiKey,
-		baseUrl:        baseUrl,
+		integration:    integration,
 		state:          jobInitial,
 		executeMutex:   &sync.Mutex{},
+		logger:         logger,
+	}
+
+	// the payload is decoded once to schedule the job, an invalid one fails when the message is handled
+	json.Unmarshal([]byte(aws.StringValue(message.Body)), &j.payload)
+	j.priority = j.payloadPriority()
+	return j
+}
+
+// payloadPriority returns the priority of the mapped action if it has one, otherwise the priority of the alert.
+// The messages without any have the default priority.
+func (j *job) payloadPriority() int {
+	if priority, ok := j.messageHandler.ActionPriority(j.payload.actionName()); ok {
+		return priority
+	}
+	if priority, ok := parsePriority(j.payload.Alert.Priority); ok {
+		return priority
 	}
+	return worker_pool.DefaultPriority
 }
 
 func (j *job) Id() string {
 	return *j.message.MessageId
 }
 
+// Priority returns the priority of the alert or the mapped action of the message.
+func (j *job) Priority() int {
+	return j.priority
+}
+
 func (j *job) sqsMessage() sqs.Message {
 	return j.message
 }
 
-func (j *job) Execute() error {
+func (j *job) Execute(ctx context.Context) (err error) {
 
 	defer j.executeMutex.Unlock()
 	j.executeMutex.Lock()
@@ -59,44 +96,108 @@ func (j *job) Execute() error {
 		return errors.Errorf("Job[%s] is already executing or finished.", j.Id())
 	}
 	j.state = jobExecuting
+	j.submitSpan.End()
+
+	ctx, span := tracing.Start(tracing.ContextWithSpan(ctx, j.receiveSpan), "execute", tracing.Internal)
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = j.recover(ctx, recovered)
+		}
+		span.SetError(err)
+		span.End()
+	}()
+	return j.execute(ctx)
+}
+
+// recover fails the job which has panicked. A failure result is sent to Opsgenie if the message has been deleted
+// from the queue and its payload has the action, since it will not be processed again.
+func (j *job) recover(ctx context.Context, recovered interface{}) error {
+	j.state = jobError
+	panicErr := worker_pool.NewPanicError(j.Id(), recovered)
+
+	if !j.deleted {
+		return panicErr
+	}
+
+	queuePayload := j.payload
+	action := queuePayload.actionName()
+	if action == "" {
+		return panicErr
+	}
+
+	// the job may have panicked since it is cancelled, the result should be sent anyway
+	j.resultSender.Send(context.WithoutCancel(ctx), j.Id(), &runbook.ActionResultPayload{
+		RequestId:      queuePayload.RequestId,
+		EntityId:       queuePayload.Entity.Id,
+		EntityType:     queuePayload.Entity.Type,
+		Action:         action,
+		ActionType:     queuePayload.ActionType,
+		FailureMessage: redaction.String(fmt.Sprintf("Action[%s] could not be processed, OEC has panicked: %v", action, recovered)),
+	})
+	return panicErr
+}
+
+func (j *job) execute(ctx context.Context) error {
 
 	region := j.queueProvider.Properties().Region()
 	messageId := j.Id()
 
+	if ctx.Err() != nil {
+		j.state = jobError
+		return errors.Errorf("Message[%s] will not be processed, job is cancelled before it has started: %s", messageId, ctx.Err())
+	}
+
 	err := j.queueProvider.DeleteMessage(&j.message)
 	if err != nil {
 		j.state = jobError
 		return errors.Errorf("Message[%s] could not be deleted from the queue[%s]: %s", messageId, region, err)
 	}
 
-	logrus.Debugf("Message[%s] is deleted from the queue[%s].", messageId, region)
-
-	messageAttr := j.sqsMessage().MessageAttributes
+	j.deleted = true
+	j.logger.Debugf("Message[%s] is deleted from the queue[%s].", messageId, region)
+	messagesDeleted.WithLabelValues(j.integration, region).Inc()
 
-	if messageAttr == nil ||
-		*messageAttr[ownerId].StringValue != j.ownerId {
+	ownerAttr, ok := j.sqsMessage().MessageAttributes[ownerId]
+	if !ok || ownerAttr == nil || aws.StringValue(ownerAttr.StringValue) != j.ownerId {
 		j.state = jobError
 		return errors.Errorf("Message[%s] is invalid, will not be processed.", messageId)
 	}
 
-	result, err := j.messageHandler.Handle(j.message)
+	result, err := j.messageHandler.Handle(ctx, j.message)
 	if err != nil {
 		j.state = jobError
 		return errors.Errorf("Message[%s] could not be processed: %s", messageId, err)
 	}
 
-	go func() {
-		start := time.Now()
-
-		err = runbook.SendResultToOpsGenieFunc(result, j.apiKey, j.baseUrl)
-		if err != nil {
-			logrus.Warnf("Could not send action result[%+v] of message[%s] to Opsgenie: %s", result, messageId, err)
-		} else {
-			took := time.Since(start)
-			logrus.Debugf("Successfully sent result of message[%s] to OpsGenie and it took %f seconds.", messageId, took.Seconds())
-		}
-	}()
+	j.resultSender.Send(ctx, messageId, result)
 
 	j.state = jobFinished
 	return nil
 }
+
+// Release makes the message visible in the queue again, so that it can be processed by another instance.
+// It is called instead of Execute, if the job could not be started before the worker pool has stopped.
+func (j *job) Release() error {
+
+	defer j.executeMutex.Unlock()
+	j.executeMutex.Lock()
+
+	if j.state != jobInitial {
+		return errors.Errorf("Job[%s] is already executing or finished.", j.Id())
+	}
+	j.state = jobReleased
+	j.submitSpan.SetError(errors.New("Message is released to the queue before it has started."))
+	j.submitSpan.End()
+
+	region := j.queueProvider.Properties().Region()
+	messageId := j.Id()
+
+	err := j.queueProvider.ChangeMessageVisibility(&j.message, 0)
+	if err != nil {
+		return errors.Errorf("Visibility of message[%s] could not be terminated in the queue[%s]: %s", messageId, region, err)
+	}
+
+	messagesReleased.WithLabelValues(j.integration, region).Inc()
+	j.logger.Debugf("Message[%s] is released to the queue[%s], since it could not be started before shutdown.", messageId, region)
+	return nil
+}
@@ -1,9 +1,11 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/opsgenie/oec/runbook"
+	"github.com/opsgenie/oec/worker_pool"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
@@ -33,12 +35,12 @@ func newJobTest() *job {
 	return &job{
 		queueProvider:  NewMockQueueProvider(),
 		messageHandler: mockMessageHandler,
+		resultSender:   newAsyncResultSender("", mockApiKey, mockBaseUrl, newIntegrationLogger("")),
 		message:        message,
 		executeMutex:   &sync.Mutex{},
-		apiKey:         mockApiKey,
-		baseUrl:        mockBaseUrl,
 		ownerId:        mockOwnerId,
 		state:          jobInitial,
+		logger:         newIntegrationLogger(""),
 	}
 }
 
@@ -59,10 +61,10 @@ func TestExecute(t *testing.T) {
 	defer testServer.Close()
 
 	sqsJob := newJobTest()
-	sqsJob.baseUrl = testServer.URL
+	sqsJob.resultSender = newAsyncResultSender("", mockApiKey, testServer.URL, newIntegrationLogger(""))
 
 	wg.Add(1)
-	err := sqsJob.Execute()
+	err := sqsJob.Execute(context.Background())
 
 	wg.Wait()
 	assert.Nil(t, err)
@@ -83,7 +85,7 @@ func TestMultipleExecute(t *testing.T) {
 	defer testServer.Close()
 
 	sqsJob := newJobTest()
-	sqsJob.baseUrl = testServer.URL
+	sqsJob.resultSender = newAsyncResultSender("", mockApiKey, testServer.URL, newIntegrationLogger(""))
 
 	errorResults := make(chan error, 25)
 
@@ -91,9 +93,9 @@ func TestMultipleExecute(t *testing.T) {
 	for i := 0; i < 25; i++ {
 		go func() {
 			defer wg.Done()
-			err := sqsJob.Execute()
+			err := sqsJob.Execute(context.Background())
 			if err != nil {
-				errorResults <- sqsJob.Execute()
+				errorResults <- sqsJob.Execute(context.Background())
 			}
 		}()
 	}
@@ -111,7 +113,7 @@ func TestExecuteInNotInitialState(t *testing.T) {
 	sqsJob := newJobTest()
 	sqsJob.state = jobExecuting
 
-	err := sqsJob.Execute()
+	err := sqsJob.Execute(context.Background())
 	assert.NotNil(t, err)
 
 	expectedErr := errors.Errorf("Job[%s] is already executing or finished.", sqsJob.Id())
@@ -126,7 +128,7 @@ func TestExecuteWithProcessError(t *testing.T) {
 		return nil, errors.New("Process Error")
 	}
 
-	err := sqsJob.Execute()
+	err := sqsJob.Execute(context.Background())
 	assert.NotNil(t, err)
 
 	expectedErr := errors.Errorf("Message[%s] could not be processed: %s", sqsJob.Id(), "Process Error")
@@ -146,7 +148,7 @@ func TestExecuteWithDeleteError(t *testing.T) {
 		return errors.New("Delete Error")
 	}
 
-	err := sqsJob.Execute()
+	err := sqsJob.Execute(context.Background())
 	assert.NotNil(t, err)
 
 	expectedErr := errors.Errorf("Message[%s] could not be deleted from the queue[%s]: %s", sqsJob.Id(), sqsJob.queueProvider.Properties().Region(), "Delete Error")
@@ -158,6 +160,38 @@ func TestExecuteWithDeleteError(t *testing.T) {
 	assert.Equal(t, expectedState, actualState)
 }
 
+func TestRelease(t *testing.T) {
+
+	sqsJob := newJobTest()
+
+	var releasedMessage *sqs.Message
+	sqsJob.queueProvider.(*MockSQSProvider).ChangeMessageVisibilityFunc = func(message *sqs.Message, visibilityTimeout int64) error {
+		assert.Equal(t, int64(0), visibilityTimeout)
+		releasedMessage = message
+		return nil
+	}
+
+	err := sqsJob.Release()
+	assert.Nil(t, err)
+	assert.Equal(t, mockMessageId, *releasedMessage.MessageId)
+	assert.Equal(t, int32(jobReleased), sqsJob.state)
+
+	err = sqsJob.Execute(context.Background())
+	assert.EqualError(t, err, "Job["+mockMessageId+"] is already executing or finished.")
+}
+
+func TestReleaseWithVisibilityError(t *testing.T) {
+
+	sqsJob := newJobTest()
+
+	sqsJob.queueProvider.(*MockSQSProvider).ChangeMessageVisibilityFunc = func(message *sqs.Message, visibilityTimeout int64) error {
+		return errors.New("Test visibility error")
+	}
+
+	err := sqsJob.Release()
+	assert.Contains(t, err.Error(), "Test visibility error")
+}
+
 func TestExecuteWithInvalidQueueMessage(t *testing.T) {
 
 	sqsJob := newJobTest()
@@ -166,7 +200,7 @@ func TestExecuteWithInvalidQueueMessage(t *testing.T) {
 	messageAttr := map[string]*sqs.MessageAttributeValue{ownerId: {StringValue: &falseIntegrationId}}
 	sqsJob.message = sqs.Message{MessageAttributes: messageAttr, MessageId: &mockMessageId}
 
-	err := sqsJob.Execute()
+	err := sqsJob.Execute(context.Background())
 	assert.NotNil(t, err)
 
 	expectedErr := errors.Errorf("Message[%s] is invalid, will not be processed.", sqsJob.Id())
@@ -177,3 +211,125 @@ func TestExecuteWithInvalidQueueMessage(t *testing.T) {
 
 	assert.Equal(t, expectedState, actualState)
 }
+
+func TestExecuteWithoutOwnerIdAttribute(t *testing.T) {
+
+	sqsJob := newJobTest()
+	sqsJob.message.MessageAttributes = map[string]*sqs.MessageAttributeValue{"other": {}}
+
+	err := sqsJob.Execute(context.Background())
+	assert.EqualError(t, err, "Message["+sqsJob.Id()+"] is invalid, will not be processed.")
+	assert.Equal(t, int32(jobError), sqsJob.state)
+
+	sqsJob = newJobTest()
+	sqsJob.message.MessageAttributes = map[string]*sqs.MessageAttributeValue{ownerId: {}}
+
+	err = sqsJob.Execute(context.Background())
+	assert.EqualError(t, err, "Message["+sqsJob.Id()+"] is invalid, will not be processed.")
+}
+
+func TestExecuteWithPanicSendsFailureResult(t *testing.T) {
+	results := make(chan *runbook.ActionResultPayload, 1)
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusAccepted)
+
+		actionResult := &runbook.ActionResultPayload{}
+		body, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(body, actionResult)
+		results <- actionResult
+	}))
+	defer testServer.Close()
+
+	sqsJob := newJobTest()
+	body := `{"requestId":"requestId","entity":{"id":"alertId","type":"alert"},"action":"Restart","actionType":"custom"}`
+	message := sqsJob.message
+	message.Body = &body
+	sqsJob = newJob(sqsJob.queueProvider, sqsJob.messageHandler, newAsyncResultSender("", mockApiKey, testServer.URL, newIntegrationLogger("")),
+		message, mockOwnerId, "", newIntegrationLogger(""))
+	sqsJob.messageHandler.(*MockMessageHandler).HandleFunc = func(message sqs.Message) (*runbook.ActionResultPayload, error) {
+		panic("test panic")
+	}
+
+	err := sqsJob.Execute(context.Background())
+
+	panicErr, ok := err.(*worker_pool.PanicError)
+	assert.True(t, ok)
+	assert.Equal(t, "test panic", panicErr.Recovered)
+	assert.Equal(t, int32(jobError), sqsJob.state)
+
+	result := <-results
+	assert.False(t, result.IsSuccessful)
+	assert.Equal(t, "requestId", result.RequestId)
+	assert.Equal(t, "alertId", result.EntityId)
+	assert.Equal(t, "Restart", result.Action)
+	assert.Equal(t, "Action[Restart] could not be processed, OEC has panicked: test panic", result.FailureMessage)
+}
+
+func TestExecuteWithPanicAfterCancelSendsFailureResult(t *testing.T) {
+
+	var resultCtxErr error
+	resultSent := false
+
+	sqsJob := newJobTest()
+	body := `{"requestId":"requestId","entity":{"id":"alertId","type":"alert"},"action":"Restart","actionType":"custom"}`
+	message := sqsJob.message
+	message.Body = &body
+	resultSender := &MockResultSender{
+		SendFunc: func(ctx context.Context, messageId string, result *runbook.ActionResultPayload) {
+			resultSent = true
+			resultCtxErr = ctx.Err()
+		},
+	}
+	sqsJob = newJob(sqsJob.queueProvider, sqsJob.messageHandler, resultSender, message, mockOwnerId, "", newIntegrationLogger(""))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sqsJob.messageHandler.(*MockMessageHandler).HandleFunc = func(message sqs.Message) (*runbook.ActionResultPayload, error) {
+		cancel()
+		panic("test panic")
+	}
+
+	err := sqsJob.Execute(ctx)
+
+	_, ok := err.(*worker_pool.PanicError)
+	assert.True(t, ok)
+	assert.True(t, resultSent)
+	assert.Nil(t, resultCtxErr)
+}
+
+func TestExecuteWithPanicBeforeDeleteSendsNoResult(t *testing.T) {
+
+	sqsJob := newJobTest()
+	sqsJob.resultSender = nil
+	sqsJob.queueProvider.(*MockSQSProvider).DeleteMessageFunc = func(message *sqs.Message) error {
+		panic("test panic")
+	}
+
+	err := sqsJob.Execute(context.Background())
+
+	_, ok := err.(*worker_pool.PanicError)
+	assert.True(t, ok)
+	assert.Equal(t, int32(jobError), sqsJob.state)
+}
+
+func TestJobPriority(t *testing.T) {
+
+	messageHandler := &MockMessageHandler{
+		ActionPriorityFunc: func(action string) (int, bool) {
+			return 1, action == "Restart"
+		},
+	}
+
+	priority := func(body string) int {
+		var job worker_pool.Job = newJob(NewMockQueueProvider(), messageHandler, nil, sqs.Message{MessageId: &mockMessageId, Body: &body}, mockOwnerId, "", newIntegrationLogger(""))
+		prioritizedJob, ok := job.(worker_pool.PrioritizedJob)
+		assert.True(t, ok)
+		return prioritizedJob.Priority()
+	}
+
+	assert.Equal(t, 1, priority(`{"action":"Restart", "alert": {"priority": "P5"}}`))
+	assert.Equal(t, 1, priority(`{"mappedActionV2": {"name": "Restart"}, "action": "Enrich"}`))
+	assert.Equal(t, 4, priority(`{"action":"Enrich", "alert": {"priority": "P4"}}`))
+	assert.Equal(t, worker_pool.DefaultPriority, priority(`{"action":"Enrich", "alert": {"priority": "Critical"}}`))
+	assert.Equal(t, worker_pool.DefaultPriority, priority(`not json`))
+}
@@ -2,37 +2,90 @@ package queue
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/opsgenie/oec/audit"
 	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/deadletter"
 	"github.com/opsgenie/oec/git"
+	"github.com/opsgenie/oec/history"
+	"github.com/opsgenie/oec/logging"
+	"github.com/opsgenie/oec/redaction"
 	"github.com/opsgenie/oec/runbook"
+	"github.com/opsgenie/oec/tracing"
+	"github.com/opsgenie/oec/worker_pool"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"io"
+	"strconv"
+	"sync"
 	"time"
 )
 
+// FencingTokenEnvName is the environment variable which passes the fencing token of the lease to the actions,
+// so that the systems they change can reject the actions of a former leader.
+const FencingTokenEnvName = "OEC_FENCING_TOKEN"
+
+// payloadPlaceholder replaces the payload in the command lines written to the audit log.
+const payloadPlaceholder = "<payload>"
+
+// ErrCancelledByOperator is the cause of the cancellation of a job which is cancelled through the admin API.
+var ErrCancelledByOperator = errors.New("Job is cancelled by an operator.")
+
 type MessageHandler interface {
-	Handle(message sqs.Message) (*runbook.ActionResultPayload, error)
+	Handle(ctx context.Context, message sqs.Message) (*runbook.ActionResultPayload, error)
+	ActionPriority(action string) (int, bool)
 }
 
 type messageHandler struct {
-	repositories  git.Repositories
-	actionSpecs   conf.ActionSpecifications
-	actionLoggers map[string]io.Writer
+	repositories    git.Repositories
+	actionSpecs     *actionSpecs
+	actionLoggers   map[string]io.Writer
+	deadLetterStore deadletter.Store
+	outputConf      conf.ActionOutputConf
+	captureStore    *captureStore
+	integrationName string
+	fencingToken    uint64
+	logger          *logrus.Entry
 }
 
 func NewMessageHandler(repositories git.Repositories, actionSpecs conf.ActionSpecifications, actionLoggers map[string]io.Writer) MessageHandler {
 	return &messageHandler{
 		repositories:  repositories,
-		actionSpecs:   actionSpecs,
+		actionSpecs:   newActionSpecs(actionSpecs),
 		actionLoggers: actionLoggers,
+		logger:        newIntegrationLogger(""),
+	}
+}
+
+func (mh *messageHandler) Handle(ctx context.Context, message sqs.Message) (*runbook.ActionResultPayload, error) {
+	start := time.Now()
+
+	result, err := mh.handle(ctx, message)
+	if err != nil {
+		mh.deadLetter(message, start, err.Error())
+	} else if !result.IsSuccessful {
+		mh.deadLetter(message, start, result.FailureMessage)
+	}
+
+	return result, err
+}
+
+// ActionPriority returns the priority of the mapped action, it returns false if the action has no priority.
+func (mh *messageHandler) ActionPriority(action string) (int, bool) {
+	mappedAction, ok := mh.actionSpecs.get().ActionMappings[conf.ActionName(action)]
+	if !ok {
+		return 0, false
 	}
+	return parsePriority(mappedAction.Priority)
 }
 
-func (mh *messageHandler) Handle(message sqs.Message) (*runbook.ActionResultPayload, error) {
+func (mh *messageHandler) handle(ctx context.Context, message sqs.Message) (*runbook.ActionResultPayload, error) {
 	queuePayload := payload{}
 	err := json.Unmarshal([]byte(*message.Body), &queuePayload)
 	if err != nil {
@@ -52,7 +105,14 @@ func (mh *messageHandler) Handle(message sqs.Message) (*runbook.ActionResultPayl
 		return nil, errors.Errorf("SQS message with entityId[%s] does not contain action property.", entityId)
 	}
 
-	mappedAction, ok := mh.actionSpecs.ActionMappings[conf.ActionName(action)]
+	span := tracing.SpanFromContext(ctx)
+	span.SetAttribute("oec.request_id", queuePayload.RequestId)
+	span.SetAttribute("oec.entity_id", entityId)
+	span.SetAttribute("oec.action", action)
+	span.SetAttribute("oec.action_type", actionType)
+
+	actionSpecs := mh.actionSpecs.get()
+	mappedAction, ok := actionSpecs.ActionMappings[conf.ActionName(action)]
 	if !ok {
 		return nil, errors.Errorf("There is no mapped action found for action[%s]. SQS message with entityId[%s] will be ignored.", action, entityId)
 	}
@@ -70,39 +130,134 @@ func (mh *messageHandler) Handle(message sqs.Message) (*runbook.ActionResultPayl
 		RequestId:  queuePayload.RequestId,
 	}
 
+	record := &audit.Record{
+		Type:        audit.ExecutionRecord,
+		Integration: mh.integrationName,
+		MessageId:   aws.StringValue(message.MessageId),
+		RequestId:   queuePayload.RequestId,
+		EntityId:    entityId,
+		EntityType:  entityType,
+		Action:      action,
+		ActionType:  actionType,
+		SourceType:  mappedAction.SourceType,
+		RunAsUser:   audit.RunAsUser(),
+	}
+
+	logger := mh.logger.WithFields(logrus.Fields{"requestId": queuePayload.RequestId, "action": action})
+	execution := &execution{record: record}
+
 	start := time.Now()
-	executionResult, err := mh.execute(&mappedAction, *message.Body)
+	executionResult, err := mh.execute(ctx, actionSpecs, &mappedAction, *message.Body, execution)
 	took := time.Since(start)
+	result.CaptureFile = record.CaptureFile
+
+	outcome := actionSucceeded
+	defer func() {
+		actionsExecuted.WithLabelValues(mh.integrationName, action, actionType, outcome).Inc()
+		actionDuration.WithLabelValues(mh.integrationName, action, actionType).Observe(took.Seconds())
+
+		record.IsSuccessful = result.IsSuccessful
+		if record.Error == "" {
+			record.Error = result.FailureMessage
+		}
+		audit.Write(record)
+
+		mh.addHistory(execution, start, took, outcome, result.FailureMessage)
+	}()
 
 	switch err := err.(type) {
 	case *runbook.ExecError:
+		record.ExitCode = &err.ExitCode
+		record.Error = err.Error()
 		result.IsSuccessful = false
-		result.FailureMessage = fmt.Sprintf("Err: %s, Stderr: %s", err.Error(), err.Stderr)
-		logrus.Debugf("Action[%s] execution of message[%s] with entityId[%s] failed: %s Stderr: %s", action, *message.MessageId, entityId, err.Error(), err.Stderr)
+		output := mh.failureOutput(err, execution)
+		result.FailureMessage = fmt.Sprintf("Err: %s, %s", err.Error(), output)
+		outcome = actionFailed
+		if cause := context.Cause(ctx); errors.Is(cause, worker_pool.ErrShutdown) {
+			result.FailureMessage = fmt.Sprintf("Action is cancelled due to shutdown. Err: %s, %s", err.Error(), output)
+			outcome = actionCancelled
+		} else if errors.Is(cause, ErrCancelledByOperator) {
+			result.FailureMessage = fmt.Sprintf("Action is cancelled by an operator. Err: %s, %s", err.Error(), output)
+			outcome = actionCancelled
+		}
+		logger.Debugf("Action[%s] execution of message[%s] with entityId[%s] failed: %s Stderr: %s", action, *message.MessageId, entityId, err.Error(), err.Stderr)
 	case nil:
+		exitCode := 0
+		record.ExitCode = &exitCode
 		result.IsSuccessful = true
 		if !queuePayload.DiscardScriptResponse && queuePayload.ActionType == HttpActionType {
 			httpResult := &runbook.HttpResponse{}
 			err := json.Unmarshal([]byte(executionResult), httpResult)
 			if err != nil {
 				result.IsSuccessful = false
-				logrus.Debugf("Http Action[%s] execution of message[%s] with entityId[%s] failed, could not parse http response fields: %s, error: %s",
+				outcome = actionFailed
+				logger.Debugf("Http Action[%s] execution of message[%s] with entityId[%s] failed, could not parse http response fields: %s, error: %s",
 					action, *message.MessageId, entityId, executionResult, err.Error())
 				result.FailureMessage = "Could not parse http response fields: " + executionResult
 			} else {
 				result.HttpResponse = httpResult
 			}
 		}
-		logrus.Debugf("Action[%s] execution of message[%s] with entityId[%s] has been completed and it took %f seconds.", action, *message.MessageId, entityId, took.Seconds())
+		logger.Debugf("Action[%s] execution of message[%s] with entityId[%s] has been completed and it took %f seconds.", action, *message.MessageId, entityId, took.Seconds())
 
 	default:
+		outcome = actionErrored
+		record.Error = err.Error()
 		return nil, err
 	}
 
+	result.FailureMessage = redaction.String(result.FailureMessage)
 	return result, nil
 }
 
-func (mh *messageHandler) execute(mappedAction *conf.MappedAction, messageBody string) (string, error) {
+func (mh *messageHandler) deadLetter(message sqs.Message, processedAt time.Time, failure string) {
+	if mh.deadLetterStore == nil {
+		return
+	}
+
+	entry := &deadletter.Entry{
+		MessageId:   aws.StringValue(message.MessageId),
+		Integration: mh.integrationName,
+		Body:        aws.StringValue(message.Body),
+		Error:       redaction.String(failure),
+		ProcessedAt: processedAt,
+		FailedAt:    time.Now(),
+	}
+
+	queuePayload := payload{}
+	if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), &queuePayload); err == nil {
+		entry.RequestId = queuePayload.RequestId
+		entry.EntityId = queuePayload.Entity.Id
+		entry.Action = queuePayload.MappedAction.Name
+		if entry.Action == "" {
+			entry.Action = queuePayload.Action
+		}
+		if mappedAction, ok := mh.actionSpecs.get().ActionMappings[conf.ActionName(entry.Action)]; ok {
+			entry.MappedAction = &mappedAction
+		}
+	}
+
+	err := mh.deadLetterStore.Put(entry)
+	if err != nil {
+		mh.logger.Errorf("Message[%s] could not be stored as dead letter: %s", entry.MessageId, err)
+		return
+	}
+	mh.logger.Debugf("Message[%s] is stored as dead letter[%s].", entry.MessageId, entry.Id)
+}
+
+// execution is what is known about an execution of an action besides its result.
+type execution struct {
+	record        *audit.Record
+	stdoutTail    *tailBuffer
+	stderrTail    *tailBuffer
+	stdoutExcerpt *tailBuffer
+	stderrExcerpt *tailBuffer
+}
+
+// execute runs the mapped action and fills the audit record with what is run.
+func (mh *messageHandler) execute(ctx context.Context, actionSpecs conf.ActionSpecifications, mappedAction *conf.MappedAction, messageBody string, execution *execution) (string, error) {
+
+	record := execution.record
 
 	sourceType := mappedAction.SourceType
 	switch sourceType {
@@ -118,29 +273,224 @@ func (mh *messageHandler) execute(mappedAction *conf.MappedAction, messageBody s
 
 		repository.RLock()
 		defer repository.RUnlock()
+		if audit.IsEnabled() {
+			record.GitUrl = mappedAction.GitOptions.Url
+			record.GitCommit = repository.Commit()
+		}
 		fallthrough
 
 	case conf.LocalSourceType:
-		args := append(mh.actionSpecs.GlobalFlags.Args(), mappedAction.Flags.Args()...)
+		args := append(actionSpecs.GlobalFlags.Args(), mappedAction.Flags.Args()...)
 		args = append(args, []string{"-payload", messageBody}...)
-		args = append(args, mh.actionSpecs.GlobalArgs...)
+		args = append(args, actionSpecs.GlobalArgs...)
 		args = append(args, mappedAction.Args...)
-		env := append(mh.actionSpecs.GlobalEnv, mappedAction.Env...)
+		env := append(actionSpecs.GlobalEnv, mappedAction.Env...)
+		if mh.fencingToken != 0 {
+			env = append([]string{FencingTokenEnvName + "=" + strconv.FormatUint(mh.fencingToken, 10)}, env...)
+		}
 
-		stdout := mh.actionLoggers[mappedAction.Stdout]
+		stdout, stderr, flushOutputs := mh.outputWriters(mappedAction, execution)
 		stdoutBuff := &bytes.Buffer{}
 		if mappedAction.Type == HttpActionType {
 			if stdout != nil {
-				stdout = io.MultiWriter(stdoutBuff, mh.actionLoggers[mappedAction.Stdout])
+				stdout = io.MultiWriter(stdoutBuff, stdout)
 			} else {
 				stdout = stdoutBuff
 			}
 		}
-		stderr := mh.actionLoggers[mappedAction.Stderr]
 
-		err := runbook.ExecuteFunc(mappedAction.Filepath, args, env, stdout, stderr)
+		ctx, span := tracing.Start(ctx, "script", tracing.Internal)
+		span.SetAttribute("oec.action.filepath", mappedAction.Filepath)
+		span.SetAttribute("oec.action.source_type", sourceType)
+		if traceParent := tracing.TraceParent(ctx); traceParent != "" {
+			env = append([]string{tracing.TraceParentEnvName + "=" + traceParent}, env...)
+		}
+
+		if audit.IsEnabled() {
+			mh.auditCommand(record, mappedAction.Filepath, args, messageBody)
+		}
+
+		startedAt := time.Now()
+		record.StartedAt = &startedAt
+		err := runbook.ExecuteFunc(ctx, mappedAction.Filepath, args, env, stdout, stderr)
+		flushOutputs()
+		endedAt := time.Now()
+		record.EndedAt = &endedAt
+		span.SetError(err)
+		span.End()
 		return stdoutBuff.String(), err
 	default:
 		return "", errors.Errorf("Unknown action sourceType[%s].", sourceType)
 	}
 }
+
+// outputWriters returns the writers of the stdout and stderr of an execution, which are nil if the outputs are not
+// kept, and a function flushing them once the execution completes. Outputs are written to the tails and the capture
+// file first, since they do not fail.
+func (mh *messageHandler) outputWriters(mappedAction *conf.MappedAction, execution *execution) (io.Writer, io.Writer, func()) {
+	record := execution.record
+	stdouts := make([]io.Writer, 0)
+	stderrs := make([]io.Writer, 0)
+	framers := make([]*lineFramer, 0)
+	var capture *captureFile
+
+	frame := func(writer io.Writer, stream string) *lineFramer {
+		framer := newLineFramer(writer, record.RequestId, record.Action, stream)
+		framers = append(framers, framer)
+		return framer
+	}
+
+	if size := mh.outputConf.TailSizeInBytes; size > 0 {
+		execution.stdoutTail = newTailBuffer(size)
+		execution.stderrTail = newTailBuffer(size)
+		stdouts = append(stdouts, execution.stdoutTail)
+		stderrs = append(stderrs, execution.stderrTail)
+	}
+
+	if size := history.OutputExcerptSize(); size > 0 {
+		execution.stdoutExcerpt = newTailBuffer(size)
+		execution.stderrExcerpt = newTailBuffer(size)
+		stdouts = append(stdouts, execution.stdoutExcerpt)
+		stderrs = append(stderrs, execution.stderrExcerpt)
+	}
+
+	if mh.captureStore != nil {
+		var err error
+		capture, err = mh.captureStore.create(record.MessageId)
+		if err != nil {
+			mh.logger.Warnf("Outputs of message[%s] will not be captured: %s", record.MessageId, err)
+		} else {
+			record.CaptureFile = capture.path
+			stdouts = append(stdouts, frame(capture, stdoutStream))
+			stderrs = append(stderrs, frame(capture, stderrStream))
+		}
+	}
+
+	for _, output := range []struct {
+		writer  io.Writer
+		stream  string
+		writers *[]io.Writer
+	}{
+		{mh.actionLoggers[mappedAction.Stdout], stdoutStream, &stdouts},
+		{mh.actionLoggers[mappedAction.Stderr], stderrStream, &stderrs},
+	} {
+		if output.writer == nil {
+			continue
+		}
+		// lines are sent to syslog and journald with their fields even if framing is disabled
+		if _, isLineWriter := output.writer.(logging.LineWriter); mh.outputConf.DisableFraming && !isLineWriter {
+			*output.writers = append(*output.writers, output.writer)
+		} else {
+			*output.writers = append(*output.writers, frame(output.writer, output.stream))
+		}
+	}
+
+	flush := func() {
+		for _, framer := range framers {
+			if err := framer.Flush(); err != nil {
+				mh.logger.Warnf("Output of message[%s] could not be written: %s", record.MessageId, err)
+			}
+		}
+		if capture != nil {
+			capture.Close()
+		}
+	}
+	return combineWriters(stdouts), combineWriters(stderrs), flush
+}
+
+func combineWriters(writers []io.Writer) io.Writer {
+	switch len(writers) {
+	case 0:
+		return nil
+	case 1:
+		return writers[0]
+	default:
+		return io.MultiWriter(writers...)
+	}
+}
+
+// failureOutput returns the outputs of a failed execution for its result; the whole stderr, or the tails of both
+// stderr and stdout if the tail size is set.
+func (mh *messageHandler) failureOutput(err *runbook.ExecError, execution *execution) string {
+	if execution.stderrTail == nil {
+		return "Stderr: " + err.Stderr
+	}
+	return fmt.Sprintf("Stderr: %s, Stdout: %s", execution.stderrTail, execution.stdoutTail)
+}
+
+// addHistory adds the execution to the history with the excerpts of its outputs, if the history is enabled.
+func (mh *messageHandler) addHistory(execution *execution, startedAt time.Time, took time.Duration, outcome, failureMessage string) {
+	record := execution.record
+	entry := &history.Entry{
+		Integration:    record.Integration,
+		MessageId:      record.MessageId,
+		RequestId:      record.RequestId,
+		EntityId:       record.EntityId,
+		EntityType:     record.EntityType,
+		Action:         record.Action,
+		ActionType:     record.ActionType,
+		StartedAt:      startedAt,
+		EndedAt:        startedAt.Add(took),
+		Outcome:        outcome,
+		ExitCode:       record.ExitCode,
+		FailureMessage: redaction.String(failureMessage),
+		CaptureFile:    record.CaptureFile,
+	}
+	if outcome == actionErrored {
+		entry.FailureMessage = redaction.String(record.Error)
+	}
+	if execution.stdoutExcerpt != nil {
+		entry.Stdout = redaction.String(execution.stdoutExcerpt.String())
+		entry.Stderr = redaction.String(execution.stderrExcerpt.String())
+	}
+	history.Add(entry)
+}
+
+// auditCommand records the command line of the action with the hash of its script. The payload is replaced with
+// its hash, since it is already in the message and may have sensitive data.
+func (mh *messageHandler) auditCommand(record *audit.Record, filepath string, args []string, messageBody string) {
+	payloadSum := sha256.Sum256([]byte(messageBody))
+	record.PayloadSha256 = hex.EncodeToString(payloadSum[:])
+
+	commandArgs := make([]string, len(args))
+	for i, arg := range args {
+		commandArgs[i] = arg
+		if i > 0 && args[i-1] == "-payload" && arg == messageBody {
+			commandArgs[i] = payloadPlaceholder
+		}
+	}
+	record.Command = runbook.Command(filepath, commandArgs)
+
+	scriptSha256, err := audit.FileSha256(filepath)
+	if err != nil {
+		mh.logger.Warnf("Hash of action script[%s] could not be computed for the audit log: %s", filepath, err)
+		return
+	}
+	record.ScriptSha256 = scriptSha256
+}
+
+// actionSpecs holds the action specifications shared by the message handlers of an integration,
+// so that they can be replaced on reload while messages are being handled.
+type actionSpecs struct {
+	specs conf.ActionSpecifications
+	mu    *sync.RWMutex
+}
+
+func newActionSpecs(specs conf.ActionSpecifications) *actionSpecs {
+	return &actionSpecs{
+		specs: specs,
+		mu:    &sync.RWMutex{},
+	}
+}
+
+func (a *actionSpecs) get() conf.ActionSpecifications {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.specs
+}
+
+func (a *actionSpecs) set(specs conf.ActionSpecifications) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.specs = specs
+}
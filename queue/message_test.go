@@ -2,14 +2,30 @@ package queue
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/opsgenie/oec/audit"
 	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/deadletter"
 	"github.com/opsgenie/oec/git"
+	"github.com/opsgenie/oec/history"
+	"github.com/opsgenie/oec/redaction"
 	"github.com/opsgenie/oec/runbook"
+	"github.com/opsgenie/oec/tracing"
+	"github.com/opsgenie/oec/worker_pool"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"io/ioutil"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -62,7 +78,7 @@ var mockActionLoggers = map[string]io.Writer{
 	"/path/to/stderr": mockStderr,
 }
 
-func mockExecute(executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+func mockExecute(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
 	return nil
 }
 
@@ -73,6 +89,17 @@ func TestProcess(t *testing.T) {
 	t.Run("TestProcessActionTypeNotMatched", testProcessActionTypeNotMatched)
 	t.Run("TestProcessFieldMissing", testProcessFieldMissing)
 	t.Run("TestProcessHttpActionSuccessfully", testProcessHttpActionSuccessfully)
+	t.Run("TestProcessStoresDeadLetterOnError", testProcessStoresDeadLetterOnError)
+	t.Run("TestProcessStoresDeadLetterOnExecutionFailure", testProcessStoresDeadLetterOnExecutionFailure)
+	t.Run("TestProcessSuccessfullyDoesNotStoreDeadLetter", testProcessSuccessfullyDoesNotStoreDeadLetter)
+	t.Run("TestProcessCancelledDueToShutdown", testProcessCancelledDueToShutdown)
+	t.Run("TestProcessCancelledByOperator", testProcessCancelledByOperator)
+	t.Run("TestProcessPassesFencingToken", testProcessPassesFencingToken)
+	t.Run("TestProcessPassesTraceParent", testProcessPassesTraceParent)
+	t.Run("TestProcessWritesAuditRecord", testProcessWritesAuditRecord)
+	t.Run("TestProcessRedactsFailureAndKeepsDeadLetterBody", testProcessRedactsFailureAndKeepsDeadLetterBody)
+	t.Run("TestProcessCapturesOutputs", testProcessCapturesOutputs)
+	t.Run("TestProcessAddsHistory", testProcessAddsHistory)
 
 	runbook.ExecuteFunc = runbook.Execute
 }
@@ -84,21 +111,258 @@ func testProcessSuccessfully(t *testing.T) {
 	message := sqs.Message{Body: &body, MessageId: &id}
 	queueMessage := NewMessageHandler(nil, mockActionSpecs, mockActionLoggers)
 
-	runbook.ExecuteFunc = func(executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
-		assert.Equal(t, mockStdout, stdout)
-		assert.Equal(t, mockStderr, stderr)
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+		io.WriteString(stdout, "out line\n")
+		io.WriteString(stderr, "err line")
 		return nil
 	}
 
-	result, err := queueMessage.Handle(message)
+	result, err := queueMessage.Handle(context.Background(), message)
 	assert.Nil(t, err)
 	assert.Equal(t, "Create", result.Action)
 	assert.Equal(t, "RequestId", result.RequestId)
 	assert.True(t, result.IsSuccessful)
+	assert.Contains(t, mockStdout.String(), " requestId=RequestId action=Create stream=stdout out line\n")
+	assert.Contains(t, mockStderr.String(), " requestId=RequestId action=Create stream=stderr err line\n")
+}
+
+func testProcessAddsHistory(t *testing.T) {
+	directory, err := ioutil.TempDir("", "oecHistory")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	assert.Nil(t, history.Configure(&conf.HistoryConf{Enabled: true, Directory: directory, OutputExcerptSizeInBytes: 6}))
+	defer history.Close()
+
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+		io.WriteString(stdout, "restarting\n")
+		io.WriteString(stderr, "not found\n")
+		return runbook.Execute(ctx, "/path/to/not/existing/action.bin", nil, nil, nil, nil)
+	}
+
+	body := `{"action":"Create","requestId":"RequestId","entity":{"id":"EntityId","type":"alert"}}`
+	message := sqs.Message{Body: &body, MessageId: &mockMessageId}
+	messageHandler := &messageHandler{actionSpecs: newActionSpecs(mockActionSpecs), integrationName: "Integration", logger: newIntegrationLogger("")}
+
+	result, err := messageHandler.Handle(context.Background(), message)
+	assert.Nil(t, err)
+	assert.False(t, result.IsSuccessful)
+
+	entries, err := history.Query(&history.Filter{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+
+	entry := entries[0]
+	assert.Equal(t, "Integration", entry.Integration)
+	assert.Equal(t, mockMessageId, entry.MessageId)
+	assert.Equal(t, "RequestId", entry.RequestId)
+	assert.Equal(t, "EntityId", entry.EntityId)
+	assert.Equal(t, "Create", entry.Action)
+	assert.Equal(t, history.Failed, entry.Outcome)
+	assert.Equal(t, result.FailureMessage, entry.FailureMessage)
+	assert.Equal(t, "rting\n", entry.Stdout)
+	assert.Equal(t, "found\n", entry.Stderr)
+	assert.False(t, entry.EndedAt.Before(entry.StartedAt))
+}
+
+func testProcessCapturesOutputs(t *testing.T) {
+	captureDirectory, err := ioutil.TempDir("", "captures")
+	assert.Nil(t, err)
+	defer os.RemoveAll(captureDirectory)
+
+	outputConf := conf.ActionOutputConf{CaptureEnabled: true, CaptureDirectory: captureDirectory, TailSizeInBytes: 4}
+	store, err := newCaptureStore(&outputConf)
+	assert.Nil(t, err)
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	messageHandler := &messageHandler{
+		actionSpecs:   newActionSpecs(mockActionSpecs),
+		actionLoggers: map[string]io.Writer{"/path/to/stdout": stdout, "/path/to/stderr": stderr},
+		outputConf:    conf.ActionOutputConf{DisableFraming: true, TailSizeInBytes: 4},
+		captureStore:  store,
+		logger:        newIntegrationLogger(""),
+	}
+
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+		io.WriteString(stdout, "first\nsecond\n")
+		io.WriteString(stderr, "failed\n")
+		return runbook.Execute(ctx, "/path/to/not/existing/action.bin", nil, nil, nil, nil)
+	}
+
+	body := `{"action":"Create","requestId":"RequestId"}`
+	message := sqs.Message{Body: &body, MessageId: &mockMessageId}
+
+	result, err := messageHandler.Handle(context.Background(), message)
+	assert.Nil(t, err)
+	assert.False(t, result.IsSuccessful)
+	assert.True(t, strings.HasSuffix(result.FailureMessage, ", Stderr: led\n, Stdout: ond\n"), result.FailureMessage)
+
+	assert.Equal(t, "first\nsecond\n", stdout.String())
+	assert.Equal(t, "failed\n", stderr.String())
+
+	assert.Equal(t, captureDirectory, filepath.Dir(result.CaptureFile))
+	captured, err := ioutil.ReadFile(result.CaptureFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(captured), " requestId=RequestId action=Create stream=stdout second\n")
+	assert.Contains(t, string(captured), " requestId=RequestId action=Create stream=stderr failed\n")
+}
+
+func testProcessCancelledDueToShutdown(t *testing.T) {
+
+	body := `{"action":"Create", "requestId": "RequestId"}`
+	id := "MessageId"
+	message := sqs.Message{Body: &body, MessageId: &id}
+	queueMessage := NewMessageHandler(nil, mockActionSpecs, mockActionLoggers)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+		cancel(worker_pool.ErrShutdown)
+		return runbook.Execute(ctx, "/path/to/not/existing/action.bin", nil, nil, nil, nil)
+	}
+
+	result, err := queueMessage.Handle(ctx, message)
+	assert.Nil(t, err)
+	assert.False(t, result.IsSuccessful)
+	assert.Equal(t, "RequestId", result.RequestId)
+	assert.True(t, strings.HasPrefix(result.FailureMessage, "Action is cancelled due to shutdown."), result.FailureMessage)
+}
+
+func testProcessCancelledByOperator(t *testing.T) {
+
+	body := `{"action":"Create", "requestId": "RequestId"}`
+	id := "MessageId"
+	message := sqs.Message{Body: &body, MessageId: &id}
+	queueMessage := NewMessageHandler(nil, mockActionSpecs, mockActionLoggers)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+		cancel(ErrCancelledByOperator)
+		return runbook.Execute(ctx, "/path/to/not/existing/action.bin", nil, nil, nil, nil)
+	}
+
+	result, err := queueMessage.Handle(ctx, message)
+	assert.Nil(t, err)
+	assert.False(t, result.IsSuccessful)
+	assert.True(t, strings.HasPrefix(result.FailureMessage, "Action is cancelled by an operator."), result.FailureMessage)
+}
+
+func testProcessPassesFencingToken(t *testing.T) {
+
+	body := `{"action":"Create", "requestId": "RequestId"}`
+	id := "MessageId"
+	message := sqs.Message{Body: &body, MessageId: &id}
+	queueMessage := &messageHandler{
+		actionSpecs:   newActionSpecs(mockActionSpecs),
+		actionLoggers: mockActionLoggers,
+		fencingToken:  42,
+		logger:        newIntegrationLogger(""),
+	}
+
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+		assert.Contains(t, environmentVars, "OEC_FENCING_TOKEN=42")
+		return nil
+	}
+
+	result, err := queueMessage.Handle(context.Background(), message)
+	assert.Nil(t, err)
+	assert.True(t, result.IsSuccessful)
+}
+
+func testProcessPassesTraceParent(t *testing.T) {
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer collector.Close()
+
+	tracing.Configure(&conf.TracingConf{Enabled: true, Endpoint: collector.URL})
+	defer tracing.Shutdown(context.Background())
+
+	body := `{"action":"Create", "requestId": "RequestId"}`
+	id := "MessageId"
+	message := sqs.Message{Body: &body, MessageId: &id}
+	queueMessage := NewMessageHandler(nil, mockActionSpecs, mockActionLoggers)
+
+	ctx, span := tracing.Start(context.Background(), "execute", tracing.Internal)
+	defer span.End()
+
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+		traceParent := tracing.TraceParent(ctx)
+		assert.Contains(t, environmentVars, tracing.TraceParentEnvName+"="+traceParent)
+
+		scriptSpanContext, err := tracing.ParseTraceParent(traceParent)
+		assert.Nil(t, err)
+		assert.Equal(t, span.SpanContext().TraceId, scriptSpanContext.TraceId)
+		assert.NotEqual(t, span.SpanContext().SpanId, scriptSpanContext.SpanId)
+		return nil
+	}
+
+	result, err := queueMessage.Handle(ctx, message)
+	assert.Nil(t, err)
+	assert.True(t, result.IsSuccessful)
+}
+
+func testProcessWritesAuditRecord(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "oecAudit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	scriptPath := filepath.Join(directory, "create.sh")
+	err = ioutil.WriteFile(scriptPath, []byte("echo create"), 0700)
+	assert.Nil(t, err)
+
+	auditPath := filepath.Join(directory, "audit.jsonl")
+	err = audit.Configure(&conf.AuditConf{Enabled: true, Filepath: auditPath})
+	assert.Nil(t, err)
+	defer audit.Close()
+
+	body := `{"action":"Create", "requestId": "RequestId", "entity": {"id": "EntityId", "type": "alert"}}`
+	id := "MessageId"
+	message := sqs.Message{Body: &body, MessageId: &id}
+	queueMessage := &messageHandler{
+		actionSpecs: newActionSpecs(conf.ActionSpecifications{
+			ActionMappings: conf.ActionMappings{
+				"Create": conf.MappedAction{SourceType: "local", Filepath: scriptPath},
+			},
+		}),
+		integrationName: "first",
+		logger:          newIntegrationLogger("first"),
+	}
+
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+		return nil
+	}
+
+	result, err := queueMessage.Handle(context.Background(), message)
+	assert.Nil(t, err)
+	assert.True(t, result.IsSuccessful)
+	assert.Nil(t, audit.Close())
+
+	content, err := ioutil.ReadFile(auditPath)
+	assert.Nil(t, err)
+	record := &audit.Record{}
+	assert.Nil(t, json.Unmarshal(content, record))
+
+	scriptSum := sha256.Sum256([]byte("echo create"))
+	payloadSum := sha256.Sum256([]byte(body))
+	assert.Equal(t, audit.ExecutionRecord, record.Type)
+	assert.Equal(t, "first", record.Integration)
+	assert.Equal(t, "MessageId", record.MessageId)
+	assert.Equal(t, "RequestId", record.RequestId)
+	assert.Equal(t, "EntityId", record.EntityId)
+	assert.Equal(t, "Create", record.Action)
+	assert.Equal(t, []string{"sh", scriptPath, "-payload", payloadPlaceholder}, record.Command)
+	assert.Equal(t, hex.EncodeToString(payloadSum[:]), record.PayloadSha256)
+	assert.Equal(t, hex.EncodeToString(scriptSum[:]), record.ScriptSha256)
+	assert.Equal(t, audit.RunAsUser(), record.RunAsUser)
+	assert.NotNil(t, record.StartedAt)
+	assert.NotNil(t, record.EndedAt)
+	assert.Equal(t, 0, *record.ExitCode)
+	assert.True(t, record.IsSuccessful)
 }
 
 func testProcessHttpActionSuccessfully(t *testing.T) {
-	runbook.ExecuteFunc = func(executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
 		io.Copy(stdout, bytes.NewBufferString(`{"headers": {"Date": "Wed, 14 Oct 2020 08:59:30 GMT"},"body": "done", "statusCode": 200}`))
 		return nil
 	}
@@ -108,7 +372,7 @@ func testProcessHttpActionSuccessfully(t *testing.T) {
 	message := sqs.Message{Body: &body, MessageId: &id}
 	queueMessage := NewMessageHandler(nil, mockActionSpecs, mockActionLoggers)
 
-	result, err := queueMessage.Handle(message)
+	result, err := queueMessage.Handle(context.Background(), message)
 	assert.Nil(t, err)
 	assert.Equal(t, "Retrieve", result.Action)
 	assert.Equal(t, "RequestId", result.RequestId)
@@ -129,7 +393,7 @@ func testProcessMappedActionNotFound(t *testing.T) {
 	message := sqs.Message{Body: &body}
 	messageHandler := NewMessageHandler(nil, mockActionSpecs, mockActionLoggers)
 
-	_, err := messageHandler.Handle(message)
+	_, err := messageHandler.Handle(context.Background(), message)
 	expectedErr := errors.New("There is no mapped action found for action[Ack]. SQS message with entityId[] will be ignored.")
 	assert.EqualError(t, err, expectedErr.Error())
 }
@@ -141,7 +405,7 @@ func testProcessActionTypeNotMatched(t *testing.T) {
 	message := sqs.Message{Body: &body}
 	messageHandler := NewMessageHandler(nil, mockActionSpecs, mockActionLoggers)
 
-	_, err := messageHandler.Handle(message)
+	_, err := messageHandler.Handle(context.Background(), message)
 	expectedErr := errors.New("The mapped action found for action[Close] with type[custom] but action is coming with type[http]. " +
 		"SQS message with entityId[] will be ignored.")
 	assert.EqualError(t, err, expectedErr.Error())
@@ -155,17 +419,177 @@ func testProcessFieldMissing(t *testing.T) {
 	message := sqs.Message{Body: &body}
 	messageHandler := NewMessageHandler(nil, mockActionSpecs, mockActionLoggers)
 
-	_, err := messageHandler.Handle(message)
+	_, err := messageHandler.Handle(context.Background(), message)
 	expectedErr := errors.New("SQS message with entityId[] does not contain action property.")
 	assert.EqualError(t, err, expectedErr.Error())
 }
 
+func testProcessStoresDeadLetterOnError(t *testing.T) {
+	runbook.ExecuteFunc = mockExecute
+
+	body := `{"action":"Ack", "requestId": "RequestId", "entity": {"id": "EntityId"}}`
+	message := sqs.Message{Body: &body, MessageId: &mockMessageId}
+
+	store := NewMockDeadLetterStore()
+	messageHandler := &messageHandler{actionSpecs: newActionSpecs(mockActionSpecs), actionLoggers: mockActionLoggers, deadLetterStore: store, logger: newIntegrationLogger("")}
+
+	_, err := messageHandler.Handle(context.Background(), message)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, 1, len(store.entries))
+	entry := store.entries[0]
+	assert.Equal(t, mockMessageId, entry.MessageId)
+	assert.Equal(t, body, entry.Body)
+	assert.Equal(t, "Ack", entry.Action)
+	assert.Equal(t, "EntityId", entry.EntityId)
+	assert.Equal(t, "RequestId", entry.RequestId)
+	assert.Equal(t, err.Error(), entry.Error)
+	assert.Nil(t, entry.MappedAction)
+	assert.False(t, entry.ProcessedAt.After(entry.FailedAt))
+}
+
+func testProcessStoresDeadLetterOnExecutionFailure(t *testing.T) {
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+		return runbook.Execute(ctx, "/path/to/not/existing/action.bin", nil, nil, nil, nil)
+	}
+
+	body := `{"action":"Create", "requestId": "RequestId"}`
+	message := sqs.Message{Body: &body, MessageId: &mockMessageId}
+
+	store := NewMockDeadLetterStore()
+	messageHandler := &messageHandler{actionSpecs: newActionSpecs(mockActionSpecs), actionLoggers: mockActionLoggers, deadLetterStore: store, logger: newIntegrationLogger("")}
+
+	result, err := messageHandler.Handle(context.Background(), message)
+	assert.Nil(t, err)
+	assert.False(t, result.IsSuccessful)
+
+	assert.Equal(t, 1, len(store.entries))
+	entry := store.entries[0]
+	assert.Equal(t, result.FailureMessage, entry.Error)
+	assert.Equal(t, "Create", entry.Action)
+
+	expectedMappedAction := mockActionMappings["Create"]
+	assert.Equal(t, &expectedMappedAction, entry.MappedAction)
+}
+
+func testProcessRedactsFailureAndKeepsDeadLetterBody(t *testing.T) {
+	err := redaction.Configure(&conf.Configuration{
+		ApiKey:        mockApiKey,
+		RedactionConf: conf.RedactionConf{JsonPaths: []string{"alert.details.customer"}},
+	})
+	assert.Nil(t, err)
+	defer redaction.Configure(&conf.Configuration{RedactionConf: conf.RedactionConf{Disabled: true}})
+
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+		return runbook.Execute(ctx, "/path/to/not/existing/"+mockApiKey+".bin", nil, nil, nil, nil)
+	}
+
+	body := `{"action":"Create","requestId":"RequestId","alert":{"details":{"customer":"John"}}}`
+	message := sqs.Message{Body: &body, MessageId: &mockMessageId}
+
+	store := NewMockDeadLetterStore()
+	messageHandler := &messageHandler{actionSpecs: newActionSpecs(mockActionSpecs), actionLoggers: mockActionLoggers, deadLetterStore: store, logger: newIntegrationLogger("")}
+
+	result, err := messageHandler.Handle(context.Background(), message)
+	assert.Nil(t, err)
+	assert.False(t, result.IsSuccessful)
+	assert.NotContains(t, result.FailureMessage, mockApiKey)
+	assert.Contains(t, result.FailureMessage, redaction.Replacement)
+
+	entry := store.entries[0]
+	// the body is kept as it is so that the replayed action gets the original payload
+	assert.Equal(t, body, entry.Body)
+	assert.Equal(t, "RequestId", entry.RequestId)
+	assert.NotContains(t, entry.Error, mockApiKey)
+}
+
+func testProcessSuccessfullyDoesNotStoreDeadLetter(t *testing.T) {
+	runbook.ExecuteFunc = mockExecute
+
+	body := `{"action":"Create", "requestId": "RequestId"}`
+	message := sqs.Message{Body: &body, MessageId: &mockMessageId}
+
+	store := NewMockDeadLetterStore()
+	messageHandler := &messageHandler{actionSpecs: newActionSpecs(mockActionSpecs), actionLoggers: mockActionLoggers, deadLetterStore: store, logger: newIntegrationLogger("")}
+
+	result, err := messageHandler.Handle(context.Background(), message)
+	assert.Nil(t, err)
+	assert.True(t, result.IsSuccessful)
+	assert.Empty(t, store.entries)
+}
+
+// Mock Dead Letter Store
+type MockDeadLetterStore struct {
+	entries []*deadletter.Entry
+}
+
+func NewMockDeadLetterStore() *MockDeadLetterStore {
+	return &MockDeadLetterStore{}
+}
+
+func (m *MockDeadLetterStore) Put(entry *deadletter.Entry) error {
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *MockDeadLetterStore) Get(id string) (*deadletter.Entry, error) {
+	for _, entry := range m.entries {
+		if entry.Id == id {
+			return entry, nil
+		}
+	}
+	return nil, errors.Errorf("Dead letter[%s] could not be found.", id)
+}
+
+func (m *MockDeadLetterStore) List() ([]*deadletter.Entry, error) {
+	return m.entries, nil
+}
+
+func (m *MockDeadLetterStore) Remove(id string) error {
+	for i, entry := range m.entries {
+		if entry.Id == id {
+			m.entries = append(m.entries[:i], m.entries[i+1:]...)
+			return nil
+		}
+	}
+	return errors.Errorf("Dead letter[%s] could not be found.", id)
+}
+
+func (m *MockDeadLetterStore) Purge() (int, error) {
+	count := len(m.entries)
+	m.entries = nil
+	return count, nil
+}
+
+func TestActionPriority(t *testing.T) {
+	queueMessage := &messageHandler{
+		actionSpecs: newActionSpecs(conf.ActionSpecifications{
+			ActionMappings: conf.ActionMappings{
+				"Restart": conf.MappedAction{SourceType: "local", Filepath: "/path/to/restart.sh", Priority: "P1"},
+				"Enrich":  conf.MappedAction{SourceType: "local", Filepath: "/path/to/enrich.sh"},
+			},
+		}),
+		logger: newIntegrationLogger(""),
+	}
+
+	priority, ok := queueMessage.ActionPriority("Restart")
+	assert.True(t, ok)
+	assert.Equal(t, 1, priority)
+
+	_, ok = queueMessage.ActionPriority("Enrich")
+	assert.False(t, ok)
+
+	_, ok = queueMessage.ActionPriority("Unknown")
+	assert.False(t, ok)
+}
+
 // Mock Queue Message
 type MockMessageHandler struct {
-	HandleFunc func(message sqs.Message) (*runbook.ActionResultPayload, error)
+	HandleFunc         func(message sqs.Message) (*runbook.ActionResultPayload, error)
+	ActionPriorityFunc func(action string) (int, bool)
 }
 
-func (mqm *MockMessageHandler) Handle(message sqs.Message) (*runbook.ActionResultPayload, error) {
+func (mqm *MockMessageHandler) Handle(ctx context.Context, message sqs.Message) (*runbook.ActionResultPayload, error) {
 	if mqm.HandleFunc != nil {
 		return mqm.HandleFunc(message)
 	}
@@ -175,6 +599,13 @@ func (mqm *MockMessageHandler) Handle(message sqs.Message) (*runbook.ActionResul
 	return &runbook.ActionResultPayload{}, nil
 }
 
+func (mqm *MockMessageHandler) ActionPriority(action string) (int, bool) {
+	if mqm.ActionPriorityFunc != nil {
+		return mqm.ActionPriorityFunc(action)
+	}
+	return 0, false
+}
+
 func NewMockMessageHandler() MessageHandler {
 	return &MockMessageHandler{}
 }
@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"github.com/opsgenie/oec/worker_pool"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+var circuitStateValues = map[circuitState]float64{
+	circuitClosed:   0,
+	circuitHalfOpen: 1,
+	circuitOpen:     2,
+}
+
+// Outcomes of the executed actions.
+const (
+	actionSucceeded = "success"
+	actionFailed    = "failure"
+	actionCancelled = "cancelled"
+	actionErrored   = "error"
+)
+
+var (
+	pollerCircuitState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "oec_poller_circuit_state",
+			Help: "State of the receive circuit of the poller: 0 closed, 1 half-open, 2 open.",
+		},
+		[]string{"integration", "region"},
+	)
+	pollerConsecutiveFailures = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "oec_poller_consecutive_receive_failures",
+			Help: "Number of consecutive failed receive calls of the poller.",
+		},
+		[]string{"integration", "region"},
+	)
+	pollerReceiveErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oec_poller_receive_errors_total",
+			Help: "Number of failed receive calls of the poller by error class.",
+		},
+		[]string{"integration", "region", "class"},
+	)
+	messagesReceived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oec_messages_received_total",
+			Help: "Number of messages received from the queue.",
+		},
+		[]string{"integration", "region"},
+	)
+	messagesDeleted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oec_messages_deleted_total",
+			Help: "Number of messages deleted from the queue to be processed.",
+		},
+		[]string{"integration", "region"},
+	)
+	messagesReleased = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oec_messages_released_total",
+			Help: "Number of messages made visible in the queue again since they could not be processed by this instance.",
+		},
+		[]string{"integration", "region"},
+	)
+	actionsExecuted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oec_actions_executed_total",
+			Help: "Number of executed actions by outcome: success, failure, cancelled or error.",
+		},
+		[]string{"integration", "action", "type", "outcome"},
+	)
+	actionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "oec_action_duration_seconds",
+			Help:    "Execution duration of the actions.",
+			Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		},
+		[]string{"integration", "action", "type"},
+	)
+	tokenRefreshes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oec_token_refreshes_total",
+			Help: "Number of attempts to receive the queue token from Opsgenie by outcome: success or failure.",
+		},
+		[]string{"integration", "outcome"},
+	)
+	workerPools = newWorkerPoolCollector()
+)
+
+func init() {
+	prometheus.MustRegister(
+		pollerCircuitState,
+		pollerConsecutiveFailures,
+		pollerReceiveErrors,
+		messagesReceived,
+		messagesDeleted,
+		messagesReleased,
+		actionsExecuted,
+		actionDuration,
+		tokenRefreshes,
+		workerPools,
+	)
+}
+
+// workerPoolCollector reads the gauges of the worker pools of the running processors while metrics are collected.
+type workerPoolCollector struct {
+	pools map[string]worker_pool.WorkerPool
+	mu    *sync.RWMutex
+
+	workers        *prometheus.Desc
+	idleWorkers    *prometheus.Desc
+	maxWorkers     *prometheus.Desc
+	queuedJobs     *prometheus.Desc
+	queueSize      *prometheus.Desc
+	poolSaturation *prometheus.Desc
+	panickedJobs   *prometheus.Desc
+}
+
+func newWorkerPoolCollector() *workerPoolCollector {
+	labels := []string{"integration"}
+	return &workerPoolCollector{
+		pools:          make(map[string]worker_pool.WorkerPool),
+		mu:             &sync.RWMutex{},
+		workers:        prometheus.NewDesc("oec_worker_pool_workers", "Number of current workers of the pool.", labels, nil),
+		idleWorkers:    prometheus.NewDesc("oec_worker_pool_idle_workers", "Number of idle workers of the pool.", labels, nil),
+		maxWorkers:     prometheus.NewDesc("oec_worker_pool_max_workers", "Max number of workers of the pool.", labels, nil),
+		queuedJobs:     prometheus.NewDesc("oec_worker_pool_queued_jobs", "Number of jobs waiting in the queue of the pool.", labels, nil),
+		queueSize:      prometheus.NewDesc("oec_worker_pool_queue_size", "Capacity of the queue of the pool.", labels, nil),
+		poolSaturation: prometheus.NewDesc("oec_worker_pool_saturation", "Ratio of the busy workers to the max number of workers of the pool.", labels, nil),
+		panickedJobs:   prometheus.NewDesc("oec_worker_pool_job_panics_total", "Number of jobs of the pool which have panicked.", labels, nil),
+	}
+}
+
+func (c *workerPoolCollector) add(integration string, pool worker_pool.WorkerPool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pools[integration] = pool
+}
+
+// remove stops collecting the pool, unless it has already been replaced by the pool of another processor.
+func (c *workerPoolCollector) remove(integration string, pool worker_pool.WorkerPool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pools[integration] == pool {
+		delete(c.pools, integration)
+	}
+}
+
+func (c *workerPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.workers
+	ch <- c.idleWorkers
+	ch <- c.maxWorkers
+	ch <- c.queuedJobs
+	ch <- c.queueSize
+	ch <- c.poolSaturation
+	ch <- c.panickedJobs
+}
+
+func (c *workerPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for integration, pool := range c.pools {
+		status := pool.Status()
+		ch <- prometheus.MustNewConstMetric(c.workers, prometheus.GaugeValue, float64(status.NumberOfCurrentWorker), integration)
+		ch <- prometheus.MustNewConstMetric(c.idleWorkers, prometheus.GaugeValue, float64(status.NumberOfCurrentWorker-status.NumberOfBusyWorker), integration)
+		ch <- prometheus.MustNewConstMetric(c.maxWorkers, prometheus.GaugeValue, float64(status.MaxNumberOfWorker), integration)
+		ch <- prometheus.MustNewConstMetric(c.queuedJobs, prometheus.GaugeValue, float64(status.NumberOfQueuedJob), integration)
+		ch <- prometheus.MustNewConstMetric(c.queueSize, prometheus.GaugeValue, float64(status.QueueSize), integration)
+		ch <- prometheus.MustNewConstMetric(c.poolSaturation, prometheus.GaugeValue, status.Saturation, integration)
+		ch <- prometheus.MustNewConstMetric(c.panickedJobs, prometheus.CounterValue, float64(status.NumberOfPanickedJob), integration)
+	}
+}
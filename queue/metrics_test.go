@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/opsgenie/oec/runbook"
+	"github.com/opsgenie/oec/worker_pool"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	metric := &dto.Metric{}
+	assert.Nil(t, counter.Write(metric))
+	return metric.GetCounter().GetValue()
+}
+
+func TestActionMetrics(t *testing.T) {
+	defer func() { runbook.ExecuteFunc = runbook.Execute }()
+
+	body := `{"action":"Create", "requestId": "RequestId"}`
+	id := "MessageId"
+	message := sqs.Message{Body: &body, MessageId: &id}
+	queueMessage := &messageHandler{
+		actionSpecs:     newActionSpecs(mockActionSpecs),
+		actionLoggers:   mockActionLoggers,
+		integrationName: "metrics",
+		logger:          newIntegrationLogger("metrics"),
+	}
+
+	succeeded := actionsExecuted.WithLabelValues("metrics", "Create", "", actionSucceeded)
+	failed := actionsExecuted.WithLabelValues("metrics", "Create", "", actionFailed)
+	succeededBefore, failedBefore := counterValue(t, succeeded), counterValue(t, failed)
+
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+		return nil
+	}
+	_, err := queueMessage.Handle(context.Background(), message)
+	assert.Nil(t, err)
+
+	runbook.ExecuteFunc = func(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+		return runbook.Execute(ctx, "/path/to/not/existing/action.bin", nil, nil, nil, nil)
+	}
+	_, err = queueMessage.Handle(context.Background(), message)
+	assert.Nil(t, err)
+
+	assert.Equal(t, succeededBefore+1, counterValue(t, succeeded))
+	assert.Equal(t, failedBefore+1, counterValue(t, failed))
+
+	histogram := &dto.Metric{}
+	err = actionDuration.WithLabelValues("metrics", "Create", "").(prometheus.Histogram).Write(histogram)
+	assert.Nil(t, err)
+	assert.True(t, histogram.GetHistogram().GetSampleCount() >= 2)
+}
+
+func TestWorkerPoolCollector(t *testing.T) {
+
+	collector := newWorkerPoolCollector()
+	pool := NewMockWorkerPool()
+	pool.StatusFunc = func() worker_pool.Status {
+		return worker_pool.Status{MaxNumberOfWorker: 8, NumberOfCurrentWorker: 4, NumberOfBusyWorker: 3, QueueSize: 2, NumberOfQueuedJob: 1, Saturation: 0.375, NumberOfPanickedJob: 3}
+	}
+	collector.add("first", pool)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		assert.Len(t, family.GetMetric(), 1)
+		assert.Equal(t, "first", family.GetMetric()[0].GetLabel()[0].GetValue())
+		if family.GetType() == dto.MetricType_COUNTER {
+			values[family.GetName()] = family.GetMetric()[0].GetCounter().GetValue()
+		} else {
+			values[family.GetName()] = family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	assert.Equal(t, map[string]float64{
+		"oec_worker_pool_workers":          4,
+		"oec_worker_pool_idle_workers":     1,
+		"oec_worker_pool_max_workers":      8,
+		"oec_worker_pool_queued_jobs":      1,
+		"oec_worker_pool_queue_size":       2,
+		"oec_worker_pool_saturation":       0.375,
+		"oec_worker_pool_job_panics_total": 3,
+	}, values)
+
+	collector.remove("first", NewMockWorkerPool())
+	families, _ = registry.Gather()
+	assert.Len(t, families, 7)
+
+	collector.remove("first", pool)
+	families, _ = registry.Gather()
+	assert.Empty(t, families)
+}
@@ -1,5 +1,7 @@
 package queue
 
+import "github.com/opsgenie/oec/conf"
+
 type payload struct {
 	RequestId             string       `json:"requestId"`
 	Entity                entity       `json:"entity"`
@@ -7,6 +9,19 @@ type payload struct {
 	MappedAction          mappedAction `json:"mappedActionV2"`
 	ActionType            string       `json:"actionType"`
 	DiscardScriptResponse bool         `json:"discardScriptResponse"`
+	Alert                 alert        `json:"alert"`
+}
+
+type alert struct {
+	Priority string `json:"priority"`
+}
+
+// actionName returns the name of the mapped action, or the action if the payload has no mapped action.
+func (p payload) actionName() string {
+	if p.MappedAction.Name != "" {
+		return p.MappedAction.Name
+	}
+	return p.Action
 }
 
 type entity struct {
@@ -23,3 +38,11 @@ const (
 	CustomActionType = "custom"
 	HttpActionType   = "http"
 )
+
+// parsePriority parses the priorities of the alerts and the actions from P1 to P5.
+func parsePriority(priority string) (int, bool) {
+	if !conf.IsValidPriority(priority) {
+		return 0, false
+	}
+	return int(priority[1] - '0'), true
+}
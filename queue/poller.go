@@ -1,35 +1,48 @@
 package queue
 
 import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/logging"
+	"github.com/opsgenie/oec/redaction"
+	"github.com/opsgenie/oec/tracing"
 	"github.com/opsgenie/oec/util"
 	"github.com/opsgenie/oec/worker_pool"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/natefinch/lumberjack.v2"
-	"os"
-	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Poller interface {
-	Processor
+	Start(ctx context.Context) error
+	Stop() error
 	RefreshClient(assumeRoleResult AssumeRoleResult) error
 	QueueProvider() SQSProvider
+	Status() PollerStatus
+	Pause()
+	Resume()
 }
 
 type poller struct {
 	workerPool     worker_pool.WorkerPool
 	queueProvider  SQSProvider
 	messageHandler MessageHandler
+	resultSender   ResultSender
 
 	ownerId            string
 	conf               *conf.Configuration
 	queueMessageLogrus *logrus.Logger
+	backoff            *receiveBackoff
+	isPaused           int32 // messages are not received while paused, accessed atomically
+	logger             *logrus.Entry
 
+	ctx         context.Context
+	cancel      context.CancelFunc
 	isRunning   bool
 	isRunningWg *sync.WaitGroup
 	startStopMu *sync.Mutex
@@ -40,21 +53,26 @@ type poller struct {
 func NewPoller(workerPool worker_pool.WorkerPool,
 	queueProvider SQSProvider,
 	messageHandler MessageHandler,
+	resultSender ResultSender,
 	conf *conf.Configuration,
-	ownerId string) Poller {
+	ownerId string,
+	queueMessageLogrus *logrus.Logger) Poller {
 
 	return &poller{
 		workerPool:         workerPool,
 		queueProvider:      queueProvider,
 		messageHandler:     messageHandler,
+		resultSender:       resultSender,
 		ownerId:            ownerId,
 		conf:               conf,
-		queueMessageLogrus: newQueueMessageLogrus(queueProvider.Properties().Region()),
+		queueMessageLogrus: queueMessageLogrus,
+		backoff:            newReceiveBackoff(conf.PollerConf.CircuitBreakerThreshold, conf.PollerConf.CircuitBreakerOpenIntervalInMillis*time.Millisecond),
 		isRunning:          false,
 		isRunningWg:        &sync.WaitGroup{},
 		startStopMu:        &sync.Mutex{},
 		quit:               make(chan struct{}),
 		wakeUp:             make(chan struct{}),
+		logger:             newIntegrationLogger(conf.IntegrationName).WithField("region", queueProvider.Properties().Region()),
 	}
 }
 
@@ -66,7 +84,47 @@ func (p *poller) RefreshClient(assumeRoleResult AssumeRoleResult) error {
 	return p.queueProvider.RefreshClient(assumeRoleResult)
 }
 
-func (p *poller) Start() error {
+func (p *poller) Status() PollerStatus {
+	p.startStopMu.Lock()
+	isRunning := p.isRunning
+	p.startStopMu.Unlock()
+
+	properties := p.queueProvider.Properties()
+	status := PollerStatus{
+		QueueUrl:            properties.Url(),
+		Region:              properties.Region(),
+		IsRunning:           isRunning,
+		IsPaused:            p.IsPaused(),
+		IsTokenExpired:      p.queueProvider.IsTokenExpired(),
+		CredentialsExpireAt: time.Unix(0, properties.ExpireTimeMillis()*int64(time.Millisecond)),
+		CircuitState:        string(p.backoff.State()),
+		ConsecutiveFailures: p.backoff.ConsecutiveFailures(),
+		LastErrorClass:      string(p.backoff.LastErrorClass()),
+	}
+	if lastReceiveAt := p.backoff.LastSuccessAt(); !lastReceiveAt.IsZero() {
+		status.LastReceiveAt = &lastReceiveAt
+	}
+	return status
+}
+
+// Pause stops receiving messages until the poller is resumed. Messages which have been received are still processed.
+func (p *poller) Pause() {
+	if atomic.CompareAndSwapInt32(&p.isPaused, 0, 1) {
+		p.logger.Infof("Poller[%s] is paused.", p.queueProvider.Properties().Url())
+	}
+}
+
+func (p *poller) Resume() {
+	if atomic.CompareAndSwapInt32(&p.isPaused, 1, 0) {
+		p.logger.Infof("Poller[%s] is resumed.", p.queueProvider.Properties().Url())
+	}
+}
+
+func (p *poller) IsPaused() bool {
+	return atomic.LoadInt32(&p.isPaused) == 1
+}
+
+func (p *poller) Start(ctx context.Context) error {
 	defer p.startStopMu.Unlock()
 	p.startStopMu.Lock()
 
@@ -74,6 +132,8 @@ func (p *poller) Start() error {
 		return errors.New("Poller is already running.")
 	}
 
+	p.ctx, p.cancel = context.WithCancel(ctx)
+
 	p.isRunningWg.Add(1)
 	go p.run()
 
@@ -90,6 +150,7 @@ func (p *poller) Stop() error {
 		return errors.New("Poller is not running.")
 	}
 
+	p.cancel() // interrupts the long polling
 	close(p.quit)
 	close(p.wakeUp)
 
@@ -103,22 +164,37 @@ func (p *poller) terminateMessageVisibility(messages []*sqs.Message) {
 
 	region := p.queueProvider.Properties().Region()
 
-	for i := 0; i < len(messages); i++ {
+	errs := p.queueProvider.ChangeMessageVisibilityBatch(messages, 0)
+	for i, err := range errs {
 		messageId := *messages[i].MessageId
 
-		err := p.queueProvider.ChangeMessageVisibility(messages[i], 0)
 		if err != nil {
-			logrus.Warnf("Poller[%s] could not terminate visibility of message[%s]: %s.", region, messageId, err.Error())
+			p.logger.Warnf("Poller[%s] could not terminate visibility of message[%s]: %s.", region, messageId, err.Error())
 			continue
 		}
+		messagesReleased.WithLabelValues(p.conf.IntegrationName, region).Inc()
 
-		logrus.Debugf("Poller[%s] terminated visibility of message[%s].", region, messageId)
+		p.logger.Debugf("Poller[%s] terminated visibility of message[%s].", region, messageId)
 	}
 }
 
+// shouldPrefetch returns whether messages should be received to wait in the queue of the pool, so that urgent ones
+// are started before the queued ones. Since the visibility timeout of the prefetched messages runs while they wait,
+// no more are prefetched once the queued jobs have waited for half of the visibility timeout.
+func (p *poller) shouldPrefetch() bool {
+	if !p.conf.PollerConf.PrefetchEnabled {
+		return false
+	}
+	visibilityTimeout := time.Duration(p.conf.PollerConf.VisibilityTimeoutInSeconds) * time.Second
+	return p.workerPool.OldestQueuedJobAge() < visibilityTimeout/2
+}
+
 func (p *poller) poll() (shouldWait bool) {
 
 	availableWorkerCount := p.workerPool.NumberOfAvailableWorker()
+	if p.shouldPrefetch() {
+		availableWorkerCount += p.workerPool.NumberOfQueueableJob()
+	}
 	if !(availableWorkerCount > 0) {
 		return true
 	}
@@ -126,51 +202,139 @@ func (p *poller) poll() (shouldWait bool) {
 	region := p.queueProvider.Properties().Region()
 	maxNumberOfMessages := util.Min(p.conf.PollerConf.MaxNumberOfMessages, int64(availableWorkerCount))
 
-	messages, err := p.queueProvider.ReceiveMessage(maxNumberOfMessages, p.conf.PollerConf.VisibilityTimeoutInSeconds)
-	if err != nil { // todo check wait time according to error / check error
-		logrus.Errorf("Poller[%s] could not receive message: %s", region, err.Error())
+	if !p.backoff.allow() {
+		p.logger.Tracef("Circuit of poller[%s] is open, receiving message is skipped.", region)
 		return true
 	}
 
+	receiveStartedAt := time.Now()
+	messages, err := p.queueProvider.ReceiveMessage(p.ctx, maxNumberOfMessages, p.conf.PollerConf.VisibilityTimeoutInSeconds)
+	if err != nil {
+		if p.ctx.Err() != nil {
+			p.logger.Debugf("Poller[%s] has been interrupted while receiving message.", region)
+			return true
+		}
+		p.onReceiveError(err)
+		return true
+	}
+	p.onReceiveSuccess()
+
 	messageLength := len(messages)
 	if messageLength == 0 {
-		logrus.Tracef("There is no new message in the queue[%s].", region)
+		p.logger.Tracef("There is no new message in the queue[%s].", region)
 		return true
 	}
 
-	logrus.Debugf("Received %d messages from the queue[%s].", messageLength, region)
+	p.logger.Debugf("Received %d messages from the queue[%s].", messageLength, region)
+	messagesReceived.WithLabelValues(p.conf.IntegrationName, region).Add(float64(messageLength))
 
 	for i := 0; i < messageLength; i++ {
 
-		p.queueMessageLogrus.
-			WithField("messageId", *messages[i].MessageId).
-			Info("Message body: ", *messages[i].Body)
+		if p.queueMessageLogrus != nil {
+			p.queueMessageLogrus.
+				WithField("messageId", *messages[i].MessageId).
+				WithField("region", region).
+				Info("Message body: ", redaction.Json(*messages[i].Body))
+		}
 
 		job := newJob(
 			p.queueProvider,
 			p.messageHandler,
+			p.resultSender,
 			*messages[i],
-			p.conf.ApiKey,
-			p.conf.BaseUrl,
 			p.ownerId,
+			p.conf.IntegrationName,
+			p.logger,
 		)
+		job.receiveSpan = p.traceReceive(messages[i], receiveStartedAt)
+		_, job.submitSpan = tracing.Start(tracing.ContextWithSpan(p.ctx, job.receiveSpan), "submit", tracing.Internal)
 
 		isSubmitted, err := p.workerPool.Submit(job)
 		if err != nil {
-			logrus.Debugf("Error occurred while submitting, messages will be terminated: %s.", err.Error())
+			p.logger.Debugf("Error occurred while submitting, messages will be terminated: %s.", err.Error())
+			job.submitSpan.SetError(err)
+			job.submitSpan.End()
 			p.terminateMessageVisibility(messages[i:])
 			return true
 		} else if !isSubmitted {
+			job.submitSpan.SetError(errors.New("Worker pool is busy, message is released."))
+			job.submitSpan.End()
 			p.terminateMessageVisibility(messages[i : i+1])
 		}
 	}
 	return false
 }
 
+// traceReceive records the receipt of the message as the root span of its trace, with the time it has waited in the queue.
+// The span starts when the message is sent, if that is later than the start of the long polling.
+func (p *poller) traceReceive(message *sqs.Message, receiveStartedAt time.Time) *tracing.Span {
+	receivedAt := time.Now()
+
+	var queueLatency time.Duration
+	sentTimestamp, err := strconv.ParseInt(aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]), 10, 64)
+	if err == nil {
+		sentAt := time.Unix(0, sentTimestamp*int64(time.Millisecond))
+		queueLatency = receivedAt.Sub(sentAt)
+		if sentAt.After(receiveStartedAt) && sentAt.Before(receivedAt) {
+			receiveStartedAt = sentAt
+		}
+	}
+
+	_, span := tracing.StartAt(context.Background(), "receive", tracing.Consumer, receiveStartedAt)
+	span.SetAttribute("messaging.system", "aws_sqs")
+	span.SetAttribute("messaging.message_id", aws.StringValue(message.MessageId))
+	span.SetAttribute("cloud.region", p.queueProvider.Properties().Region())
+	span.SetAttribute("oec.integration", p.conf.IntegrationName)
+	if err == nil {
+		span.SetAttribute("oec.queue_latency_ms", queueLatency.Milliseconds())
+	}
+	span.End()
+	return span
+}
+
+func (p *poller) onReceiveError(err error) {
+
+	region := p.queueProvider.Properties().Region()
+	class := classifyError(err)
+	previousState := p.backoff.onFailure(class)
+	state := p.backoff.State()
+
+	pollerReceiveErrors.WithLabelValues(p.conf.IntegrationName, region, string(class)).Inc()
+	p.updateStateMetrics(region, state)
+
+	switch {
+	case state == circuitOpen && previousState != circuitOpen:
+		p.logger.Errorf("Poller[%s] could not receive message after %d attempts, circuit is opened for %s: [%s] %s",
+			region, p.backoff.ConsecutiveFailures(), p.backoff.openInterval.String(), class, err.Error())
+	case previousState == circuitClosed && p.backoff.ConsecutiveFailures() == 1:
+		p.logger.Errorf("Poller[%s] could not receive message, will back off: [%s] %s", region, class, err.Error())
+	default:
+		p.logger.Debugf("Poller[%s] could not receive message, state is %s: [%s] %s", region, state, class, err.Error())
+	}
+}
+
+func (p *poller) onReceiveSuccess() {
+
+	region := p.queueProvider.Properties().Region()
+	previousFailures := p.backoff.ConsecutiveFailures()
+	previousState := p.backoff.onSuccess()
+
+	p.updateStateMetrics(region, circuitClosed)
+
+	if previousFailures > 0 {
+		p.logger.Infof("Poller[%s] has recovered after %d failed attempts, previous circuit state was %s.", region, previousFailures, previousState)
+	}
+}
+
+func (p *poller) updateStateMetrics(region string, state circuitState) {
+	pollerCircuitState.WithLabelValues(p.conf.IntegrationName, region).Set(circuitStateValues[state])
+	pollerConsecutiveFailures.WithLabelValues(p.conf.IntegrationName, region).Set(float64(p.backoff.ConsecutiveFailures()))
+}
+
 func (p *poller) wait(pollingWaitInterval time.Duration) {
 
 	queueUrl := p.queueProvider.Properties().Url()
-	logrus.Tracef("Poller[%s] will wait %s before next polling", queueUrl, pollingWaitInterval.String())
+	p.logger.Tracef("Poller[%s] will wait %s before next polling", queueUrl, pollingWaitInterval.String())
 
 	ticker := time.NewTicker(pollingWaitInterval)
 	defer ticker.Stop()
@@ -178,7 +342,7 @@ func (p *poller) wait(pollingWaitInterval time.Duration) {
 	for {
 		select {
 		case <-p.wakeUp:
-			logrus.Debugf("Poller[%s] has been interrupted while waiting for next polling.", queueUrl)
+			p.logger.Debugf("Poller[%s] has been interrupted while waiting for next polling.", queueUrl)
 			return
 		case <-ticker.C:
 			return
@@ -189,7 +353,7 @@ func (p *poller) wait(pollingWaitInterval time.Duration) {
 func (p *poller) run() {
 
 	queueUrl := p.queueProvider.Properties().Url()
-	logrus.Infof("Poller[%s] has started to run.", queueUrl)
+	p.logger.Infof("Poller[%s] has started to run.", queueUrl)
 
 	pollingWaitInterval := p.conf.PollerConf.PollingWaitIntervalInMillis * time.Millisecond
 	expiredTokenWaitInterval := errorRefreshPeriod
@@ -197,41 +361,34 @@ func (p *poller) run() {
 	for {
 		select {
 		case <-p.quit:
-			logrus.Infof("Poller[%s] has stopped to poll.", queueUrl)
+			p.logger.Infof("Poller[%s] has stopped to poll.", queueUrl)
 			p.isRunningWg.Done()
 			return
 		default:
-			if p.queueProvider.IsTokenExpired() {
+			if p.IsPaused() {
+				p.logger.Tracef("Poller[%s] is paused, receiving message is skipped.", queueUrl)
+				p.wait(pollingWaitInterval)
+			} else if p.queueProvider.IsTokenExpired() {
 				region := p.queueProvider.Properties().Region()
-				logrus.Warnf("Security token is expired, poller[%s] skips to receive message.", region)
+				p.logger.Warnf("Security token is expired, poller[%s] skips to receive message.", region)
 				p.wait(expiredTokenWaitInterval)
 			} else if shouldWait := p.poll(); shouldWait {
-				p.wait(pollingWaitInterval)
+				p.wait(p.backoff.waitInterval(pollingWaitInterval))
 			}
 		}
 	}
 }
 
-func newQueueMessageLogrus(region string) *logrus.Logger {
-	logFilePath := filepath.Join("/var", "log", "opsgenie", "oecQueueMessages-"+region+"-"+strconv.Itoa(os.Getpid())+".log")
-	queueMessageLogger := &lumberjack.Logger{
-		Filename:  logFilePath,
-		MaxSize:   3,  // MB
-		MaxAge:    10, // Days
-		LocalTime: true,
+// newQueueMessageLogrus returns the logger of the received messages, or nil if they are not logged. Its output
+// should be closed once the pollers using it have stopped.
+func newQueueMessageLogrus(logConf *conf.LogConf, integrationName, region string) *logrus.Logger {
+	writer := logging.QueueMessagesWriter(logConf, integrationName, region)
+	if writer == nil {
+		return nil
 	}
 
 	queueMessageLogrus := logrus.New()
-	queueMessageLogrus.SetFormatter(conf.PrepareLogFormat())
-
-	err := queueMessageLogger.Rotate()
-	if err != nil {
-		logrus.Info("Cannot create log file for queueMessages. Reason: ", err)
-	}
-
-	queueMessageLogrus.SetOutput(queueMessageLogger)
-
-	go util.CheckLogFile(queueMessageLogger, time.Second*10)
-
+	queueMessageLogrus.SetFormatter(redaction.NewFormatter(logging.QueueMessagesFormatter(logConf, conf.PrepareLogFormat())))
+	queueMessageLogrus.SetOutput(writer)
 	return queueMessageLogrus
 }
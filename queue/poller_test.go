@@ -1,24 +1,30 @@
 package queue
 
 import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/runbook"
 	"github.com/opsgenie/oec/worker_pool"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var mockPollerConf = &conf.PollerConf{
-	pollingWaitIntervalInMillis,
-	visibilityTimeoutInSec,
-	maxNumberOfMessages,
+	PollingWaitIntervalInMillis: pollingWaitIntervalInMillis,
+	VisibilityTimeoutInSeconds:  visibilityTimeoutInSec,
+	MaxNumberOfMessages:         maxNumberOfMessages,
 }
 
 func newPollerTest() *poller {
 	return &poller{
+		ctx:         context.Background(),
 		quit:        make(chan struct{}),
 		wakeUp:      make(chan struct{}),
 		isRunning:   false,
@@ -34,7 +40,10 @@ func newPollerTest() *poller {
 		workerPool:         NewMockWorkerPool(),
 		queueProvider:      NewMockQueueProvider(),
 		messageHandler:     NewMockMessageHandler(),
+		resultSender:       newAsyncResultSender("", mockApiKey, mockBaseUrl, newIntegrationLogger("")),
 		queueMessageLogrus: &logrus.Logger{},
+		backoff:            newReceiveBackoff(circuitBreakerThreshold, circuitBreakerOpenIntervalInMillis*time.Millisecond),
+		logger:             newIntegrationLogger(""),
 	}
 }
 
@@ -42,11 +51,11 @@ func TestStartAndStopPolling(t *testing.T) {
 
 	poller := newPollerTest()
 
-	err := poller.Start()
+	err := poller.Start(context.Background())
 	assert.Nil(t, err)
 	assert.Equal(t, true, poller.isRunning)
 
-	err = poller.Start()
+	err = poller.Start(context.Background())
 	assert.NotNil(t, err)
 	assert.Equal(t, "Poller is already running.", err.Error())
 	assert.Equal(t, true, poller.isRunning)
@@ -56,6 +65,64 @@ func TestStartAndStopPolling(t *testing.T) {
 	assert.Equal(t, false, poller.isRunning)
 }
 
+func TestStopInterruptsReceive(t *testing.T) {
+
+	poller := newPollerTest()
+
+	poller.workerPool.(*MockWorkerPool).NumberOfAvailableWorkerFunc = func() int32 {
+		return 1
+	}
+
+	receiving := make(chan struct{}, 1)
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(i int64, i2 int64) ([]*sqs.Message, error) {
+		receiving <- struct{}{}
+		<-poller.ctx.Done()
+		return nil, poller.ctx.Err()
+	}
+
+	err := poller.Start(context.Background())
+	assert.Nil(t, err)
+	<-receiving
+
+	start := time.Now()
+	err = poller.Stop()
+
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 0, poller.backoff.ConsecutiveFailures())
+}
+
+func TestPausedPollerDoesNotReceive(t *testing.T) {
+
+	poller := newPollerTest()
+
+	poller.workerPool.(*MockWorkerPool).NumberOfAvailableWorkerFunc = func() int32 {
+		return 1
+	}
+
+	receiveCount := int32(0)
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(i int64, i2 int64) ([]*sqs.Message, error) {
+		atomic.AddInt32(&receiveCount, 1)
+		return []*sqs.Message{}, nil
+	}
+
+	poller.Pause()
+	assert.True(t, poller.Status().IsPaused)
+
+	err := poller.Start(context.Background())
+	assert.Nil(t, err)
+
+	time.Sleep(3 * pollingWaitIntervalInMillis * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&receiveCount))
+
+	poller.Resume()
+	assert.False(t, poller.Status().IsPaused)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&receiveCount) > 0 }, time.Second, time.Millisecond)
+
+	err = poller.Stop()
+	assert.Nil(t, err)
+}
+
 func TestStopPollingNonPollingState(t *testing.T) {
 
 	poller := newPollerTest()
@@ -92,6 +159,30 @@ func TestPollWithReceiveError(t *testing.T) {
 	assert.True(t, shouldWait)
 }
 
+func TestPollOpensCircuitAfterReceiveErrors(t *testing.T) {
+
+	poller := newPollerTest()
+
+	poller.workerPool.(*MockWorkerPool).NumberOfAvailableWorkerFunc = func() int32 {
+		return 1
+	}
+
+	receiveCount := 0
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(i int64, i2 int64) ([]*sqs.Message, error) {
+		receiveCount++
+		return nil, errors.New("Receive Error")
+	}
+
+	for i := 0; i < circuitBreakerThreshold+2; i++ {
+		shouldWait := poller.poll()
+		assert.True(t, shouldWait)
+	}
+
+	assert.Equal(t, circuitBreakerThreshold, receiveCount)
+	assert.Equal(t, circuitOpen, poller.backoff.State())
+	assert.True(t, poller.backoff.waitInterval(pollingWaitIntervalInMillis*time.Millisecond) > time.Second)
+}
+
 func TestPollZeroMessage(t *testing.T) {
 
 	poller := newPollerTest()
@@ -129,6 +220,106 @@ func TestPollMaxMessage(t *testing.T) {
 	assert.Equal(t, expected, maxNumberOfMessages)
 }
 
+func TestPollPrefetchesIntoQueueOfPool(t *testing.T) {
+
+	poller := newPollerTest()
+	poller.conf.PollerConf.PrefetchEnabled = true
+
+	poller.workerPool.(*MockWorkerPool).NumberOfAvailableWorkerFunc = func() int32 {
+		return 0
+	}
+	poller.workerPool.(*MockWorkerPool).NumberOfQueueableJobFunc = func() int32 {
+		return 3
+	}
+
+	maxNumberOfMessages := 0
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error) {
+		maxNumberOfMessages = int(numOfMessage)
+		return nil, errors.New("Receive Error")
+	}
+
+	shouldWait := poller.poll()
+	assert.True(t, shouldWait)
+	assert.Equal(t, 3, maxNumberOfMessages)
+
+	// the queued jobs have waited for half of the visibility timeout, the messages would expire while waiting
+	poller.workerPool.(*MockWorkerPool).OldestQueuedJobAgeFunc = func() time.Duration {
+		return visibilityTimeoutInSec * time.Second / 2
+	}
+	maxNumberOfMessages = 0
+
+	shouldWait = poller.poll()
+	assert.True(t, shouldWait)
+	assert.Equal(t, 0, maxNumberOfMessages)
+
+	poller.workerPool.(*MockWorkerPool).OldestQueuedJobAgeFunc = nil
+	poller.conf.PollerConf.PrefetchEnabled = false
+
+	shouldWait = poller.poll()
+	assert.True(t, shouldWait)
+	assert.Equal(t, 0, maxNumberOfMessages)
+}
+
+func TestPrefetchedUrgentMessageOvertakesQueuedMessages(t *testing.T) {
+
+	poller := newPollerTest()
+	poller.conf.PollerConf.PrefetchEnabled = true
+
+	workerPool := worker_pool.New(&conf.PoolConf{MaxNumberOfWorker: 1, MinNumberOfWorker: 1, QueueSize: 3})
+	err := workerPool.Start()
+	assert.Nil(t, err)
+	poller.workerPool = workerPool
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handled := make(chan string, 4)
+	poller.messageHandler = &MockMessageHandler{
+		HandleFunc: func(message sqs.Message) (*runbook.ActionResultPayload, error) {
+			if *message.MessageId == "running" {
+				close(started)
+				<-finish
+			}
+			handled <- *message.MessageId
+			return &runbook.ActionResultPayload{}, nil
+		},
+	}
+	poller.resultSender = &MockResultSender{}
+
+	newMessage := func(id, priority string) *sqs.Message {
+		body := `{"action":"Create","alert":{"priority":"` + priority + `"}}`
+		return &sqs.Message{
+			MessageId:         aws.String(id),
+			Body:              &body,
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{ownerId: {StringValue: aws.String(poller.ownerId)}},
+		}
+	}
+	receives := [][]*sqs.Message{
+		{newMessage("running", "P3"), newMessage("enrich-1", "P5"), newMessage("enrich-2", "P5")},
+		{newMessage("restart", "P1")},
+	}
+	numbersOfMessages := make([]int64, 0)
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error) {
+		numbersOfMessages = append(numbersOfMessages, numOfMessage)
+		messages := receives[0]
+		receives = receives[1:]
+		return messages, nil
+	}
+
+	assert.False(t, poller.poll())
+	<-started
+
+	// the only worker is busy, the urgent message is received for the room left in the queue
+	assert.False(t, poller.poll())
+	close(finish)
+
+	order := []string{<-handled, <-handled, <-handled, <-handled}
+	assert.Equal(t, []string{"running", "restart", "enrich-1", "enrich-2"}, order)
+	assert.Equal(t, []int64{4, 1}, numbersOfMessages)
+
+	err = workerPool.Stop(context.Background())
+	assert.Nil(t, err)
+}
+
 func TestPollMaxMessageUpperBound(t *testing.T) {
 
 	poller := newPollerTest()
@@ -239,6 +430,9 @@ type MockPoller struct {
 
 	RefreshClientFunc func(assumeRoleResult AssumeRoleResult) error
 	QueueProviderFunc func() SQSProvider
+	StatusFunc        func() PollerStatus
+	PauseFunc         func()
+	ResumeFunc        func()
 }
 
 func NewMockPoller() Poller {
@@ -246,11 +440,11 @@ func NewMockPoller() Poller {
 }
 
 func NewMockPollerForQueueProcessor(workerPool worker_pool.WorkerPool, queueProvider SQSProvider,
-	messageHandler MessageHandler, conf *conf.Configuration, ownerId string) Poller {
+	messageHandler MessageHandler, resultSender ResultSender, conf *conf.Configuration, ownerId string, queueMessageLogrus *logrus.Logger) Poller {
 	return NewMockPoller()
 }
 
-func (p *MockPoller) Start() error {
+func (p *MockPoller) Start(ctx context.Context) error {
 	if p.StartPollingFunc != nil {
 		return p.StartPollingFunc()
 	}
@@ -271,6 +465,25 @@ func (p *MockPoller) RefreshClient(assumeRoleResult AssumeRoleResult) error {
 	return nil
 }
 
+func (p *MockPoller) Status() PollerStatus {
+	if p.StatusFunc != nil {
+		return p.StatusFunc()
+	}
+	return PollerStatus{}
+}
+
+func (p *MockPoller) Pause() {
+	if p.PauseFunc != nil {
+		p.PauseFunc()
+	}
+}
+
+func (p *MockPoller) Resume() {
+	if p.ResumeFunc != nil {
+		p.ResumeFunc()
+	}
+}
+
 func (p *MockPoller) QueueProvider() SQSProvider {
 	if p.QueueProviderFunc != nil {
 		return p.QueueProviderFunc()
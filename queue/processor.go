@@ -2,17 +2,21 @@ package queue
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/deadletter"
 	"github.com/opsgenie/oec/git"
+	"github.com/opsgenie/oec/logging"
+	"github.com/opsgenie/oec/outbox"
 	"github.com/opsgenie/oec/retryer"
 	"github.com/opsgenie/oec/worker_pool"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/natefinch/lumberjack.v2"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -25,8 +29,13 @@ const (
 	visibilityTimeoutInSec      = 30
 	maxNumberOfMessages         = 10
 
-	successRefreshPeriod = time.Minute
-	errorRefreshPeriod   = time.Minute
+	circuitBreakerThreshold            = 5
+	circuitBreakerOpenIntervalInMillis = 60000
+	batchFlushIntervalInMillis         = 50
+
+	successRefreshPeriod          = time.Minute
+	errorRefreshPeriod            = time.Minute
+	credentialRefreshMarginInSecs = 300
 
 	repositoryRefreshPeriod = time.Minute
 )
@@ -36,8 +45,19 @@ const tokenPath = "/v1/oec/credentials"
 var newPollerFunc = NewPoller
 
 type Processor interface {
-	Start() error
-	Stop() error
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+	Status() Status
+
+	// PausePollers stops receiving messages from the queue, or from all queues of the integration if the queue
+	// url is empty, until they are resumed. Empty integration matches all. It returns the number of pollers.
+	PausePollers(integration, queueUrl string) int
+	ResumePollers(integration, queueUrl string) int
+	PullRepositories() []git.RepositoryStatus
+	Jobs() []JobStatus
+	CancelJob(id string) bool
+	// Reload replaces the action specifications of the running integrations with the given ones.
+	Reload(configurations []*conf.Configuration) error
 }
 
 type processor struct {
@@ -46,13 +66,41 @@ type processor struct {
 
 	retryer *retryer.Retryer
 
-	configuration *conf.Configuration
-	repositories  git.Repositories
-	actionLoggers map[string]io.Writer
+	configuration   *conf.Configuration
+	repositories    git.Repositories
+	actionSpecs     *actionSpecs
+	actionLoggers   map[string]io.Writer
+	deadLetterStore deadletter.Store
+	captureStore    *captureStore
+	tokenCache      tokenCache
+	scheduler       *refreshScheduler
+	resultSender    resultDispatcher
+
+	// loggers of the received messages by region, shared by the pollers and closed when the processor stops
+	queueMessageLoggers map[string]*logrus.Logger
 
+	// used for the queues which do not have their own periods and while there is not any queue
 	successRefreshPeriod time.Duration
 	errorRefreshPeriod   time.Duration
 
+	// repositories are downloaded, pulled and removed by the owner if they are shared among processors
+	ownsRepositories bool
+	// fencing token of the lease while running as the leader of high availability instances
+	fencingToken uint64
+	logger       *logrus.Entry
+
+	// state served by Status, since the pollers and the repositories are not safe to access during refreshes
+	state              IntegrationStatus
+	activePollers      []Poller
+	activeRepositories git.Repositories
+	statusMu           *sync.RWMutex
+
+	// queues which are paused through the admin API, also applied to the pollers added after that
+	isPaused        bool
+	pausedQueueUrls map[string]struct{}
+
+	ctx         context.Context
+	cancel      context.CancelFunc
 	isRunning   bool
 	isRunningWg *sync.WaitGroup
 	startStopMu *sync.Mutex
@@ -60,6 +108,11 @@ type processor struct {
 }
 
 func NewProcessor(conf *conf.Configuration) Processor {
+	return newProcessor(conf, git.NewRepositories(), NewActionLoggers(&conf.LogConf, conf.ActionMappings), newDeadLetterStore(conf), true)
+}
+
+func newProcessor(conf *conf.Configuration, repositories git.Repositories, actionLoggers map[string]io.Writer,
+	deadLetterStore deadletter.Store, ownsRepositories bool) *processor {
 
 	if conf.PollerConf.MaxNumberOfMessages <= 0 {
 		logrus.Infof("Max number of messages should be greater than 0, default value[%d] is set.", maxNumberOfMessages)
@@ -76,14 +129,77 @@ func NewProcessor(conf *conf.Configuration) Processor {
 		conf.PollerConf.VisibilityTimeoutInSeconds = visibilityTimeoutInSec
 	}
 
+	if conf.PollerConf.CircuitBreakerThreshold <= 0 {
+		logrus.Infof("Circuit breaker threshold should be greater than 0, default value[%d] is set.", circuitBreakerThreshold)
+		conf.PollerConf.CircuitBreakerThreshold = circuitBreakerThreshold
+	}
+
+	if conf.PollerConf.CircuitBreakerOpenIntervalInMillis <= 0 {
+		logrus.Infof("Circuit breaker open interval should be greater than 0, default value[%d ms.] is set.", circuitBreakerOpenIntervalInMillis)
+		conf.PollerConf.CircuitBreakerOpenIntervalInMillis = circuitBreakerOpenIntervalInMillis
+	}
+
+	if conf.PollerConf.BatchFlushIntervalInMillis <= 0 {
+		logrus.Infof("Batch flush interval should be greater than 0, default value[%d ms.] is set.", batchFlushIntervalInMillis)
+		conf.PollerConf.BatchFlushIntervalInMillis = batchFlushIntervalInMillis
+	}
+
+	if conf.PollerConf.CredentialRefreshMarginInSeconds <= 0 {
+		logrus.Infof("Credential refresh margin should be greater than 0, default value[%d s.] is set.", credentialRefreshMarginInSecs)
+		conf.PollerConf.CredentialRefreshMarginInSeconds = credentialRefreshMarginInSecs
+	}
+
+	var cache tokenCache
+	if !conf.TokenCacheConf.Disabled {
+		var err error
+		cache, err = newTokenCache(conf)
+		if err != nil {
+			logrus.Errorf("Token cache could not be created, processor will not be able to start without Opsgenie: %s", err)
+		}
+	}
+
+	logger := newIntegrationLogger(conf.IntegrationName)
+
+	var captures *captureStore
+	if conf.ActionOutputConf.CaptureEnabled {
+		var err error
+		captures, err = newCaptureStore(&conf.ActionOutputConf)
+		if err != nil {
+			logger.Errorf("Capture store could not be created, outputs of the executions will not be captured: %s", err)
+		}
+	}
+
+	var resultSender resultDispatcher = newAsyncResultSender(conf.IntegrationName, conf.ApiKey, conf.BaseUrl, logger)
+	if conf.OutboxConf.Disabled {
+		logger.Warnf("Outbox is disabled, action results will be tried once and the ones being sent will be lost if OEC crashes.")
+	} else {
+		resultOutbox, err := outbox.New(&conf.OutboxConf, conf.IntegrationName, conf.ApiKey, conf.BaseUrl, logger)
+		if err != nil {
+			logger.Errorf("Outbox could not be created, action results will be tried once and the ones being sent will be lost if OEC crashes: %s", err)
+		} else {
+			resultSender = resultOutbox
+		}
+	}
+
 	return &processor{
 		successRefreshPeriod: successRefreshPeriod,
 		errorRefreshPeriod:   errorRefreshPeriod,
 		workerPool:           worker_pool.New(&conf.PoolConf),
 		configuration:        conf,
-		repositories:         git.NewRepositories(),
-		actionLoggers:        newActionLoggers(conf.ActionMappings),
+		repositories:         repositories,
+		actionSpecs:          newActionSpecs(conf.ActionSpecifications),
+		actionLoggers:        actionLoggers,
+		deadLetterStore:      deadLetterStore,
+		captureStore:         captures,
+		tokenCache:           cache,
+		scheduler:            newRefreshScheduler(time.Duration(conf.PollerConf.CredentialRefreshMarginInSeconds)*time.Second, successRefreshPeriod, errorRefreshPeriod),
+		resultSender:         resultSender,
+		ownsRepositories:     ownsRepositories,
+		logger:               logger,
 		pollers:              make(map[string]Poller),
+		state:                IntegrationStatus{Name: conf.IntegrationName},
+		statusMu:             &sync.RWMutex{},
+		pausedQueueUrls:      make(map[string]struct{}),
 		quit:                 make(chan struct{}),
 		isRunning:            false,
 		isRunningWg:          &sync.WaitGroup{},
@@ -92,7 +208,7 @@ func NewProcessor(conf *conf.Configuration) Processor {
 	}
 }
 
-func (qp *processor) Start() error {
+func (qp *processor) Start(ctx context.Context) error {
 	defer qp.startStopMu.Unlock()
 	qp.startStopMu.Lock()
 
@@ -100,35 +216,73 @@ func (qp *processor) Start() error {
 		return errors.New("Queue processor is already running.")
 	}
 
-	logrus.Infof("Queue processor is starting.")
+	qp.logger.Infof("Queue processor is starting.")
+	qp.ctx, qp.cancel = context.WithCancel(ctx)
+
+	refreshPeriod := qp.successRefreshPeriod
+	isCachedToken := false
 	token, err := qp.receiveToken()
+	qp.onTokenRefresh(err)
 	if err != nil {
-		logrus.Errorf("Queue processor could not get initial token and will terminate.")
-		return err
+		cachedToken, cacheErr := qp.loadCachedToken()
+		if cacheErr != nil {
+			qp.cancel()
+			qp.logger.Errorf("Queue processor could not get initial token and will terminate. Cached token could not be used: %s", cacheErr)
+			return err
+		}
+
+		qp.logger.Warnf("Queue processor could not get initial token, pollers will start with the cached token until it is refreshed: %s", err)
+		token = cachedToken
+		isCachedToken = true
+		refreshPeriod = qp.errorRefreshPeriod
 	}
 
-	err = qp.repositories.DownloadAll(qp.configuration.ActionMappings.GitActions())
-	if err != nil {
-		logrus.Errorf("Queue processor could not clone a git repository and will terminate.")
-		return err
+	if qp.ownsRepositories {
+		err = qp.repositories.DownloadAll(qp.configuration.ActionMappings.GitActions())
+		if err != nil {
+			qp.cancel()
+			qp.logger.Errorf("Queue processor could not clone a git repository and will terminate.")
+			return err
+		}
 	}
 
 	if qp.repositories.NotEmpty() {
-		qp.isRunningWg.Add(1) // one for pulling repositories
-		go qp.startPullingRepositories(repositoryRefreshPeriod)
+		if qp.ownsRepositories {
+			qp.isRunningWg.Add(1) // one for pulling repositories
+			go qp.startPullingRepositories(repositoryRefreshPeriod)
+			qp.setActiveRepositories(qp.repositories)
+		}
 
 		conf.AddRepositoryPathToGitActionFilepaths(qp.configuration.ActionMappings, qp.repositories)
 	}
+	err = qp.resultSender.Start()
+	if err != nil {
+		// results would only pile up in the memory of the outbox, they are tried once instead
+		qp.logger.Errorf("Outbox could not be started, action results will be tried once and the ones being sent will be lost if OEC crashes: %s", err)
+		resultSender := newAsyncResultSender(qp.configuration.IntegrationName, qp.configuration.ApiKey, qp.configuration.BaseUrl, qp.logger)
+		resultSender.Start()
+		qp.statusMu.Lock()
+		qp.resultSender = resultSender
+		qp.statusMu.Unlock()
+	}
 	qp.workerPool.Start()
+	workerPools.add(qp.configuration.IntegrationName, qp.workerPool)
 	qp.refreshPollers(token)
+	if isCachedToken {
+		qp.scheduler.onFailureAll(time.Now())
+	}
 	qp.isRunningWg.Add(1) // one for receiving token
-	go qp.run()
+	go qp.run(refreshPeriod)
 
 	qp.isRunning = true
+	qp.setRunning(true)
 	return nil
 }
 
-func (qp *processor) Stop() error {
+// Stop stops polling and token refreshing at once and releases the messages which have not started to be processed.
+// Then it waits the running jobs until the given context is done; the jobs still running after that are cancelled
+// and their results are sent to Opsgenie as cancelled due to shutdown.
+func (qp *processor) Stop(ctx context.Context) error {
 	defer qp.startStopMu.Unlock()
 	qp.startStopMu.Lock()
 
@@ -136,16 +290,32 @@ func (qp *processor) Stop() error {
 		return errors.New("Queue processor is not running.")
 	}
 
-	logrus.Infof("Queue processor is stopping.")
+	qp.logger.Infof("Queue processor is stopping.")
+	qp.setRunning(false)
 
 	close(qp.quit)
+	qp.cancel()
 	qp.isRunningWg.Wait()
+	qp.closeQueueMessageLoggers()
 
-	qp.workerPool.Stop()
-	qp.repositories.RemoveAll()
+	err := qp.workerPool.Stop(ctx)
+	if err != nil {
+		qp.logger.Warnf("Worker pool could not be stopped gracefully: %s", err)
+	}
+	workerPools.remove(qp.configuration.IntegrationName, qp.workerPool)
+
+	resultCtx, cancel := context.WithTimeout(context.Background(), qp.configuration.ShutdownConf.ResultTimeout())
+	defer cancel()
+	err = qp.resultSender.Stop(resultCtx)
+	if err != nil {
+		qp.logger.Warnf("Some of the action results could not be sent to Opsgenie before shutdown: %s", err)
+	}
+	if qp.ownsRepositories {
+		qp.repositories.RemoveAll()
+	}
 
 	qp.isRunning = false
-	logrus.Infof("Queue processor has stopped.")
+	qp.logger.Infof("Queue processor has stopped.")
 	return nil
 }
 
@@ -158,6 +328,12 @@ func (qp *processor) receiveToken() (*token, error) {
 		return nil, err
 	}
 
+	ctx := qp.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	request.Request = request.Request.WithContext(ctx)
+
 	request.Header.Add("Authorization", "GenieKey "+qp.configuration.ApiKey)
 	request.Header.Add("X-OEC-Client-Info", UserAgentHeader)
 
@@ -193,37 +369,128 @@ func (qp *processor) receiveToken() (*token, error) {
 	return token, nil
 }
 
+// loadCachedToken returns the cached token with the queues whose credentials have not expired yet.
+func (qp *processor) loadCachedToken() (*token, error) {
+
+	if qp.tokenCache == nil {
+		return nil, errors.New("Token cache is disabled.")
+	}
+
+	cachedToken, err := qp.tokenCache.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	nowMillis := time.Now().UnixNano() / int64(time.Millisecond)
+	validQueueProperties := make([]Properties, 0, len(cachedToken.QueuePropertiesList))
+	for _, queueProperties := range cachedToken.QueuePropertiesList {
+		if queueProperties.ExpireTimeMillis() > nowMillis {
+			validQueueProperties = append(validQueueProperties, queueProperties)
+		}
+	}
+
+	if len(validQueueProperties) == 0 {
+		return nil, errors.New("Credentials of the cached token have expired.")
+	}
+
+	cachedToken.QueuePropertiesList = validQueueProperties
+	return cachedToken, nil
+}
+
+// cacheToken saves the properties of the current pollers, since a received token contains
+// only the credentials which are refreshed.
+func (qp *processor) cacheToken(ownerId string) {
+
+	if qp.tokenCache == nil {
+		return
+	}
+
+	currentToken := &token{OwnerId: ownerId}
+	for _, poller := range qp.pollers {
+		currentToken.QueuePropertiesList = append(currentToken.QueuePropertiesList, poller.QueueProvider().Properties())
+	}
+
+	err := qp.tokenCache.Save(currentToken)
+	if err != nil {
+		qp.logger.Warnf("Token could not be cached: %s", err)
+	}
+}
+
 func (qp *processor) addPoller(queueProperties Properties, ownerId string) (Poller, error) {
 
-	queueProvider, err := NewSqsProvider(queueProperties)
+	sqsProvider, err := NewSqsProvider(queueProperties)
 	if err != nil {
 		return nil, err
 	}
+	queueProvider := newBatchingSQSProvider(sqsProvider, qp.configuration.PollerConf.BatchFlushIntervalInMillis*time.Millisecond)
 
 	messageHandler := &messageHandler{
-		repositories:  qp.repositories,
-		actionSpecs:   qp.configuration.ActionSpecifications,
-		actionLoggers: qp.actionLoggers,
+		repositories:    qp.repositories,
+		actionSpecs:     qp.actionSpecs,
+		actionLoggers:   qp.actionLoggers,
+		deadLetterStore: qp.deadLetterStore,
+		outputConf:      qp.configuration.ActionOutputConf,
+		captureStore:    qp.captureStore,
+		integrationName: qp.configuration.IntegrationName,
+		fencingToken:    qp.fencingToken,
+		logger:          qp.logger,
 	}
 
 	poller := newPollerFunc(
 		qp.workerPool,
 		queueProvider,
 		messageHandler,
+		qp.resultSender,
 		qp.configuration,
 		ownerId,
+		qp.queueMessageLogger(queueProvider.Properties().Region()),
 	)
 	qp.pollers[queueProvider.Properties().Url()] = poller
 	return poller, nil
 }
 
+// queueMessageLogger returns the logger of the messages received from the queues of the region, or nil if they
+// are not logged.
+func (qp *processor) queueMessageLogger(region string) *logrus.Logger {
+	if logger, ok := qp.queueMessageLoggers[region]; ok {
+		return logger
+	}
+
+	if qp.queueMessageLoggers == nil {
+		qp.queueMessageLoggers = make(map[string]*logrus.Logger)
+	}
+	logger := newQueueMessageLogrus(&qp.configuration.LogConf, qp.configuration.IntegrationName, region)
+	qp.queueMessageLoggers[region] = logger
+	return logger
+}
+
+// closeQueueMessageLoggers closes the log files or connections of the received messages, it should be called
+// after the pollers have stopped.
+func (qp *processor) closeQueueMessageLoggers() {
+	for region, logger := range qp.queueMessageLoggers {
+		if logger == nil {
+			continue
+		}
+		if closer, ok := logger.Out.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				qp.logger.Warnf("Logger of the queue messages of region[%s] could not be closed: %s", region, err)
+			}
+		}
+	}
+	qp.queueMessageLoggers = nil
+}
+
 func (qp *processor) removePoller(queueUrl string) Poller {
 	poller := qp.pollers[queueUrl]
 	delete(qp.pollers, queueUrl)
 	return poller
 }
 
+// refreshPollers adds and removes pollers according to the token, and refreshes the clients of the
+// queues whose credentials are renewed. Refresh of each queue is scheduled on its own.
 func (qp *processor) refreshPollers(token *token) {
+	now := time.Now()
+
 	pollerKeys := make(map[string]struct{}, len(qp.pollers))
 	for key := range qp.pollers {
 		pollerKeys[key] = struct{}{}
@@ -238,9 +505,17 @@ func (qp *processor) refreshPollers(token *token) {
 			if isTokenRefreshed {
 				err := poller.RefreshClient(queueProperties.AssumeRoleResult)
 				if err != nil {
-					logrus.Errorf("Client of queue provider[%s] could not be refreshed.", queueUrl)
+					schedule := qp.scheduler.onFailure(queueProperties, now)
+					qp.logger.Errorf("Client of queue provider[%s] could not be refreshed, will be retried after %s: %s",
+						queueUrl, schedule.errorPeriod.String(), err)
+				} else {
+					schedule := qp.scheduler.onSuccess(queueProperties, now)
+					qp.logger.Infof("Client of queue provider[%s] has refreshed, next refresh is at %s.", queueUrl, schedule.next.Format(time.RFC3339))
 				}
-				logrus.Infof("Client of queue provider[%s] has refreshed.", queueUrl)
+			} else if qp.scheduler.isDue(queueUrl, now) {
+				currentProperties := poller.QueueProvider().Properties()
+				currentProperties.Configuration = queueProperties.Configuration
+				qp.scheduleNotRefreshed(currentProperties, now)
 			}
 			delete(pollerKeys, queueUrl)
 
@@ -248,60 +523,319 @@ func (qp *processor) refreshPollers(token *token) {
 		} else {
 			poller, err := qp.addPoller(queueProperties, token.OwnerId)
 			if err != nil {
-				logrus.Errorf("Poller[%s] could not be added: %s.", queueUrl, err)
+				schedule := qp.scheduler.onFailure(queueProperties, now)
+				qp.logger.Errorf("Poller[%s] could not be added, will be retried after %s: %s.", queueUrl, schedule.errorPeriod.String(), err)
 				continue
 			}
-			poller.Start()
-			logrus.Debugf("Poller[%s] is added.", queueUrl)
+			if qp.isPollerPaused(queueUrl) {
+				poller.Pause()
+			}
+			poller.Start(qp.ctx)
+			qp.scheduler.onSuccess(queueProperties, now)
+			qp.logger.Debugf("Poller[%s] is added.", queueUrl)
 		}
 	}
 
 	// remove unnecessary pollers
 	for queueUrl := range pollerKeys {
 		qp.removePoller(queueUrl).Stop()
-		logrus.Debugf("Poller[%s] is removed.", queueUrl)
+		qp.logger.Debugf("Poller[%s] is removed.", queueUrl)
 	}
+	qp.scheduler.retain(token.QueuePropertiesList)
+	qp.setActivePollers()
+
+	qp.cacheToken(token.OwnerId)
+}
+
+// scheduleNotRefreshed schedules a queue which was due but has not got new credentials. Opsgenie renews
+// credentials only when they are close to expire, so it is a failure only if they are within the margin.
+func (qp *processor) scheduleNotRefreshed(queueProperties Properties, now time.Time) {
 
-	if len(token.QueuePropertiesList) != 0 { // pick first Properties to refresh waitPeriods, can be change for further usage
-		qp.successRefreshPeriod = time.Second * time.Duration(token.QueuePropertiesList[0].Configuration.SuccessRefreshPeriodInSeconds)
-		qp.errorRefreshPeriod = time.Second * time.Duration(token.QueuePropertiesList[0].Configuration.ErrorRefreshPeriodInSeconds)
+	if qp.scheduler.isExpiring(queueProperties, now) {
+		schedule := qp.scheduler.onFailure(queueProperties, now)
+		qp.logger.Warnf("Credentials of queue provider[%s] expire at %s but have not been refreshed, will be retried after %s. Consecutive failures: %d",
+			queueProperties.Url(), schedule.expireTime.Format(time.RFC3339), schedule.errorPeriod.String(), schedule.consecutiveFailures)
+		return
 	}
+
+	qp.scheduler.onSuccess(queueProperties, now)
 }
 
-func (qp *processor) run() {
+func (qp *processor) run(refreshPeriod time.Duration) {
 
-	logrus.Infof("Queue processor has started to run. Refresh client period: %s.", qp.successRefreshPeriod.String())
+	qp.logger.Infof("Queue processor has started to run. Credentials will be refreshed %s before they expire.", qp.scheduler.margin.String())
 
-	ticker := time.NewTicker(qp.successRefreshPeriod)
+	timer := time.NewTimer(qp.scheduleRefresh(refreshPeriod))
 
 	for {
 		select {
 		case <-qp.quit:
-			ticker.Stop()
+			timer.Stop()
 			for _, poller := range qp.pollers {
 				poller.Stop()
 			}
 			qp.isRunningWg.Done()
 			return
-		case <-ticker.C:
-			ticker.Stop()
+		case <-timer.C:
 			token, err := qp.receiveToken()
+			qp.onTokenRefresh(err)
 			if err != nil {
-				logrus.Warnf("Refresh cycle of queue processor has failed: %s", err)
-				logrus.Debugf("Will refresh token after %s", qp.errorRefreshPeriod.String())
-				ticker = time.NewTicker(qp.errorRefreshPeriod)
+				qp.scheduler.onFailureDue(time.Now())
+				nextRefresh := qp.scheduleRefresh(qp.errorRefreshPeriod)
+				qp.logger.Warnf("Refresh cycle of queue processor has failed: %s", err)
+				qp.logger.Debugf("Will refresh token after %s", nextRefresh.String())
+				timer.Reset(nextRefresh)
 				break
 			}
 			qp.refreshPollers(token)
 
-			ticker = time.NewTicker(qp.successRefreshPeriod)
+			timer.Reset(qp.scheduleRefresh(qp.successRefreshPeriod))
+		}
+	}
+}
+
+// Status returns the state of the processor, its pollers, pool and outbox.
+func (qp *processor) Status() Status {
+	status := qp.integrationStatus()
+	return Status{
+		IsRunning:    status.IsRunning,
+		Integrations: []IntegrationStatus{status},
+		Repositories: qp.repositoryStatus(),
+	}
+}
+
+func (qp *processor) integrationStatus() IntegrationStatus {
+	qp.statusMu.RLock()
+	status := qp.state
+	activePollers := qp.activePollers
+	resultSender := qp.resultSender
+	qp.statusMu.RUnlock()
+
+	status.Pollers = make([]PollerStatus, 0, len(activePollers))
+	for _, poller := range activePollers {
+		status.Pollers = append(status.Pollers, poller.Status())
+	}
+	sort.Slice(status.Pollers, func(i, j int) bool {
+		return status.Pollers[i].QueueUrl < status.Pollers[j].QueueUrl
+	})
+
+	status.Pool = qp.workerPool.Status()
+	status.OutboxDepth = resultSender.Depth()
+	return status
+}
+
+func (qp *processor) repositoryStatus() []git.RepositoryStatus {
+	qp.statusMu.RLock()
+	defer qp.statusMu.RUnlock()
+	return qp.activeRepositories.Status()
+}
+
+func (qp *processor) setRunning(isRunning bool) {
+	qp.statusMu.Lock()
+	defer qp.statusMu.Unlock()
+	qp.state.IsRunning = isRunning
+	if !isRunning {
+		qp.activePollers = nil
+		qp.activeRepositories = nil
+	}
+}
+
+func (qp *processor) setActivePollers() {
+	activePollers := make([]Poller, 0, len(qp.pollers))
+	for _, poller := range qp.pollers {
+		activePollers = append(activePollers, poller)
+	}
+
+	qp.statusMu.Lock()
+	defer qp.statusMu.Unlock()
+	qp.activePollers = activePollers
+}
+
+func (qp *processor) setActiveRepositories(repositories git.Repositories) {
+	qp.statusMu.Lock()
+	defer qp.statusMu.Unlock()
+	qp.activeRepositories = copyRepositories(repositories)
+}
+
+func (qp *processor) onTokenRefresh(err error) {
+	qp.statusMu.Lock()
+	defer qp.statusMu.Unlock()
+	if err != nil {
+		qp.state.TokenRefresh.onFailure(time.Now(), err)
+		tokenRefreshes.WithLabelValues(qp.configuration.IntegrationName, "failure").Inc()
+	} else {
+		qp.state.TokenRefresh.onSuccess(time.Now())
+		tokenRefreshes.WithLabelValues(qp.configuration.IntegrationName, "success").Inc()
+	}
+}
+
+// scheduleRefresh returns the duration until the next refresh, and keeps its time for the status.
+func (qp *processor) scheduleRefresh(period time.Duration) time.Duration {
+	now := time.Now()
+	nextRefresh := qp.scheduler.nextRefresh(now, period)
+	nextRefreshAt := now.Add(nextRefresh)
+
+	qp.statusMu.Lock()
+	defer qp.statusMu.Unlock()
+	qp.state.TokenRefresh.NextRefreshAt = &nextRefreshAt
+	return nextRefresh
+}
+
+func (qp *processor) PausePollers(integration, queueUrl string) int {
+	return qp.setPollersPaused(integration, queueUrl, true)
+}
+
+func (qp *processor) ResumePollers(integration, queueUrl string) int {
+	return qp.setPollersPaused(integration, queueUrl, false)
+}
+
+func (qp *processor) setPollersPaused(integration, queueUrl string, isPaused bool) int {
+
+	if integration != "" && integration != qp.configuration.IntegrationName {
+		return 0
+	}
+
+	qp.statusMu.Lock()
+	defer qp.statusMu.Unlock()
+
+	switch {
+	case queueUrl == "":
+		qp.isPaused = isPaused
+		qp.pausedQueueUrls = make(map[string]struct{})
+	case isPaused:
+		qp.pausedQueueUrls[queueUrl] = struct{}{}
+	default:
+		if qp.isPaused {
+			qp.isPaused = false
+			for _, poller := range qp.activePollers {
+				qp.pausedQueueUrls[poller.QueueProvider().Properties().Url()] = struct{}{}
+			}
+		}
+		delete(qp.pausedQueueUrls, queueUrl)
+	}
+
+	count := 0
+	for _, poller := range qp.activePollers {
+		if queueUrl != "" && poller.QueueProvider().Properties().Url() != queueUrl {
+			continue
+		}
+		if isPaused {
+			poller.Pause()
+		} else {
+			poller.Resume()
+		}
+		count++
+	}
+	return count
+}
+
+func (qp *processor) isPollerPaused(queueUrl string) bool {
+	qp.statusMu.RLock()
+	defer qp.statusMu.RUnlock()
+	_, isPaused := qp.pausedQueueUrls[queueUrl]
+	return qp.isPaused || isPaused
+}
+
+// PullRepositories pulls the repositories at once if the processor owns them, and returns their status.
+func (qp *processor) PullRepositories() []git.RepositoryStatus {
+	qp.statusMu.RLock()
+	repositories := qp.activeRepositories
+	qp.statusMu.RUnlock()
+
+	repositories.PullAll()
+	return repositories.Status()
+}
+
+func (qp *processor) Jobs() []JobStatus {
+	now := time.Now()
+	runningJobs := qp.workerPool.RunningJobs()
+
+	jobs := make([]JobStatus, 0, len(runningJobs))
+	for _, runningJob := range runningJobs {
+		jobs = append(jobs, JobStatus{
+			Id:               runningJob.Id,
+			Integration:      qp.configuration.IntegrationName,
+			StartedAt:        runningJob.StartedAt,
+			ElapsedInSeconds: now.Sub(runningJob.StartedAt).Seconds(),
+		})
+	}
+	return jobs
+}
+
+// CancelJob cancels the running job, whose result is sent to Opsgenie as cancelled by an operator.
+func (qp *processor) CancelJob(id string) bool {
+	if !qp.workerPool.Cancel(id, ErrCancelledByOperator) {
+		return false
+	}
+	qp.logger.Warnf("Job[%s] is cancelled by an operator.", id)
+	return true
+}
+
+func (qp *processor) Reload(configurations []*conf.Configuration) error {
+
+	if len(configurations) != 1 {
+		return errors.Errorf("Configuration has %d integrations, OEC should be restarted to run them.", len(configurations))
+	}
+
+	actionSpecs, err := qp.reloadableActionSpecs(configurations)
+	if err != nil {
+		return err
+	}
+
+	qp.actionSpecs.set(actionSpecs)
+	qp.logger.Infof("Action mappings are reloaded.")
+	return nil
+}
+
+// reloadableActionSpecs returns the action specifications of the integration in the configurations. Only the
+// git repositories which have been cloned and the action log files which have been opened can be used.
+func (qp *processor) reloadableActionSpecs(configurations []*conf.Configuration) (conf.ActionSpecifications, error) {
+
+	integration := qp.configuration.IntegrationName
+
+	var configuration *conf.Configuration
+	for _, candidate := range configurations {
+		if candidate.IntegrationName == integration {
+			configuration = candidate
 		}
 	}
+	if configuration == nil {
+		return conf.ActionSpecifications{}, errors.Errorf("Integration[%s] could not be found in the configuration, OEC should be restarted to remove it.", integration)
+	}
+
+	mappings := make(conf.ActionMappings, len(configuration.ActionMappings))
+	for name, action := range configuration.ActionMappings {
+		if action.SourceType == conf.GitSourceType {
+			if _, err := qp.repositories.Get(action.GitOptions.Url); err != nil {
+				return conf.ActionSpecifications{}, errors.Errorf("Git repository[%s] of action[%s] is not cloned, OEC should be restarted to use it.", action.GitOptions.Url, name)
+			}
+		}
+		for _, logFile := range []string{action.Stdout, action.Stderr} {
+			if _, ok := qp.actionLoggers[logFile]; logFile != "" && !ok {
+				return conf.ActionSpecifications{}, errors.Errorf("Log file[%s] of action[%s] is not open, OEC should be restarted to use it.", logFile, name)
+			}
+		}
+		mappings[name] = action
+	}
+	conf.AddRepositoryPathToGitActionFilepaths(mappings, qp.repositories)
+
+	actionSpecs := configuration.ActionSpecifications
+	actionSpecs.ActionMappings = mappings
+	return actionSpecs, nil
+}
+
+// copyRepositories copies the repositories, so that they can be read while the original is modified.
+func copyRepositories(repositories git.Repositories) git.Repositories {
+	copied := git.NewRepositories()
+	for url, repository := range repositories {
+		copied[url] = repository
+	}
+	return copied
 }
 
 func (qp *processor) startPullingRepositories(pullPeriod time.Duration) {
 
-	logrus.Infof("Repositories will be updated in every %s.", pullPeriod.String())
+	qp.logger.Infof("Repositories will be updated in every %s.", pullPeriod.String())
 
 	ticker := time.NewTicker(pullPeriod)
 
@@ -309,7 +843,7 @@ func (qp *processor) startPullingRepositories(pullPeriod time.Duration) {
 		select {
 		case <-qp.quit:
 			ticker.Stop()
-			logrus.Info("All git repositories will be removed.")
+			qp.logger.Info("All git repositories will be removed.")
 			qp.isRunningWg.Done()
 			return
 		case <-ticker.C:
@@ -320,28 +854,44 @@ func (qp *processor) startPullingRepositories(pullPeriod time.Duration) {
 	}
 }
 
-func newActionLoggers(mappings conf.ActionMappings) map[string]io.Writer {
+// NewActionLoggers returns a logger per stdout and stderr file of the actions, files used by several actions share the same logger.
+// Loggers of the files are nil if the outputs of the actions are not logged.
+func NewActionLoggers(logConf *conf.LogConf, mappingsList ...conf.ActionMappings) map[string]io.Writer {
 	actionLoggers := make(map[string]io.Writer)
-	for _, action := range mappings {
-		if action.Stdout != "" {
-			if _, ok := actionLoggers[action.Stdout]; !ok {
-				actionLoggers[action.Stdout] = newLogger(action.Stdout)
+	for _, mappings := range mappingsList {
+		for _, action := range mappings {
+			if action.Stdout != "" {
+				if _, ok := actionLoggers[action.Stdout]; !ok {
+					actionLoggers[action.Stdout] = logging.ActionWriter(logConf, action.Stdout)
+				}
 			}
-		}
-		if action.Stderr != "" {
-			if _, ok := actionLoggers[action.Stderr]; !ok {
-				actionLoggers[action.Stderr] = newLogger(action.Stderr)
+			if action.Stderr != "" {
+				if _, ok := actionLoggers[action.Stderr]; !ok {
+					actionLoggers[action.Stderr] = logging.ActionWriter(logConf, action.Stderr)
+				}
 			}
 		}
 	}
 	return actionLoggers
 }
 
-func newLogger(filename string) *lumberjack.Logger {
-	return &lumberjack.Logger{
-		Filename:  filename,
-		MaxSize:   3, // MB
-		MaxAge:    1, // Days
-		LocalTime: true,
+func newDeadLetterStore(conf *conf.Configuration) deadletter.Store {
+	if !conf.DeadLetterConf.Enabled {
+		return nil
+	}
+
+	store, err := deadletter.NewStore(&conf.DeadLetterConf)
+	if err != nil {
+		logrus.Errorf("Dead letter store could not be created, failed messages will not be stored: %s", err)
+		return nil
+	}
+	return store
+}
+
+// newIntegrationLogger returns a logger which labels the entries with the integration name, if there is one.
+func newIntegrationLogger(integrationName string) *logrus.Entry {
+	if integrationName == "" {
+		return logrus.NewEntry(logrus.StandardLogger())
 	}
+	return logrus.WithField("integration", integrationName)
 }
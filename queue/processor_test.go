@@ -2,18 +2,25 @@ package queue
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/opsgenie/oec/conf"
 	"github.com/opsgenie/oec/git"
+	"github.com/opsgenie/oec/outbox"
 	"github.com/opsgenie/oec/retryer"
 	"github.com/opsgenie/oec/worker_pool"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -22,6 +29,7 @@ var mockConf = &conf.Configuration{
 	ApiKey:     "ApiKey",
 	PollerConf: *mockPollerConf,
 	PoolConf:   *mockPoolConf,
+	OutboxConf: conf.OutboxConf{Disabled: true},
 }
 
 var mockPoolConf = &conf.PoolConf{
@@ -34,6 +42,7 @@ func newQueueProcessorTest() *processor {
 	return &processor{
 		successRefreshPeriod: successRefreshPeriod,
 		errorRefreshPeriod:   errorRefreshPeriod,
+		scheduler:            newRefreshScheduler(credentialRefreshMarginInSecs*time.Second, successRefreshPeriod, errorRefreshPeriod),
 		workerPool:           NewMockWorkerPool(),
 		configuration:        mockConf,
 		repositories:         git.NewRepositories(),
@@ -43,6 +52,12 @@ func newQueueProcessorTest() *processor {
 		isRunningWg:          &sync.WaitGroup{},
 		startStopMu:          &sync.Mutex{},
 		retryer:              &retryer.Retryer{},
+		resultSender:         newAsyncResultSender("", mockApiKey, mockBaseUrl, newIntegrationLogger("")),
+		ownsRepositories:     true,
+		actionSpecs:          newActionSpecs(mockConf.ActionSpecifications),
+		statusMu:             &sync.RWMutex{},
+		pausedQueueUrls:      make(map[string]struct{}),
+		logger:               newIntegrationLogger(""),
 	}
 }
 
@@ -99,16 +114,75 @@ func TestStartAndStopQueueProcessor(t *testing.T) {
 	processor.retryer.DoFunc = mockHttpGet
 	newPollerFunc = NewMockPollerForQueueProcessor
 
-	err := processor.Start()
+	err := processor.Start(context.Background())
 	assert.Nil(t, err)
 
 	assert.Equal(t, 2, len(processor.pollers))
 
-	err = processor.Stop()
+	err = processor.Stop(context.Background())
 	assert.Nil(t, err)
 }
 
-func TestStartQueueProcessorAndRefresh(t *testing.T) {
+func TestStartQueueProcessorWhenOutboxCouldNotBeStarted(t *testing.T) {
+
+	defer func() {
+		newPollerFunc = NewPoller
+	}()
+
+	directory, err := ioutil.TempDir("", "oecOutbox")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	resultOutbox, err := outbox.New(&conf.OutboxConf{Directory: directory}, "integration", mockApiKey, mockBaseUrl, newIntegrationLogger(""))
+	assert.Nil(t, err)
+
+	// entries of the previous run could not be loaded
+	err = os.RemoveAll(filepath.Join(directory, "integration"))
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(filepath.Join(directory, "integration"), []byte{}, 0600)
+	assert.Nil(t, err)
+
+	processor := newQueueProcessorTest()
+	processor.resultSender = resultOutbox
+	processor.retryer.DoFunc = mockHttpGet
+	newPollerFunc = NewMockPollerForQueueProcessor
+
+	err = processor.Start(context.Background())
+	assert.Nil(t, err)
+
+	_, ok := processor.resultSender.(*asyncResultSender)
+	assert.True(t, ok)
+
+	err = processor.Stop(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestQueueMessageLoggersAreSharedAndClosed(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "oecLogs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	configuration := *mockConf
+	configuration.LogConf = conf.LogConf{Directory: directory, Output: conf.LogOutputFile}
+
+	processor := newQueueProcessorTest()
+	processor.configuration = &configuration
+
+	logger := processor.queueMessageLogger("us-west-2")
+	assert.NotNil(t, logger)
+	assert.True(t, logger == processor.queueMessageLogger("us-west-2"))
+	assert.False(t, logger == processor.queueMessageLogger("eu-west-1"))
+
+	processor.closeQueueMessageLoggers()
+	assert.Nil(t, processor.queueMessageLoggers)
+
+	configuration.LogConf.QueueMessages.Disabled = true
+	assert.Nil(t, processor.queueMessageLogger("us-west-2"))
+	processor.closeQueueMessageLoggers()
+}
+
+func TestQueueProcessorStatus(t *testing.T) {
 
 	defer func() {
 		newPollerFunc = NewPoller
@@ -117,19 +191,131 @@ func TestStartQueueProcessorAndRefresh(t *testing.T) {
 	processor := newQueueProcessorTest()
 
 	processor.retryer.DoFunc = mockHttpGet
-	processor.successRefreshPeriod = time.Nanosecond
 	newPollerFunc = NewMockPollerForQueueProcessor
 
-	err := processor.Start()
+	assert.False(t, processor.Status().IsRunning)
+
+	err := processor.Start(context.Background())
 	assert.Nil(t, err)
 
-	time.Sleep(time.Nanosecond * 100)
+	status := processor.Status()
+	assert.True(t, status.IsRunning)
+	assert.Equal(t, 1, len(status.Integrations))
+	assert.Equal(t, 2, len(status.Integrations[0].Pollers))
+	assert.NotNil(t, status.Integrations[0].TokenRefresh.LastSuccessAt)
+	assert.Eventually(t, func() bool {
+		return processor.Status().Integrations[0].TokenRefresh.NextRefreshAt != nil
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 0, status.Integrations[0].TokenRefresh.ConsecutiveFailures)
+
+	processor.onTokenRefresh(errors.New("Test error"))
+	processor.onTokenRefresh(errors.New("Test error"))
+
+	tokenRefresh := processor.Status().Integrations[0].TokenRefresh
+	assert.Equal(t, 2, tokenRefresh.ConsecutiveFailures)
+	assert.Equal(t, "Test error", tokenRefresh.LastError)
+	assert.NotNil(t, tokenRefresh.LastFailureAt)
+
+	err = processor.Stop(context.Background())
+	assert.Nil(t, err)
 
-	assert.Equal(t, 2, len(processor.pollers))
-	assert.Equal(t, successRefreshPeriod, processor.successRefreshPeriod)
+	status = processor.Status()
+	assert.False(t, status.IsRunning)
+	assert.Empty(t, status.Integrations[0].Pollers)
+}
+
+func TestStartQueueProcessorAndRefresh(t *testing.T) {
 
-	err = processor.Stop()
+	defer func() {
+		newPollerFunc = NewPoller
+	}()
+
+	processor := newQueueProcessorTest()
+
+	processor.scheduler.defaultErrorPeriod = 10 * time.Millisecond
+	newPollerFunc = NewMockPollerForQueueProcessor
+
+	token := mockToken
+	token.QueuePropertiesList = []Properties{mockQueueProperties1, mockQueueProperties2}
+	token.QueuePropertiesList[0].Configuration.ErrorRefreshPeriodInSeconds = 0 // expired credentials are retried after default period
+	tokenJson, _ := json.Marshal(token)
+	refreshCount := int32(0)
+	processor.retryer.DoFunc = func(retryer *retryer.Retryer, request *retryer.Request) (*http.Response, error) {
+		atomic.AddInt32(&refreshCount, 1)
+		header := http.Header{}
+		header.Add("Token", string(tokenJson))
+		return &http.Response{StatusCode: 200, Header: header, Body: ioutil.NopCloser(nil)}, nil
+	}
+
+	err := processor.Start(context.Background())
 	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&refreshCount) > 2 }, time.Second, time.Millisecond)
+
+	err = processor.Stop(context.Background())
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, len(processor.pollers))
+	assert.Equal(t, 10*time.Millisecond, processor.scheduler.schedules[mockQueueUrl1].errorPeriod)
+	assert.Equal(t, 60*time.Second, processor.scheduler.schedules[mockQueueUrl2].errorPeriod)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), processor.scheduler.schedules[mockQueueUrl2].next, time.Second)
+}
+
+func TestRefreshPollersIsolatesFailingQueue(t *testing.T) {
+
+	defer func() {
+		newPollerFunc = NewPoller
+	}()
+
+	processor := newQueueProcessorTest()
+	newPollerFunc = NewMockPollerForQueueProcessor
+
+	validToken := mockToken
+	validToken.QueuePropertiesList = []Properties{mockQueueProperties1, mockQueueProperties2}
+	expireTime := time.Now().Add(time.Hour)
+	for i := range validToken.QueuePropertiesList {
+		validToken.QueuePropertiesList[i].AssumeRoleResult.Credentials.ExpireTimeMillis = expireTime.UnixNano() / int64(time.Millisecond)
+	}
+
+	processor.refreshPollers(&validToken)
+	processor.pollers[mockQueueUrl1].(*MockPoller).RefreshClientFunc = func(assumeRoleResult AssumeRoleResult) error {
+		return errors.New("Test refresh error")
+	}
+
+	processor.refreshPollers(&validToken)
+
+	failed := processor.scheduler.schedules[mockQueueUrl1]
+	healthy := processor.scheduler.schedules[mockQueueUrl2]
+	assert.Equal(t, 1, failed.consecutiveFailures)
+	assert.Equal(t, 0, healthy.consecutiveFailures)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), failed.next, time.Second)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), healthy.next, time.Second)
+	assert.Equal(t, 2, len(processor.pollers))
+}
+
+func TestRefreshPollersSchedulesBeforeExpiration(t *testing.T) {
+
+	defer func() {
+		newPollerFunc = NewPoller
+	}()
+
+	processor := newQueueProcessorTest()
+	newPollerFunc = NewMockPollerForQueueProcessor
+
+	expiringToken := mockToken
+	expiringToken.QueuePropertiesList = []Properties{mockQueueProperties1}
+	expiringToken.QueuePropertiesList[0].Configuration.SuccessRefreshPeriodInSeconds = 3600
+	expireTime := time.Now().Add(10 * time.Minute)
+	expiringToken.QueuePropertiesList[0].AssumeRoleResult.Credentials.ExpireTimeMillis = expireTime.UnixNano() / int64(time.Millisecond)
+
+	processor.refreshPollers(&expiringToken)
+
+	schedule := processor.scheduler.schedules[mockQueueUrl1]
+	assert.WithinDuration(t, expireTime.Add(-credentialRefreshMarginInSecs*time.Second), schedule.next, time.Second)
+	assert.Equal(t, 1, len(processor.scheduler.schedules))
+
+	processor.refreshPollers(&mockEmptyToken)
+	assert.Empty(t, processor.scheduler.schedules)
 }
 
 func TestStartQueueProcessorInitialError(t *testing.T) {
@@ -143,17 +329,76 @@ func TestStartQueueProcessorInitialError(t *testing.T) {
 	processor.retryer.DoFunc = mockHttpGetError
 	newPollerFunc = NewMockPollerForQueueProcessor
 
-	err := processor.Start()
+	err := processor.Start(context.Background())
 
 	assert.NotNil(t, err)
 	assert.Equal(t, "Test http error has occurred while getting token.", err.Error())
 }
 
+func TestStartQueueProcessorWithCachedToken(t *testing.T) {
+
+	defer func() {
+		newPollerFunc = NewPoller
+	}()
+
+	directory, err := ioutil.TempDir("", "oecTokenCache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	processor := newQueueProcessorTest()
+	processor.tokenCache = newTokenCacheTest(t, mockApiKey, directory)
+
+	validProperties := mockQueueProperties1
+	validProperties.AssumeRoleResult.Credentials.ExpireTimeMillis = time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond)
+	err = processor.tokenCache.Save(&token{
+		OwnerId:             "12345",
+		QueuePropertiesList: []Properties{validProperties, mockQueueProperties2}, // second one is expired
+	})
+	assert.Nil(t, err)
+
+	processor.retryer.DoFunc = mockHttpGetError
+	newPollerFunc = NewMockPollerForQueueProcessor
+
+	err = processor.Start(context.Background())
+	assert.Nil(t, err)
+
+	assert.Equal(t, 1, len(processor.pollers))
+	assert.Contains(t, processor.pollers, mockQueueUrl1)
+
+	err = processor.Stop(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestStartQueueProcessorWithExpiredCachedToken(t *testing.T) {
+
+	defer func() {
+		newPollerFunc = NewPoller
+	}()
+
+	directory, err := ioutil.TempDir("", "oecTokenCache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	processor := newQueueProcessorTest()
+	processor.tokenCache = newTokenCacheTest(t, mockApiKey, directory)
+
+	err = processor.tokenCache.Save(&mockToken)
+	assert.Nil(t, err)
+
+	processor.retryer.DoFunc = mockHttpGetError
+	newPollerFunc = NewMockPollerForQueueProcessor
+
+	err = processor.Start(context.Background())
+
+	assert.EqualError(t, err, "Test http error has occurred while getting token.")
+	assert.Empty(t, processor.pollers)
+}
+
 func TestStopQueueProcessorWhileNotRunning(t *testing.T) {
 
 	processor := newQueueProcessorTest()
 
-	err := processor.Stop()
+	err := processor.Stop(context.Background())
 
 	assert.NotNil(t, err)
 	assert.Equal(t, "Queue processor is not running.", err.Error())
@@ -366,6 +611,123 @@ func TestRefreshPollerWithEmptyToken(t *testing.T) {
 	assert.Equal(t, 0, len(processor.pollers))
 }
 
+func TestPauseAndResumePollers(t *testing.T) {
+
+	defer func() {
+		newPollerFunc = NewPoller
+	}()
+
+	pausedPollers := &sync.Map{}
+	isPaused := func(queueUrl string) bool {
+		value, _ := pausedPollers.Load(queueUrl)
+		return value == true
+	}
+	newPollerFunc = func(workerPool worker_pool.WorkerPool, queueProvider SQSProvider, messageHandler MessageHandler,
+		resultSender ResultSender, conf *conf.Configuration, ownerId string, queueMessageLogrus *logrus.Logger) Poller {
+		queueUrl := queueProvider.Properties().Url()
+		return &MockPoller{
+			QueueProviderFunc: func() SQSProvider { return queueProvider },
+			PauseFunc:         func() { pausedPollers.Store(queueUrl, true) },
+			ResumeFunc:        func() { pausedPollers.Store(queueUrl, false) },
+		}
+	}
+
+	processor := newQueueProcessorTest()
+
+	token := mockToken
+	token.QueuePropertiesList = []Properties{mockQueueProperties1}
+	processor.refreshPollers(&token)
+
+	assert.Equal(t, 0, processor.PausePollers("otherIntegration", ""))
+	assert.Equal(t, 1, processor.PausePollers("", ""))
+	assert.True(t, isPaused(mockQueueUrl1))
+
+	token.QueuePropertiesList = []Properties{mockQueueProperties1, mockQueueProperties2}
+	processor.refreshPollers(&token)
+	assert.True(t, isPaused(mockQueueUrl2), "pollers added while paused should be paused")
+
+	assert.Equal(t, 1, processor.ResumePollers("", mockQueueUrl1))
+	assert.False(t, isPaused(mockQueueUrl1))
+	assert.True(t, isPaused(mockQueueUrl2))
+	assert.False(t, processor.isPollerPaused(mockQueueUrl1))
+	assert.True(t, processor.isPollerPaused(mockQueueUrl2))
+
+	assert.Equal(t, 2, processor.ResumePollers("", ""))
+	assert.False(t, isPaused(mockQueueUrl2))
+	assert.False(t, processor.isPollerPaused(mockQueueUrl2))
+}
+
+func TestJobsAndCancelJob(t *testing.T) {
+
+	processor := newQueueProcessorTest()
+
+	startedAt := time.Now().Add(-time.Minute)
+	var cancelCause error
+	workerPool := processor.workerPool.(*MockWorkerPool)
+	workerPool.RunningJobsFunc = func() []worker_pool.RunningJob {
+		return []worker_pool.RunningJob{{Id: "jobId", StartedAt: startedAt}}
+	}
+	workerPool.CancelFunc = func(jobId string, cause error) bool {
+		cancelCause = cause
+		return jobId == "jobId"
+	}
+
+	jobs := processor.Jobs()
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "jobId", jobs[0].Id)
+	assert.Equal(t, startedAt, jobs[0].StartedAt)
+	assert.True(t, jobs[0].ElapsedInSeconds >= 60)
+
+	assert.False(t, processor.CancelJob("unknownJobId"))
+	assert.True(t, processor.CancelJob("jobId"))
+	assert.Equal(t, ErrCancelledByOperator, cancelCause)
+}
+
+func TestReloadActionSpecifications(t *testing.T) {
+
+	processor := newQueueProcessorTest()
+	processor.actionLoggers = map[string]io.Writer{"/path/to/stdout": ioutil.Discard}
+
+	reloaded := &conf.Configuration{
+		ActionSpecifications: conf.ActionSpecifications{
+			ActionMappings: conf.ActionMappings{
+				"Create": conf.MappedAction{SourceType: conf.LocalSourceType, Filepath: "/path/to/create.sh", Stdout: "/path/to/stdout"},
+			},
+			GlobalEnv: []string{"e1=v1"},
+		},
+	}
+
+	err := processor.Reload([]*conf.Configuration{reloaded})
+	assert.Nil(t, err)
+	assert.Equal(t, "/path/to/create.sh", processor.actionSpecs.get().ActionMappings["Create"].Filepath)
+	assert.Equal(t, []string{"e1=v1"}, processor.actionSpecs.get().GlobalEnv)
+
+	err = processor.Reload([]*conf.Configuration{reloaded, reloaded})
+	assert.EqualError(t, err, "Configuration has 2 integrations, OEC should be restarted to run them.")
+
+	withNewLogFile := &conf.Configuration{
+		ActionSpecifications: conf.ActionSpecifications{
+			ActionMappings: conf.ActionMappings{
+				"Create": conf.MappedAction{SourceType: conf.LocalSourceType, Filepath: "/path/to/create.sh", Stderr: "/path/to/stderr"},
+			},
+		},
+	}
+	err = processor.Reload([]*conf.Configuration{withNewLogFile})
+	assert.EqualError(t, err, "Log file[/path/to/stderr] of action[Create] is not open, OEC should be restarted to use it.")
+
+	withNewRepository := &conf.Configuration{
+		ActionSpecifications: conf.ActionSpecifications{
+			ActionMappings: conf.ActionMappings{
+				"Close": conf.MappedAction{SourceType: conf.GitSourceType, GitOptions: git.Options{Url: "newUrl"}, Filepath: "close.sh"},
+			},
+		},
+	}
+	err = processor.Reload([]*conf.Configuration{withNewRepository})
+	assert.EqualError(t, err, "Git repository[newUrl] of action[Close] is not cloned, OEC should be restarted to use it.")
+
+	assert.Equal(t, "/path/to/create.sh", processor.actionSpecs.get().ActionMappings["Create"].Filepath)
+}
+
 // Mock QueueProcessor
 type MockQueueProcessor struct {
 	StartProcessingFunc func() error
@@ -408,9 +770,14 @@ func (m *MockQueueProcessor) Wait() {
 // Mock Worker Pool
 type MockWorkerPool struct {
 	NumberOfAvailableWorkerFunc func() int32
+	NumberOfQueueableJobFunc    func() int32
+	OldestQueuedJobAgeFunc      func() time.Duration
 	StartFunc                   func() error
 	StopFunc                    func() error
 	SubmitFunc                  func(worker_pool.Job) (bool, error)
+	StatusFunc                  func() worker_pool.Status
+	RunningJobsFunc             func() []worker_pool.RunningJob
+	CancelFunc                  func(jobId string, cause error) bool
 }
 
 func NewMockWorkerPool() *MockWorkerPool {
@@ -424,6 +791,20 @@ func (m *MockWorkerPool) NumberOfAvailableWorker() int32 {
 	return 0
 }
 
+func (m *MockWorkerPool) NumberOfQueueableJob() int32 {
+	if m.NumberOfQueueableJobFunc != nil {
+		return m.NumberOfQueueableJobFunc()
+	}
+	return 0
+}
+
+func (m *MockWorkerPool) OldestQueuedJobAge() time.Duration {
+	if m.OldestQueuedJobAgeFunc != nil {
+		return m.OldestQueuedJobAgeFunc()
+	}
+	return 0
+}
+
 func (m *MockWorkerPool) Start() error {
 	if m.StartFunc != nil {
 		return m.StartFunc()
@@ -431,13 +812,34 @@ func (m *MockWorkerPool) Start() error {
 	return nil
 }
 
-func (m *MockWorkerPool) Stop() error {
+func (m *MockWorkerPool) Stop(ctx context.Context) error {
 	if m.StopFunc != nil {
 		return m.StopFunc()
 	}
 	return nil
 }
 
+func (m *MockWorkerPool) Status() worker_pool.Status {
+	if m.StatusFunc != nil {
+		return m.StatusFunc()
+	}
+	return worker_pool.Status{}
+}
+
+func (m *MockWorkerPool) RunningJobs() []worker_pool.RunningJob {
+	if m.RunningJobsFunc != nil {
+		return m.RunningJobsFunc()
+	}
+	return []worker_pool.RunningJob{}
+}
+
+func (m *MockWorkerPool) Cancel(jobId string, cause error) bool {
+	if m.CancelFunc != nil {
+		return m.CancelFunc(jobId, cause)
+	}
+	return false
+}
+
 func (m *MockWorkerPool) Submit(job worker_pool.Job) (bool, error) {
 	if m.SubmitFunc != nil {
 		return m.SubmitFunc(job)
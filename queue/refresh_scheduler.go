@@ -0,0 +1,151 @@
+package queue
+
+import (
+	"time"
+)
+
+// refreshScheduler keeps the next credential refresh time of each queue. A queue is refreshed
+// a margin before its credentials expire, or after its success refresh period if that comes earlier.
+// A queue whose refresh has failed is retried after its own error refresh period, without changing
+// the schedules of the others.
+type refreshScheduler struct {
+	margin               time.Duration
+	defaultSuccessPeriod time.Duration
+	defaultErrorPeriod   time.Duration
+	schedules            map[string]*refreshSchedule
+}
+
+type refreshSchedule struct {
+	region              string
+	successPeriod       time.Duration
+	errorPeriod         time.Duration
+	expireTime          time.Time
+	next                time.Time
+	consecutiveFailures int
+}
+
+func newRefreshScheduler(margin, defaultSuccessPeriod, defaultErrorPeriod time.Duration) *refreshScheduler {
+	return &refreshScheduler{
+		margin:               margin,
+		defaultSuccessPeriod: defaultSuccessPeriod,
+		defaultErrorPeriod:   defaultErrorPeriod,
+		schedules:            make(map[string]*refreshSchedule),
+	}
+}
+
+// onSuccess schedules the next refresh of the queue according to the expire time of its current credentials.
+// If the credentials are already within the margin, the refresh is retried after the error refresh period.
+func (rs *refreshScheduler) onSuccess(queueProperties Properties, now time.Time) *refreshSchedule {
+
+	schedule := rs.update(queueProperties)
+	schedule.consecutiveFailures = 0
+
+	schedule.next = now.Add(schedule.successPeriod)
+	if refreshTime := schedule.expireTime.Add(-rs.margin); refreshTime.Before(schedule.next) {
+		schedule.next = refreshTime
+	}
+	if !schedule.next.After(now) {
+		schedule.next = now.Add(schedule.errorPeriod)
+	}
+
+	return schedule
+}
+
+// onFailure schedules the next refresh of the queue after its error refresh period.
+func (rs *refreshScheduler) onFailure(queueProperties Properties, now time.Time) *refreshSchedule {
+
+	schedule := rs.update(queueProperties)
+	schedule.fail(now)
+
+	return schedule
+}
+
+// onFailureDue schedules the queues whose refresh is due after their error refresh periods.
+func (rs *refreshScheduler) onFailureDue(now time.Time) {
+	for _, schedule := range rs.schedules {
+		if !schedule.next.After(now) {
+			schedule.fail(now)
+		}
+	}
+}
+
+// onFailureAll schedules all queues after their error refresh periods.
+func (rs *refreshScheduler) onFailureAll(now time.Time) {
+	for _, schedule := range rs.schedules {
+		schedule.fail(now)
+	}
+}
+
+func (s *refreshSchedule) fail(now time.Time) {
+	s.consecutiveFailures++
+	s.next = now.Add(s.errorPeriod)
+}
+
+func (rs *refreshScheduler) update(queueProperties Properties) *refreshSchedule {
+
+	schedule, ok := rs.schedules[queueProperties.Url()]
+	if !ok {
+		schedule = &refreshSchedule{}
+		rs.schedules[queueProperties.Url()] = schedule
+	}
+
+	schedule.region = queueProperties.Region()
+	schedule.successPeriod = time.Duration(queueProperties.Configuration.SuccessRefreshPeriodInSeconds) * time.Second
+	if schedule.successPeriod <= 0 {
+		schedule.successPeriod = rs.defaultSuccessPeriod
+	}
+	schedule.errorPeriod = time.Duration(queueProperties.Configuration.ErrorRefreshPeriodInSeconds) * time.Second
+	if schedule.errorPeriod <= 0 {
+		schedule.errorPeriod = rs.defaultErrorPeriod
+	}
+	schedule.expireTime = time.Unix(0, queueProperties.ExpireTimeMillis()*int64(time.Millisecond))
+
+	return schedule
+}
+
+// isExpiring returns true if the credentials of the queue expire within the margin.
+func (rs *refreshScheduler) isExpiring(queueProperties Properties, now time.Time) bool {
+	expireTime := time.Unix(0, queueProperties.ExpireTimeMillis()*int64(time.Millisecond))
+	return !expireTime.Add(-rs.margin).After(now)
+}
+
+func (rs *refreshScheduler) isDue(queueUrl string, now time.Time) bool {
+	schedule, ok := rs.schedules[queueUrl]
+	return !ok || !schedule.next.After(now)
+}
+
+// retain removes the schedules of the queues which are not in the given list.
+func (rs *refreshScheduler) retain(queuePropertiesList []Properties) {
+
+	queueUrls := make(map[string]struct{}, len(queuePropertiesList))
+	for _, queueProperties := range queuePropertiesList {
+		queueUrls[queueProperties.Url()] = struct{}{}
+	}
+
+	for queueUrl := range rs.schedules {
+		if _, ok := queueUrls[queueUrl]; !ok {
+			delete(rs.schedules, queueUrl)
+		}
+	}
+}
+
+// nextRefresh returns the duration until the earliest scheduled refresh. Without any queue,
+// the given period is returned so that new queues are still discovered.
+func (rs *refreshScheduler) nextRefresh(now time.Time, period time.Duration) time.Duration {
+
+	if len(rs.schedules) == 0 {
+		return period
+	}
+
+	var earliest time.Time
+	for _, schedule := range rs.schedules {
+		if earliest.IsZero() || schedule.next.Before(earliest) {
+			earliest = schedule.next
+		}
+	}
+
+	if wait := earliest.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newRefreshSchedulerTest() *refreshScheduler {
+	return newRefreshScheduler(5*time.Minute, successRefreshPeriod, errorRefreshPeriod)
+}
+
+func newPropertiesExpiringAt(queueConf Configuration, expireTime time.Time) Properties {
+	properties := Properties{Configuration: queueConf}
+	properties.AssumeRoleResult.Credentials.ExpireTimeMillis = expireTime.UnixNano() / int64(time.Millisecond)
+	return properties
+}
+
+func TestRefreshSchedulerSchedulesSuccessPeriod(t *testing.T) {
+	scheduler := newRefreshSchedulerTest()
+	now := time.Now()
+
+	schedule := scheduler.onSuccess(newPropertiesExpiringAt(mockQueueConf1, now.Add(time.Hour)), now)
+
+	assert.Equal(t, now.Add(60*time.Second), schedule.next)
+	assert.Equal(t, 60*time.Second, scheduler.nextRefresh(now, time.Hour))
+}
+
+func TestRefreshSchedulerSchedulesBeforeExpiration(t *testing.T) {
+	scheduler := newRefreshSchedulerTest()
+	now := time.Now()
+	queueConf := mockQueueConf1
+	queueConf.SuccessRefreshPeriodInSeconds = 3600
+
+	schedule := scheduler.onSuccess(newPropertiesExpiringAt(queueConf, now.Add(10*time.Minute)), now)
+
+	assert.WithinDuration(t, now.Add(5*time.Minute), schedule.next, time.Millisecond)
+}
+
+func TestRefreshSchedulerRetriesExpiringCredentials(t *testing.T) {
+	scheduler := newRefreshSchedulerTest()
+	now := time.Now()
+	queueConf := mockQueueConf1
+	queueConf.ErrorRefreshPeriodInSeconds = 10
+
+	properties := newPropertiesExpiringAt(queueConf, now.Add(time.Minute))
+	schedule := scheduler.onSuccess(properties, now)
+
+	assert.True(t, scheduler.isExpiring(properties, now))
+	assert.Equal(t, now.Add(10*time.Second), schedule.next)
+}
+
+func TestRefreshSchedulerIsolatesFailures(t *testing.T) {
+	scheduler := newRefreshSchedulerTest()
+	now := time.Now()
+	queueConf := mockQueueConf2
+	queueConf.ErrorRefreshPeriodInSeconds = 10
+
+	healthy := scheduler.onSuccess(newPropertiesExpiringAt(mockQueueConf1, now.Add(time.Hour)), now)
+	failed := scheduler.onFailure(newPropertiesExpiringAt(queueConf, now.Add(time.Hour)), now)
+
+	later := now.Add(10 * time.Second)
+	assert.False(t, scheduler.isDue(mockQueueUrl1, now))
+	assert.True(t, scheduler.isDue(mockQueueUrl2, later))
+
+	scheduler.onFailureDue(later)
+
+	assert.Equal(t, 0, healthy.consecutiveFailures)
+	assert.Equal(t, 2, failed.consecutiveFailures)
+	assert.Equal(t, later.Add(10*time.Second), failed.next)
+	assert.Equal(t, now.Add(60*time.Second), healthy.next)
+}
+
+func TestRefreshSchedulerRetain(t *testing.T) {
+	scheduler := newRefreshSchedulerTest()
+	now := time.Now()
+
+	scheduler.onSuccess(mockQueueProperties1, now)
+	scheduler.onSuccess(mockQueueProperties2, now)
+	scheduler.retain([]Properties{mockQueueProperties2})
+
+	assert.Equal(t, 1, len(scheduler.schedules))
+	assert.Contains(t, scheduler.schedules, mockQueueUrl2)
+
+	scheduler.retain(nil)
+	assert.Equal(t, time.Hour, scheduler.nextRefresh(now, time.Hour))
+}
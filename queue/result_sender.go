@@ -0,0 +1,95 @@
+package queue
+
+import (
+	"context"
+	"github.com/opsgenie/oec/audit"
+	"github.com/opsgenie/oec/runbook"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ResultSender sends the action results of the messages to Opsgenie without blocking the workers.
+// The context carries the trace of the message, sending is not cancelled with it.
+type ResultSender interface {
+	Send(ctx context.Context, messageId string, result *runbook.ActionResultPayload)
+}
+
+// resultDispatcher is the ResultSender of a processor, it runs as long as the processor does.
+// Stop waits for the results being sent until the given context is done.
+type resultDispatcher interface {
+	ResultSender
+	Start() error
+	Stop(ctx context.Context) error
+	Depth() int
+}
+
+// asyncResultSender sends each result once in the background, and keeps track of them so that
+// they can be waited on shutdown. It is used when the outbox is disabled.
+type asyncResultSender struct {
+	integration string
+	apiKey      string
+	baseUrl     string
+	logger      *logrus.Entry
+	sendingWg   *sync.WaitGroup
+	sending     int32
+}
+
+func newAsyncResultSender(integration, apiKey, baseUrl string, logger *logrus.Entry) *asyncResultSender {
+	return &asyncResultSender{
+		integration: integration,
+		apiKey:      apiKey,
+		baseUrl:     baseUrl,
+		logger:      logger,
+		sendingWg:   &sync.WaitGroup{},
+	}
+}
+
+func (s *asyncResultSender) Start() error {
+	return nil
+}
+
+func (s *asyncResultSender) Send(ctx context.Context, messageId string, result *runbook.ActionResultPayload) {
+	s.sendingWg.Add(1)
+	atomic.AddInt32(&s.sending, 1)
+	go func() {
+		defer s.sendingWg.Done()
+		defer atomic.AddInt32(&s.sending, -1)
+		start := time.Now()
+
+		_, span := runbook.StartSendResultSpan(ctx, result)
+		err := runbook.SendResultToOpsGenieFunc(result, s.apiKey, s.baseUrl)
+		span.SetError(err)
+		span.End()
+		took := time.Since(start)
+		runbook.ObserveResultCallback(s.integration, took, err)
+		if err != nil {
+			audit.WriteResult(s.integration, messageId, result, audit.ResultFailed, 1, err)
+			s.logger.Warnf("Could not send action result[%+v] of message[%s] to Opsgenie: %s", result, messageId, err)
+		} else {
+			audit.WriteResult(s.integration, messageId, result, audit.ResultSent, 1, nil)
+			s.logger.Debugf("Successfully sent result of message[%s] to OpsGenie and it took %f seconds.", messageId, took.Seconds())
+		}
+	}()
+}
+
+// Depth returns the number of results being sent.
+func (s *asyncResultSender) Depth() int {
+	return int(atomic.LoadInt32(&s.sending))
+}
+
+func (s *asyncResultSender) Stop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.sendingWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,43 @@
+package queue
+
+import (
+	"context"
+	"github.com/opsgenie/oec/runbook"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestResultSenderWaitsResults(t *testing.T) {
+	defer func() {
+		runbook.SendResultToOpsGenieFunc = runbook.SendResultToOpsGenie
+	}()
+
+	release := make(chan struct{})
+	runbook.SendResultToOpsGenieFunc = func(resultPayload *runbook.ActionResultPayload, apiKey, baseUrl string) error {
+		assert.Equal(t, mockApiKey, apiKey)
+		<-release
+		return nil
+	}
+
+	sender := newAsyncResultSender("", mockApiKey, mockBaseUrl, newIntegrationLogger(""))
+	sender.Send(context.Background(), mockMessageId, mockActionResultPayload)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, sender.Stop(ctx))
+
+	close(release)
+	assert.Nil(t, sender.Stop(context.Background()))
+}
+
+// Mock ResultSender
+type MockResultSender struct {
+	SendFunc func(ctx context.Context, messageId string, result *runbook.ActionResultPayload)
+}
+
+func (m *MockResultSender) Send(ctx context.Context, messageId string, result *runbook.ActionResultPayload) {
+	if m.SendFunc != nil {
+		m.SendFunc(ctx, messageId, result)
+	}
+}
@@ -1,27 +1,44 @@
 package queue
 
 import (
+	"context"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	aws_credentials "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 )
 
 const ownerId = "ownerId"
 
+const maxBatchSize = 10
+
+// HttpClient is used by the SQS clients if it is set, otherwise the default client of the AWS SDK is used.
+var HttpClient *http.Client
+
 type SQSClient interface {
 	ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error)
+	ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error)
 	DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
-	ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error)
+	ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error)
 }
 
 type SQSProvider interface {
 	ChangeMessageVisibility(message *sqs.Message, visibilityTimeout int64) error
 	DeleteMessage(message *sqs.Message) error
-	ReceiveMessage(numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error)
+	// ReceiveMessage returns as soon as the context is done, instead of waiting the long polling.
+	ReceiveMessage(ctx context.Context, numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error)
+
+	// Batch operations return an error per message in the order of the given messages, nil ones are succeeded.
+	ChangeMessageVisibilityBatch(messages []*sqs.Message, visibilityTimeout int64) []error
+	DeleteMessageBatch(messages []*sqs.Message) []error
 
 	RefreshClient(assumeRoleResult AssumeRoleResult) error
 	Properties() Properties
@@ -104,11 +121,111 @@ func (qp *sqsProvider) DeleteMessage(message *sqs.Message) error {
 	return nil
 }
 
-func (qp *sqsProvider) ReceiveMessage(maxNumOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error) {
+func (qp *sqsProvider) ChangeMessageVisibilityBatch(messages []*sqs.Message, visibilityTimeout int64) []error {
+
+	queueUrl := qp.queueProperties.Url()
+	errs := make([]error, len(messages))
+
+	for start := 0; start < len(messages); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+
+		entries := make([]*sqs.ChangeMessageVisibilityBatchRequestEntry, 0, end-start)
+		for i := start; i < end; i++ {
+			entries = append(entries, &sqs.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(i)),
+				ReceiptHandle:     messages[i].ReceiptHandle,
+				VisibilityTimeout: aws.Int64(visibilityTimeout),
+			})
+		}
+
+		request := &sqs.ChangeMessageVisibilityBatchInput{
+			QueueUrl: &queueUrl,
+			Entries:  entries,
+		}
+
+		qp.refreshClientMu.RLock()
+		output, err := qp.client.ChangeMessageVisibilityBatch(request)
+		qp.checkExpiration(err)
+		qp.refreshClientMu.RUnlock()
+
+		var failed []*sqs.BatchResultErrorEntry
+		if output != nil {
+			failed = output.Failed
+		}
+		applyBatchResult(errs, start, end, failed, err)
+	}
+
+	return errs
+}
+
+func (qp *sqsProvider) DeleteMessageBatch(messages []*sqs.Message) []error {
+
+	queueUrl := qp.queueProperties.Url()
+	errs := make([]error, len(messages))
+
+	for start := 0; start < len(messages); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+
+		entries := make([]*sqs.DeleteMessageBatchRequestEntry, 0, end-start)
+		for i := start; i < end; i++ {
+			entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(strconv.Itoa(i)),
+				ReceiptHandle: messages[i].ReceiptHandle,
+			})
+		}
+
+		request := &sqs.DeleteMessageBatchInput{
+			QueueUrl: &queueUrl,
+			Entries:  entries,
+		}
+
+		qp.refreshClientMu.RLock()
+		output, err := qp.client.DeleteMessageBatch(request)
+		qp.checkExpiration(err)
+		qp.refreshClientMu.RUnlock()
+
+		var failed []*sqs.BatchResultErrorEntry
+		if output != nil {
+			failed = output.Failed
+		}
+		applyBatchResult(errs, start, end, failed, err)
+	}
+
+	return errs
+}
+
+// applyBatchResult fills errs[start:end] with the request error, or with the failed entries of the batch.
+func applyBatchResult(errs []error, start, end int, failed []*sqs.BatchResultErrorEntry, err error) {
+	if err != nil {
+		for i := start; i < end; i++ {
+			errs[i] = err
+		}
+		return
+	}
+
+	for _, entry := range failed {
+		index, convErr := strconv.Atoi(aws.StringValue(entry.Id))
+		if convErr != nil || index < start || index >= end {
+			continue
+		}
+		errs[index] = errors.Errorf("%s: %s", aws.StringValue(entry.Code), aws.StringValue(entry.Message))
+	}
+}
+
+func (qp *sqsProvider) ReceiveMessage(ctx context.Context, maxNumOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error) {
 
 	queueUrl := qp.queueProperties.Url()
 
 	request := &sqs.ReceiveMessageInput{
+		AttributeNames: []*string{
+			aws.String(sqs.MessageSystemAttributeNameSentTimestamp),
+		},
 		MessageAttributeNames: []*string{
 			aws.String(ownerId),
 		},
@@ -119,7 +236,7 @@ func (qp *sqsProvider) ReceiveMessage(maxNumOfMessage int64, visibilityTimeout i
 	}
 
 	qp.refreshClientMu.RLock()
-	result, err := qp.client.ReceiveMessage(request)
+	result, err := qp.client.ReceiveMessageWithContext(ctx, request)
 	qp.checkExpiration(err)
 	qp.refreshClientMu.RUnlock()
 
@@ -162,6 +279,10 @@ func (qp *sqsProvider) newConfig(assumeRoleResult AssumeRoleResult) *aws.Config
 		WithRegion(qp.queueProperties.Region()).
 		WithCredentials(credentials)
 
+	if HttpClient != nil {
+		awsConfig = awsConfig.WithHTTPClient(HttpClient)
+	}
+
 	return awsConfig
 }
 
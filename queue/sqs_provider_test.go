@@ -1,8 +1,10 @@
 package queue
 
 import (
+	"context"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -91,6 +93,63 @@ func TestDeleteMessageWithError(t *testing.T) {
 	assert.Equal(t, "Test delete message error", err.Error())
 }
 
+func TestDeleteMessageBatch(t *testing.T) {
+
+	provider := newQueueProviderTest()
+
+	var capturedInputs []*sqs.DeleteMessageBatchInput
+	provider.client.(*mockSqsClient).DeleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		capturedInputs = append(capturedInputs, input)
+		return &sqs.DeleteMessageBatchOutput{
+			Failed: []*sqs.BatchResultErrorEntry{
+				{Id: input.Entries[0].Id, Code: aws.String("ReceiptHandleIsInvalid"), Message: aws.String("invalid")},
+			},
+		}, nil
+	}
+
+	messages := make([]*sqs.Message, 12)
+	for i := range messages {
+		messages[i] = &sqs.Message{ReceiptHandle: aws.String("receiptHandle" + strconv.Itoa(i))}
+	}
+
+	errs := provider.DeleteMessageBatch(messages)
+
+	assert.Equal(t, 2, len(capturedInputs))
+	assert.Equal(t, 10, len(capturedInputs[0].Entries))
+	assert.Equal(t, 2, len(capturedInputs[1].Entries))
+	assert.Equal(t, mockQueueUrl1, *capturedInputs[0].QueueUrl)
+	assert.Equal(t, "receiptHandle11", *capturedInputs[1].Entries[1].ReceiptHandle)
+
+	assert.Equal(t, 12, len(errs))
+	for i, err := range errs {
+		if i == 0 || i == 10 {
+			assert.EqualError(t, err, "ReceiptHandleIsInvalid: invalid")
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestChangeMessageVisibilityBatchWithError(t *testing.T) {
+
+	provider := newQueueProviderTest()
+
+	var capturedInput *sqs.ChangeMessageVisibilityBatchInput
+	provider.client.(*mockSqsClient).ChangeMessageVisibilityBatchFunc = func(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+		capturedInput = input
+		return nil, errors.New("Test change message visibility batch error")
+	}
+
+	messages := []*sqs.Message{{ReceiptHandle: &mockReceiptHandle}, {ReceiptHandle: &mockReceiptHandle}}
+	errs := provider.ChangeMessageVisibilityBatch(messages, 0)
+
+	assert.Equal(t, int64(0), *capturedInput.Entries[0].VisibilityTimeout)
+	assert.Equal(t, 2, len(errs))
+	for _, err := range errs {
+		assert.EqualError(t, err, "Test change message visibility batch error")
+	}
+}
+
 func TestReceiveMessage(t *testing.T) {
 
 	provider := newQueueProviderTest()
@@ -101,7 +160,7 @@ func TestReceiveMessage(t *testing.T) {
 		return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{{}, {}}}, nil
 	}
 
-	messages, err := provider.ReceiveMessage(10, 30)
+	messages, err := provider.ReceiveMessage(context.Background(), 10, 30)
 
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(messages))
@@ -121,7 +180,7 @@ func TestReceiveMessageWithError(t *testing.T) {
 		return nil, errors.New("Test receive message visibility error")
 	}
 
-	_, err := provider.ReceiveMessage(10, 30)
+	_, err := provider.ReceiveMessage(context.Background(), 10, 30)
 
 	assert.NotNil(t, err)
 	assert.Equal(t, "Test receive message visibility error", err.Error())
@@ -146,9 +205,25 @@ func TestRefreshClient(t *testing.T) {
 
 // Mock SqsClient
 type mockSqsClient struct {
-	DeleteMessageFunc           func(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
-	ChangeMessageVisibilityFunc func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error)
-	ReceiveMessageFunc          func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageFunc                func(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
+	DeleteMessageBatchFunc           func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error)
+	ChangeMessageVisibilityFunc      func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error)
+	ChangeMessageVisibilityBatchFunc func(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error)
+	ReceiveMessageFunc               func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error)
+}
+
+func (c *mockSqsClient) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	if c.DeleteMessageBatchFunc != nil {
+		return c.DeleteMessageBatchFunc(input)
+	}
+	return &sqs.DeleteMessageBatchOutput{}, nil
+}
+
+func (c *mockSqsClient) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	if c.ChangeMessageVisibilityBatchFunc != nil {
+		return c.ChangeMessageVisibilityBatchFunc(input)
+	}
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
 }
 
 func (c *mockSqsClient) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
@@ -165,7 +240,7 @@ func (c *mockSqsClient) ChangeMessageVisibility(input *sqs.ChangeMessageVisibili
 	return nil, nil
 }
 
-func (c *mockSqsClient) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+func (c *mockSqsClient) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
 	if c.ReceiveMessageFunc != nil {
 		return c.ReceiveMessageFunc(input)
 	}
@@ -174,12 +249,14 @@ func (c *mockSqsClient) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.Rec
 
 // Mock SQSProvider
 type MockSQSProvider struct {
-	ChangeMessageVisibilityFunc func(message *sqs.Message, visibilityTimeout int64) error
-	DeleteMessageFunc           func(message *sqs.Message) error
-	ReceiveMessageFunc          func(numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error)
-	QueuePropertiesFunc         func() Properties
-	RefreshClientFunc           func(assumeRoleResult AssumeRoleResult) error
-	IsTokenExpiredFunc          func() bool
+	ChangeMessageVisibilityFunc      func(message *sqs.Message, visibilityTimeout int64) error
+	ChangeMessageVisibilityBatchFunc func(messages []*sqs.Message, visibilityTimeout int64) []error
+	DeleteMessageFunc                func(message *sqs.Message) error
+	DeleteMessageBatchFunc           func(messages []*sqs.Message) []error
+	ReceiveMessageFunc               func(numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error)
+	QueuePropertiesFunc              func() Properties
+	RefreshClientFunc                func(assumeRoleResult AssumeRoleResult) error
+	IsTokenExpiredFunc               func() bool
 }
 
 func NewMockQueueProvider() SQSProvider {
@@ -200,6 +277,28 @@ func (mqp *MockSQSProvider) ChangeMessageVisibility(message *sqs.Message, visibi
 	return nil
 }
 
+func (mqp *MockSQSProvider) ChangeMessageVisibilityBatch(messages []*sqs.Message, visibilityTimeout int64) []error {
+	if mqp.ChangeMessageVisibilityBatchFunc != nil {
+		return mqp.ChangeMessageVisibilityBatchFunc(messages, visibilityTimeout)
+	}
+	errs := make([]error, len(messages))
+	for i, message := range messages {
+		errs[i] = mqp.ChangeMessageVisibility(message, visibilityTimeout)
+	}
+	return errs
+}
+
+func (mqp *MockSQSProvider) DeleteMessageBatch(messages []*sqs.Message) []error {
+	if mqp.DeleteMessageBatchFunc != nil {
+		return mqp.DeleteMessageBatchFunc(messages)
+	}
+	errs := make([]error, len(messages))
+	for i, message := range messages {
+		errs[i] = mqp.DeleteMessage(message)
+	}
+	return errs
+}
+
 func (mqp *MockSQSProvider) DeleteMessage(message *sqs.Message) error {
 	if mqp.DeleteMessageFunc != nil {
 		return mqp.DeleteMessageFunc(message)
@@ -207,7 +306,7 @@ func (mqp *MockSQSProvider) DeleteMessage(message *sqs.Message) error {
 	return nil
 }
 
-func (mqp *MockSQSProvider) ReceiveMessage(numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error) {
+func (mqp *MockSQSProvider) ReceiveMessage(ctx context.Context, numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error) {
 	if mqp.ReceiveMessageFunc != nil {
 		return mqp.ReceiveMessageFunc(numOfMessage, visibilityTimeout)
 	}
@@ -0,0 +1,66 @@
+package queue
+
+import (
+	"github.com/opsgenie/oec/git"
+	"github.com/opsgenie/oec/lease"
+	"github.com/opsgenie/oec/worker_pool"
+	"time"
+)
+
+// Status is a snapshot of the state of a processor, which the health endpoints are based on.
+type Status struct {
+	IsRunning    bool                   `json:"isRunning"`
+	Integrations []IntegrationStatus    `json:"integrations"`
+	Repositories []git.RepositoryStatus `json:"repositories"`
+	Lease        *lease.Status          `json:"lease,omitempty"`
+}
+
+type IntegrationStatus struct {
+	Name         string             `json:"name,omitempty"`
+	IsRunning    bool               `json:"isRunning"`
+	TokenRefresh TokenRefreshStatus `json:"tokenRefresh"`
+	Pollers      []PollerStatus     `json:"pollers"`
+	Pool         worker_pool.Status `json:"pool"`
+	OutboxDepth  int                `json:"outboxDepth"`
+}
+
+type TokenRefreshStatus struct {
+	LastSuccessAt       *time.Time `json:"lastSuccessAt,omitempty"`
+	LastFailureAt       *time.Time `json:"lastFailureAt,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	NextRefreshAt       *time.Time `json:"nextRefreshAt,omitempty"`
+}
+
+type PollerStatus struct {
+	QueueUrl            string     `json:"queueUrl"`
+	Region              string     `json:"region"`
+	IsRunning           bool       `json:"isRunning"`
+	IsPaused            bool       `json:"isPaused"`
+	IsTokenExpired      bool       `json:"isTokenExpired"`
+	CredentialsExpireAt time.Time  `json:"credentialsExpireAt"`
+	CircuitState        string     `json:"circuitState"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastErrorClass      string     `json:"lastErrorClass,omitempty"`
+	LastReceiveAt       *time.Time `json:"lastReceiveAt,omitempty"`
+}
+
+// JobStatus is an action which is being executed.
+type JobStatus struct {
+	Id               string    `json:"id"`
+	Integration      string    `json:"integration,omitempty"`
+	StartedAt        time.Time `json:"startedAt"`
+	ElapsedInSeconds float64   `json:"elapsedInSeconds"`
+}
+
+func (s *TokenRefreshStatus) onSuccess(now time.Time) {
+	s.LastSuccessAt = &now
+	s.LastError = ""
+	s.ConsecutiveFailures = 0
+}
+
+func (s *TokenRefreshStatus) onFailure(now time.Time, err error) {
+	s.LastFailureAt = &now
+	s.LastError = err.Error()
+	s.ConsecutiveFailures++
+}
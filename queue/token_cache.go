@@ -0,0 +1,143 @@
+package queue
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/opsgenie/oec/conf"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// hostIdFilepath is the id of the host which the key of the cache is bound to, the key is not bound to the host
+// if it does not exist.
+var hostIdFilepath = "/etc/machine-id"
+
+// tokenCache keeps the last received token on disk, so that pollers can be started
+// with the credentials it contains while Opsgenie is unreachable.
+type tokenCache interface {
+	Load() (*token, error)
+	Save(token *token) error
+}
+
+// fileTokenCache encrypts the token with a key derived from the api key of the integration and the id of the host,
+// the key is never stored. Anyone who has the api key can receive the token from Opsgenie anyway, so the cache
+// protects the token from the ones who can read the cache but not the configuration, e.g. from a backup or a copy
+// on another host. It does not protect the token from the user running OEC or root, who can read the configuration.
+// The api key and base url are authenticated along with the token, so a token cached for another integration
+// cannot be loaded.
+type fileTokenCache struct {
+	tokenFilepath  string
+	key            []byte
+	additionalData []byte
+}
+
+func newTokenCache(configuration *conf.Configuration) (tokenCache, error) {
+
+	directory := configuration.TokenCacheConf.Directory
+	if directory == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		directory = filepath.Join(homeDir, "oec", "tokenCache")
+	}
+
+	integration := sha256.Sum256([]byte(configuration.ApiKey + "@" + configuration.BaseUrl))
+
+	return &fileTokenCache{
+		tokenFilepath:  filepath.Join(directory, hex.EncodeToString(integration[:8])+".token"),
+		key:            tokenCacheKey(configuration.ApiKey),
+		additionalData: integration[:],
+	}, nil
+}
+
+// tokenCacheKey returns the AES-256 key of the token cache of the api key on this host.
+func tokenCacheKey(apiKey string) []byte {
+	mac := hmac.New(sha256.New, []byte(apiKey))
+	mac.Write([]byte("oec-token-cache"))
+	if hostId, err := ioutil.ReadFile(hostIdFilepath); err == nil {
+		mac.Write(bytes.TrimSpace(hostId))
+	}
+	return mac.Sum(nil)
+}
+
+func (c *fileTokenCache) Load() (*token, error) {
+
+	sealed, err := ioutil.ReadFile(c.tokenFilepath)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(c.key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.Errorf("Cached token[%s] is corrupted.", c.tokenFilepath)
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, c.additionalData)
+	if err != nil {
+		return nil, errors.Errorf("Cached token[%s] could not be decrypted: %s", c.tokenFilepath, err)
+	}
+
+	cachedToken := &token{}
+	err = json.Unmarshal(plaintext, cachedToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return cachedToken, nil
+}
+
+func (c *fileTokenCache) Save(token *token) error {
+
+	err := os.MkdirAll(filepath.Dir(c.tokenFilepath), 0700)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(c.key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, c.additionalData)
+
+	tmpFilepath := c.tokenFilepath + ".tmp"
+	err = ioutil.WriteFile(tmpFilepath, sealed, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFilepath, c.tokenFilepath)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
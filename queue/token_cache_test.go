@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"github.com/opsgenie/oec/conf"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTokenCacheTest(t *testing.T, apiKey string, directory string) *fileTokenCache {
+	cache, err := newTokenCache(&conf.Configuration{
+		ApiKey:         apiKey,
+		BaseUrl:        mockBaseUrl,
+		TokenCacheConf: conf.TokenCacheConf{Directory: directory},
+	})
+	assert.Nil(t, err)
+	return cache.(*fileTokenCache)
+}
+
+func TestSaveAndLoadToken(t *testing.T) {
+	directory, err := ioutil.TempDir("", "oecTokenCache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	cache := newTokenCacheTest(t, mockApiKey, directory)
+
+	err = cache.Save(&mockToken)
+	assert.Nil(t, err)
+
+	content, err := ioutil.ReadFile(cache.tokenFilepath)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(content), "secretAccessKey1")
+
+	info, err := os.Stat(cache.tokenFilepath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// the key is not stored with the token
+	files, err := ioutil.ReadDir(directory)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+
+	actual, err := cache.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, mockToken, *actual)
+}
+
+func TestLoadTokenOfAnotherIntegration(t *testing.T) {
+	directory, err := ioutil.TempDir("", "oecTokenCache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	cache := newTokenCacheTest(t, mockApiKey, directory)
+	err = cache.Save(&mockToken)
+	assert.Nil(t, err)
+
+	anotherCache := newTokenCacheTest(t, "anotherApiKey", directory)
+	assert.NotEqual(t, cache.tokenFilepath, anotherCache.tokenFilepath)
+
+	// even if the token file is copied, it cannot be decrypted for another integration
+	anotherCache.tokenFilepath = cache.tokenFilepath
+	_, err = anotherCache.Load()
+	assert.NotNil(t, err)
+}
+
+func TestLoadTokenOnAnotherHost(t *testing.T) {
+	directory, err := ioutil.TempDir("", "oecTokenCache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	defer func(previous string) {
+		hostIdFilepath = previous
+	}(hostIdFilepath)
+	hostIdFilepath = filepath.Join(directory, "machine-id")
+
+	err = ioutil.WriteFile(hostIdFilepath, []byte("host1\n"), 0644)
+	assert.Nil(t, err)
+	err = newTokenCacheTest(t, mockApiKey, directory).Save(&mockToken)
+	assert.Nil(t, err)
+
+	_, err = newTokenCacheTest(t, mockApiKey, directory).Load()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(hostIdFilepath, []byte("host2\n"), 0644)
+	assert.Nil(t, err)
+	_, err = newTokenCacheTest(t, mockApiKey, directory).Load()
+	assert.NotNil(t, err)
+}
+
+func TestLoadNotCachedToken(t *testing.T) {
+	directory, err := ioutil.TempDir("", "oecTokenCache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	cache := newTokenCacheTest(t, mockApiKey, filepath.Join(directory, "nested"))
+
+	_, err = cache.Load()
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,40 @@
+package redaction
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Formatter redacts the message and the fields of the log entries by the configured redactor before they are
+// formatted, so that all the logs are redacted whichever logger writes them.
+type Formatter struct {
+	logrus.Formatter
+}
+
+func NewFormatter(formatter logrus.Formatter) *Formatter {
+	return &Formatter{Formatter: formatter}
+}
+
+func (f *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
+	redactor := get()
+	if redactor == nil {
+		return f.Formatter.Format(entry)
+	}
+
+	redacted := *entry
+	redacted.Message = redactor.String(entry.Message)
+	if len(entry.Data) > 0 {
+		redacted.Data = make(logrus.Fields, len(entry.Data))
+		for key, value := range entry.Data {
+			switch value := value.(type) {
+			case string:
+				redacted.Data[key] = redactor.String(value)
+			case error:
+				redacted.Data[key] = errors.New(redactor.String(value.Error()))
+			default:
+				redacted.Data[key] = value
+			}
+		}
+	}
+	return f.Formatter.Format(&redacted)
+}
@@ -0,0 +1,239 @@
+package redaction
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/opsgenie/oec/conf"
+	"github.com/pkg/errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const Replacement = "[REDACTED]"
+
+// builtInKeyPatterns redact the API keys and the passphrases, whether they are in the configuration or not.
+var builtInKeyPatterns = []string{`(?i)api[-_]?key`, `(?i)passphrase`}
+
+// keyValueRegex matches the key-value pairs of the JSON documents in a text, values are either strings or literals.
+var keyValueRegex = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"(\s*:\s*)("(?:[^"\\]|\\.)*"|[^\s,}\]"]+)`)
+
+// Redactor redacts the secrets of the configuration and the matches of the redaction rules.
+type Redactor struct {
+	secrets     *strings.Replacer
+	keyPatterns []*regexp.Regexp
+	jsonPaths   [][]string
+	regexes     []*regexp.Regexp
+}
+
+// NewRedactor returns a redactor of the rules, which also redacts the given secrets wherever they are.
+func NewRedactor(redactionConf *conf.RedactionConf, secrets []string) (*Redactor, error) {
+	redactor := &Redactor{}
+
+	for _, pattern := range append(append([]string{}, builtInKeyPatterns...), redactionConf.KeyPatterns...) {
+		keyPattern, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Errorf("Redaction key pattern[%s] is not valid: %s", pattern, err)
+		}
+		redactor.keyPatterns = append(redactor.keyPatterns, keyPattern)
+	}
+
+	for _, pattern := range redactionConf.Regexes {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Errorf("Redaction regex[%s] is not valid: %s", pattern, err)
+		}
+		redactor.regexes = append(redactor.regexes, regex)
+	}
+
+	for _, path := range redactionConf.JsonPaths {
+		path = strings.Trim(strings.TrimPrefix(path, "$"), ".")
+		redactor.jsonPaths = append(redactor.jsonPaths, strings.Split(path, "."))
+	}
+
+	// longer secrets are replaced first, so that a secret containing another one is not partially redacted
+	sortedSecrets := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret != "" {
+			sortedSecrets = append(sortedSecrets, secret)
+		}
+	}
+	sort.Slice(sortedSecrets, func(i, j int) bool {
+		return len(sortedSecrets[i]) > len(sortedSecrets[j])
+	})
+	oldNew := make([]string, 0, 2*len(sortedSecrets))
+	for _, secret := range sortedSecrets {
+		oldNew = append(oldNew, secret, Replacement)
+	}
+	redactor.secrets = strings.NewReplacer(oldNew...)
+
+	return redactor, nil
+}
+
+// String redacts the secrets and the matches of the regexes in the text, and the values of the sensitive keys
+// of the JSON documents in it.
+func (r *Redactor) String(text string) string {
+	text = r.secrets.Replace(text)
+
+	for _, regex := range r.regexes {
+		text = regex.ReplaceAllString(text, Replacement)
+	}
+
+	return keyValueRegex.ReplaceAllStringFunc(text, func(keyValue string) string {
+		groups := keyValueRegex.FindStringSubmatch(keyValue)
+		if !r.isSensitiveKey(groups[1]) {
+			return keyValue
+		}
+		return `"` + groups[1] + `"` + groups[2] + `"` + Replacement + `"`
+	})
+}
+
+// Json redacts the values of the sensitive keys and of the JSON paths in the document, as well as what String
+// redacts in its strings. Document is redacted as a text if it is not valid.
+func (r *Redactor) Json(document string) string {
+	decoder := json.NewDecoder(strings.NewReader(document))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return r.String(document)
+	}
+
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(r.redactValue(value, nil)); err != nil {
+		return r.String(document)
+	}
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+func (r *Redactor) redactValue(value interface{}, path []string) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			childPath := append(path[:len(path):len(path)], key)
+			if r.isSensitiveKey(key) || r.matchesJsonPath(childPath) {
+				value[key] = Replacement
+			} else {
+				value[key] = r.redactValue(child, childPath)
+			}
+		}
+		return value
+	case []interface{}:
+		for i, child := range value {
+			childPath := append(path[:len(path):len(path)], strconv.Itoa(i))
+			if r.matchesJsonPath(childPath) {
+				value[i] = Replacement
+			} else {
+				value[i] = r.redactValue(child, childPath)
+			}
+		}
+		return value
+	case string:
+		return r.String(value)
+	default:
+		return value
+	}
+}
+
+func (r *Redactor) isSensitiveKey(key string) bool {
+	for _, keyPattern := range r.keyPatterns {
+		if keyPattern.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesJsonPath tells whether the path of a value is one of the JSON paths. Elements of the arrays are on the
+// paths of their indexes, a "*" segment matches any key or index.
+func (r *Redactor) matchesJsonPath(path []string) bool {
+	for _, jsonPath := range r.jsonPaths {
+		if len(jsonPath) != len(path) {
+			continue
+		}
+		matches := true
+		for i, segment := range jsonPath {
+			if segment != "*" && segment != path[i] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+/******************************************************************************************/
+
+var (
+	current   *Redactor
+	currentMu = &sync.RWMutex{}
+)
+
+// Configure redacts by the rules and the secrets of the configuration from then on. Nothing is redacted if
+// redaction is disabled.
+func Configure(configuration *conf.Configuration) error {
+
+	var redactor *Redactor
+	if !configuration.RedactionConf.Disabled {
+		var err error
+		redactor, err = NewRedactor(&configuration.RedactionConf, Secrets(configuration))
+		if err != nil {
+			return err
+		}
+	}
+
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = redactor
+	return nil
+}
+
+func get() *Redactor {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current
+}
+
+// String redacts the text by the configured redactor.
+func String(text string) string {
+	if redactor := get(); redactor != nil {
+		return redactor.String(text)
+	}
+	return text
+}
+
+// Json redacts the JSON document by the configured redactor.
+func Json(document string) string {
+	if redactor := get(); redactor != nil {
+		return redactor.Json(document)
+	}
+	return document
+}
+
+// Secrets returns the API keys, git passphrases, proxy password and admin token of the configuration.
+func Secrets(configuration *conf.Configuration) []string {
+	secrets := []string{
+		configuration.ApiKey,
+		configuration.HttpClientConf.ProxyConf.Password,
+		configuration.AdminConf.Token,
+	}
+
+	mappingsList := []conf.ActionMappings{configuration.ActionMappings}
+	for _, integration := range configuration.Integrations {
+		secrets = append(secrets, integration.ApiKey)
+		mappingsList = append(mappingsList, integration.ActionMappings)
+	}
+	for _, mappings := range mappingsList {
+		for _, action := range mappings {
+			secrets = append(secrets, action.GitOptions.Passphrase)
+		}
+	}
+	return secrets
+}
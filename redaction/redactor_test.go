@@ -0,0 +1,109 @@
+package redaction
+
+import (
+	"bytes"
+	"github.com/opsgenie/oec/conf"
+	"github.com/opsgenie/oec/git"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newRedactorTest(t *testing.T) *Redactor {
+	redactor, err := NewRedactor(&conf.RedactionConf{
+		JsonPaths:   []string{"$.alert.details.customerName", "alert.responders.*.id"},
+		KeyPatterns: []string{"(?i)password", "^token$"},
+		Regexes:     []string{`\b\d{4}-\d{4}-\d{4}-\d{4}\b`},
+	}, []string{"", "apiKey123", "gitPass"})
+	assert.Nil(t, err)
+	return redactor
+}
+
+func TestRedactString(t *testing.T) {
+	redactor := newRedactorTest(t)
+
+	assert.Equal(t, "Could not send with key [REDACTED]: 401", redactor.String("Could not send with key apiKey123: 401"))
+	assert.Equal(t, "Card [REDACTED] is charged.", redactor.String("Card 1234-5678-9012-3456 is charged."))
+	assert.Equal(t, `Message body: {"dbPassword": "[REDACTED]", "token":"[REDACTED]", "tokens":"t", "apiKey":"[REDACTED]"}`,
+		redactor.String(`Message body: {"dbPassword": "s3cr\"et", "token":12, "tokens":"t", "apiKey":"other"}`))
+	assert.Equal(t, "Nothing to redact.", redactor.String("Nothing to redact."))
+}
+
+func TestRedactJson(t *testing.T) {
+	redactor := newRedactorTest(t)
+
+	body := `{"action":"Create","alert":{"message":"Disk <full> on card 1234-5678-9012-3456","details":{"customerName":"John","password":"p","host":"h"},` +
+		`"responders":[{"id":"r1","type":"team"},{"id":"r2","type":"user"}]},"passphrase":"gitPass","count":12.50}`
+
+	assert.Equal(t, `{"action":"Create","alert":{"details":{"customerName":"[REDACTED]","host":"h","password":"[REDACTED]"},`+
+		`"message":"Disk <full> on card [REDACTED]","responders":[{"id":"[REDACTED]","type":"team"},{"id":"[REDACTED]","type":"user"}]},`+
+		`"count":12.50,"passphrase":"[REDACTED]"}`, redactor.Json(body))
+
+	assert.Equal(t, `not json with apiKey=[REDACTED]`, redactor.Json("not json with apiKey=apiKey123"))
+	assert.Equal(t, `["[REDACTED]",1]`, redactor.Json(`["gitPass",1]`))
+}
+
+func TestNewRedactorFailsWithInvalidPattern(t *testing.T) {
+	_, err := NewRedactor(&conf.RedactionConf{Regexes: []string{"("}}, nil)
+	assert.EqualError(t, err, "Redaction regex[(] is not valid: error parsing regexp: missing closing ): `(`")
+
+	_, err = NewRedactor(&conf.RedactionConf{KeyPatterns: []string{"[a-"}}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestSecrets(t *testing.T) {
+	configuration := &conf.Configuration{
+		ApiKey: "apiKey",
+		ActionSpecifications: conf.ActionSpecifications{ActionMappings: conf.ActionMappings{
+			"Create": conf.MappedAction{GitOptions: git.Options{Passphrase: "passphrase"}},
+		}},
+		Integrations: []conf.IntegrationConf{{
+			ApiKey: "integrationApiKey",
+			ActionSpecifications: conf.ActionSpecifications{ActionMappings: conf.ActionMappings{
+				"Close": conf.MappedAction{GitOptions: git.Options{Passphrase: "integrationPassphrase"}},
+			}},
+		}},
+		HttpClientConf: conf.HttpClientConf{ProxyConf: conf.ProxyConf{Password: "proxyPassword"}},
+		AdminConf:      conf.AdminConf{Token: "adminToken"},
+	}
+
+	assert.ElementsMatch(t, []string{"apiKey", "proxyPassword", "adminToken", "integrationApiKey", "passphrase", "integrationPassphrase"},
+		Secrets(configuration))
+}
+
+func TestConfigure(t *testing.T) {
+	defer Configure(&conf.Configuration{RedactionConf: conf.RedactionConf{Disabled: true}})
+
+	configuration := &conf.Configuration{ApiKey: "apiKey123"}
+	assert.Nil(t, Configure(configuration))
+	assert.Equal(t, "key [REDACTED]", String("key apiKey123"))
+	assert.Equal(t, `{"key":"[REDACTED]"}`, Json(`{"key":"apiKey123"}`))
+
+	configuration.RedactionConf.Disabled = true
+	assert.Nil(t, Configure(configuration))
+	assert.Equal(t, "key apiKey123", String("key apiKey123"))
+
+	configuration.RedactionConf = conf.RedactionConf{Regexes: []string{"("}}
+	assert.NotNil(t, Configure(configuration))
+}
+
+func TestFormatter(t *testing.T) {
+	defer Configure(&conf.Configuration{RedactionConf: conf.RedactionConf{Disabled: true}})
+	assert.Nil(t, Configure(&conf.Configuration{ApiKey: "apiKey123"}))
+
+	buffer := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.SetOutput(buffer)
+	logger.SetFormatter(NewFormatter(&logrus.JSONFormatter{}))
+
+	logger.WithField("key", "apiKey123").
+		WithError(errors.New("Unauthorized key apiKey123")).
+		WithField("attempts", 3).
+		Warnf(`Could not send result with payload {"apiKey":"%s"}`, "apiKey123")
+
+	assert.NotContains(t, buffer.String(), "apiKey123")
+	assert.Contains(t, buffer.String(), `"key":"[REDACTED]"`)
+	assert.Contains(t, buffer.String(), `"error":"Unauthorized key [REDACTED]"`)
+	assert.Contains(t, buffer.String(), `"attempts":3`)
+}
@@ -33,6 +33,11 @@ func (r *Retryer) Do(request *Request) (*http.Response, error) {
 	return DoWithExponentialBackoff(r, request)
 }
 
+// IsRetryable returns whether a request which is responded with the status code is retried.
+func IsRetryable(statusCode int) bool {
+	return shouldRetry(statusCode)
+}
+
 func shouldRetry(statusCode int) bool {
 	_, shouldRetry := retryStatusCodes[statusCode]
 
@@ -93,7 +98,11 @@ func DoWithExponentialBackoff(retryer *Retryer, request *Request) (*http.Respons
 		}
 
 		waitDuration := getWaitTime(retryCount - 1)
-		time.Sleep(waitDuration)
+		select {
+		case <-request.Context().Done():
+			return nil, request.Context().Err()
+		case <-time.After(waitDuration):
+		}
 	}
 
 	return nil, errors.Errorf("Couldn't get a success response, maximum retry count[%d] is exceeded, %s", maxRetryCount, errMessage)
@@ -2,15 +2,21 @@ package runbook
 
 import (
 	"bytes"
+	"context"
+	"github.com/pkg/errors"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 var ExecuteFunc = Execute
 
+// KillGracePeriod is the time given to a cancelled execution to exit after it is signalled, before it is killed.
+var KillGracePeriod = 10 * time.Second
+
 var executables = map[string][]string{
 	".bat":    {"cmd", "/C"},
 	".cmd":    {"cmd", "/C"},
@@ -21,12 +27,27 @@ var executables = map[string][]string{
 	".go":     {"go", "run"},
 }
 
+// ExecError is returned if the execution fails. Exit code is -1 if the process could not be started or was
+// terminated by a signal.
 type ExecError struct {
-	Stderr string
+	Stderr   string
+	ExitCode int
 	error
 }
 
-func Execute(executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
+// Command returns the command line an executable is run with, which starts with the interpreter of the
+// executable if it is a script.
+func Command(executablePath string, args []string) []string {
+	fileExt := filepath.Ext(strings.ToLower(executablePath))
+	command := executables[fileExt]
+
+	commandLine := make([]string, 0, len(command)+len(args)+1)
+	commandLine = append(commandLine, command...)
+	commandLine = append(commandLine, executablePath)
+	return append(commandLine, args...)
+}
+
+func Execute(ctx context.Context, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) error {
 
 	if args == nil {
 		args = []string{}
@@ -34,16 +55,10 @@ func Execute(executablePath string, args, environmentVars []string, stdout, stde
 		environmentVars = []string{}
 	}
 
-	var cmd *exec.Cmd
-	fileExt := filepath.Ext(strings.ToLower(executablePath))
-	command, exist := executables[fileExt]
+	command := Command(executablePath, args)
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
 
-	if exist {
-		args = append(append(command[1:], executablePath), args...)
-		cmd = exec.Command(command[0], args...)
-	} else {
-		cmd = exec.Command(executablePath, args...)
-	}
+	setTermination(cmd)
 
 	cmd.Env = append(os.Environ(), environmentVars...)
 
@@ -57,8 +72,15 @@ func Execute(executablePath string, args, environmentVars []string, stdout, stde
 	}
 
 	err := cmd.Run()
+	if errors.Is(err, exec.ErrWaitDelay) && cmd.ProcessState.ExitCode() == 0 && ctx.Err() == nil {
+		// the executable has succeeded, but a process it has left in the background still holds its output
+		return nil
+	}
 	if err != nil {
-		return &ExecError{stderrBuff.String(), err}
+		if ctx.Err() != nil {
+			err = errors.Errorf("%s (execution is cancelled: %s)", err, ctx.Err())
+		}
+		return &ExecError{Stderr: stderrBuff.String(), ExitCode: cmd.ProcessState.ExitCode(), error: err}
 	}
 
 	return nil
@@ -2,11 +2,13 @@ package runbook
 
 import (
 	"bytes"
+	"context"
 	"github.com/opsgenie/oec/util"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"runtime"
 	"testing"
+	"time"
 )
 
 const shFileExt = ".sh"
@@ -25,7 +27,7 @@ func TestExecuteSuccess(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		err = Execute(tmpFilePath, nil, testEnvironmentVariables, cmdOutput, cmdErr)
+		err = Execute(context.Background(), tmpFilePath, nil, testEnvironmentVariables, cmdOutput, cmdErr)
 
 		assert.NoError(t, err, "Error from Execute operation was not empty.")
 		assert.Equal(t, "", cmdErr.String(), "Error stream from executed file was not empty.")
@@ -41,7 +43,7 @@ func TestExecuteSuccess(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		err = Execute(tmpFilePath, nil, testEnvironmentVariables, cmdOutput, cmdErr)
+		err = Execute(context.Background(), tmpFilePath, nil, testEnvironmentVariables, cmdOutput, cmdErr)
 
 		assert.NoError(t, err, "Error from Execute operation was not empty.")
 		assert.Equal(t, "", cmdErr.String(), "Error stream from executed file was not empty.")
@@ -61,7 +63,7 @@ func TestExecuteWithErrorStream(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		err = Execute(tmpFilePath, nil, nil, cmdOutput, cmdErr)
+		err = Execute(context.Background(), tmpFilePath, nil, nil, cmdOutput, cmdErr)
 
 		assert.NoError(t, err, "Error from Execute operation was not empty.")
 		assert.Equal(t, "", cmdOutput.String(), "Output stream from executed file was not empty.")
@@ -77,7 +79,7 @@ func TestExecuteWithErrorStream(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		err = Execute(tmpFilePath, nil, nil, cmdOutput, cmdErr)
+		err = Execute(context.Background(), tmpFilePath, nil, nil, cmdOutput, cmdErr)
 
 		assert.NoError(t, err, "Error from Execute operation was not empty.")
 		assert.Equal(t, "", cmdOutput.String(), "Output stream from executed file was not empty.")
@@ -96,7 +98,7 @@ func TestExecuteWithError(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		err = Execute(tmpFilePath, nil, nil, cmdOutput, cmdErr)
+		err = Execute(context.Background(), tmpFilePath, nil, nil, cmdOutput, cmdErr)
 
 		assert.IsType(t, &ExecError{}, err)
 		assert.Error(t, err, "Error from Execute operation was empty.")
@@ -114,7 +116,7 @@ func TestExecuteWithError(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		err = Execute(tmpFilePath, nil, nil, cmdOutput, cmdErr)
+		err = Execute(context.Background(), tmpFilePath, nil, nil, cmdOutput, cmdErr)
 
 		assert.IsType(t, &ExecError{}, err)
 		assert.Error(t, err, "Error from Execute operation was empty.")
@@ -133,7 +135,7 @@ func TestExecuteWithError(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		err = Execute(tmpFilePath, nil, nil, cmdOutput, cmdErr)
+		err = Execute(context.Background(), tmpFilePath, nil, nil, cmdOutput, cmdErr)
 
 		assert.IsType(t, &ExecError{}, err)
 		assert.Error(t, err, "Error from Execute operation was empty.")
@@ -143,3 +145,23 @@ func TestExecuteWithError(t *testing.T) {
 		assert.Contains(t, err.(*ExecError).Stderr, cmdErr.String(), "ExecError is not same as cmdErr.")
 	}
 }
+
+func TestExecuteCancelled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Graceful termination is not supported on windows.")
+	}
+
+	tmpFilePath, err := util.CreateTempTestFile([]byte("sleep 10\n"), shFileExt)
+	defer os.Remove(tmpFilePath)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = Execute(ctx, tmpFilePath, nil, nil, &bytes.Buffer{}, &bytes.Buffer{})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "execution is cancelled")
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
@@ -0,0 +1,39 @@
+package runbook
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+var (
+	resultCallbackDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "oec_result_callback_duration_seconds",
+			Help:    "Duration of sending the action results to Opsgenie.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"integration"},
+	)
+	resultCallbackFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oec_result_callback_failures_total",
+			Help: "Number of action results which could not be sent to Opsgenie.",
+		},
+		[]string{"integration"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		resultCallbackDuration,
+		resultCallbackFailures,
+	)
+}
+
+// ObserveResultCallback records a result of the integration which is sent to Opsgenie in the given duration.
+func ObserveResultCallback(integration string, took time.Duration, err error) {
+	resultCallbackDuration.WithLabelValues(integration).Observe(took.Seconds())
+	if err != nil {
+		resultCallbackFailures.WithLabelValues(integration).Inc()
+	}
+}
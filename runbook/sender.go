@@ -2,8 +2,10 @@ package runbook
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/opsgenie/oec/retryer"
+	"github.com/opsgenie/oec/tracing"
 	"github.com/pkg/errors"
 	"io/ioutil"
 	"net/http"
@@ -24,15 +26,37 @@ type ActionResultPayload struct {
 	Action         string `json:"action,omitempty"`
 	ActionType     string `json:"actionType,omitempty"`
 	FailureMessage string `json:"failureMessage,omitempty"`
+	CaptureFile    string `json:"captureFile,omitempty"`
 	*HttpResponse
 }
 
+// ResultError is returned if Opsgenie responds to the result with an unexpected status.
+type ResultError struct {
+	StatusCode int
+	error
+}
+
+// IsRetryable returns whether the result may be accepted if it is sent again.
+func (e *ResultError) IsRetryable() bool {
+	return retryer.IsRetryable(e.StatusCode)
+}
+
 type HttpResponse struct {
 	Headers    map[string]string `json:"headers"`
 	Body       string            `json:"body"`
 	StatusCode int               `json:"statusCode"`
 }
 
+// StartSendResultSpan starts the span of sending the result to Opsgenie in the trace of the message.
+func StartSendResultSpan(ctx context.Context, resultPayload *ActionResultPayload) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "send-result", tracing.Client)
+	span.SetAttribute("oec.request_id", resultPayload.RequestId)
+	span.SetAttribute("oec.entity_id", resultPayload.EntityId)
+	span.SetAttribute("oec.action", resultPayload.Action)
+	span.SetAttribute("oec.is_successful", resultPayload.IsSuccessful)
+	return ctx, span
+}
+
 func SendResultToOpsGenie(resultPayload *ActionResultPayload, apiKey, baseUrl string) error {
 
 	body, err := json.Marshal(resultPayload)
@@ -62,9 +86,9 @@ func SendResultToOpsGenie(resultPayload *ActionResultPayload, apiKey, baseUrl st
 
 		body, err := ioutil.ReadAll(response.Body)
 		if err == nil {
-			return errors.Errorf("%s, error message: %s", errorMessage, string(body))
+			return &ResultError{response.StatusCode, errors.Errorf("%s, error message: %s", errorMessage, string(body))}
 		} else {
-			return errors.Errorf("%s, also could not read response body: %s", errorMessage, err)
+			return &ResultError{response.StatusCode, errors.Errorf("%s, also could not read response body: %s", errorMessage, err)}
 		}
 	}
 
@@ -78,6 +78,11 @@ func TestCannotSendResultToOpsGenie(t *testing.T) {
 	err := SendResultToOpsGenie(new(ActionResultPayload), apiKey, ts.URL)
 
 	assert.Error(t, err, "Could not send action result to OpsGenie. HttpStatus: 400")
+
+	resultErr, ok := err.(*ResultError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusBadRequest, resultErr.StatusCode)
+	assert.False(t, resultErr.IsRetryable())
 }
 
 func TestSendResultToOpsGenieClientError(t *testing.T) {
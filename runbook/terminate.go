@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package runbook
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setTermination runs the command in its own process group so that the processes it spawns
+// are asked to exit gracefully together with it, they are killed if still running after KillGracePeriod.
+// The whole group is killed, since exec kills only the process it has started once WaitDelay passes.
+func setTermination(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		pgid := cmd.Process.Pid
+		time.AfterFunc(KillGracePeriod, func() {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		})
+		return syscall.Kill(-pgid, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = KillGracePeriod
+}
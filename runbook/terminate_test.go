@@ -0,0 +1,86 @@
+//go:build !windows
+// +build !windows
+
+package runbook
+
+import (
+	"bytes"
+	"context"
+	"github.com/opsgenie/oec/util"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestExecuteCancelledKillsChildrenIgnoringTermination(t *testing.T) {
+	defer func(gracePeriod time.Duration) {
+		KillGracePeriod = gracePeriod
+	}(KillGracePeriod)
+	KillGracePeriod = 200 * time.Millisecond
+
+	childPidFile, err := ioutil.TempFile("", "oecChildPid")
+	assert.Nil(t, err)
+	childPidFile.Close()
+	defer os.Remove(childPidFile.Name())
+
+	content := []byte("trap '' TERM\nsleep 30 &\necho $! > " + childPidFile.Name() + "\nwait\n")
+	tmpFilePath, err := util.CreateTempTestFile(content, shFileExt)
+	defer os.Remove(tmpFilePath)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var childPid int
+	go func() {
+		assert.Eventually(t, func() bool {
+			pid, err := ioutil.ReadFile(childPidFile.Name())
+			childPid, _ = strconv.Atoi(strings.TrimSpace(string(pid)))
+			return err == nil && childPid > 0
+		}, 5*time.Second, 10*time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	err = Execute(ctx, tmpFilePath, nil, nil, &bytes.Buffer{}, &bytes.Buffer{})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "execution is cancelled")
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Eventually(t, func() bool {
+		return !isRunning(childPid)
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestExecuteSucceedsWithBackgroundProcessHoldingOutput(t *testing.T) {
+	defer func(gracePeriod time.Duration) {
+		KillGracePeriod = gracePeriod
+	}(KillGracePeriod)
+	KillGracePeriod = 100 * time.Millisecond
+
+	tmpFilePath, err := util.CreateTempTestFile([]byte("sleep 2 &\necho done\n"), shFileExt)
+	defer os.Remove(tmpFilePath)
+	assert.Nil(t, err)
+
+	cmdOutput := &bytes.Buffer{}
+	err = Execute(context.Background(), tmpFilePath, nil, nil, cmdOutput, &bytes.Buffer{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "done\n", cmdOutput.String())
+}
+
+// isRunning returns whether the process exists and has not exited, an exited process may be a zombie until it is
+// reaped by its new parent.
+func isRunning(pid int) bool {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return syscall.Kill(pid, 0) == nil
+	}
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package runbook
+
+import "os/exec"
+
+// setTermination kills the process since Windows does not support sending interrupt signals to processes.
+func setTermination(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = KillGracePeriod
+}
@@ -0,0 +1,284 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/opsgenie/oec/redaction"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tracesPath      = "/v1/traces"
+	scopeName       = "github.com/opsgenie/oec"
+	maxQueueSize    = 2048
+	maxBatchSize    = 512
+	statusCodeError = 2
+)
+
+// exporter sends the ended spans in batches to an OTLP/HTTP endpoint in the JSON encoding. Spans are
+// dropped if they are ended faster than they can be exported.
+type exporter struct {
+	url         string
+	headers     map[string]string
+	serviceName string
+	period      time.Duration
+
+	spans   chan *Span
+	quit    chan struct{}
+	done    chan struct{}
+	dropped int64
+	stopMu  *sync.Mutex
+}
+
+func newExporter(endpoint string, headers map[string]string, serviceName string, period time.Duration) *exporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, tracesPath) {
+		url += tracesPath
+	}
+	return &exporter{
+		url:         url,
+		headers:     headers,
+		serviceName: serviceName,
+		period:      period,
+		spans:       make(chan *Span, maxQueueSize),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+		stopMu:      &sync.Mutex{},
+	}
+}
+
+func (e *exporter) start() {
+	go e.run()
+}
+
+func (e *exporter) export(span *Span) {
+	select {
+	case e.spans <- span:
+	default:
+		if atomic.AddInt64(&e.dropped, 1) == 1 {
+			logrus.Warnf("Spans are dropped, since they could not be exported as fast as they ended.")
+		}
+	}
+}
+
+// stop exports the spans which have already ended, until the given context is done.
+func (e *exporter) stop(ctx context.Context) error {
+	e.stopMu.Lock()
+	select {
+	case <-e.quit:
+	default:
+		close(e.quit)
+	}
+	e.stopMu.Unlock()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return errors.Errorf("Spans could not be exported before shutdown: %s", ctx.Err())
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.period)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, maxBatchSize)
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) >= maxBatchSize {
+				e.send(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				e.send(batch)
+				batch = batch[:0]
+			}
+		case <-e.quit:
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+			}
+			for len(batch) > 0 {
+				size := len(batch)
+				if size > maxBatchSize {
+					size = maxBatchSize
+				}
+				e.send(batch[:size])
+				batch = batch[size:]
+			}
+			return
+		}
+	}
+}
+
+func (e *exporter) send(spans []*Span) {
+
+	body, err := json.Marshal(e.newRequest(spans))
+	if err != nil {
+		logrus.Warnf("%d spans could not be encoded: %s", len(spans), err)
+		return
+	}
+
+	request, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		logrus.Warnf("%d spans could not be exported: %s", len(spans), err)
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := HttpClient.Do(request)
+	if err != nil {
+		logrus.Warnf("%d spans could not be exported to %s: %s", len(spans), e.url, err)
+		return
+	}
+	defer response.Body.Close()
+	defer io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode/100 != 2 {
+		logrus.Warnf("%d spans could not be exported to %s, unexpected response status: %d", len(spans), e.url, response.StatusCode)
+		return
+	}
+
+	if dropped := atomic.SwapInt64(&e.dropped, 0); dropped > 0 {
+		logrus.Warnf("%d spans have been dropped since the last export.", dropped)
+	}
+	logrus.Tracef("%d spans are exported to %s.", len(spans), e.url)
+}
+
+/******************************************************************************************/
+// OTLP/HTTP JSON encoding of the spans, as defined by opentelemetry-proto.
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanData `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type spanData struct {
+	TraceId           string     `json:"traceId"`
+	SpanId            string     `json:"spanId"`
+	ParentSpanId      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              SpanKind   `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            *status    `json:"status,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func (e *exporter) newRequest(spans []*Span) *exportRequest {
+	data := make([]spanData, 0, len(spans))
+	for _, span := range spans {
+		data = append(data, newSpanData(span))
+	}
+
+	return &exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{Attributes: []keyValue{newKeyValue("service.name", e.serviceName)}},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: scopeName},
+				Spans: data,
+			}},
+		}},
+	}
+}
+
+func newSpanData(span *Span) spanData {
+	span.mu.Lock()
+	defer span.mu.Unlock()
+
+	data := spanData{
+		TraceId:           hex.EncodeToString(span.spanContext.TraceId[:]),
+		SpanId:            hex.EncodeToString(span.spanContext.SpanId[:]),
+		Name:              span.name,
+		Kind:              span.kind,
+		StartTimeUnixNano: strconv.FormatInt(span.startTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.endTime.UnixNano(), 10),
+	}
+	if span.parentSpanId != (SpanId{}) {
+		data.ParentSpanId = hex.EncodeToString(span.parentSpanId[:])
+	}
+	for _, attribute := range span.attributes {
+		data.Attributes = append(data.Attributes, newKeyValue(attribute.Key, attribute.Value))
+	}
+	if span.errorMessage != "" {
+		data.Status = &status{Code: statusCodeError, Message: redaction.String(span.errorMessage)}
+	}
+	return data
+}
+
+func newKeyValue(key string, value interface{}) keyValue {
+	kv := keyValue{Key: key}
+	switch value := value.(type) {
+	case string:
+		value = redaction.String(value)
+		kv.Value.StringValue = &value
+	case bool:
+		kv.Value.BoolValue = &value
+	case int:
+		intValue := strconv.Itoa(value)
+		kv.Value.IntValue = &intValue
+	case int64:
+		intValue := strconv.FormatInt(value, 10)
+		kv.Value.IntValue = &intValue
+	case float64:
+		kv.Value.DoubleValue = &value
+	default:
+		stringValue := fmt.Sprint(value)
+		kv.Value.StringValue = &stringValue
+	}
+	return kv
+}
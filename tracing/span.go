@@ -0,0 +1,214 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceParentEnvName is the environment variable which passes the trace context to the actions, so that
+// the calls they make can join the trace of the message.
+const TraceParentEnvName = "TRACEPARENT"
+
+type TraceId [16]byte
+type SpanId [8]byte
+
+type SpanKind int
+
+// Kinds of the spans as defined by OpenTelemetry.
+const (
+	Internal SpanKind = 1
+	Client   SpanKind = 3
+	Consumer SpanKind = 5
+)
+
+// SpanContext identifies a span in a trace, it is propagated in the W3C traceparent format.
+type SpanContext struct {
+	TraceId TraceId
+	SpanId  SpanId
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceId != TraceId{} && sc.SpanId != SpanId{}
+}
+
+// TraceParent returns the span context in the W3C traceparent format, or an empty string if it is not valid.
+func (sc SpanContext) TraceParent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceId[:]) + "-" + hex.EncodeToString(sc.SpanId[:]) + "-" + flags
+}
+
+// ParseTraceParent parses a span context in the W3C traceparent format.
+func ParseTraceParent(traceParent string) (SpanContext, error) {
+	sc := SpanContext{}
+
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, errors.Errorf("Traceparent[%s] is not valid.", traceParent)
+	}
+
+	traceId, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceId) != len(sc.TraceId) {
+		return sc, errors.Errorf("Trace id of traceparent[%s] is not valid.", traceParent)
+	}
+	spanId, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanId) != len(sc.SpanId) {
+		return sc, errors.Errorf("Span id of traceparent[%s] is not valid.", traceParent)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, errors.Errorf("Flags of traceparent[%s] are not valid.", traceParent)
+	}
+
+	copy(sc.TraceId[:], traceId)
+	copy(sc.SpanId[:], spanId)
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.IsValid() {
+		return SpanContext{}, errors.Errorf("Traceparent[%s] is not valid.", traceParent)
+	}
+	return sc, nil
+}
+
+// Span is an operation in the trace of a message. Methods of a nil span do nothing, so that
+// spans can be used in the same way while tracing is disabled.
+type Span struct {
+	tracer       *tracer
+	name         string
+	kind         SpanKind
+	spanContext  SpanContext
+	parentSpanId SpanId
+
+	startTime    time.Time
+	endTime      time.Time
+	attributes   []Attribute
+	errorMessage string
+	isEnded      bool
+	mu           *sync.Mutex
+}
+
+// Attribute is a tag of a span, its value is a string, bool, int, int64 or float64.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.spanContext
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil || !s.spanContext.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.attributes {
+		if s.attributes[i].Key == key {
+			s.attributes[i].Value = value
+			return
+		}
+	}
+	s.attributes = append(s.attributes, Attribute{Key: key, Value: value})
+}
+
+// SetError marks the span as failed with the given error. It does nothing if the error is nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errorMessage = err.Error()
+}
+
+// End completes the span and exports it if it is sampled. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.isEnded {
+		s.mu.Unlock()
+		return
+	}
+	s.isEnded = true
+	s.endTime = time.Now()
+	s.mu.Unlock()
+
+	if s.spanContext.Sampled {
+		s.tracer.exporter.export(s)
+	}
+}
+
+type spanKey struct{}
+type remoteSpanContextKey struct{}
+
+// ContextWithSpan returns a context whose spans are children of the given span. It returns the context itself
+// if the span is nil.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// ContextWithRemoteSpanContext returns a context whose spans are children of a span of another process.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteSpanContextKey{}, sc)
+}
+
+// SpanFromContext returns the current span of the context, or nil if there is not any.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// TraceParent returns the current span context of the context in the W3C traceparent format, or an empty
+// string if there is not any.
+func TraceParent(ctx context.Context) string {
+	return parentSpanContext(ctx).TraceParent()
+}
+
+func parentSpanContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.spanContext
+	}
+	if ctx == nil {
+		return SpanContext{}
+	}
+	sc, _ := ctx.Value(remoteSpanContextKey{}).(SpanContext)
+	return sc
+}
+
+func newTraceId() TraceId {
+	traceId := TraceId{}
+	rand.Read(traceId[:])
+	return traceId
+}
+
+func newSpanId() SpanId {
+	spanId := SpanId{}
+	rand.Read(spanId[:])
+	return spanId
+}
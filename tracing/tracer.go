@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/opsgenie/oec/conf"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultServiceName  = "oec"
+	defaultSampleRatio  = 1.0
+	defaultExportPeriod = 5 * time.Second
+	exportTimeout       = 40 * time.Second
+)
+
+// HttpClient is used to export the spans, its transport is replaced with the one using the configured proxy and
+// certificates.
+var HttpClient = &http.Client{Timeout: exportTimeout}
+
+var (
+	current   *tracer
+	currentMu = &sync.RWMutex{}
+)
+
+type tracer struct {
+	sampleRatio float64
+	exporter    *exporter
+}
+
+// Configure starts exporting the spans to the configured OTLP endpoint. Tracing is a no-op until it is
+// configured, or if it is not enabled.
+func Configure(tracingConf *conf.TracingConf) {
+
+	if !tracingConf.Enabled {
+		return
+	}
+
+	if tracingConf.ServiceName == "" {
+		tracingConf.ServiceName = defaultServiceName
+	}
+
+	if tracingConf.SampleRatio <= 0 || tracingConf.SampleRatio > 1 {
+		logrus.Infof("Sample ratio of tracing should be between 0 and 1, default value[%g] is set.", defaultSampleRatio)
+		tracingConf.SampleRatio = defaultSampleRatio
+	}
+
+	exportPeriod := defaultExportPeriod
+	if tracingConf.ExportPeriodInSeconds > 0 {
+		exportPeriod = time.Duration(tracingConf.ExportPeriodInSeconds) * time.Second
+	}
+
+	t := &tracer{
+		sampleRatio: tracingConf.SampleRatio,
+		exporter:    newExporter(tracingConf.Endpoint, tracingConf.Headers, tracingConf.ServiceName, exportPeriod),
+	}
+	t.exporter.start()
+
+	currentMu.Lock()
+	previous := current
+	current = t
+	currentMu.Unlock()
+
+	if previous != nil {
+		previous.exporter.stop(context.Background())
+	}
+
+	logrus.Infof("Spans are exported to %s with sample ratio %g.", t.exporter.url, t.sampleRatio)
+}
+
+// Shutdown exports the remaining spans until the given context is done, then tracing becomes a no-op.
+func Shutdown(ctx context.Context) error {
+	currentMu.Lock()
+	t := current
+	current = nil
+	currentMu.Unlock()
+
+	if t == nil {
+		return nil
+	}
+	return t.exporter.stop(ctx)
+}
+
+// Start starts a span as a child of the current span of the context. It returns a nil span, which does nothing,
+// if tracing is not configured.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return StartAt(ctx, name, kind, time.Now())
+}
+
+// StartAt starts a span which has started at the given time.
+func StartAt(ctx context.Context, name string, kind SpanKind, startTime time.Time) (context.Context, *Span) {
+	currentMu.RLock()
+	t := current
+	currentMu.RUnlock()
+
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := parentSpanContext(ctx)
+	span := &Span{
+		tracer:    t,
+		name:      name,
+		kind:      kind,
+		startTime: startTime,
+		mu:        &sync.Mutex{},
+	}
+
+	if parent.IsValid() {
+		span.spanContext = SpanContext{TraceId: parent.TraceId, SpanId: newSpanId(), Sampled: parent.Sampled}
+		span.parentSpanId = parent.SpanId
+	} else {
+		traceId := newTraceId()
+		span.spanContext = SpanContext{TraceId: traceId, SpanId: newSpanId(), Sampled: t.shouldSample(traceId)}
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// shouldSample samples the traces by their ids, so that all instances sample the same traces for the same ratio.
+func (t *tracer) shouldSample(traceId TraceId) bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	bound := uint64(t.sampleRatio * (1 << 63))
+	return binary.BigEndian.Uint64(traceId[8:16])>>1 < bound
+}
@@ -0,0 +1,169 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/opsgenie/oec/conf"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+type mockCollector struct {
+	server   *httptest.Server
+	requests []exportRequest
+	headers  []http.Header
+	mu       *sync.Mutex
+}
+
+func newMockCollector() *mockCollector {
+	collector := &mockCollector{mu: &sync.Mutex{}}
+	collector.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := exportRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+
+		collector.mu.Lock()
+		defer collector.mu.Unlock()
+		collector.requests = append(collector.requests, request)
+		collector.headers = append(collector.headers, r.Header)
+	}))
+	return collector
+}
+
+func (c *mockCollector) spans() []spanData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	spans := make([]spanData, 0)
+	for _, request := range c.requests {
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				spans = append(spans, scopeSpans.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+func TestTraceParent(t *testing.T) {
+
+	sc, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.Nil(t, err)
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.TraceParent())
+
+	sc, err = ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	assert.Nil(t, err)
+	assert.False(t, sc.Sampled)
+
+	_, err = ParseTraceParent("00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	assert.NotNil(t, err)
+
+	_, err = ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7")
+	assert.NotNil(t, err)
+
+	_, err = ParseTraceParent("00-4bf92f3577b34da6-00f067aa0ba902b7-01")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, "", SpanContext{}.TraceParent())
+}
+
+func TestSpansAreNoOpIfNotConfigured(t *testing.T) {
+
+	ctx, span := Start(context.Background(), "test", Internal)
+	assert.Nil(t, span)
+	assert.Nil(t, SpanFromContext(ctx))
+	assert.Equal(t, "", TraceParent(ctx))
+
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("Test error"))
+	span.End()
+	assert.False(t, span.SpanContext().IsValid())
+}
+
+func TestSampling(t *testing.T) {
+
+	tracer := &tracer{sampleRatio: 0.5}
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		if tracer.shouldSample(newTraceId()) {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 500, sampled, 100)
+
+	tracer.sampleRatio = 1
+	assert.True(t, tracer.shouldSample(newTraceId()))
+}
+
+func TestExportSpans(t *testing.T) {
+
+	collector := newMockCollector()
+	defer collector.server.Close()
+
+	Configure(&conf.TracingConf{
+		Enabled:     true,
+		Endpoint:    collector.server.URL,
+		Headers:     map[string]string{"X-Api-Key": "key"},
+		ServiceName: "oec-test",
+	})
+
+	ctx, parent := Start(context.Background(), "parent", Consumer)
+	parent.SetAttribute("oec.request_id", "requestId")
+	parent.SetAttribute("oec.queue_latency_ms", int64(12))
+
+	childCtx, child := Start(ctx, "child", Internal)
+	child.SetError(errors.New("Test error"))
+	assert.Equal(t, child.SpanContext().TraceParent(), TraceParent(childCtx))
+	child.End()
+	child.End()
+	parent.End()
+
+	remote, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	assert.Nil(t, err)
+	_, unsampled := Start(ContextWithRemoteSpanContext(context.Background(), remote), "unsampled", Client)
+	assert.False(t, unsampled.SpanContext().Sampled)
+	unsampled.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = Shutdown(ctx)
+	assert.Nil(t, err)
+
+	_, span := Start(context.Background(), "afterShutdown", Internal)
+	assert.Nil(t, span)
+
+	spans := collector.spans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "key", collector.headers[0].Get("X-Api-Key"))
+	assert.Equal(t, "oec-test", *collector.requests[0].ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+
+	childData, parentData := spans[0], spans[1]
+	assert.Equal(t, "child", childData.Name)
+	assert.Equal(t, parentData.TraceId, childData.TraceId)
+	assert.Equal(t, parentData.SpanId, childData.ParentSpanId)
+	assert.Equal(t, &status{Code: statusCodeError, Message: "Test error"}, childData.Status)
+
+	assert.Equal(t, "parent", parentData.Name)
+	assert.Equal(t, Consumer, parentData.Kind)
+	assert.Equal(t, "", parentData.ParentSpanId)
+	assert.Equal(t, "requestId", *parentData.Attributes[0].Value.StringValue)
+	assert.Equal(t, "12", *parentData.Attributes[1].Value.IntValue)
+}
+
+func TestNewExporterAddsTracesPath(t *testing.T) {
+
+	assert.Equal(t, "http://localhost:4318/v1/traces", newExporter("http://localhost:4318/", nil, "oec", time.Second).url)
+	assert.Equal(t, "http://localhost:4318/v1/traces", newExporter("http://localhost:4318/v1/traces", nil, "oec", time.Second).url)
+}
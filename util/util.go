@@ -41,9 +41,12 @@ func ChmodRecursively(path string, mode os.FileMode) error {
 	})
 }
 
-func CheckLogFile(logger *lumberjack.Logger, interval time.Duration) {
+// CheckLogFile creates the log file again in every interval if it is removed, until quit is closed.
+func CheckLogFile(logger *lumberjack.Logger, interval time.Duration, quit <-chan struct{}) {
 	for {
 		select {
+		case <-quit:
+			return
 		case <-time.After(interval):
 			if _, err := os.Stat(logger.Filename); os.IsNotExist(err) {
 				logrus.Warnf("Failed to open OEC log file: %v. New file will be created.", err)
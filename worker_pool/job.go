@@ -1,6 +1,69 @@
 package worker_pool
 
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
 type Job interface {
 	Id() string
-	Execute() error
+	Execute(ctx context.Context) error
+}
+
+// ReleasableJob is a job which should give back what it holds, if it could not be started
+// before the worker pool has stopped.
+type ReleasableJob interface {
+	Job
+	Release() error
+}
+
+// Priorities of the jobs, like the priorities of the alerts from P1 to P5.
+const (
+	HighestPriority = 1
+	DefaultPriority = 3
+	LowestPriority  = 5
+)
+
+// PrioritizedJob is a job which should be started before the waiting jobs of lower priorities. The jobs which are
+// not prioritized have the default priority.
+type PrioritizedJob interface {
+	Job
+	Priority() int
+}
+
+func priorityOf(job Job) int {
+	prioritizedJob, ok := job.(PrioritizedJob)
+	if !ok {
+		return DefaultPriority
+	}
+
+	priority := prioritizedJob.Priority()
+	if priority < HighestPriority {
+		return HighestPriority
+	}
+	if priority > LowestPriority {
+		return LowestPriority
+	}
+	return priority
+}
+
+// PanicError is the error of a job which has panicked, with the stack trace of the panic.
+type PanicError struct {
+	JobId     string
+	Recovered interface{}
+	Stack     []byte
+}
+
+// NewPanicError returns the error of the recovered panic, it should be called by the deferred func recovering it.
+func NewPanicError(jobId string, recovered interface{}) *PanicError {
+	return &PanicError{
+		JobId:     jobId,
+		Recovered: recovered,
+		Stack:     debug.Stack(),
+	}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("Job[%s] has panicked: %v", e.JobId, e.Recovered)
 }
@@ -0,0 +1,150 @@
+package worker_pool
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// queuedJob is a job waiting in the queue of the pool. Jobs are started in the order of their deadlines, which are
+// their submit times delayed by an aging period for each level below the highest priority. So a waiting job is
+// started before the jobs of one level higher priority submitted an aging period after it, and no job starves.
+type queuedJob struct {
+	job         Job
+	priority    int
+	submittedAt time.Time
+	deadline    time.Time
+	sequence    uint64
+	index       int
+}
+
+type jobHeap []*queuedJob
+
+func (h jobHeap) Len() int {
+	return len(h)
+}
+
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].deadline.Equal(h[j].deadline) {
+		return h[i].sequence < h[j].sequence
+	}
+	return h[i].deadline.Before(h[j].deadline)
+}
+
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x interface{}) {
+	item := x.(*queuedJob)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	item.index = -1
+	return item
+}
+
+// jobQueue is the priority queue of the jobs waiting for a worker. It notifies the dispatcher of the pool whenever
+// a job is pushed, since the job may be started before the one the dispatcher is about to hand out.
+type jobQueue struct {
+	jobs        jobHeap
+	agingPeriod time.Duration
+	sequence    uint64
+	pushed      chan struct{}
+	mu          *sync.Mutex
+}
+
+func newJobQueue(agingPeriod time.Duration) *jobQueue {
+	return &jobQueue{
+		jobs:        make(jobHeap, 0),
+		agingPeriod: agingPeriod,
+		pushed:      make(chan struct{}, 1),
+		mu:          &sync.Mutex{},
+	}
+}
+
+// offer pushes the job unless the queue has limit or more jobs. It returns whether the job is pushed.
+func (q *jobQueue) offer(job Job, limit int, now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.jobs) >= limit {
+		return false
+	}
+	q.pushLocked(job, now)
+	return true
+}
+
+func (q *jobQueue) push(job Job, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pushLocked(job, now)
+}
+
+func (q *jobQueue) pushLocked(job Job, now time.Time) {
+	priority := priorityOf(job)
+	q.sequence++
+	heap.Push(&q.jobs, &queuedJob{
+		job:         job,
+		priority:    priority,
+		submittedAt: now,
+		deadline:    now.Add(time.Duration(priority-HighestPriority) * q.agingPeriod),
+		sequence:    q.sequence,
+	})
+
+	select {
+	case q.pushed <- struct{}{}:
+	default:
+	}
+}
+
+// peek returns the job to start first, or nil if the queue is empty. The job stays in the queue until it is removed.
+func (q *jobQueue) peek() *queuedJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.jobs) == 0 {
+		return nil
+	}
+	return q.jobs[0]
+}
+
+// remove removes the job returned by peek, which might not be the first one anymore.
+func (q *jobQueue) remove(item *queuedJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if item.index >= 0 && item.index < len(q.jobs) && q.jobs[item.index] == item {
+		heap.Remove(&q.jobs, item.index)
+	}
+}
+
+func (q *jobQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.jobs)
+}
+
+// oldestSubmittedAt returns the submit time of the job which has waited the longest, or zero if the queue is empty.
+func (q *jobQueue) oldestSubmittedAt() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	oldest := time.Time{}
+	for _, item := range q.jobs {
+		if oldest.IsZero() || item.submittedAt.Before(oldest) {
+			oldest = item.submittedAt
+		}
+	}
+	return oldest
+}
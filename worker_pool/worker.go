@@ -1,7 +1,9 @@
 package worker_pool
 
 import (
+	"context"
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"time"
 )
@@ -18,13 +20,31 @@ func newWorker(workerPool *workerPool) worker {
 	}
 }
 
-func (w *worker) doJob(job Job) {
+// doJob takes the job out of the queue once the worker is busy, so that the pool never counts it as both queued
+// and waiting for an idle worker.
+func (w *worker) doJob(item *queuedJob) {
 	defer w.workerPool.AddNumberOfIdleWorker(1)
 	w.workerPool.AddNumberOfIdleWorker(-1)
+	w.workerPool.jobQueue.remove(item)
+
+	job := item.job
+
+	if releasableJob, ok := job.(ReleasableJob); ok && w.workerPool.isStopping() {
+		w.releaseJob(releasableJob)
+		return
+	}
 
 	logrus.Debugf("Job[%s] is submitted to worker[%s]", job.Id(), w.id.String())
 
-	err := job.Execute() // todo panic recover, stay the pool as working
+	ctx, done := w.workerPool.startJob(job)
+	defer done()
+
+	err := w.execute(ctx, job)
+	if panicErr := (*PanicError)(nil); errors.As(err, &panicErr) {
+		w.workerPool.addPanickedJob()
+		logrus.Errorf("%s\n%s", panicErr.Error(), panicErr.Stack)
+		return
+	}
 	if err != nil {
 		logrus.Errorf(err.Error())
 		return
@@ -33,15 +53,46 @@ func (w *worker) doJob(job Job) {
 	logrus.Debugf("Job[%s] has been processed by worker[%s].", job.Id(), w.id.String())
 }
 
-func (w *worker) work(initialJob Job) {
+// execute executes the job, a panic of the job is returned as a PanicError so that the worker keeps working.
+func (w *worker) execute(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = NewPanicError(job.Id(), recovered)
+		}
+	}()
+	return job.Execute(ctx)
+}
+
+// releaseJob is called instead of executing the job, if it has not started before the pool is stopped.
+func (w *worker) releaseJob(job ReleasableJob) {
+	err := w.release(job)
+	if panicErr := (*PanicError)(nil); errors.As(err, &panicErr) {
+		w.workerPool.addPanickedJob()
+		logrus.Errorf("Job[%s] could not be released: %s\n%s", job.Id(), panicErr.Error(), panicErr.Stack)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Job[%s] could not be released: %s", job.Id(), err)
+		return
+	}
+
+	logrus.Debugf("Job[%s] is released by worker[%s], since worker pool is stopping.", job.Id(), w.id.String())
+}
+
+func (w *worker) release(job ReleasableJob) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = NewPanicError(job.Id(), recovered)
+		}
+	}()
+	return job.Release()
+}
+
+func (w *worker) work() {
 
 	logrus.Debugf("worker[%s] is spawned.", w.id.String())
 	defer w.workerPool.workersWg.Done()
 
-	if initialJob != nil {
-		w.doJob(initialJob)
-	}
-
 	if w.workerPool.poolConf.MinNumberOfWorker == w.workerPool.poolConf.MaxNumberOfWorker {
 		w.runWithFixedNumberOfWorker()
 	} else {
@@ -61,7 +112,7 @@ func (w *worker) runWithDynamicNumberOfWorker() {
 			logrus.Debugf("worker [%s] has stopped working.", w.id.String())
 			w.workerPool.AddNumberOfCurrentAndIdleWorker(-1)
 			return
-		case job, isOpen := <-w.workerPool.jobQueue:
+		case item, isOpen := <-w.workerPool.jobs:
 			ticker.Stop()
 
 			if !isOpen {
@@ -70,7 +121,7 @@ func (w *worker) runWithDynamicNumberOfWorker() {
 				return
 			}
 
-			w.doJob(job)
+			w.doJob(item)
 
 			ticker = time.NewTicker(keepAliveTime)
 		case <-ticker.C:
@@ -95,14 +146,14 @@ func (w *worker) runWithFixedNumberOfWorker() {
 			logrus.Debugf("worker [%s] has stopped working.", w.id.String())
 			w.workerPool.AddNumberOfCurrentAndIdleWorker(-1)
 			return
-		case job, isOpen := <-w.workerPool.jobQueue:
+		case item, isOpen := <-w.workerPool.jobs:
 			if !isOpen {
 				w.workerPool.AddNumberOfCurrentAndIdleWorker(-1)
 				logrus.Debugf("worker[%s] has done its job.", w.id.String())
 				return
 			}
 
-			w.doJob(job)
+			w.doJob(item)
 		}
 	}
 }
@@ -1,9 +1,11 @@
 package worker_pool
 
 import (
+	"context"
 	"github.com/opsgenie/oec/conf"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,13 +17,44 @@ const (
 	queueSize                = 0
 	keepAliveTimeInMillis    = 6000
 	monitoringPeriodInMillis = 15000
+	agingPeriodInMillis      = 30000
 )
 
+// ErrShutdown is the cause of the cancellation of the jobs which are still running when the pool has stopped.
+var ErrShutdown = errors.New("Worker pool has stopped.")
+
 type WorkerPool interface {
 	Start() error
-	Stop() error
+	Stop(ctx context.Context) error
 	Submit(job Job) (bool, error)
 	NumberOfAvailableWorker() int32
+	NumberOfQueueableJob() int32
+	OldestQueuedJobAge() time.Duration
+	Status() Status
+	RunningJobs() []RunningJob
+	Cancel(jobId string, cause error) bool
+}
+
+// RunningJob is a job which is being executed by a worker.
+type RunningJob struct {
+	Id        string    `json:"id"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+type runningJob struct {
+	startedAt time.Time
+	cancel    context.CancelCauseFunc
+}
+
+// Status is a snapshot of the workers and the queue of a pool.
+type Status struct {
+	MaxNumberOfWorker     int32   `json:"maxNumberOfWorker"`
+	NumberOfCurrentWorker int32   `json:"numberOfCurrentWorker"`
+	NumberOfBusyWorker    int32   `json:"numberOfBusyWorker"`
+	QueueSize             int     `json:"queueSize"`
+	NumberOfQueuedJob     int     `json:"numberOfQueuedJob"`
+	Saturation            float64 `json:"saturation"` // ratio of the busy workers to the max number of workers
+	NumberOfPanickedJob   int64   `json:"numberOfPanickedJob"`
 }
 
 type workerPool struct {
@@ -29,12 +62,21 @@ type workerPool struct {
 
 	numberOfCurrentWorker int32
 	numberOfIdleWorker    int32
+	numberOfPanickedJob   int64
 
-	jobQueue  chan Job
+	jobQueue  *jobQueue
+	jobs      chan *queuedJob
 	quit      chan struct{}
 	quitNow   chan struct{}
+	drained   chan struct{}
 	isRunning bool
 
+	jobsCtx    context.Context
+	cancelJobs context.CancelCauseFunc
+
+	runningJobs   map[string]*runningJob
+	runningJobsMu *sync.Mutex
+
 	workersWg        *sync.WaitGroup
 	startStopMu      *sync.RWMutex
 	numberOfWorkerMu *sync.RWMutex
@@ -72,10 +114,23 @@ func New(poolConf *conf.PoolConf) WorkerPool {
 		poolConf.MonitoringPeriodInMillis = monitoringPeriodInMillis
 	}
 
+	if poolConf.AgingPeriodInMillis <= 0 {
+		logrus.Infof("Aging period of the queued jobs should be greater than zero, default value[%d ms.] is set.", agingPeriodInMillis)
+		poolConf.AgingPeriodInMillis = agingPeriodInMillis
+	}
+
+	jobsCtx, cancelJobs := context.WithCancelCause(context.Background())
+
 	return &workerPool{
-		jobQueue:         make(chan Job, poolConf.QueueSize),
+		jobsCtx:          jobsCtx,
+		cancelJobs:       cancelJobs,
+		runningJobs:      make(map[string]*runningJob),
+		runningJobsMu:    &sync.Mutex{},
+		jobQueue:         newJobQueue(poolConf.AgingPeriodInMillis * time.Millisecond),
+		jobs:             make(chan *queuedJob),
 		quit:             make(chan struct{}),
 		quitNow:          make(chan struct{}),
+		drained:          make(chan struct{}),
 		poolConf:         poolConf,
 		workersWg:        &sync.WaitGroup{},
 		startStopMu:      &sync.RWMutex{},
@@ -100,7 +155,9 @@ func (wp *workerPool) Start() error {
 	return nil
 }
 
-func (wp *workerPool) Stop() error {
+// Stop releases the submitted jobs which have not started yet and waits for the running ones until the
+// given context is done. Then the running jobs are cancelled with ErrShutdown.
+func (wp *workerPool) Stop(ctx context.Context) error {
 	defer wp.startStopMu.Unlock()
 	wp.startStopMu.Lock()
 
@@ -111,7 +168,16 @@ func (wp *workerPool) Stop() error {
 
 	logrus.Infof("Worker pool is stopping.")
 	close(wp.quit)
-	wp.workersWg.Wait()
+
+	select {
+	case <-wp.drained:
+	case <-ctx.Done():
+		logrus.Warnf("Worker pool could not be drained in time, running jobs will be cancelled: %s", ctx.Err())
+		wp.cancelJobs(ErrShutdown)
+		<-wp.drained
+	}
+	wp.cancelJobs(ErrShutdown)
+
 	logrus.Infof("Worker pool has stopped.")
 
 	return nil
@@ -128,26 +194,37 @@ func (wp *workerPool) Submit(job Job) (isSubmitted bool, err error) {
 
 	logrus.Debugf("Job[%s] is being submitted", job.Id())
 
-	select {
-	case wp.jobQueue <- job:
+	// the idle workers take the queued jobs right away, so they make room in the queue
+	if wp.jobQueue.offer(job, int(wp.poolConf.QueueSize+wp.NumberOfIdleWorker()), time.Now()) {
 		return true, nil
-	default:
-		if wp.poolConf.MaxNumberOfWorker == wp.poolConf.MinNumberOfWorker {
-			return false, nil
-		}
-
-		if wp.CompareAndIncrementCurrentWorker() {
-			wp.workersWg.Add(1)
-			go func() {
-				worker := newWorker(wp)
-				worker.work(job)
-			}()
-			return true, nil
-		}
+	}
 
-		logrus.Debugf("Job[%s] could not be submitted", job.Id())
+	if wp.poolConf.MaxNumberOfWorker == wp.poolConf.MinNumberOfWorker {
 		return false, nil
 	}
+
+	// the new worker takes the first queued job, which might have a higher priority than the submitted one
+	if wp.CompareAndIncrementCurrentWorker() {
+		wp.jobQueue.push(job, time.Now())
+		wp.workersWg.Add(1)
+		go func() {
+			worker := newWorker(wp)
+			worker.work()
+		}()
+		return true, nil
+	}
+
+	logrus.Debugf("Job[%s] could not be submitted", job.Id())
+	return false, nil
+}
+
+func (wp *workerPool) isStopping() bool {
+	select {
+	case <-wp.quit:
+		return true
+	default:
+		return false
+	}
 }
 
 func (wp *workerPool) monitorMetrics(monitoringPeriodInMillis time.Duration) {
@@ -155,14 +232,14 @@ func (wp *workerPool) monitorMetrics(monitoringPeriodInMillis time.Duration) {
 		return
 	}
 
-	logrus.Infof("Worker pool is running with; Min Worker: %d, Max Worker: %d, Queue Size: %d", wp.poolConf.MinNumberOfWorker, wp.poolConf.MaxNumberOfWorker, cap(wp.jobQueue))
+	logrus.Infof("Worker pool is running with; Min Worker: %d, Max Worker: %d, Queue Size: %d, Aging Period: %d ms.", wp.poolConf.MinNumberOfWorker, wp.poolConf.MaxNumberOfWorker, wp.poolConf.QueueSize, wp.poolConf.AgingPeriodInMillis)
 
 	ticker := time.NewTicker(monitoringPeriodInMillis * time.Millisecond)
 
 	for {
 		select {
 		case <-ticker.C:
-			logrus.Debugf("Current Worker: %d, Idle Worker: %d, Queue Size: %d, Queue load: %d", wp.NumberOfCurrentWorker(), wp.numberOfIdleWorker, cap(wp.jobQueue), len(wp.jobQueue))
+			logrus.Debugf("Current Worker: %d, Idle Worker: %d, Queue Size: %d, Queue load: %d", wp.NumberOfCurrentWorker(), wp.NumberOfIdleWorker(), wp.poolConf.QueueSize, wp.jobQueue.len())
 		case <-wp.quit:
 			ticker.Stop()
 			logrus.Infof("Monitor metrics has stopped.")
@@ -178,20 +255,37 @@ func (wp *workerPool) addInitialWorkers(num int32) {
 
 	for i := int32(0); i < num; i++ {
 		worker := newWorker(wp)
-		go worker.work(nil)
+		go worker.work()
 	}
 }
 
+// run hands the queued jobs to the workers, the first job of the queue is handed unless a job which should be
+// started before it is submitted while waiting for a worker.
 func (wp *workerPool) run() {
 
 	logrus.Infof("Worker pool has started to run.")
 
 	for {
+		next := wp.jobQueue.peek()
+		if next == nil {
+			select {
+			case <-wp.jobQueue.pushed:
+				continue
+			case <-wp.quit:
+				wp.drain()
+				return
+			case <-wp.quitNow:
+				logrus.Infof("Worker pool has stopped immediately.")
+				return
+			}
+		}
+
 		select {
+		case wp.jobs <- next:
+			wp.jobQueue.remove(next)
+		case <-wp.jobQueue.pushed:
 		case <-wp.quit:
-			logrus.Infof("Worker pool is waiting that all workers are done.")
-			close(wp.jobQueue)
-			wp.workersWg.Wait()
+			wp.drain()
 			return
 		case <-wp.quitNow:
 			logrus.Infof("Worker pool has stopped immediately.")
@@ -200,12 +294,136 @@ func (wp *workerPool) run() {
 	}
 }
 
+// drain hands the jobs left in the queue to the workers, which release them instead of executing if they are
+// releasable, then waits that all workers are done.
+func (wp *workerPool) drain() {
+	logrus.Infof("Worker pool is waiting that all workers are done.")
+
+	keepAliveTime := wp.poolConf.KeepAliveTimeInMillis * time.Millisecond
+	for next := wp.jobQueue.peek(); next != nil; next = wp.jobQueue.peek() {
+		wp.jobQueue.remove(next)
+		wp.handOver(next, keepAliveTime)
+	}
+
+	close(wp.jobs)
+	wp.workersWg.Wait()
+	close(wp.drained)
+}
+
+// handOver waits for a worker to take the job, a worker is added if none is left since the idle workers of a
+// dynamic pool may have killed themselves.
+func (wp *workerPool) handOver(item *queuedJob, keepAliveTime time.Duration) {
+	ticker := time.NewTicker(keepAliveTime)
+	defer ticker.Stop()
+
+	for {
+		if wp.NumberOfCurrentWorker() == 0 {
+			wp.addInitialWorkers(1)
+		}
+
+		select {
+		case wp.jobs <- item:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func (wp *workerPool) NumberOfAvailableWorker() int32 {
 	wp.numberOfWorkerMu.Lock()
 	defer wp.numberOfWorkerMu.Unlock()
 	return wp.poolConf.MaxNumberOfWorker - wp.numberOfCurrentWorker + wp.numberOfIdleWorker
 }
 
+// NumberOfQueueableJob returns the number of the jobs which can wait in the queue, in addition to the ones taken by
+// the available workers.
+func (wp *workerPool) NumberOfQueueableJob() int32 {
+	queueable := wp.poolConf.QueueSize - int32(wp.jobQueue.len())
+	if queueable < 0 {
+		return 0
+	}
+	return queueable
+}
+
+// OldestQueuedJobAge returns how long the job which has waited the longest in the queue has waited, or zero if the
+// queue is empty.
+func (wp *workerPool) OldestQueuedJobAge() time.Duration {
+	oldest := wp.jobQueue.oldestSubmittedAt()
+	if oldest.IsZero() {
+		return 0
+	}
+	return time.Since(oldest)
+}
+
+func (wp *workerPool) Status() Status {
+	wp.numberOfWorkerMu.RLock()
+	defer wp.numberOfWorkerMu.RUnlock()
+
+	status := Status{
+		MaxNumberOfWorker:     wp.poolConf.MaxNumberOfWorker,
+		NumberOfCurrentWorker: wp.numberOfCurrentWorker,
+		NumberOfBusyWorker:    wp.numberOfCurrentWorker - wp.numberOfIdleWorker,
+		QueueSize:             int(wp.poolConf.QueueSize),
+		NumberOfQueuedJob:     wp.jobQueue.len(),
+		NumberOfPanickedJob:   atomic.LoadInt64(&wp.numberOfPanickedJob),
+	}
+	if status.MaxNumberOfWorker > 0 {
+		status.Saturation = float64(status.NumberOfBusyWorker) / float64(status.MaxNumberOfWorker)
+	}
+	return status
+}
+
+// RunningJobs returns the jobs being executed, the earliest started first.
+func (wp *workerPool) RunningJobs() []RunningJob {
+	wp.runningJobsMu.Lock()
+	defer wp.runningJobsMu.Unlock()
+
+	jobs := make([]RunningJob, 0, len(wp.runningJobs))
+	for id, job := range wp.runningJobs {
+		jobs = append(jobs, RunningJob{Id: id, StartedAt: job.startedAt})
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.Before(jobs[j].StartedAt)
+	})
+	return jobs
+}
+
+// Cancel cancels the context of the running job with the given cause. It returns false if the job is not running.
+func (wp *workerPool) Cancel(jobId string, cause error) bool {
+	wp.runningJobsMu.Lock()
+	defer wp.runningJobsMu.Unlock()
+
+	job, ok := wp.runningJobs[jobId]
+	if !ok {
+		return false
+	}
+	job.cancel(cause)
+	return true
+}
+
+// startJob returns the context which the job is executed with, and the func to call once it is done.
+func (wp *workerPool) startJob(job Job) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(wp.jobsCtx)
+	running := &runningJob{startedAt: time.Now(), cancel: cancel}
+
+	wp.runningJobsMu.Lock()
+	defer wp.runningJobsMu.Unlock()
+	wp.runningJobs[job.Id()] = running
+
+	return ctx, func() {
+		wp.runningJobsMu.Lock()
+		defer wp.runningJobsMu.Unlock()
+		if wp.runningJobs[job.Id()] == running {
+			delete(wp.runningJobs, job.Id())
+		}
+		cancel(nil)
+	}
+}
+
+func (wp *workerPool) addPanickedJob() {
+	atomic.AddInt64(&wp.numberOfPanickedJob, 1)
+}
+
 func (wp *workerPool) NumberOfCurrentWorker() int32 {
 	wp.numberOfWorkerMu.RLock()
 	defer wp.numberOfWorkerMu.RUnlock()
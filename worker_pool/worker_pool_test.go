@@ -1,7 +1,9 @@
 package worker_pool
 
 import (
+	"context"
 	"github.com/opsgenie/oec/conf"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
@@ -40,11 +42,12 @@ func TestMain(m *testing.M) {
 
 func TestValidateNewWorkerPool(t *testing.T) {
 	configuration := &conf.PoolConf{
-		-1,
-		-1,
-		-1,
-		-1,
-		-1,
+		MaxNumberOfWorker:        -1,
+		MinNumberOfWorker:        -1,
+		QueueSize:                -1,
+		KeepAliveTimeInMillis:    -1,
+		MonitoringPeriodInMillis: -1,
+		AgingPeriodInMillis:      -1,
 	}
 	pool := New(configuration).(*workerPool)
 
@@ -53,15 +56,16 @@ func TestValidateNewWorkerPool(t *testing.T) {
 	assert.Equal(t, int32(queueSize), pool.poolConf.QueueSize)
 	assert.Equal(t, time.Duration(keepAliveTimeInMillis), pool.poolConf.KeepAliveTimeInMillis)
 	assert.Equal(t, time.Duration(monitoringPeriodInMillis), pool.poolConf.MonitoringPeriodInMillis)
+	assert.Equal(t, time.Duration(agingPeriodInMillis), pool.poolConf.AgingPeriodInMillis)
 }
 
 func TestValidateWorkerNumbersNewWorkerPool(t *testing.T) {
 	configuration := &conf.PoolConf{
-		1,
-		2,
-		-1,
-		0,
-		0,
+		MaxNumberOfWorker:        1,
+		MinNumberOfWorker:        2,
+		QueueSize:                -1,
+		KeepAliveTimeInMillis:    0,
+		MonitoringPeriodInMillis: 0,
 	}
 	pool := New(configuration).(*workerPool)
 
@@ -89,7 +93,7 @@ func TestStartPool(t *testing.T) {
 		job.JobIdFunc = func() string {
 			return id
 		}
-		job.ExecuteFunc = func() error {
+		job.ExecuteFunc = func(ctx context.Context) error {
 			atomic.AddInt32(&executeJobCallCount, 1)
 			time.Sleep(time.Nanosecond)
 			return nil
@@ -99,12 +103,360 @@ func TestStartPool(t *testing.T) {
 		}
 	}
 
-	err = pool.Stop()
+	err = pool.Stop(context.Background())
 
 	assert.Nil(t, err)
 	assert.Equal(t, int32(1000), executeJobCallCount)
 }
 
+func TestPoolStatus(t *testing.T) {
+
+	pool := New(testPoolConf).(*workerPool)
+
+	err := pool.Start()
+	assert.Nil(t, err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		job := NewMockJob()
+		job.ExecuteFunc = func(ctx context.Context) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		}
+		for isSubmitted, _ := pool.Submit(job); !isSubmitted; isSubmitted, _ = pool.Submit(job) {
+		}
+		<-started
+	}
+
+	status := pool.Status()
+	assert.Equal(t, int32(16), status.MaxNumberOfWorker)
+	assert.True(t, status.NumberOfCurrentWorker >= 4)
+	assert.Equal(t, int32(4), status.NumberOfBusyWorker)
+	assert.Equal(t, 0.25, status.Saturation)
+
+	close(release)
+	err = pool.Stop(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestStopPoolCancelsRunningJobsAfterDeadline(t *testing.T) {
+
+	pool := New(testPoolConf).(*workerPool)
+
+	err := pool.Start()
+	assert.Nil(t, err)
+
+	started := make(chan struct{})
+	job := NewMockJob()
+	job.ExecuteFunc = func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	isSubmitted, err := pool.Submit(job)
+	assert.Nil(t, err)
+	assert.True(t, isSubmitted)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = pool.Stop(ctx)
+
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.NotNil(t, pool.jobsCtx.Err())
+}
+
+func TestStopPoolReleasesJobsWhichHaveNotStarted(t *testing.T) {
+
+	pool := New(&conf.PoolConf{
+		MaxNumberOfWorker: 1,
+		MinNumberOfWorker: 1,
+		QueueSize:         3,
+	}).(*workerPool)
+
+	err := pool.Start()
+	assert.Nil(t, err)
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	runningJob := NewMockJob()
+	runningJob.ExecuteFunc = func(ctx context.Context) error {
+		close(started)
+		<-finish
+		return nil
+	}
+	pool.Submit(runningJob)
+	<-started
+
+	var executeCount, releaseCount int32
+	for i := 0; i < 3; i++ {
+		job := &MockReleasableJob{MockJob: NewMockJob()}
+		job.ExecuteFunc = func(ctx context.Context) error {
+			atomic.AddInt32(&executeCount, 1)
+			return nil
+		}
+		job.ReleaseFunc = func() error {
+			atomic.AddInt32(&releaseCount, 1)
+			return nil
+		}
+		isSubmitted, err := pool.Submit(job)
+		assert.Nil(t, err)
+		assert.True(t, isSubmitted)
+	}
+
+	go func() {
+		for !pool.isStopping() {
+			time.Sleep(time.Millisecond)
+		}
+		close(finish)
+	}()
+
+	err = pool.Stop(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), executeCount)
+	assert.Equal(t, int32(3), releaseCount)
+}
+
+func TestStopPoolCancelsJobsWithShutdownCause(t *testing.T) {
+
+	pool := New(testPoolConf).(*workerPool)
+
+	err := pool.Start()
+	assert.Nil(t, err)
+
+	started := make(chan struct{})
+	var cause error
+	job := NewMockJob()
+	job.ExecuteFunc = func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		cause = context.Cause(ctx)
+		return ctx.Err()
+	}
+	pool.Submit(job)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = pool.Stop(ctx)
+
+	assert.Nil(t, err)
+	assert.Equal(t, ErrShutdown, cause)
+}
+
+func TestCancelRunningJob(t *testing.T) {
+
+	pool := New(testPoolConf).(*workerPool)
+
+	err := pool.Start()
+	assert.Nil(t, err)
+
+	errCancelled := errors.New("Test cancel")
+	started := make(chan struct{})
+	causes := make(chan error, 1)
+	job := NewMockJob()
+	job.ExecuteFunc = func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+		return ctx.Err()
+	}
+	pool.Submit(job)
+	<-started
+
+	runningJobs := pool.RunningJobs()
+	assert.Equal(t, 1, len(runningJobs))
+	assert.Equal(t, "mockJobId", runningJobs[0].Id)
+	assert.WithinDuration(t, time.Now(), runningJobs[0].StartedAt, time.Second)
+
+	assert.False(t, pool.Cancel("unknownJobId", errCancelled))
+	assert.True(t, pool.Cancel("mockJobId", errCancelled))
+	assert.Equal(t, errCancelled, <-causes)
+
+	assert.Eventually(t, func() bool { return len(pool.RunningJobs()) == 0 }, time.Second, time.Millisecond)
+
+	err = pool.Stop(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestPanickedJobKeepsWorkerCounters(t *testing.T) {
+
+	pool := New(&conf.PoolConf{MaxNumberOfWorker: 2, MinNumberOfWorker: 2, QueueSize: 1}).(*workerPool)
+
+	err := pool.Start()
+	assert.Nil(t, err)
+
+	for i := 0; i < 4; i++ {
+		job := NewMockJob()
+		job.ExecuteFunc = func(ctx context.Context) error {
+			var attributes map[string]*string
+			return errors.New(*attributes["ownerId"])
+		}
+		for isSubmitted, _ := pool.Submit(job); !isSubmitted; isSubmitted, _ = pool.Submit(job) {
+		}
+	}
+
+	executed := make(chan struct{})
+	job := NewMockJob()
+	job.ExecuteFunc = func(ctx context.Context) error {
+		close(executed)
+		return nil
+	}
+	for isSubmitted, _ := pool.Submit(job); !isSubmitted; isSubmitted, _ = pool.Submit(job) {
+	}
+	<-executed
+
+	assert.Eventually(t, func() bool {
+		status := pool.Status()
+		return status.NumberOfBusyWorker == 0 && len(pool.RunningJobs()) == 0
+	}, time.Second, time.Millisecond)
+
+	status := pool.Status()
+	assert.Equal(t, int64(4), status.NumberOfPanickedJob)
+	assert.Equal(t, int32(2), status.NumberOfCurrentWorker)
+	assert.Equal(t, int32(2), pool.NumberOfAvailableWorker())
+
+	err = pool.Stop(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestPanicErrorHasStack(t *testing.T) {
+
+	err := (&worker{workerPool: New(testPoolConf).(*workerPool)}).execute(context.Background(), &MockJob{
+		ExecuteFunc: func(ctx context.Context) error {
+			panic("test panic")
+		},
+	})
+
+	panicErr, ok := err.(*PanicError)
+	assert.True(t, ok)
+	assert.Equal(t, "Job[mockJobId] has panicked: test panic", panicErr.Error())
+	assert.Contains(t, string(panicErr.Stack), "worker_pool_test.go")
+}
+
+func TestJobQueueOrdersByPriorityWithAging(t *testing.T) {
+
+	queue := newJobQueue(time.Minute)
+	now := time.Now()
+
+	queue.push(newMockPrioritizedJob("p5-old", 5), now)
+	queue.push(newMockPrioritizedJob("p3", 3), now.Add(time.Minute))
+	queue.push(newMockPrioritizedJob("p0", 0), now.Add(time.Minute))
+	queue.push(NewMockJob(), now.Add(2*time.Minute))
+	queue.push(newMockPrioritizedJob("p1", 1), now.Add(2*time.Minute))
+	queue.push(newMockPrioritizedJob("p5-new", 5), now.Add(2*time.Minute))
+
+	ids := make([]string, 0)
+	for next := queue.peek(); next != nil; next = queue.peek() {
+		queue.remove(next)
+		ids = append(ids, next.job.Id())
+	}
+
+	// p0 is clamped to P1, p5-old has aged as much as mockJobId with the default priority submitted 2 minutes later
+	assert.Equal(t, []string{"p0", "p1", "p3", "p5-old", "mockJobId", "p5-new"}, ids)
+}
+
+func TestJobQueueOffersUpToLimit(t *testing.T) {
+
+	queue := newJobQueue(time.Minute)
+
+	assert.True(t, queue.offer(NewMockJob(), 2, time.Now()))
+	assert.True(t, queue.offer(NewMockJob(), 2, time.Now()))
+	assert.False(t, queue.offer(NewMockJob(), 2, time.Now()))
+	assert.Equal(t, 2, queue.len())
+}
+
+func TestPoolStartsHigherPriorityJobFirst(t *testing.T) {
+
+	pool := New(&conf.PoolConf{
+		MaxNumberOfWorker:   1,
+		MinNumberOfWorker:   1,
+		QueueSize:           5,
+		AgingPeriodInMillis: 60000,
+	}).(*workerPool)
+
+	err := pool.Start()
+	assert.Nil(t, err)
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	runningJob := NewMockJob()
+	runningJob.ExecuteFunc = func(ctx context.Context) error {
+		close(started)
+		<-finish
+		return nil
+	}
+	pool.Submit(runningJob)
+	<-started
+
+	executed := make(chan string, 5)
+	for _, job := range []*MockPrioritizedJob{
+		newMockPrioritizedJob("enrich-1", 5),
+		newMockPrioritizedJob("enrich-2", 5),
+		newMockPrioritizedJob("enrich-3", 5),
+		newMockPrioritizedJob("restart", 1),
+	} {
+		id := job.Id()
+		job.ExecuteFunc = func(ctx context.Context) error {
+			executed <- id
+			return nil
+		}
+		isSubmitted, err := pool.Submit(job)
+		assert.Nil(t, err)
+		assert.True(t, isSubmitted)
+	}
+
+	assert.Equal(t, 4, pool.Status().NumberOfQueuedJob)
+	assert.Equal(t, int32(1), pool.NumberOfQueueableJob())
+	assert.True(t, pool.OldestQueuedJobAge() > 0)
+
+	close(finish)
+	assert.Equal(t, "restart", <-executed)
+	assert.Equal(t, "enrich-1", <-executed)
+
+	err = pool.Stop(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, pool.Status().NumberOfQueuedJob)
+	assert.Equal(t, time.Duration(0), pool.OldestQueuedJobAge())
+}
+
+func TestFixedPoolRejectsJobsWhenQueueIsFull(t *testing.T) {
+
+	pool := New(&conf.PoolConf{MaxNumberOfWorker: 1, MinNumberOfWorker: 1, QueueSize: 1}).(*workerPool)
+
+	err := pool.Start()
+	assert.Nil(t, err)
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	runningJob := NewMockJob()
+	runningJob.ExecuteFunc = func(ctx context.Context) error {
+		close(started)
+		<-finish
+		return nil
+	}
+	pool.Submit(runningJob)
+	<-started
+
+	isSubmitted, _ := pool.Submit(NewMockJob())
+	assert.True(t, isSubmitted)
+	isSubmitted, _ = pool.Submit(newMockPrioritizedJob("restart", 1))
+	assert.False(t, isSubmitted)
+	assert.Equal(t, int32(0), pool.NumberOfQueueableJob())
+
+	close(finish)
+	err = pool.Stop(context.Background())
+	assert.Nil(t, err)
+}
+
 func BenchmarkWorkerPool(b *testing.B) {
 
 	jobSize1 := 500
@@ -132,11 +484,11 @@ func BenchmarkWorkerPool(b *testing.B) {
 
 		pool := New(
 			&conf.PoolConf{
-				int32(size.workerSize),
-				2,
-				queueSize,
-				keepAliveTimeInMillis,
-				monitoringPeriodInMillis,
+				MaxNumberOfWorker:        int32(size.workerSize),
+				MinNumberOfWorker:        2,
+				QueueSize:                queueSize,
+				KeepAliveTimeInMillis:    keepAliveTimeInMillis,
+				MonitoringPeriodInMillis: monitoringPeriodInMillis,
 			},
 		)
 
@@ -150,7 +502,7 @@ func BenchmarkWorkerPool(b *testing.B) {
 
 			for i := 0; i < size.jobSize; i++ {
 				job := NewMockJob()
-				job.ExecuteFunc = func() error {
+				job.ExecuteFunc = func(ctx context.Context) error {
 					atomic.AddInt32(&executeJobCallCount, 1)
 					dummyJob()
 					return nil
@@ -161,7 +513,7 @@ func BenchmarkWorkerPool(b *testing.B) {
 				}
 			}
 
-			err = pool.Stop()
+			err = pool.Stop(context.Background())
 
 			assert.Nil(b, err)
 			assert.Equal(b, int32(size.jobSize), executeJobCallCount)
@@ -201,11 +553,11 @@ func BenchmarkWorkerPoolWithComparableFixedWorkerSize(b *testing.B) {
 
 		pool := New(
 			&conf.PoolConf{
-				int32(testCase.maxNumberOfWorker),
-				int32(minNumberOfWorker),
-				queueSize,
-				keepAliveTimeInMillis,
-				monitoringPeriodInMillis,
+				MaxNumberOfWorker:        int32(testCase.maxNumberOfWorker),
+				MinNumberOfWorker:        int32(minNumberOfWorker),
+				QueueSize:                queueSize,
+				KeepAliveTimeInMillis:    keepAliveTimeInMillis,
+				MonitoringPeriodInMillis: monitoringPeriodInMillis,
 			},
 		)
 
@@ -219,7 +571,7 @@ func BenchmarkWorkerPoolWithComparableFixedWorkerSize(b *testing.B) {
 
 			for i := 0; i < jobSize; i++ {
 				job := NewMockJob()
-				job.ExecuteFunc = func() error {
+				job.ExecuteFunc = func(ctx context.Context) error {
 					atomic.AddInt32(&executeJobCallCount, 1)
 					dummyJob()
 					return nil
@@ -229,7 +581,7 @@ func BenchmarkWorkerPoolWithComparableFixedWorkerSize(b *testing.B) {
 				}
 			}
 
-			err = pool.Stop()
+			err = pool.Stop(context.Background())
 
 			assert.Nil(b, err)
 			assert.Equal(b, int32(jobSize), executeJobCallCount)
@@ -240,7 +592,7 @@ func BenchmarkWorkerPoolWithComparableFixedWorkerSize(b *testing.B) {
 // Mock Job
 type MockJob struct {
 	JobIdFunc   func() string
-	ExecuteFunc func() error
+	ExecuteFunc func(ctx context.Context) error
 }
 
 func NewMockJob() *MockJob {
@@ -254,9 +606,46 @@ func (mj *MockJob) Id() string {
 	return "mockJobId"
 }
 
-func (mj *MockJob) Execute() error {
+func (mj *MockJob) Execute(ctx context.Context) error {
 	if mj.ExecuteFunc != nil {
-		return mj.ExecuteFunc()
+		return mj.ExecuteFunc(ctx)
+	}
+	return nil
+}
+
+type MockReleasableJob struct {
+	*MockJob
+	ReleaseFunc func() error
+}
+
+func (mj *MockReleasableJob) Release() error {
+	if mj.ReleaseFunc != nil {
+		return mj.ReleaseFunc()
 	}
 	return nil
 }
+
+type MockPrioritizedJob struct {
+	*MockJob
+	PriorityFunc func() int
+}
+
+func newMockPrioritizedJob(id string, priority int) *MockPrioritizedJob {
+	return &MockPrioritizedJob{
+		MockJob: &MockJob{
+			JobIdFunc: func() string {
+				return id
+			},
+		},
+		PriorityFunc: func() int {
+			return priority
+		},
+	}
+}
+
+func (mj *MockPrioritizedJob) Priority() int {
+	if mj.PriorityFunc != nil {
+		return mj.PriorityFunc()
+	}
+	return DefaultPriority
+}